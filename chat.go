@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"llm-local-proxy/config"
+)
+
+// chat runs an interactive REPL against the configured upstreams through the
+// proxy pipeline, keeping the conversation history (including <thought>
+// blocks, as a client would send them back).
+func chat(args []string) int {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	configFile := fs.String("config", "config.json", "配置文件路径")
+	model := fs.String("model", "", "模型名（默认为第一个 provider 的第一个模型）")
+	system := fs.String("system", "", "系统提示词")
+	verbose := fs.Bool("v", false, "显示代理日志")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Printf("❌ 加载配置失败: %v\n", err)
+		return 1
+	}
+	if *model == "" {
+		if *model = defaultModel(cfg); *model == "" {
+			fmt.Println("❌ 无法确定模型，请使用 -model 指定")
+			return 1
+		}
+	}
+
+	// The proxy logs to stdout; keep it off the conversation unless asked
+	out := os.Stdout
+	if !*verbose {
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout = devNull
+			defer func() { os.Stdout = out }()
+		}
+	}
+
+	ts, err := pipelineServer(cfg)
+	if err != nil {
+		fmt.Fprintf(out, "❌ %v\n", err)
+		return 1
+	}
+	defer ts.Close()
+
+	var history []map[string]any
+	reset := func() {
+		history = history[:0]
+		if *system != "" {
+			history = append(history, map[string]any{"role": "system", "content": *system})
+		}
+	}
+	reset()
+
+	fmt.Fprintf(out, "💬 %s（/model <名称> 切换模型，/system <内容> 设置系统提示词，/reset 清空历史，/exit 退出）\n", *model)
+	input := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprint(out, "\n> ")
+		line, err := input.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			fmt.Fprintln(out)
+			return 0
+		}
+		line = strings.TrimSpace(line)
+
+		switch cmd, arg, _ := strings.Cut(line, " "); cmd {
+		case "":
+			continue
+		case "/exit", "/quit":
+			return 0
+		case "/reset":
+			reset()
+			fmt.Fprintln(out, "🧹 已清空历史")
+			continue
+		case "/model":
+			if arg != "" {
+				*model = strings.TrimSpace(arg)
+			}
+			fmt.Fprintf(out, "🤖 当前模型: %s\n", *model)
+			continue
+		case "/system":
+			*system = strings.TrimSpace(arg)
+			reset()
+			fmt.Fprintln(out, "📝 已设置系统提示词并清空历史")
+			continue
+		}
+
+		history = append(history, map[string]any{"role": "user", "content": line})
+		res, err := complete(ts.URL, map[string]any{
+			"model":    *model,
+			"messages": history,
+			"stream":   true,
+		}, func(text string) { fmt.Fprint(out, text) })
+		fmt.Fprintln(out)
+		if err != nil {
+			// Drop the unanswered turn so it can be retried
+			history = history[:len(history)-1]
+			fmt.Fprintf(out, "❌ %v\n", err)
+			continue
+		}
+		history = append(history, map[string]any{"role": "assistant", "content": res.Content})
+		if res.Usage.TotalTokens > 0 {
+			fmt.Fprintf(out, "  · tokens: %d 输入 / %d 输出\n", res.Usage.PromptTokens, res.Usage.CompletionTokens)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/plugin"
+	"llm-local-proxy/provider"
+	"llm-local-proxy/proxy"
+	"llm-local-proxy/transform"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+func usage() {
+	fmt.Println(`用法: llm-local-proxy [子命令] [参数]
+
+子命令:
+  serve             启动代理（默认）
+  validate-config   校验配置文件
+  test              通过配置的上游发送一条示例请求
+  chat              交互式对话（经过代理的完整变换流程）
+  monitor           终端实时监控运行中的代理
+  har               将 -record 的录制导出为 HAR 文件
+  version           打印版本信息
+
+使用 "llm-local-proxy <子命令> -h" 查看各子命令的参数`)
+}
+
+func printVersion() {
+	fmt.Printf("llm-local-proxy %s (%s %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" || s.Key == "vcs.time" {
+				fmt.Printf("  %s: %s\n", s.Key, s.Value)
+			}
+		}
+	}
+}
+
+// validateConfig loads the config and everything derived from it without
+// starting the server. Returns the process exit code.
+func validateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configFile := fs.String("config", "config.json", "配置文件路径")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 1
+	}
+	if _, err := provider.NewRegistry(cfg); err != nil {
+		fmt.Printf("❌ 初始化 provider 失败: %v\n", err)
+		return 1
+	}
+	if _, err := proxy.NewHTTPClient(cfg); err != nil {
+		fmt.Printf("❌ 初始化上游客户端失败: %v\n", err)
+		return 1
+	}
+	// Plugin processes are not started; only their types are checked
+	for _, pc := range cfg.Plugins {
+		if !slices.Contains(plugin.Types(), pc.Type) {
+			fmt.Printf("❌ 插件 %s 的类型 %q 未注册（可用: %v）\n", pc.Name, pc.Type, plugin.Types())
+			return 1
+		}
+	}
+	// A missing self-signed certificate is generated on start, not an error
+	if _, statErr := os.Stat(cfg.TLSCert); cfg.TLSCert != "" && !(cfg.TLSSelfSigned && os.IsNotExist(statErr)) {
+		if _, err := loadServerTLS(cfg); err != nil {
+			fmt.Printf("❌ TLS 配置失败: %v\n", err)
+			return 1
+		}
+	}
+
+	fmt.Printf("✅ 配置有效: %s\n", *configFile)
+	for _, p := range cfg.Providers {
+		fmt.Printf("  📡 %s [%s] → %s  models: %v\n", p.Name, p.Type, p.BaseURL, p.Models)
+	}
+	return 0
+}
+
+// testCompletion sends one chat completion through the full proxy pipeline
+// (served in-process) to the configured upstream and prints the result.
+func testCompletion(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	configFile := fs.String("config", "config.json", "配置文件路径")
+	model := fs.String("model", "", "模型名（默认为第一个 provider 的第一个模型）")
+	prompt := fs.String("prompt", "Say hello in one short sentence.", "发送的提示词")
+	stream := fs.Bool("stream", true, "使用流式响应")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Printf("❌ 加载配置失败: %v\n", err)
+		return 1
+	}
+	if *model == "" {
+		if *model = defaultModel(cfg); *model == "" {
+			fmt.Println("❌ 无法确定模型，请使用 -model 指定")
+			return 1
+		}
+	}
+
+	ts, err := pipelineServer(cfg)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 1
+	}
+	defer ts.Close()
+
+	req := map[string]any{
+		"model":    *model,
+		"messages": []map[string]any{{"role": "user", "content": *prompt}},
+		"stream":   *stream,
+	}
+	fmt.Printf("🧪 %s ← %q\n\n", *model, *prompt)
+	start := time.Now()
+	var firstToken time.Duration
+	res, err := complete(ts.URL, req, func(text string) {
+		if firstToken == 0 {
+			firstToken = time.Since(start)
+		}
+		fmt.Print(text)
+	})
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return 1
+	}
+	if !*stream {
+		fmt.Print(res.Content)
+	}
+	fmt.Println()
+
+	fmt.Printf("\n✅ 完成，耗时 %v", time.Since(start).Round(time.Millisecond))
+	if firstToken > 0 {
+		fmt.Printf("（首个 token %v）", firstToken.Round(time.Millisecond))
+	}
+	if res.Usage.TotalTokens > 0 {
+		fmt.Printf("，tokens: %d 输入 / %d 输出", res.Usage.PromptTokens, res.Usage.CompletionTokens)
+	}
+	fmt.Println()
+	return 0
+}
+
+// defaultModel returns the first explicitly configured model name.
+func defaultModel(cfg config.Config) string {
+	for _, p := range cfg.Providers {
+		if len(p.Models) > 0 && p.Models[0] != "*" {
+			return p.Models[0]
+		}
+	}
+	return ""
+}
+
+// pipelineServer serves the proxy handler on a loopback port, so the CLI
+// tools exercise exactly the transformations a real client would see.
+func pipelineServer(cfg config.Config) (*httptest.Server, error) {
+	registry, err := provider.NewRegistry(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("初始化 provider 失败: %w", err)
+	}
+	client, err := proxy.NewHTTPClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("初始化上游客户端失败: %w", err)
+	}
+	plugins, err := plugin.NewChain(cfg.Plugins)
+	if err != nil {
+		return nil, fmt.Errorf("初始化插件失败: %w", err)
+	}
+	h := proxy.NewHandler(cfg, registry, client)
+	h.SetPlugins(plugins)
+	return httptest.NewServer(h), nil
+}
+
+// completion is the text and usage of one chat completion.
+type completion struct {
+	Content string
+	Usage   transform.Usage
+}
+
+// complete sends a chat completion request to the pipeline at baseURL.
+// Streamed content is passed to onDelta as it arrives.
+func complete(baseURL string, req map[string]any, onDelta func(string)) (completion, error) {
+	var res completion
+	stream, _ := req["stream"].(bool)
+	if stream {
+		req["stream_options"] = map[string]any{"include_usage": true}
+	}
+	body, _ := json.Marshal(req)
+
+	resp, err := http.Post(baseURL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return res, fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return res, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	if !stream {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return res, fmt.Errorf("读取响应失败: %w", err)
+		}
+		var out struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		json.Unmarshal(data, &out)
+		if len(out.Choices) > 0 {
+			res.Content = out.Choices[0].Message.Content
+		}
+		res.Usage, _ = transform.UsageFromBody(data)
+		return res, nil
+	}
+
+	var content strings.Builder
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data: ")); ok && string(data) != "[DONE]" {
+			var chunk map[string]any
+			if json.Unmarshal(data, &chunk) == nil {
+				if u, ok := transform.UsageFromMap(chunk); ok {
+					res.Usage = u
+				}
+				if text := deltaContent(chunk); text != "" {
+					content.WriteString(text)
+					onDelta(text)
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	res.Content = content.String()
+	return res, nil
+}
+
+// deltaContent returns the content of the first choice of a stream chunk.
+func deltaContent(chunk map[string]any) string {
+	choices, _ := chunk["choices"].([]any)
+	if len(choices) == 0 {
+		return ""
+	}
+	choice, _ := choices[0].(map[string]any)
+	delta, _ := choice["delta"].(map[string]any)
+	text, _ := delta["content"].(string)
+	return text
+}
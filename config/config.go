@@ -4,26 +4,632 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
 )
 
 // ProviderConfig defines a single upstream LLM provider.
 type ProviderConfig struct {
-	Name            string   `json:"name"`
-	Type            string   `json:"type"`     // "deepseek", "kimi", "zhipu", "passthrough"
-	BaseURL         string   `json:"base_url"` // Full base URL including version path (e.g. "https://api.moonshot.cn/v1")
-	APIKey          string   `json:"api_key"`
-	Models          []string `json:"models"`                     // Model names to route to this provider; "*" = catch-all
-	ReasoningEffort string   `json:"reasoning_effort,omitempty"` // injected into request if client doesn't send it ("high" / "max")
+	Name            string           `json:"name"`
+	Type            string           `json:"type"`     // "deepseek", "kimi", "zhipu", "anthropic", "gemini", "passthrough"
+	BaseURL         string           `json:"base_url"` // Full base URL including version path (e.g. "https://api.moonshot.cn/v1")
+	APIKey          string           `json:"api_key"`
+	Models          []string         `json:"models"`                     // Model names to route to this provider; "*" = catch-all
+	ReasoningEffort string           `json:"reasoning_effort,omitempty"` // injected into request if client doesn't send it ("high" / "max")
+	HostOverride    string           `json:"host_override,omitempty"`    // explicit Host header / TLS SNI; default derived from base_url
+	ReasoningMode   string           `json:"reasoning_mode,omitempty"`   // overrides the global reasoning_mode for this provider
+	Sanitize        SanitizeConfig   `json:"sanitize,omitzero"`          // extra parameter rules on top of the built-in ones for the type
+	Roles           RoleConfig       `json:"roles,omitzero"`             // message role normalization for upstreams with strict role rules
+	Prefill         string           `json:"prefill,omitempty"`          // "prefix" (DeepSeek beta), "partial" (Kimi) or "none": how a trailing assistant message is marked for continuation; default by type
+	JSONSchema      string           `json:"json_schema,omitempty"`      // "native" or "emulate" response_format json_schema; default by type
+	UpstreamStream  string           `json:"upstream_stream,omitempty"`  // "always" or "never" stream from upstream, converting for the client; default as requested
+	SyntheticStream SyntheticStream  `json:"synthetic_stream,omitzero"`  // pace of streams replayed from complete responses
+	CompletionsURL  string           `json:"completions_url,omitempty"`  // native text completion (FIM) endpoint, e.g. DeepSeek's beta one; default = translated to chat
+	APIKeys         []string         `json:"api_keys,omitempty"`         // extra keys for base_url, load balanced with api_key
+	Endpoints       []EndpointConfig `json:"endpoints,omitempty"`        // extra base_url/api_key pairs in the same pool
+	Sticky          bool             `json:"sticky,omitempty"`           // send every turn of a conversation to the same endpoint of the pool
+	Scripts         ScriptsConfig    `json:"scripts,omitzero"`           // transform scripts for requests to and responses from this provider
+	Headers         HeaderRules      `json:"headers,omitzero"`           // header rewrites for this provider, after the global ones
+	CacheControl    CacheControl     `json:"cache_control,omitzero"`     // prompt-cache breakpoints added to requests; type anthropic only
+}
+
+// SanitizeConfig adjusts request parameters a provider would reject.
+type SanitizeConfig struct {
+	Drop  []string              `json:"drop,omitempty"`  // parameters removed from requests
+	Clamp map[string][2]float64 `json:"clamp,omitempty"` // parameter → [min, max] numeric values are clamped to
+}
+
+// ScriptsConfig names the script files run on a provider's traffic; see
+// package script for the language.
+type ScriptsConfig struct {
+	Request  string `json:"request,omitempty"`  // run on each upstream request: may change body and headers
+	Response string `json:"response,omitempty"` // run on each chat response with status 200, or on each chunk of streamed ones
+}
+
+// HeaderRules rewrite the headers of upstream requests and of the
+// upstream responses passed on to clients.
+type HeaderRules struct {
+	Request  HeaderRewrite `json:"request,omitzero"`  // applied to requests sent upstream
+	Response HeaderRewrite `json:"response,omitzero"` // applied to upstream responses before they reach the client
+}
+
+// HeaderRewrite renames, then removes, then sets headers.
+type HeaderRewrite struct {
+	Rename map[string]string `json:"rename,omitempty"` // header → new name, keeping its values
+	Remove []string          `json:"remove,omitempty"` // headers dropped
+	Set    map[string]string `json:"set,omitempty"`    // header → value, replacing any already there
+}
+
+// RoleConfig normalizes the roles of request messages for upstreams that
+// reject some of what OpenAI clients send.
+type RoleConfig struct {
+	Developer string `json:"developer,omitempty"` // role developer messages are sent as: "system", "user" or "developer"; default by type
+	Merge     bool   `json:"merge,omitempty"`     // join consecutive messages of the same role
+	Alternate bool   `json:"alternate,omitempty"` // user and assistant turns alternate, starting with user; implies merge
+}
+
+// SyntheticStream paces the stream a complete upstream response is replayed
+// as, for streaming clients of an upstream_stream "never" provider.
+type SyntheticStream struct {
+	ChunkSize  int      `json:"chunk_size,omitempty"`  // characters per delta; 0 = the whole text in one delta
+	ChunkDelay Duration `json:"chunk_delay,omitempty"` // pause between deltas
+}
+
+// CacheControl places Anthropic prompt-cache breakpoints, which OpenAI
+// clients never send, on the parts of a request that repeat across turns.
+type CacheControl struct {
+	Tools     bool   `json:"tools,omitempty"`      // after the last tool definition
+	System    bool   `json:"system,omitempty"`     // after the system prompt
+	History   bool   `json:"history,omitempty"`    // after the last message before the final user turn
+	MinTokens int    `json:"min_tokens,omitempty"` // estimated prefix size a breakpoint needs; default 1024
+	TTL       string `json:"ttl,omitempty"`        // "5m" or "1h"; default 5m
+}
+
+// EndpointConfig is one member of a provider's load-balanced pool.
+type EndpointConfig struct {
+	BaseURL string `json:"base_url"`
+	APIKey  string `json:"api_key"`
+	Weight  int    `json:"weight,omitempty"` // relative share of traffic; default 1
+}
+
+// EndpointList flattens base_url/api_key, api_keys and endpoints into the
+// provider's pool. base_url with api_key always comes first.
+func (p ProviderConfig) EndpointList() []EndpointConfig {
+	var list []EndpointConfig
+	if p.BaseURL != "" {
+		list = append(list, EndpointConfig{BaseURL: p.BaseURL, APIKey: p.APIKey})
+		for _, key := range p.APIKeys {
+			list = append(list, EndpointConfig{BaseURL: p.BaseURL, APIKey: key})
+		}
+	}
+	return append(list, p.Endpoints...)
+}
+
+// UpstreamTLSConfig customizes TLS for connections to upstream providers,
+// e.g. an internal gateway behind a private CA or requiring mTLS.
+type UpstreamTLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`              // PEM bundle added to the system roots
+	CertFile           string `json:"cert_file,omitempty"`            // client certificate for mTLS
+	KeyFile            string `json:"key_file,omitempty"`             // client private key for mTLS
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // disables certificate verification (testing only)
+}
+
+// RetryConfig controls retries of transient upstream failures
+// (connection errors, 5xx, 429) before any response is sent to the client.
+type RetryConfig struct {
+	MaxAttempts    int      `json:"max_attempts,omitempty"`    // total attempts including the first; 0 or 1 = no retry
+	InitialBackoff Duration `json:"initial_backoff,omitempty"` // delay before the first retry; default 500ms
+	MaxBackoff     Duration `json:"max_backoff,omitempty"`     // cap for the doubled delay; default 10s
+	Jitter         float64  `json:"jitter,omitempty"`          // fraction (0..1) of each delay that is randomized
+}
+
+// HedgeConfig sends a second copy of a chat request the upstream has not
+// answered in time, keeping whichever answer comes first.
+type HedgeConfig struct {
+	After  Duration `json:"after,omitempty"`  // wait for response headers before the copy is sent; 0 = disabled
+	Models []string `json:"models,omitempty"` // model name patterns; empty = all
+}
+
+// Applies reports whether requests for model are hedged.
+func (h HedgeConfig) Applies(model string) bool {
+	return h.After > 0 && (len(h.Models) == 0 || matchAny(h.Models, model))
+}
+
+// RateLimitQueueConfig holds requests that got a 429 from the upstream and
+// retries them after Retry-After instead of returning the error to the client.
+type RateLimitQueueConfig struct {
+	MaxWait   Duration `json:"max_wait,omitempty"`   // total time one request may spend queued; 0 = disabled
+	MaxQueued int      `json:"max_queued,omitempty"` // requests waiting at once; default 100
+}
+
+// RateLimitConfig limits each client (identified by the bearer key it sends,
+// or its source IP) with token buckets.
+type RateLimitConfig struct {
+	RequestsPerMinute int    `json:"requests_per_minute,omitempty"` // 0 = unlimited
+	TokensPerMinute   int    `json:"tokens_per_minute,omitempty"`   // 0 = unlimited
+	KeyBy             string `json:"key_by,omitempty"`              // "key" (default, falls back to IP) or "ip"
+}
+
+// Enabled reports whether any limit is configured.
+func (c RateLimitConfig) Enabled() bool {
+	return c.RequestsPerMinute > 0 || c.TokensPerMinute > 0
+}
+
+// ConcurrencyConfig caps in-flight upstream requests; excess requests wait
+// in a FIFO queue.
+type ConcurrencyConfig struct {
+	MaxConcurrent int            `json:"max_concurrent,omitempty"` // across all models; 0 = unlimited
+	PerModel      map[string]int `json:"per_model,omitempty"`      // model → max in-flight requests
+	MaxQueue      int            `json:"max_queue,omitempty"`      // waiting requests per limit; default 100
+	QueueTimeout  Duration       `json:"queue_timeout,omitempty"`  // max time waiting for a slot; default 60s
+}
+
+// VirtualKey is a client-facing API key issued by the proxy. When any keys
+// are configured, clients must present one as their bearer token.
+type VirtualKey struct {
+	Name        string       `json:"name"`
+	Key         string       `json:"key"`
+	Budget      BudgetConfig `json:"budget,omitzero"`
+	AllowModels []string     `json:"allow_models,omitempty"` // model name patterns the key may use; empty = all
+	DenyModels  []string     `json:"deny_models,omitempty"`  // model name patterns always rejected (checked before allow_models)
+}
+
+// AllowsModel reports whether the key may request model. Patterns use
+// path.Match syntax, e.g. "deepseek-*".
+func (k VirtualKey) AllowsModel(model string) bool {
+	if matchAny(k.DenyModels, model) {
+		return false
+	}
+	return len(k.AllowModels) == 0 || matchAny(k.AllowModels, model)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// BudgetConfig caps usage per calendar day / month (local time).
+// Zero values mean unlimited.
+type BudgetConfig struct {
+	DailyTokens   int64   `json:"daily_tokens,omitempty"`
+	MonthlyTokens int64   `json:"monthly_tokens,omitempty"`
+	DailyCost     float64 `json:"daily_cost,omitempty"`   // currency units, computed from pricing
+	MonthlyCost   float64 `json:"monthly_cost,omitempty"` // currency units, computed from pricing
+}
+
+// Enabled reports whether any limit is configured.
+func (b BudgetConfig) Enabled() bool {
+	return b != BudgetConfig{}
+}
+
+// ModelPrice is the cost of a model per million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`  // per 1M prompt tokens
+	Output float64 `json:"output"` // per 1M completion tokens
+}
+
+// MockConfig scripts the canned completions returned in mock mode.
+type MockConfig struct {
+	Responses  []MockResponse `json:"responses,omitempty"`   // served in turn, cycling; default echoes the prompt
+	ChunkSize  int            `json:"chunk_size,omitempty"`  // characters per streamed delta; default 8
+	ChunkDelay Duration       `json:"chunk_delay,omitempty"` // pause between streamed deltas; default 20ms
+}
+
+// MockResponse is one canned completion. Template, when set, is a Go
+// text/template with .Model, .Prompt (last user message) and .Messages.
+type MockResponse struct {
+	Text      string         `json:"text,omitempty"`
+	Template  string         `json:"template,omitempty"`
+	Reasoning string         `json:"reasoning,omitempty"` // sent as reasoning_content
+	ToolCalls []MockToolCall `json:"tool_calls,omitempty"`
+}
+
+// MockToolCall is a scripted function call in a mock response.
+type MockToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"` // JSON object or encoded string
+}
+
+// ReasoningFormatConfig controls how upstream reasoning is embedded into
+// content for clients, and recognized when they send it back.
+type ReasoningFormatConfig struct {
+	Style  string `json:"style,omitempty"`  // "tag" (default), "blockquote" or "custom"
+	Tag    string `json:"tag,omitempty"`    // tag name for style "tag"; default "thought"
+	Prefix string `json:"prefix,omitempty"` // text before the reasoning for style "custom"
+	Suffix string `json:"suffix,omitempty"` // text after the reasoning for style "custom"
+}
+
+// SystemPromptRule injects instructions into requests for matching models or
+// providers. With a client system message the text is added before or after
+// its content; otherwise a new system message is inserted first.
+type SystemPromptRule struct {
+	Models        []string `json:"models,omitempty"`          // model names the rule applies to; "*" = all
+	Provider      string   `json:"provider,omitempty"`        // provider name the rule applies to
+	Text          string   `json:"text"`                      // instructions to inject
+	Position      string   `json:"position,omitempty"`        // "prepend" (default) or "append"
+	SkipIfPresent bool     `json:"skip_if_present,omitempty"` // leave requests that already have a system message alone
+}
+
+// Matches reports whether the rule applies to model served by provider.
+func (r SystemPromptRule) Matches(model, provider string) bool {
+	if r.Provider != "" && r.Provider == provider {
+		return true
+	}
+	for _, m := range r.Models {
+		if m == "*" || m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// ParamOverride sets request parameters for matching requests. Every
+// criterion given must match; a rule without criteria applies to all.
+type ParamOverride struct {
+	Models   []string       `json:"models,omitempty"`   // model name patterns, e.g. "deepseek-*"
+	Provider string         `json:"provider,omitempty"` // provider name
+	Key      string         `json:"key,omitempty"`      // virtual key name
+	Defaults map[string]any `json:"defaults,omitempty"` // set when the client did not send the parameter
+	Force    map[string]any `json:"force,omitempty"`    // always set, replacing the client's value
+}
+
+// Matches reports whether the rule applies to model served by provider for
+// the virtual key keyName.
+func (o ParamOverride) Matches(model, provider, keyName string) bool {
+	return (len(o.Models) == 0 || matchAny(o.Models, model)) &&
+		(o.Provider == "" || o.Provider == provider) &&
+		(o.Key == "" || o.Key == keyName)
+}
+
+// PathRoute sends every request under a path prefix to one provider,
+// whatever model it names. The prefix is removed before the request is
+// handled, so "/deepseek/v1/chat/completions" is served as
+// "/v1/chat/completions".
+type PathRoute struct {
+	Prefix   string `json:"prefix"`   // e.g. "/deepseek"; matched on whole path segments
+	Provider string `json:"provider"` // provider name
+}
+
+// reservedPrefixes are served by the proxy itself ahead of path routes.
+var reservedPrefixes = []string{"/admin", "/health", "/ready", "/debug", "/ws"}
+
+// RewriteRule changes the JSON body of matching requests at the location a
+// JSONPath selects. Every criterion given must match; a rule without
+// criteria applies to all.
+type RewriteRule struct {
+	Models   []string        `json:"models,omitempty"`   // model name patterns, e.g. "deepseek-*"
+	Provider string          `json:"provider,omitempty"` // provider name
+	Key      string          `json:"key,omitempty"`      // virtual key name
+	Path     string          `json:"path"`               // JSONPath of the values changed, e.g. "$.tools[*].function.strict"
+	Action   string          `json:"action"`             // "set", "delete" or "rename"
+	Value    json.RawMessage `json:"value,omitempty"`    // set: the value written
+	To       string          `json:"to,omitempty"`       // rename: the new key
+}
+
+// Matches reports whether the rule applies to model served by provider for
+// the virtual key keyName.
+func (r RewriteRule) Matches(model, provider, keyName string) bool {
+	return (len(r.Models) == 0 || matchAny(r.Models, model)) &&
+		(r.Provider == "" || r.Provider == provider) &&
+		(r.Key == "" || r.Key == keyName)
+}
+
+// TruncationConfig drops the oldest messages of requests that would not fit
+// the target model's context window. Token counts are estimated.
+type TruncationConfig struct {
+	ContextWindows map[string]int `json:"context_windows,omitempty"` // model → context window in tokens; "*" = default; empty = disabled
+	Reserve        int            `json:"reserve,omitempty"`         // tokens left for the completion when the request has no max_tokens; default 4096
+	Trim           bool           `json:"trim,omitempty"`            // also shorten the oldest message's text when dropping is not enough
+}
+
+// Window returns the context window for model, or 0 if none is configured.
+func (t TruncationConfig) Window(model string) int {
+	if w, ok := t.ContextWindows[model]; ok {
+		return w
+	}
+	return t.ContextWindows["*"]
+}
+
+// SummarizationConfig replaces the older turns of conversations above a
+// token threshold with a summary written by a (cheap) model.
+type SummarizationConfig struct {
+	Model      string `json:"model,omitempty"`       // model that writes summaries; empty = disabled
+	Threshold  int    `json:"threshold,omitempty"`   // estimated request tokens above which history is summarized
+	KeepRecent int    `json:"keep_recent,omitempty"` // most recent messages always kept verbatim; default 6
+	Prompt     string `json:"prompt,omitempty"`      // system prompt for the summary model
+}
+
+// JSONModeConfig validates the output of non-streaming requests that ask
+// for JSON with response_format, retrying when it is invalid.
+type JSONModeConfig struct {
+	Retries int `json:"retries,omitempty"` // corrective retries; 0 = output is not validated
+}
+
+// AutoContinueConfig continues answers cut off by the token limit
+// (finish_reason "length") or a dropped upstream stream with follow-up
+// requests.
+type AutoContinueConfig struct {
+	MaxContinuations int    `json:"max_continuations,omitempty"` // follow-up requests per answer; 0 = disabled
+	MaxResumes       int    `json:"max_resumes,omitempty"`       // follow-up requests per stream the upstream dropped; 0 = disabled
+	Prompt           string `json:"prompt,omitempty"`            // user message asking to continue
+}
+
+// ReasoningStoreConfig keeps upstream reasoning on the proxy and reattaches
+// it to assistant messages clients send back without it.
+type ReasoningStoreConfig struct {
+	Enabled    bool     `json:"enabled,omitempty"`
+	MaxEntries int      `json:"max_entries,omitempty"` // messages remembered; default 10000
+	TTL        Duration `json:"ttl,omitempty"`         // how long reasoning is kept; default 1h
+}
+
+// AdminConfig protects the /admin/ API. The API is disabled without a token.
+type AdminConfig struct {
+	Token string `json:"token,omitempty"` // bearer token required by admin endpoints
+}
+
+// CircuitBreakerConfig makes requests to a persistently failing provider fail
+// fast instead of each waiting for the upstream timeout.
+type CircuitBreakerConfig struct {
+	FailureThreshold int      `json:"failure_threshold,omitempty"` // consecutive failures that open the circuit; 0 = disabled
+	OpenDuration     Duration `json:"open_duration,omitempty"`     // how long to fail fast before probing again; default 30s
+}
+
+// HealthCheckConfig enables periodic probing of every upstream endpoint.
+type HealthCheckConfig struct {
+	Interval Duration `json:"interval,omitempty"` // time between probes; 0 = disabled
+	Timeout  Duration `json:"timeout,omitempty"`  // per-probe timeout; default 10s
+	Path     string   `json:"path,omitempty"`     // GET path appended to base_url; default "/models"
+}
+
+// TimeoutConfig splits upstream timeouts by phase.
+type TimeoutConfig struct {
+	Connect        Duration `json:"connect,omitempty"`         // TCP connect; default 30s
+	ResponseHeader Duration `json:"response_header,omitempty"` // wait for response headers; default 5m
+	Request        Duration `json:"request,omitempty"`         // whole request incl. body; default 0 = unlimited
+	StreamIdle     Duration `json:"stream_idle,omitempty"`     // max gap between SSE bytes; default 2m
+}
+
+// TransportConfig tunes the upstream connection pool. Zero values keep the
+// Go defaults noted per field.
+type TransportConfig struct {
+	MaxIdleConns        int      `json:"max_idle_conns,omitempty"`          // idle connections across all hosts; default 100
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host,omitempty"` // idle connections kept per host; default 2
+	MaxConnsPerHost     int      `json:"max_conns_per_host,omitempty"`      // 0 = unlimited
+	IdleConnTimeout     Duration `json:"idle_conn_timeout,omitempty"`       // default 90s
+	TLSHandshakeTimeout Duration `json:"tls_handshake_timeout,omitempty"`   // default 10s
+	HTTPVersion         string   `json:"http_version,omitempty"`            // "" = HTTP/2 when the upstream offers it, "http1" or "http2" to force
+}
+
+// EmbeddingsBatchConfig coalesces single-input embeddings requests that
+// arrive within Window into one upstream call.
+type EmbeddingsBatchConfig struct {
+	Window   Duration `json:"window,omitempty"`    // how long a batch collects requests; 0 = disabled
+	MaxBatch int      `json:"max_batch,omitempty"` // inputs per upstream call; default 64
+}
+
+// ModelListConfig serves /models from the proxy: the model lists of all
+// upstreams merged, with the aliases added, filtered and annotated.
+type ModelListConfig struct {
+	Enabled     bool     `json:"enabled,omitempty"`
+	Refresh     Duration `json:"refresh,omitempty"`      // how long the merged list is cached; default 5m
+	AllowModels []string `json:"allow_models,omitempty"` // model name patterns listed; empty = all
+	DenyModels  []string `json:"deny_models,omitempty"`  // model name patterns never listed (checked before allow_models)
+}
+
+// Lists reports whether model appears in the list.
+func (m ModelListConfig) Lists(model string) bool {
+	if matchAny(m.DenyModels, model) {
+		return false
+	}
+	return len(m.AllowModels) == 0 || matchAny(m.AllowModels, model)
+}
+
+// RedactConfig masks secrets in logged request and response content, such
+// as the streamed text debug mode prints. Authorization header values and
+// bearer tokens are always masked.
+type RedactConfig struct {
+	Patterns []string `json:"patterns,omitempty"` // regular expressions whose matches are masked
+}
+
+// PIIConfig masks personal data in the messages of chat requests before
+// they are sent upstream.
+type PIIConfig struct {
+	Emails   bool     `json:"emails,omitempty"`   // mask email addresses
+	Phones   bool     `json:"phones,omitempty"`   // mask phone numbers
+	Patterns []string `json:"patterns,omitempty"` // regular expressions whose matches are masked as well
+	Unmask   bool     `json:"unmask,omitempty"`   // use numbered placeholders and restore the originals in responses
+}
+
+// ModerationConfig checks chat requests before they are forwarded, against
+// an OpenAI-compatible moderations endpoint, a local list of keywords and
+// patterns, or both. Blocked requests never reach the upstream.
+type ModerationConfig struct {
+	URL      string   `json:"url,omitempty"`       // moderations endpoint, e.g. "https://api.openai.com/v1/moderations"; empty = not used
+	APIKey   string   `json:"api_key,omitempty"`   // bearer token for url
+	Model    string   `json:"model,omitempty"`     // model sent to url; empty = the endpoint's default
+	Timeout  Duration `json:"timeout,omitempty"`   // how long url may take; default 10s
+	FailOpen bool     `json:"fail_open,omitempty"` // forward requests when url fails instead of refusing them
+	Keywords []string `json:"keywords,omitempty"`  // words that block a request, matched case-insensitively
+	Patterns []string `json:"patterns,omitempty"`  // regular expressions that block a request
+}
+
+// ImagesConfig prepares the image_url parts of chat messages for upstreams
+// that only take inline images, limit their size or take none at all.
+type ImagesConfig struct {
+	Inline         bool     `json:"inline,omitempty"`           // fetch http(s) image URLs and send them as base64 data URLs
+	AllowPrivate   bool     `json:"allow_private,omitempty"`    // let inline fetch from loopback, private and link-local addresses
+	FetchTimeout   Duration `json:"fetch_timeout,omitempty"`    // per image; default 10s
+	MaxBytes       int      `json:"max_bytes,omitempty"`        // larger images are re-encoded, as JPEG unless a scaled PNG fits; 0 = no limit
+	MaxDimension   int      `json:"max_dimension,omitempty"`    // longest side in pixels larger images are scaled down to; 0 = no limit
+	TextOnly       []string `json:"text_only,omitempty"`        // model name patterns that do not accept images
+	TextOnlyAction string   `json:"text_only_action,omitempty"` // "reject" (default), "strip" or "describe"
+	DescribeModel  string   `json:"describe_model,omitempty"`   // vision model that describes images for describe
+	DescribePrompt string   `json:"describe_prompt,omitempty"`  // system prompt for the describe model
+}
+
+// IsTextOnly reports whether model matches a text_only pattern. Patterns
+// use path.Match syntax.
+func (c ImagesConfig) IsTextOnly(model string) bool {
+	return matchAny(c.TextOnly, model)
+}
+
+// GuardrailsConfig checks the content of chat responses, streamed or not.
+type GuardrailsConfig struct {
+	Rules  []GuardrailRule `json:"rules,omitempty"`  // applied in order
+	Window int             `json:"window,omitempty"` // bytes of streamed text held back so matches spanning chunks are caught; default 64
+}
+
+// GuardrailRule acts on the spans of a response matching Pattern or any of
+// Keywords.
+type GuardrailRule struct {
+	Name     string   `json:"name,omitempty"`     // shown in logs; default the pattern or keywords
+	Pattern  string   `json:"pattern,omitempty"`  // regular expression
+	Keywords []string `json:"keywords,omitempty"` // words matched case-insensitively
+	Action   string   `json:"action"`             // "redact", "block" or "alert"
+	Message  string   `json:"message,omitempty"`  // redact: replacement, default "[REDACTED]"; block: text ending the answer
+}
+
+// PluginConfig adds hooks that rewrite chat requests and responses: a Go
+// plugin compiled into the proxy and registered under Type, or with type
+// "exec" an external process.
+type PluginConfig struct {
+	Name     string         `json:"name"`
+	Type     string         `json:"type"`                // "exec", or the type a compiled-in plugin registered
+	Command  []string       `json:"command,omitempty"`   // exec: program and arguments
+	Hooks    []string       `json:"hooks,omitempty"`     // "request", "response" and/or "chunk"; empty = all
+	Models   []string       `json:"models,omitempty"`    // model name patterns, e.g. "deepseek-*"; empty = all
+	Timeout  Duration       `json:"timeout,omitempty"`   // per hook call; default 5s
+	FailOpen bool           `json:"fail_open,omitempty"` // forward requests the request hook failed on instead of refusing them
+	Options  map[string]any `json:"options,omitempty"`   // passed to compiled-in plugins
+}
+
+// Runs reports whether the plugin's hook applies to requests for model.
+func (p PluginConfig) Runs(hook, model string) bool {
+	return (len(p.Hooks) == 0 || slices.Contains(p.Hooks, hook)) &&
+		(len(p.Models) == 0 || matchAny(p.Models, model))
+}
+
+// MirrorConfig sends copies of chat requests to other models in the
+// background, to try a model on real traffic. Their answers never reach
+// the client.
+type MirrorConfig struct {
+	Rules       []MirrorRule `json:"rules,omitempty"`
+	MaxInFlight int          `json:"max_in_flight,omitempty"` // copies awaiting an answer at once; further requests are not copied; default 8
+	Timeout     Duration     `json:"timeout,omitempty"`       // per copy; default 2m
+}
+
+// MirrorRule copies the requests for matching models to Target.
+type MirrorRule struct {
+	Models  []string `json:"models,omitempty"`  // model name patterns, e.g. "deepseek-*"; empty = all
+	Key     string   `json:"key,omitempty"`     // virtual key name
+	Target  string   `json:"target"`            // model the copies are sent to
+	Percent float64  `json:"percent,omitempty"` // share of matching requests copied, 0-100; default 100
+	Log     bool     `json:"log,omitempty"`     // print the answers, not just status, latency and usage
+}
+
+// Matches reports whether the rule copies requests for model made with the
+// virtual key keyName.
+func (m MirrorRule) Matches(model, keyName string) bool {
+	return (len(m.Models) == 0 || matchAny(m.Models, model)) && (m.Key == "" || m.Key == keyName)
+}
+
+// Experiment splits the requests for Model between variants, each
+// conversation staying with the variant it was first assigned to.
+type Experiment struct {
+	Name     string              `json:"name"`
+	Model    string              `json:"model"` // model name clients request
+	Variants []ExperimentVariant `json:"variants"`
+}
+
+// ExperimentVariant is one model configuration under test.
+type ExperimentVariant struct {
+	Name    string         `json:"name"`
+	Model   string         `json:"model"`            // model the variant's requests are sent to
+	Percent float64        `json:"percent"`          // share of conversations; the variants' shares add up to 100
+	Params  map[string]any `json:"params,omitempty"` // parameters forced on the variant's requests, e.g. temperature
 }
 
 // Config is the top-level configuration.
 type Config struct {
-	Listen    string           `json:"listen"` // e.g. ":12000"
-	Debug     bool             `json:"debug"`
-	Providers []ProviderConfig `json:"providers"`
+	Listen          string                `json:"listen"`                    // e.g. ":12000" or "0.0.0.0:12000"
+	Host            string                `json:"host,omitempty"`            // bind host when listen has none; default "127.0.0.1"
+	UnixSocket      string                `json:"unix_socket,omitempty"`     // optional Unix domain socket path to listen on
+	TLSCert         string                `json:"tls_cert,omitempty"`        // PEM certificate path; enables HTTPS on the TCP listener
+	TLSKey          string                `json:"tls_key,omitempty"`         // PEM private key path
+	TLSSelfSigned   bool                  `json:"tls_self_signed,omitempty"` // generate a self-signed cert at tls_cert/tls_key if missing
+	Debug           bool                  `json:"debug"`
+	RequestDebug    bool                  `json:"request_debug,omitempty"` // honor X-Proxy-Debug: true on individual requests
+	DebugLog        string                `json:"debug_log,omitempty"`     // file receiving request dumps as JSON lines instead of the console; dumps every request in debug mode
+	Redact          RedactConfig          `json:"redact,omitzero"`
+	Providers       []ProviderConfig      `json:"providers"`
+	ReasoningFormat ReasoningFormatConfig `json:"reasoning_format,omitzero"`
+	ReasoningMode   string                `json:"reasoning_mode,omitempty"` // "merge" (default), "drop" or "native"; clients override with X-Reasoning-Mode
+	ReasoningStore  ReasoningStoreConfig  `json:"reasoning_store,omitzero"`
+	SystemPrompts   []SystemPromptRule    `json:"system_prompts,omitempty"`  // applied in order
+	ParamOverrides  []ParamOverride       `json:"param_overrides,omitempty"` // applied in order
+	Rewrites        []RewriteRule         `json:"rewrites,omitempty"`        // applied in order, after param_overrides
+	PII             PIIConfig             `json:"pii,omitzero"`
+	Moderation      ModerationConfig      `json:"moderation,omitzero"`
+	Images          ImagesConfig          `json:"images,omitzero"`
+	Guardrails      GuardrailsConfig      `json:"guardrails,omitzero"`
+	Plugins         []PluginConfig        `json:"plugins,omitempty"` // hooks run in order
+	Mirror          MirrorConfig          `json:"mirror,omitzero"`
+	Experiments     []Experiment          `json:"experiments,omitempty"`
+	Truncation      TruncationConfig      `json:"truncation,omitzero"`
+	Summarization   SummarizationConfig   `json:"summarization,omitzero"`
+	AutoContinue    AutoContinueConfig    `json:"auto_continue,omitzero"`
+	JSONMode        JSONModeConfig        `json:"json_mode,omitzero"`
+	RepairToolCalls bool                  `json:"repair_tool_calls,omitempty"` // buffer streamed tool call arguments and fix invalid JSON
+	StripLogprobs   bool                  `json:"strip_logprobs,omitempty"`    // remove logprobs from responses for clients that reject them
+	IPAllow         []string              `json:"ip_allow,omitempty"`          // CIDR ranges or single IPs allowed to connect; empty = allow all
+	IPDeny          []string              `json:"ip_deny,omitempty"`           // CIDR ranges or single IPs always rejected (checked before ip_allow)
+	UpstreamTLS     UpstreamTLSConfig     `json:"upstream_tls,omitzero"`
+	Headers         HeaderRules           `json:"headers,omitzero"`
+	OutboundProxy   string                `json:"outbound_proxy,omitempty"` // http(s):// or socks5:// proxy for upstream calls; empty = HTTP(S)_PROXY env
+	Retry           RetryConfig           `json:"retry,omitzero"`
+	Hedge           HedgeConfig           `json:"hedge,omitzero"`
+	RateLimitQueue  RateLimitQueueConfig  `json:"rate_limit_queue,omitzero"`
+	RateLimit       RateLimitConfig       `json:"rate_limit,omitzero"`
+	Concurrency     ConcurrencyConfig     `json:"concurrency,omitzero"`
+	Keys            []VirtualKey          `json:"keys,omitempty"`
+	Budget          BudgetConfig          `json:"budget,omitzero"`   // global budget across all clients
+	Pricing         map[string]ModelPrice `json:"pricing,omitempty"` // model (or "*") → price, for cost budgets
+	Mock            MockConfig            `json:"mock,omitzero"`     // used when started with -mock
+	Admin           AdminConfig           `json:"admin,omitzero"`
+	CircuitBreaker  CircuitBreakerConfig  `json:"circuit_breaker,omitzero"`
+	Fallbacks       map[string][]string   `json:"fallbacks,omitempty"`     // model → ordered fallback models tried when it fails
+	ModelAliases    map[string]string     `json:"model_aliases,omitempty"` // client model name → model actually requested, e.g. "gpt-4o" → "deepseek-chat"
+	Routes          []PathRoute           `json:"routes,omitempty"`        // path prefixes served by one provider each
+	HealthCheck     HealthCheckConfig     `json:"health_check,omitzero"`
+	Timeouts        TimeoutConfig         `json:"timeouts,omitzero"`
+	Transport       TransportConfig       `json:"transport,omitzero"`
+	EmbeddingsBatch EmbeddingsBatchConfig `json:"embeddings_batch,omitzero"`
+	ModelList       ModelListConfig       `json:"model_list,omitzero"`
+	StreamUsage     bool                  `json:"stream_usage,omitempty"`     // end every stream with a usage chunk, estimated if the upstream sends none
+	CacheHeaders    bool                  `json:"cache_headers,omitempty"`    // report the upstream's prompt cache hits in X-Proxy-Cache-* response headers
+	SSEKeepalive    Duration              `json:"sse_keepalive,omitempty"`    // interval of ": ping" comments on quiet streams; 0 = off
+	SSEMaxLine      int                   `json:"sse_max_line,omitempty"`     // longest upstream SSE line in bytes; default 16 MiB
+	GzipResponses   bool                  `json:"gzip_responses,omitempty"`   // gzip responses to clients that accept it
+	WebSocketBridge bool                  `json:"websocket_bridge,omitempty"` // accept chat requests over WebSocket at /ws/v1/chat/completions
+	ShutdownTimeout Duration              `json:"shutdown_timeout,omitempty"` // time in-flight requests may finish on SIGINT/SIGTERM; default 30s
 }
 
+// DefaultHost is the bind host used when neither listen nor host specify one,
+// so the proxy is not exposed on the LAN by accident.
+const DefaultHost = "127.0.0.1"
+
 // Load reads and parses a JSON config file.
 func Load(path string) (Config, error) {
 	data, err := os.ReadFile(path)
@@ -50,8 +656,13 @@ func (c Config) Validate() error {
 	if len(c.Providers) == 0 {
 		errs = append(errs, errors.New("no providers configured"))
 	}
-	if c.Listen == "" {
-		errs = append(errs, errors.New("listen address is required (e.g. \":12000\")"))
+	if c.Listen == "" && c.UnixSocket == "" {
+		errs = append(errs, errors.New("listen address or unix_socket is required (e.g. \":12000\")"))
+	}
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("invalid listen address %q: %w", c.Listen, err))
+		}
 	}
 
 	for i, p := range c.Providers {
@@ -65,7 +676,609 @@ func (c Config) Validate() error {
 		if p.BaseURL == "" {
 			errs = append(errs, fmt.Errorf("provider %q: base_url is required", p.Name))
 		}
+		for j, ep := range p.Endpoints {
+			if ep.BaseURL == "" {
+				errs = append(errs, fmt.Errorf("provider %q: endpoints[%d]: base_url is required", p.Name, j))
+			}
+			if ep.Weight < 0 {
+				errs = append(errs, fmt.Errorf("provider %q: endpoints[%d]: weight must not be negative", p.Name, j))
+			}
+		}
+		for name, rng := range p.Sanitize.Clamp {
+			if rng[0] > rng[1] {
+				errs = append(errs, fmt.Errorf("provider %q: sanitize.clamp[%q]: min exceeds max", p.Name, name))
+			}
+		}
+		switch p.Prefill {
+		case "", "prefix", "partial", "none":
+		default:
+			errs = append(errs, fmt.Errorf("provider %q: unknown prefill %q (use prefix, partial or none)", p.Name, p.Prefill))
+		}
+		switch p.Roles.Developer {
+		case "", "system", "user", "developer":
+		default:
+			errs = append(errs, fmt.Errorf("provider %q: unknown roles.developer %q (use system, user or developer)", p.Name, p.Roles.Developer))
+		}
+		if !ValidReasoningMode(p.ReasoningMode) {
+			errs = append(errs, fmt.Errorf("provider %q: unknown reasoning_mode %q (use merge, drop or native)", p.Name, p.ReasoningMode))
+		}
+		if p.JSONSchema != "" && p.JSONSchema != "native" && p.JSONSchema != "emulate" {
+			errs = append(errs, fmt.Errorf("provider %q: unknown json_schema %q (use native or emulate)", p.Name, p.JSONSchema))
+		}
+		if p.UpstreamStream != "" && p.UpstreamStream != "always" && p.UpstreamStream != "never" {
+			errs = append(errs, fmt.Errorf("provider %q: unknown upstream_stream %q (use always or never)", p.Name, p.UpstreamStream))
+		}
+		if p.SyntheticStream.ChunkSize < 0 || p.SyntheticStream.ChunkDelay < 0 {
+			errs = append(errs, fmt.Errorf("provider %q: synthetic_stream settings must not be negative", p.Name))
+		}
+		errs = append(errs, p.Headers.validate(fmt.Sprintf("provider %q: headers", p.Name))...)
+		if p.CacheControl != (CacheControl{}) && p.Type != "anthropic" {
+			errs = append(errs, fmt.Errorf("provider %q: cache_control needs type anthropic", p.Name))
+		}
+		if p.CacheControl.MinTokens < 0 {
+			errs = append(errs, fmt.Errorf("provider %q: cache_control.min_tokens must not be negative", p.Name))
+		}
+		if ttl := p.CacheControl.TTL; ttl != "" && ttl != "5m" && ttl != "1h" {
+			errs = append(errs, fmt.Errorf("provider %q: unknown cache_control.ttl %q (use 5m or 1h)", p.Name, ttl))
+		}
+		if p.CompletionsURL != "" {
+			if u, err := url.Parse(p.CompletionsURL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+				errs = append(errs, fmt.Errorf("provider %q: completions_url %q must be an http(s) URL", p.Name, p.CompletionsURL))
+			}
+		}
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	if c.TLSSelfSigned && c.TLSCert == "" {
+		errs = append(errs, errors.New("tls_self_signed requires tls_cert and tls_key paths"))
+	}
+	errs = append(errs, c.Headers.validate("headers")...)
+	if (c.UpstreamTLS.CertFile == "") != (c.UpstreamTLS.KeyFile == "") {
+		errs = append(errs, errors.New("upstream_tls: cert_file and key_file must be set together"))
+	}
+
+	if c.OutboundProxy != "" {
+		u, err := url.Parse(c.OutboundProxy)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("invalid outbound_proxy: %w", err))
+		case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" && u.Scheme != "socks5h":
+			errs = append(errs, fmt.Errorf("outbound_proxy: unsupported scheme %q (use http, https, socks5)", u.Scheme))
+		case u.Host == "":
+			errs = append(errs, fmt.Errorf("outbound_proxy %q: missing host", c.OutboundProxy))
+		}
+	}
+
+	if c.Retry.MaxAttempts < 0 {
+		errs = append(errs, errors.New("retry.max_attempts must not be negative"))
+	}
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		errs = append(errs, errors.New("retry.jitter must be between 0 and 1"))
+	}
+	if c.Retry.InitialBackoff < 0 || c.Retry.MaxBackoff < 0 {
+		errs = append(errs, errors.New("retry backoff durations must not be negative"))
+	}
+	if c.Hedge.After < 0 {
+		errs = append(errs, errors.New("hedge.after must not be negative"))
+	}
+	for _, p := range c.Hedge.Models {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			errs = append(errs, fmt.Errorf("hedge: invalid model pattern %q", p))
+		}
+	}
+
+	if c.RateLimitQueue.MaxWait < 0 || c.RateLimitQueue.MaxQueued < 0 {
+		errs = append(errs, errors.New("rate_limit_queue values must not be negative"))
+	}
+	if c.RateLimit.RequestsPerMinute < 0 || c.RateLimit.TokensPerMinute < 0 {
+		errs = append(errs, errors.New("rate_limit values must not be negative"))
+	}
+	if k := c.RateLimit.KeyBy; k != "" && k != "key" && k != "ip" {
+		errs = append(errs, fmt.Errorf("rate_limit.key_by must be \"key\" or \"ip\", got %q", k))
+	}
+	if cc := c.Concurrency; cc.MaxConcurrent < 0 || cc.MaxQueue < 0 || cc.QueueTimeout < 0 {
+		errs = append(errs, errors.New("concurrency values must not be negative"))
+	}
+	for model, limit := range c.Concurrency.PerModel {
+		if limit < 0 {
+			errs = append(errs, fmt.Errorf("concurrency.per_model[%q] must not be negative", model))
+		}
+	}
+	names, secrets := map[string]bool{}, map[string]bool{}
+	for i, k := range c.Keys {
+		if err := k.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("keys[%d]: %w", i, err))
+			continue
+		}
+		if names[k.Name] {
+			errs = append(errs, fmt.Errorf("keys[%d]: duplicate name %q", i, k.Name))
+		}
+		if secrets[k.Key] {
+			errs = append(errs, fmt.Errorf("keys[%d]: duplicate key", i))
+		}
+		names[k.Name], secrets[k.Key] = true, true
+	}
+	if err := c.Budget.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("budget: %w", err))
+	}
+	for model, price := range c.Pricing {
+		if price.Input < 0 || price.Output < 0 {
+			errs = append(errs, fmt.Errorf("pricing[%q]: prices must not be negative", model))
+		}
+	}
+	if err := c.ReasoningFormat.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("reasoning_format: %w", err))
+	}
+	if !ValidReasoningMode(c.ReasoningMode) {
+		errs = append(errs, fmt.Errorf("unknown reasoning_mode %q (use merge, drop or native)", c.ReasoningMode))
+	}
+	if c.ReasoningStore.MaxEntries < 0 || c.ReasoningStore.TTL < 0 {
+		errs = append(errs, errors.New("reasoning_store settings must not be negative"))
+	}
+	providers := make(map[string]bool, len(c.Providers))
+	for _, p := range c.Providers {
+		providers[p.Name] = true
+	}
+	prefixes := make(map[string]bool, len(c.Routes))
+	for i, pr := range c.Routes {
+		switch p := pr.Prefix; {
+		case !strings.HasPrefix(p, "/") || p == "/" || strings.HasSuffix(p, "/") || strings.Contains(p, "//"):
+			errs = append(errs, fmt.Errorf("routes[%d]: prefix %q must start with / and not end with /", i, p))
+		case slices.ContainsFunc(reservedPrefixes, func(r string) bool { return p == r || strings.HasPrefix(p, r+"/") }):
+			errs = append(errs, fmt.Errorf("routes[%d]: prefix %q is used by the proxy itself", i, p))
+		case prefixes[p]:
+			errs = append(errs, fmt.Errorf("routes[%d]: duplicate prefix %q", i, p))
+		}
+		prefixes[pr.Prefix] = true
+		if !providers[pr.Provider] {
+			errs = append(errs, fmt.Errorf("routes[%d]: unknown provider %q", i, pr.Provider))
+		}
+	}
+	for i, sp := range c.SystemPrompts {
+		if strings.TrimSpace(sp.Text) == "" {
+			errs = append(errs, fmt.Errorf("system_prompts[%d]: text is required", i))
+		}
+		if len(sp.Models) == 0 && sp.Provider == "" {
+			errs = append(errs, fmt.Errorf("system_prompts[%d]: models or provider is required", i))
+		}
+		if sp.Provider != "" && !providers[sp.Provider] {
+			errs = append(errs, fmt.Errorf("system_prompts[%d]: unknown provider %q", i, sp.Provider))
+		}
+		if sp.Position != "" && sp.Position != "prepend" && sp.Position != "append" {
+			errs = append(errs, fmt.Errorf("system_prompts[%d]: unknown position %q (use prepend or append)", i, sp.Position))
+		}
+	}
+	for i, o := range c.ParamOverrides {
+		if o.Provider != "" && !providers[o.Provider] {
+			errs = append(errs, fmt.Errorf("param_overrides[%d]: unknown provider %q", i, o.Provider))
+		}
+		for _, p := range o.Models {
+			if _, err := path.Match(p, ""); err != nil || p == "" {
+				errs = append(errs, fmt.Errorf("param_overrides[%d]: invalid model pattern %q", i, p))
+			}
+		}
+		for _, params := range []map[string]any{o.Defaults, o.Force} {
+			for name := range params {
+				if name == "model" || name == "messages" {
+					errs = append(errs, fmt.Errorf("param_overrides[%d]: %q cannot be overridden", i, name))
+				}
+			}
+		}
+	}
+	for i, rw := range c.Rewrites {
+		if rw.Provider != "" && !providers[rw.Provider] {
+			errs = append(errs, fmt.Errorf("rewrites[%d]: unknown provider %q", i, rw.Provider))
+		}
+		for _, p := range rw.Models {
+			if _, err := path.Match(p, ""); err != nil || p == "" {
+				errs = append(errs, fmt.Errorf("rewrites[%d]: invalid model pattern %q", i, p))
+			}
+		}
+		steps, err := ParseJSONPath(rw.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rewrites[%d]: %w", i, err))
+			continue
+		}
+		switch rw.Action {
+		case "delete":
+		case "set":
+			if len(rw.Value) == 0 {
+				errs = append(errs, fmt.Errorf("rewrites[%d]: set needs a value", i))
+			}
+		case "rename":
+			if rw.To == "" || !steps[len(steps)-1].IsKey {
+				errs = append(errs, fmt.Errorf("rewrites[%d]: rename needs a path ending in a key and a non-empty to", i))
+			}
+			if slices.ContainsFunc(steps, func(s PathStep) bool { return s.All }) {
+				errs = append(errs, fmt.Errorf("rewrites[%d]: rename cannot use a [*] or .* path", i))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("rewrites[%d]: unknown action %q (use set, delete or rename)", i, rw.Action))
+		}
+	}
+	for model, w := range c.Truncation.ContextWindows {
+		if w <= 0 {
+			errs = append(errs, fmt.Errorf("truncation.context_windows[%q] must be positive", model))
+		}
+	}
+	if c.Truncation.Reserve < 0 {
+		errs = append(errs, errors.New("truncation.reserve must not be negative"))
+	}
+	if c.Summarization.Model != "" && c.Summarization.Threshold <= 0 {
+		errs = append(errs, errors.New("summarization.threshold must be positive"))
+	}
+	if c.Summarization.KeepRecent < 0 {
+		errs = append(errs, errors.New("summarization.keep_recent must not be negative"))
+	}
+	if c.AutoContinue.MaxContinuations < 0 || c.AutoContinue.MaxResumes < 0 {
+		errs = append(errs, errors.New("auto_continue limits must not be negative"))
+	}
+	if c.JSONMode.Retries < 0 {
+		errs = append(errs, errors.New("json_mode.retries must not be negative"))
+	}
+	if c.Mock.ChunkSize < 0 || c.Mock.ChunkDelay < 0 {
+		errs = append(errs, errors.New("mock chunk settings must not be negative"))
+	}
+	for i, mr := range c.Mock.Responses {
+		for j, tc := range mr.ToolCalls {
+			if tc.Name == "" {
+				errs = append(errs, fmt.Errorf("mock.responses[%d].tool_calls[%d]: name is required", i, j))
+			}
+		}
+	}
+	if c.CircuitBreaker.FailureThreshold < 0 || c.CircuitBreaker.OpenDuration < 0 {
+		errs = append(errs, errors.New("circuit_breaker values must not be negative"))
+	}
+
+	if t := c.Timeouts; t.Connect < 0 || t.ResponseHeader < 0 || t.Request < 0 || t.StreamIdle < 0 {
+		errs = append(errs, errors.New("timeouts must not be negative"))
+	}
+	if t := c.Transport; t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 ||
+		t.IdleConnTimeout < 0 || t.TLSHandshakeTimeout < 0 {
+		errs = append(errs, errors.New("transport values must not be negative"))
+	}
+	if c.EmbeddingsBatch.Window < 0 || c.EmbeddingsBatch.MaxBatch < 0 {
+		errs = append(errs, errors.New("embeddings_batch values must not be negative"))
+	}
+	if c.ModelList.Refresh < 0 {
+		errs = append(errs, errors.New("model_list.refresh must not be negative"))
+	}
+	for _, p := range append(slices.Clone(c.ModelList.AllowModels), c.ModelList.DenyModels...) {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			errs = append(errs, fmt.Errorf("model_list: invalid model pattern %q", p))
+		}
+	}
+	for _, p := range c.Redact.Patterns {
+		if _, err := regexp.Compile(p); err != nil || p == "" {
+			errs = append(errs, fmt.Errorf("redact: invalid pattern %q", p))
+		}
+	}
+	for _, p := range c.PII.Patterns {
+		if _, err := regexp.Compile(p); err != nil || p == "" {
+			errs = append(errs, fmt.Errorf("pii: invalid pattern %q", p))
+		}
+	}
+	if m := c.Moderation; m.URL != "" {
+		if u, err := url.Parse(m.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("moderation.url %q must be an http(s) URL", m.URL))
+		}
+	}
+	if c.Moderation.Timeout < 0 {
+		errs = append(errs, errors.New("moderation.timeout must not be negative"))
+	}
+	for _, k := range c.Moderation.Keywords {
+		if strings.TrimSpace(k) == "" {
+			errs = append(errs, errors.New("moderation.keywords must not be empty"))
+			break
+		}
+	}
+	for _, p := range c.Moderation.Patterns {
+		if _, err := regexp.Compile(p); err != nil || p == "" {
+			errs = append(errs, fmt.Errorf("moderation: invalid pattern %q", p))
+		}
+	}
+	if im := c.Images; im.FetchTimeout < 0 || im.MaxBytes < 0 || im.MaxDimension < 0 {
+		errs = append(errs, errors.New("images values must not be negative"))
+	}
+	for _, p := range c.Images.TextOnly {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			errs = append(errs, fmt.Errorf("images.text_only: invalid model pattern %q", p))
+		}
+	}
+	switch c.Images.TextOnlyAction {
+	case "", "reject", "strip":
+	case "describe":
+		if c.Images.DescribeModel == "" {
+			errs = append(errs, errors.New("images.describe_model is required for text_only_action describe"))
+		} else if c.Images.IsTextOnly(c.Images.DescribeModel) {
+			errs = append(errs, fmt.Errorf("images.describe_model %q is itself text_only", c.Images.DescribeModel))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("images.text_only_action: unknown action %q (use reject, strip or describe)", c.Images.TextOnlyAction))
+	}
+	for i, g := range c.Guardrails.Rules {
+		if g.Pattern == "" && len(g.Keywords) == 0 {
+			errs = append(errs, fmt.Errorf("guardrails.rules[%d]: pattern or keywords is required", i))
+		}
+		if _, err := regexp.Compile(g.Pattern); err != nil {
+			errs = append(errs, fmt.Errorf("guardrails.rules[%d]: invalid pattern %q", i, g.Pattern))
+		}
+		if slices.ContainsFunc(g.Keywords, func(k string) bool { return strings.TrimSpace(k) == "" }) {
+			errs = append(errs, fmt.Errorf("guardrails.rules[%d]: keywords must not be empty", i))
+		}
+		switch g.Action {
+		case "redact", "block", "alert":
+		default:
+			errs = append(errs, fmt.Errorf("guardrails.rules[%d]: action %q must be redact, block or alert", i, g.Action))
+		}
+	}
+	if c.Guardrails.Window < 0 {
+		errs = append(errs, errors.New("guardrails.window must not be negative"))
+	}
+	pluginNames := make(map[string]bool)
+	for i, pl := range c.Plugins {
+		if pl.Name == "" {
+			errs = append(errs, fmt.Errorf("plugins[%d]: name is required", i))
+		} else if pluginNames[pl.Name] {
+			errs = append(errs, fmt.Errorf("plugins[%d]: duplicate name %q", i, pl.Name))
+		}
+		pluginNames[pl.Name] = true
+		if pl.Type == "" {
+			errs = append(errs, fmt.Errorf("plugins[%d]: type is required", i))
+		}
+		if pl.Type == "exec" && (len(pl.Command) == 0 || pl.Command[0] == "") {
+			errs = append(errs, fmt.Errorf("plugins[%d]: command is required for type exec", i))
+		}
+		for _, hook := range pl.Hooks {
+			if hook != "request" && hook != "response" && hook != "chunk" {
+				errs = append(errs, fmt.Errorf("plugins[%d]: unknown hook %q (use request, response or chunk)", i, hook))
+			}
+		}
+		for _, p := range pl.Models {
+			if _, err := path.Match(p, ""); err != nil || p == "" {
+				errs = append(errs, fmt.Errorf("plugins[%d]: invalid model pattern %q", i, p))
+			}
+		}
+		if pl.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("plugins[%d]: timeout must not be negative", i))
+		}
+	}
+	for i, m := range c.Mirror.Rules {
+		if m.Target == "" {
+			errs = append(errs, fmt.Errorf("mirror.rules[%d]: target is required", i))
+		}
+		for _, p := range m.Models {
+			if _, err := path.Match(p, ""); err != nil || p == "" {
+				errs = append(errs, fmt.Errorf("mirror.rules[%d]: invalid model pattern %q", i, p))
+			}
+		}
+		if m.Percent < 0 || m.Percent > 100 {
+			errs = append(errs, fmt.Errorf("mirror.rules[%d]: percent must be between 0 and 100", i))
+		}
+	}
+	if c.Mirror.MaxInFlight < 0 || c.Mirror.Timeout < 0 {
+		errs = append(errs, errors.New("mirror settings must not be negative"))
+	}
+	experiments := make(map[string]bool, len(c.Experiments))
+	experimentModels := make(map[string]bool, len(c.Experiments))
+	for i, e := range c.Experiments {
+		if e.Name == "" || experiments[e.Name] {
+			errs = append(errs, fmt.Errorf("experiments[%d]: name %q must be non-empty and unique", i, e.Name))
+		}
+		if e.Model == "" || experimentModels[e.Model] {
+			errs = append(errs, fmt.Errorf("experiments[%d]: model %q must be non-empty and in no other experiment", i, e.Model))
+		}
+		experiments[e.Name], experimentModels[e.Model] = true, true
+		if len(e.Variants) < 2 {
+			errs = append(errs, fmt.Errorf("experiments[%d]: at least two variants are required", i))
+		}
+		variants := make(map[string]bool, len(e.Variants))
+		total := 0.0
+		for j, v := range e.Variants {
+			if v.Name == "" || variants[v.Name] {
+				errs = append(errs, fmt.Errorf("experiments[%d].variants[%d]: name %q must be non-empty and unique", i, j, v.Name))
+			}
+			variants[v.Name] = true
+			if v.Model == "" {
+				errs = append(errs, fmt.Errorf("experiments[%d].variants[%d]: model is required", i, j))
+			}
+			if v.Percent <= 0 {
+				errs = append(errs, fmt.Errorf("experiments[%d].variants[%d]: percent must be positive", i, j))
+			}
+			total += v.Percent
+			for name := range v.Params {
+				if name == "model" || name == "messages" {
+					errs = append(errs, fmt.Errorf("experiments[%d].variants[%d]: %q cannot be set in params", i, j, name))
+				}
+			}
+		}
+		if math.Abs(total-100) > 1e-9 {
+			errs = append(errs, fmt.Errorf("experiments[%d]: variant percents add up to %v, not 100", i, total))
+		}
+	}
+	switch c.Transport.HTTPVersion {
+	case "", "http1", "http2":
+	default:
+		errs = append(errs, fmt.Errorf("transport.http_version %q must be http1 or http2", c.Transport.HTTPVersion))
+	}
+	if c.SSEKeepalive < 0 {
+		errs = append(errs, errors.New("sse_keepalive must not be negative"))
+	}
+	if c.SSEMaxLine < 0 {
+		errs = append(errs, errors.New("sse_max_line must not be negative"))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("shutdown_timeout must not be negative"))
+	}
+	if c.HealthCheck.Interval < 0 || c.HealthCheck.Timeout < 0 {
+		errs = append(errs, errors.New("health_check durations must not be negative"))
+	}
+
+	for alias, model := range c.ModelAliases {
+		if alias == "" || model == "" || alias == model {
+			errs = append(errs, fmt.Errorf("model_aliases[%q]: invalid target model %q", alias, model))
+		}
+	}
+	for model, chain := range c.Fallbacks {
+		for _, fb := range chain {
+			if fb == "" || fb == model {
+				errs = append(errs, fmt.Errorf("fallbacks[%q]: invalid fallback model %q", model, fb))
+			}
+		}
+	}
+
+	for _, s := range c.IPAllow {
+		if _, err := ParsePrefix(s); err != nil {
+			errs = append(errs, fmt.Errorf("ip_allow: %w", err))
+		}
+	}
+	for _, s := range c.IPDeny {
+		if _, err := ParsePrefix(s); err != nil {
+			errs = append(errs, fmt.Errorf("ip_deny: %w", err))
+		}
 	}
 
 	return errors.Join(errs...)
 }
+
+// Validate checks a single virtual key.
+func (k VirtualKey) Validate() error {
+	if k.Name == "" {
+		return errors.New("name is required")
+	}
+	if k.Key == "" {
+		return fmt.Errorf("key %q: key is required", k.Name)
+	}
+	if err := k.Budget.Validate(); err != nil {
+		return fmt.Errorf("key %q: budget: %w", k.Name, err)
+	}
+	for _, p := range append(slices.Clone(k.AllowModels), k.DenyModels...) {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			return fmt.Errorf("key %q: invalid model pattern %q", k.Name, p)
+		}
+	}
+	return nil
+}
+
+// Validate checks that budget limits are non-negative.
+func (b BudgetConfig) Validate() error {
+	if b.DailyTokens < 0 || b.MonthlyTokens < 0 || b.DailyCost < 0 || b.MonthlyCost < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// Validate checks the style and that the markers can be found again in
+// content sent back by clients.
+func (f ReasoningFormatConfig) Validate() error {
+	switch f.Style {
+	case "", "tag":
+		if f.Tag != "" && !isTagName(f.Tag) {
+			return fmt.Errorf("invalid tag name %q", f.Tag)
+		}
+	case "blockquote":
+	case "custom":
+		if strings.TrimSpace(f.Prefix) == "" || strings.TrimSpace(f.Suffix) == "" {
+			return errors.New("style \"custom\" requires non-blank prefix and suffix")
+		}
+	default:
+		return fmt.Errorf("unknown style %q (use tag, blockquote or custom)", f.Style)
+	}
+	return nil
+}
+
+// ValidReasoningMode reports whether mode is a known reasoning mode;
+// empty means the default ("merge", or the global mode for a provider).
+func ValidReasoningMode(mode string) bool {
+	switch mode {
+	case "", "merge", "drop", "native":
+		return true
+	}
+	return false
+}
+
+func isTagName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case i > 0 && (r >= '0' && r <= '9' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ListenAddr returns the TCP address to bind, filling in Host (or DefaultHost)
+// when listen only specifies a port. Returns "" when no TCP listener is configured.
+func (c Config) ListenAddr() string {
+	if c.Listen == "" {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(c.Listen)
+	if err != nil || host != "" {
+		return c.Listen
+	}
+	host = c.Host
+	if host == "" {
+		host = DefaultHost
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// fixedHeaders are managed by the HTTP transport and cannot be rewritten.
+var fixedHeaders = []string{"connection", "content-length", "host", "transfer-encoding"}
+
+func (h HeaderRules) validate(where string) []error {
+	var errs []error
+	for i, rw := range []HeaderRewrite{h.Request, h.Response} {
+		dir := []string{"request", "response"}[i]
+		var names []string
+		for _, from := range slices.Sorted(maps.Keys(rw.Rename)) {
+			names = append(names, from, rw.Rename[from])
+		}
+		names = append(names, rw.Remove...)
+		names = append(names, slices.Sorted(maps.Keys(rw.Set))...)
+		for _, name := range names {
+			if !validHeaderName(name) {
+				errs = append(errs, fmt.Errorf("%s.%s: invalid header name %q", where, dir, name))
+			} else if slices.Contains(fixedHeaders, strings.ToLower(name)) {
+				errs = append(errs, fmt.Errorf("%s.%s: %s cannot be rewritten", where, dir, name))
+			}
+		}
+	}
+	return errs
+}
+
+// validHeaderName reports whether name is an HTTP token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ParsePrefix parses a CIDR range ("10.0.0.0/8") or a single IP address
+// ("192.168.1.5", treated as a /32 or /128).
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
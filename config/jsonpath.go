@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathStep is one step of a JSONPath: an object key, an array index
+// (negative counts from the end) or every element.
+type PathStep struct {
+	IsKey bool // .name or ['name']
+	Key   string
+	Index int  // [n]
+	All   bool // [*] or .*
+}
+
+// ParseJSONPath parses the JSONPath subset used by rewrite rules: "$"
+// followed by .name, ['name'], [n] and [*] / .* steps. The leading "$." may
+// be left out.
+func ParseJSONPath(s string) ([]PathStep, error) {
+	rest := s
+	switch {
+	case strings.HasPrefix(rest, "$"):
+		rest = rest[1:]
+	case rest != "" && rest[0] != '.' && rest[0] != '[':
+		rest = "." + rest
+	}
+	var steps []PathStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : 1+end]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("invalid JSONPath %q: empty name", s)
+			case "*":
+				steps = append(steps, PathStep{All: true})
+			default:
+				steps = append(steps, PathStep{Key: name, IsKey: true})
+			}
+			rest = rest[1+end:]
+		case '[':
+			if len(rest) > 1 && (rest[1] == '\'' || rest[1] == '"') {
+				end := strings.Index(rest[2:], string(rest[1])+"]")
+				if end < 0 {
+					return nil, fmt.Errorf("invalid JSONPath %q: unterminated name", s)
+				}
+				steps = append(steps, PathStep{Key: rest[2 : 2+end], IsKey: true})
+				rest = rest[2+end+2:]
+				continue
+			}
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q: missing ]", s)
+			}
+			if inner := rest[1:end]; inner == "*" {
+				steps = append(steps, PathStep{All: true})
+			} else if n, err := strconv.Atoi(inner); err == nil {
+				steps = append(steps, PathStep{Index: n})
+			} else {
+				return nil, fmt.Errorf("invalid JSONPath %q: bad index [%s]", s, inner)
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q: unexpected %q", s, rest[0])
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("invalid JSONPath %q: selects the whole body", s)
+	}
+	return steps, nil
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	key := func(k string) PathStep { return PathStep{IsKey: true, Key: k} }
+	index := func(n int) PathStep { return PathStep{Index: n} }
+	all := PathStep{All: true}
+
+	tests := []struct {
+		path string
+		want []PathStep
+	}{
+		{"$.max_tokens", []PathStep{key("max_tokens")}},
+		{"max_tokens", []PathStep{key("max_tokens")}},
+		{".max_tokens", []PathStep{key("max_tokens")}},
+		{"$.a.b.c", []PathStep{key("a"), key("b"), key("c")}},
+		{"$['a.b']", []PathStep{key("a.b")}},
+		{`$["a'b"]`, []PathStep{key("a'b")}},
+		{"$['a]b'].c", []PathStep{key("a]b"), key("c")}},
+		{"$['']", []PathStep{key("")}},
+		{"$['*']", []PathStep{key("*")}},
+		{"$.list[0]", []PathStep{key("list"), index(0)}},
+		{"$.list[-1]", []PathStep{key("list"), index(-1)}},
+		{"$.list[12].name", []PathStep{key("list"), index(12), key("name")}},
+		{"[0]", []PathStep{index(0)}},
+		{"$.messages[*].name", []PathStep{key("messages"), all, key("name")}},
+		{"$.obj.*", []PathStep{key("obj"), all}},
+		{"$.*.x", []PathStep{all, key("x")}},
+		{"$[*][*]", []PathStep{all, all}},
+		{"$.a[0][1]", []PathStep{key("a"), index(0), index(1)}},
+	}
+	for _, tt := range tests {
+		got, err := ParseJSONPath(tt.path)
+		if err != nil {
+			t.Errorf("ParseJSONPath(%q): %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseJSONPath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseJSONPathErrors(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "selects the whole body"},
+		{"$", "selects the whole body"},
+		{"$.", "empty name"},
+		{"$.a..b", "empty name"},
+		{"$.a.", "empty name"},
+		{"$.list[", "missing ]"},
+		{"$.list[0", "missing ]"},
+		{"$.list[]", "bad index"},
+		{"$.list[x]", "bad index"},
+		{"$.list[1.5]", "bad index"},
+		{"$.list[**]", "bad index"},
+		{"$['a", "unterminated name"},
+		{`$["a']`, "unterminated name"},
+		{"$x", "unexpected"},
+		{"$.a[0]b", "unexpected"},
+	}
+	for _, tt := range tests {
+		_, err := ParseJSONPath(tt.path)
+		if err == nil {
+			t.Errorf("ParseJSONPath(%q) succeeded, want an error", tt.path)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseJSONPath(%q) = %v, want an error containing %q", tt.path, err, tt.want)
+		}
+	}
+}
+
+func TestValidateRewrites(t *testing.T) {
+	tests := []struct {
+		rule RewriteRule
+		want string // "" = valid
+	}{
+		{RewriteRule{Path: "$.max_tokens", Action: "delete"}, ""},
+		{RewriteRule{Path: "$.messages[*].name", Action: "delete"}, ""},
+		{RewriteRule{Path: "$.a", Action: "set", Value: []byte(`1`)}, ""},
+		{RewriteRule{Path: "$.a", Action: "set"}, "set needs a value"},
+		{RewriteRule{Path: "$.a", Action: "rename", To: "b"}, ""},
+		{RewriteRule{Path: "$.a", Action: "rename"}, "rename needs"},
+		{RewriteRule{Path: "$.a[0]", Action: "rename", To: "b"}, "rename needs"},
+		{RewriteRule{Path: "$.obj.*", Action: "rename", To: "b"}, "rename cannot use"},
+		{RewriteRule{Path: "$.messages[*].name", Action: "rename", To: "n"}, "rename cannot use"},
+		{RewriteRule{Path: "$.*.name", Action: "rename", To: "n"}, "rename cannot use"},
+		{RewriteRule{Path: "$.a[", Action: "delete"}, "missing ]"},
+		{RewriteRule{Path: "$.a", Action: "move"}, "unknown action"},
+		{RewriteRule{Path: "$.a", Action: "delete", Provider: "other"}, "unknown provider"},
+	}
+	for _, tt := range tests {
+		cfg := Config{
+			Listen:    ":12000",
+			Providers: []ProviderConfig{{Name: "p", Type: "deepseek", BaseURL: "http://localhost"}},
+			Rewrites:  []RewriteRule{tt.rule},
+		}
+		err := cfg.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%+v: %v", tt.rule, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%+v: got %v, want an error containing %q", tt.rule, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"llm-local-proxy/proxy"
+)
+
+// exportHAR converts -record recordings to a HAR file for browser devtools,
+// Fiddler and similar tools. Returns the process exit code.
+func exportHAR(args []string) int {
+	fs := flag.NewFlagSet("har", flag.ExitOnError)
+	out := fs.String("o", "", "输出的 HAR 文件（默认输出到标准输出）")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: llm-local-proxy har [-o out.har] <录制文件或目录>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var files []string
+	for _, arg := range fs.Args() {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			matches, _ := filepath.Glob(filepath.Join(arg, "*.jsonl"))
+			slices.Sort(matches)
+			files = append(files, matches...)
+		} else {
+			files = append(files, arg)
+		}
+	}
+	var recs []proxy.Recording
+	for _, name := range files {
+		r, err := proxy.ReadRecordings(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 读取录制失败 %s: %v\n", name, err)
+			return 1
+		}
+		recs = append(recs, r...)
+	}
+	if len(recs) == 0 {
+		fmt.Fprintln(os.Stderr, "❌ 没有找到录制")
+		return 1
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(proxy.NewHAR(recs, version)); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 写入 HAR 失败: %v\n", err)
+		return 1
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "✅ %d 条记录已导出到 %s\n", len(recs), *out)
+	}
+	return 0
+}
@@ -1,22 +1,77 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"llm-local-proxy/config"
-	"llm-local-proxy/provider"
 	"llm-local-proxy/proxy"
 )
 
+// defaultShutdownTimeout bounds how long in-flight streams may finish on exit.
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
+	cmd, args := "serve", os.Args[1:]
+	// Without a subcommand (or with flags first) the proxy serves, as before
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "validate-config":
+		os.Exit(validateConfig(args))
+	case "test":
+		os.Exit(testCompletion(args))
+	case "chat":
+		os.Exit(chat(args))
+	case "monitor":
+		os.Exit(monitor(args))
+	case "har":
+		os.Exit(exportHAR(args))
+	case "version":
+		printVersion()
+	case "help":
+		usage()
+	default:
+		fmt.Printf("❌ 未知子命令: %s\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// serve runs the proxy until SIGINT/SIGTERM.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
 	var configFile string
-	var debug bool
-	flag.StringVar(&configFile, "config", "config.json", "配置文件路径")
-	flag.BoolVar(&debug, "debug", false, "启用调试模式")
-	flag.Parse()
+	var debug, mock, pprof bool
+	var recordDir, replayDir, replayMatch string
+	var chaos proxy.ChaosOptions
+	fs.StringVar(&configFile, "config", "config.json", "配置文件路径")
+	fs.BoolVar(&debug, "debug", false, "启用调试模式")
+	fs.BoolVar(&pprof, "pprof", false, "挂载 /debug/pprof/ 与 /debug/runtime 诊断接口")
+	fs.BoolVar(&mock, "mock", false, "模拟上游：返回配置中的固定回复，不访问任何 Provider")
+	fs.StringVar(&recordDir, "record", "", "将请求/响应记录为 JSONL 写入该目录")
+	fs.StringVar(&replayDir, "replay", "", "从该目录的录制文件回放响应，不访问上游")
+	fs.StringVar(&replayMatch, "replay-match", "hash", "回放匹配方式: hash | sequence")
+	fs.Float64Var(&chaos.ErrorRate, "chaos-error", 0, "故障注入：直接返回 500 的概率 (0-1)")
+	fs.Float64Var(&chaos.DisconnectRate, "chaos-disconnect", 0, "故障注入：响应中途断开连接的概率 (0-1)")
+	fs.Float64Var(&chaos.SlowRate, "chaos-slow", 0, "故障注入：单个流式分块延迟发送的概率 (0-1)")
+	fs.DurationVar(&chaos.SlowDelay, "chaos-slow-delay", 2*time.Second, "故障注入：慢分块的延迟")
+	fs.Float64Var(&chaos.MalformedRate, "chaos-malformed", 0, "故障注入：单行 SSE 数据被截断的概率 (0-1)")
+	fs.Parse(args)
 
 	cfg, err := config.Load(configFile)
 	if err != nil {
@@ -25,32 +80,212 @@ func main() {
 		os.Exit(1)
 	}
 
-	// CLI flag overrides config
-	if debug {
-		cfg.Debug = true
+	srv := &server{configPath: configFile, debug: debug, pprof: pprof, stats: proxy.NewStats()}
+	if mock {
+		mt, err := proxy.NewMockTransport(cfg.Mock)
+		if err != nil {
+			fmt.Printf("❌ 初始化模拟上游失败: %v\n", err)
+			os.Exit(1)
+		}
+		srv.mock = &http.Client{Transport: mt}
+		fmt.Println("🎭 模拟上游模式：不会访问任何 Provider")
+	}
+	if replayDir != "" {
+		if replayMatch != "hash" && replayMatch != "sequence" {
+			fmt.Printf("❌ 无效的 -replay-match: %q（可选 hash / sequence）\n", replayMatch)
+			os.Exit(1)
+		}
+		rp, err := proxy.NewReplayer(replayDir, replayMatch)
+		if err != nil {
+			fmt.Printf("❌ 加载回放失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("⏯️  回放模式: %d 条录制 (%s 匹配)\n", rp.Len(), replayMatch)
+		srv.replay = rp
 	}
 
-	registry, err := provider.NewRegistry(cfg)
+	if cfg.DebugLog != "" {
+		dl, err := proxy.OpenDebugLog(cfg.DebugLog)
+		if err != nil {
+			fmt.Printf("❌ 打开调试日志失败: %v\n", err)
+			os.Exit(1)
+		}
+		defer dl.Close()
+		srv.debugLog = dl
+	}
+
+	// Outer API layers survive config reloads: chaos → record → stats → current chain
+	api := srv.stats.Wrap(http.HandlerFunc(srv.serveAPI))
+	if recordDir != "" {
+		rec, err := proxy.NewRecorder(recordDir, api)
+		if err != nil {
+			fmt.Printf("❌ 初始化录制失败: %v\n", err)
+			os.Exit(1)
+		}
+		defer rec.Close()
+		fmt.Printf("⏺️  录制到: %s\n", rec.Path())
+		api = rec
+	}
+	if chaos.Enabled() {
+		if err := chaos.Validate(); err != nil {
+			fmt.Printf("❌ 故障注入参数无效: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("💥 故障注入: 500=%.0f%% 断连=%.0f%% 慢分块=%.0f%% (%v) 畸形 SSE=%.0f%%\n",
+			chaos.ErrorRate*100, chaos.DisconnectRate*100, chaos.SlowRate*100, chaos.SlowDelay, chaos.MalformedRate*100)
+		api = proxy.NewChaos(chaos, api)
+	}
+	srv.api = api
+
+	rt, err := srv.build(cfg)
+	if err != nil {
+		fmt.Printf("❌ 初始化失败: %v\n", err)
+		os.Exit(1)
+	}
+	srv.current.Store(rt)
+	cfg = rt.cfg
+
+	tlsCfg, err := loadServerTLS(cfg)
 	if err != nil {
-		fmt.Printf("❌ 初始化 provider 失败: %v\n", err)
+		fmt.Printf("❌ TLS 配置失败: %v\n", err)
 		os.Exit(1)
 	}
 
-	handler := proxy.NewHandler(registry)
+	listeners, err := openListeners(cfg, tlsCfg)
+	if err != nil {
+		fmt.Printf("❌ 监听失败: %v\n", err)
+		os.Exit(1)
+	}
 
-	fmt.Printf("🚀 LLM Proxy 已就绪: http://127.0.0.1%s\n", cfg.Listen)
+	for _, ln := range listeners {
+		switch {
+		case ln.Addr().Network() == "unix":
+			fmt.Printf("🚀 LLM Proxy 已就绪: unix:%s\n", ln.Addr())
+		case tlsCfg != nil:
+			fmt.Printf("🚀 LLM Proxy 已就绪: https://%s\n", ln.Addr())
+		default:
+			fmt.Printf("🚀 LLM Proxy 已就绪: http://%s\n", ln.Addr())
+		}
+	}
 	for _, p := range cfg.Providers {
 		models := p.Models
 		if len(models) == 0 {
 			models = []string{"(none)"}
 		}
 		fmt.Printf("  📡 %s [%s] → %s  models: %v\n", p.Name, p.Type, p.BaseURL, models)
+		if n := len(p.EndpointList()); n > 1 {
+			fmt.Printf("     负载均衡: %d 个端点/密钥\n", n)
+		}
+	}
+	for _, pr := range cfg.Routes {
+		fmt.Printf("  🧭 %s/* → %s\n", pr.Prefix, pr.Provider)
+	}
+	if len(cfg.IPAllow) > 0 || len(cfg.IPDeny) > 0 {
+		fmt.Printf("🛡️  IP 过滤: allow=%v deny=%v\n", cfg.IPAllow, cfg.IPDeny)
+	}
+	if cfg.RateLimit.Enabled() {
+		fmt.Printf("🚦 客户端限流: %d 请求/分钟, %d tokens/分钟\n", cfg.RateLimit.RequestsPerMinute, cfg.RateLimit.TokensPerMinute)
+	}
+	if len(cfg.Keys) > 0 {
+		fmt.Printf("🔑 虚拟密钥: %d 个（客户端须携带密钥访问）\n", len(cfg.Keys))
+	}
+	if cfg.HealthCheck.Interval > 0 {
+		fmt.Printf("💓 上游健康检查: 每 %v\n", cfg.HealthCheck.Interval.Std())
+	}
+	if cfg.OutboundProxy != "" {
+		fmt.Printf("🌐 出站代理: %s\n", redactURL(cfg.OutboundProxy))
+	}
+	if cfg.UpstreamTLS.InsecureSkipVerify {
+		fmt.Println("⚠️  上游 TLS 证书校验已关闭 (insecure_skip_verify)")
+	}
+	if cfg.WebSocketBridge {
+		fmt.Println("🔌 WebSocket 桥接已启用: /ws/v1/chat/completions")
+	}
+	if cfg.Admin.Token != "" {
+		fmt.Println("🛠️  管理接口已启用: /admin/")
+	}
+	if pprof {
+		fmt.Println("🩺 诊断接口已启用: /debug/pprof/ /debug/runtime")
 	}
 	if cfg.Debug {
 		fmt.Println("🔧 调试模式已启用")
 	}
+	if srv.debugLog != nil {
+		fmt.Printf("📝 请求转储写入: %s\n", srv.debugLog.Path())
+	}
+
+	httpSrv := &http.Server{Handler: srv}
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func() {
+			if err := httpSrv.Serve(ln); err != http.ErrServerClosed {
+				errCh <- err
+			}
+		}()
+	}
 
-	if err := http.ListenAndServe(cfg.Listen, handler); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errCh:
 		fmt.Printf("服务器启动失败: %v\n", err)
+		return
+	case <-ctx.Done():
 	}
+	// A second signal kills the process right away
+	stop()
+
+	drain := srv.Config().ShutdownTimeout.Or(defaultShutdownTimeout)
+	fmt.Printf("\n🛑 正在关闭：不再接受新连接，等待 %d 个进行中的请求完成（最多 %v）\n", srv.stats.Snapshot().InFlight, drain)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("⚠️  等待超时，强制断开剩余 %d 个请求\n", srv.stats.Snapshot().InFlight)
+		httpSrv.Close()
+	}
+	srv.current.Load().stopHealth()
+	srv.current.Load().plugins.Close()
+	fmt.Println("👋 已退出")
+}
+
+// redactURL hides the password of a URL with userinfo (e.g. proxy credentials).
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// openListeners binds the TCP address and/or Unix socket from config.
+// The TCP listener serves HTTPS when tlsCfg is non-nil.
+func openListeners(cfg config.Config, tlsCfg *tls.Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	if addr := cfg.ListenAddr(); addr != "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg != nil {
+			ln = tls.NewListener(ln, tlsCfg)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	if cfg.UnixSocket != "" {
+		// Remove a stale socket left behind by a previous run
+		if fi, err := os.Stat(cfg.UnixSocket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(cfg.UnixSocket)
+		}
+		ln, err := net.Listen("unix", cfg.UnixSocket)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
 }
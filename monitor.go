@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/proxy"
+	"llm-local-proxy/transform"
+)
+
+// monitor renders a running proxy's /admin/stats as a live terminal view.
+func monitor(args []string) int {
+	fs := flag.NewFlagSet("monitor", flag.ExitOnError)
+	configFile := fs.String("config", "config.json", "配置文件路径（用于读取地址与 admin.token）")
+	baseURL := fs.String("url", "", "代理地址（默认由配置中的 listen 推导）")
+	token := fs.String("token", "", "管理接口 token（默认取配置中的 admin.token）")
+	interval := fs.Duration("interval", time.Second, "刷新间隔")
+	fs.Parse(args)
+
+	// The config is optional when -url and -token are given
+	if cfg, err := config.Load(*configFile); err == nil {
+		if *baseURL == "" && cfg.ListenAddr() != "" {
+			scheme := "http"
+			if cfg.TLSCert != "" {
+				scheme = "https"
+			}
+			*baseURL = scheme + "://" + strings.Replace(cfg.ListenAddr(), "0.0.0.0", "127.0.0.1", 1)
+		}
+		if *token == "" {
+			*token = cfg.Admin.Token
+		}
+	}
+	if *baseURL == "" {
+		fmt.Println("❌ 无法确定代理地址，请使用 -url 指定")
+		return 1
+	}
+	*baseURL = strings.TrimRight(*baseURL, "/")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Print("\033[?25l") // hide cursor
+	defer fmt.Print("\033[?25h\n")
+
+	var prev proxy.StatsSnapshot
+	var prevAt time.Time
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		snap, err := fetchStats(ctx, *baseURL, *token)
+		now := time.Now()
+		var b strings.Builder
+		fmt.Fprintf(&b, "LLM Proxy 监控 — %s    %s 刷新，Ctrl+C 退出\n\n", *baseURL, *interval)
+		if err != nil {
+			fmt.Fprintf(&b, "❌ %v\n", err)
+		} else {
+			rate := 0.0
+			if !prevAt.IsZero() {
+				rate = float64(snap.Total.CompletionTokens-prev.Total.CompletionTokens) / now.Sub(prevAt).Seconds()
+			}
+			renderStats(&b, snap, rate)
+			prev, prevAt = snap, now
+		}
+		fmt.Print("\033[H\033[2J" + b.String())
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
+
+func fetchStats(ctx context.Context, baseURL, token string) (proxy.StatsSnapshot, error) {
+	var snap proxy.StatsSnapshot
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/admin/stats", nil)
+	if err != nil {
+		return snap, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return snap, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return snap, fmt.Errorf("GET /admin/stats: %s（需要在配置中设置 admin.token）", resp.Status)
+	}
+	return snap, json.NewDecoder(resp.Body).Decode(&snap)
+}
+
+func renderStats(b *strings.Builder, snap proxy.StatsSnapshot, rate float64) {
+	fmt.Fprintf(b, "运行 %s   请求 %d   错误 %d   进行中 %d   输出 %.1f tokens/s\n",
+		snap.Uptime, snap.Total.Requests, snap.Total.Errors, snap.InFlight, rate)
+	fmt.Fprintf(b, "累计 tokens: %d 输入 / %d 输出", snap.Total.PromptTokens, snap.Total.CompletionTokens)
+	if snap.Total.CacheHitTokens+snap.Total.CacheMissTokens > 0 {
+		fmt.Fprintf(b, "   缓存命中 %.1f%%", snap.Total.CacheHitRate()*100)
+	}
+	b.WriteByte('\n')
+
+	tw := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "\n▶ 活跃请求 (%d)\n", len(snap.Active))
+	if len(snap.Active) > 0 {
+		fmt.Fprintln(tw, "  MODEL\tCLIENT\tPATH\tSTREAM\tELAPSED\t≈TOKENS\t≈TOKENS/S")
+		for _, a := range snap.Active {
+			tokens := transform.EstimateTokens(int(a.BytesOut))
+			perSec := 0.0
+			if d, err := time.ParseDuration(a.Elapsed); err == nil && d > 0 {
+				perSec = float64(tokens) / d.Seconds()
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%v\t%s\t%d\t%.1f\n", a.Model, a.Client, a.Path, a.Stream, a.Elapsed, tokens, perSec)
+		}
+	}
+
+	fmt.Fprintln(tw, "\n▶ 按模型")
+	if len(snap.ByModel) > 0 {
+		fmt.Fprintln(tw, "  MODEL\tREQUESTS\tERRORS\tPROMPT\tCOMPLETION\tCACHE HIT")
+		for _, model := range slices.Sorted(maps.Keys(snap.ByModel)) {
+			c := snap.ByModel[model]
+			hit := "-"
+			if c.CacheHitTokens+c.CacheMissTokens > 0 {
+				hit = fmt.Sprintf("%.1f%%", c.CacheHitRate()*100)
+			}
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%d\t%d\t%s\n", model, c.Requests, c.Errors, c.PromptTokens, c.CompletionTokens, hit)
+		}
+	}
+
+	if len(snap.ByVariant) > 0 {
+		fmt.Fprintln(tw, "\n▶ 实验分组")
+		fmt.Fprintln(tw, "  VARIANT\tREQUESTS\tERRORS\tPROMPT\tCOMPLETION\tAVG MS\tFIRST BYTE MS")
+		for _, variant := range slices.Sorted(maps.Keys(snap.ByVariant)) {
+			c := snap.ByVariant[variant]
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%d\t%d\t%d\t%d\n", variant, c.Requests, c.Errors, c.PromptTokens, c.CompletionTokens, c.AvgLatencyMS, c.AvgFirstByteMS)
+		}
+	}
+
+	fmt.Fprintln(tw, "\n▶ 最近错误")
+	errs := snap.Errors
+	if len(errs) > 10 {
+		errs = errs[len(errs)-10:]
+	}
+	if len(errs) > 0 {
+		fmt.Fprintln(tw, "  TIME\tSTATUS\tMODEL\tCLIENT\tPATH")
+		for _, e := range slices.Backward(errs) {
+			fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\t%s\n", e.Time.Local().Format("15:04:05"), e.Status, e.Model, e.Client, e.Path)
+		}
+	}
+	tw.Flush()
+}
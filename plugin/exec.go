@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+)
+
+const (
+	defaultExecTimeout = 5 * time.Second
+	maxExecReply       = 16 << 20 // longest reply line an exec plugin may write
+)
+
+// execPlugin runs hooks in an external process that reads one JSON message
+// per line on stdin and answers each with one JSON line on stdout:
+//
+//	→ {"hook": "request", "model": "deepseek-chat", "body": {...}}
+//	← {"body": {...}}      the rewritten body
+//	← {}                   the body unchanged
+//	← {"error": "message"} a request refused with this message
+//
+// The process is started with the proxy and restarted after it exits,
+// answers with something else or times out. Calls are serialized.
+type execPlugin struct {
+	name    string
+	command []string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd // nil while not running
+	stdin  io.WriteCloser
+	lines  chan []byte // stdout lines; closed when stdout ends
+	closed bool
+}
+
+type execMessage struct {
+	Hook  string          `json:"hook"`
+	Model string          `json:"model"`
+	Body  json.RawMessage `json:"body"`
+}
+
+type execReply struct {
+	Body  json.RawMessage `json:"body"`
+	Error string          `json:"error"`
+}
+
+func newExec(cfg config.PluginConfig) (Plugin, error) {
+	p := &execPlugin{
+		name:    cfg.Name,
+		command: cfg.Command,
+		timeout: cfg.Timeout.Or(defaultExecTimeout),
+	}
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *execPlugin) Request(ctx context.Context, model string, body []byte) ([]byte, error) {
+	return p.call(ctx, HookRequest, model, body)
+}
+
+func (p *execPlugin) Response(ctx context.Context, model string, body []byte) ([]byte, error) {
+	return p.call(ctx, HookResponse, model, body)
+}
+
+func (p *execPlugin) Chunk(ctx context.Context, model string, data []byte) ([]byte, error) {
+	return p.call(ctx, HookChunk, model, data)
+}
+
+// start launches the process; p.mu is held or p is not shared yet.
+func (p *execPlugin) start() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", p.command[0], err)
+	}
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), maxExecReply)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+	}()
+	p.cmd, p.stdin, p.lines = cmd, stdin, lines
+	return nil
+}
+
+// stop kills the process; p.mu is held.
+func (p *execPlugin) stop() {
+	if p.cmd == nil {
+		return
+	}
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	// Unblock the reader, which may be waiting to hand over a late reply
+	go func(lines chan []byte) {
+		for range lines {
+		}
+	}(p.lines)
+	go p.cmd.Wait()
+	p.cmd = nil
+}
+
+func (p *execPlugin) call(ctx context.Context, hook, model string, body []byte) ([]byte, error) {
+	msg, err := json.Marshal(execMessage{Hook: hook, Model: model, Body: body})
+	if err != nil {
+		return body, nil // not JSON; nothing a plugin could rewrite
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errors.New("plugin closed")
+	}
+	if p.cmd == nil {
+		fmt.Printf("  ↻ restarting plugin %s\n", p.name)
+		if err := p.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The timeout covers the write too: a process that stops reading would
+	// block it once the pipe is full, and only stop can unblock it
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	written := make(chan error, 1)
+	go func(stdin io.Writer) {
+		_, err := stdin.Write(append(msg, '\n'))
+		written <- err
+	}(p.stdin)
+	for {
+		select {
+		case err := <-written:
+			if err != nil {
+				p.stop()
+				return nil, fmt.Errorf("write to process: %w", err)
+			}
+			written = nil
+		case line, ok := <-p.lines:
+			if !ok {
+				p.stop()
+				return nil, errors.New("process exited")
+			}
+			if written != nil {
+				// Answered before reading the whole message; the rest of it
+				// must not reach the next call
+				p.stop()
+			}
+			return p.reply(line, body)
+		case <-timer.C:
+			p.stop()
+			return nil, fmt.Errorf("no reply within %s", p.timeout)
+		case <-ctx.Done():
+			// The reply would arrive for the next call
+			p.stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// reply decodes a reply line to a call with body; p.mu is held.
+func (p *execPlugin) reply(line, body []byte) ([]byte, error) {
+	var reply execReply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("invalid reply: %w", err)
+	}
+	switch {
+	case reply.Error != "":
+		return nil, &Rejection{Message: reply.Error}
+	case len(reply.Body) == 0 || string(reply.Body) == "null":
+		return body, nil
+	}
+	return reply.Body, nil
+}
+
+// Close stops the process.
+func (p *execPlugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.stop()
+	return nil
+}
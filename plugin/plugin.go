@@ -0,0 +1,244 @@
+// Package plugin runs hooks that rewrite chat requests and responses
+// outside the proxy's own code: Go plugins compiled in and registered by
+// type, or external processes (type "exec").
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"llm-local-proxy/config"
+)
+
+// Hook names, as used in the hooks list of a plugin's config.
+const (
+	HookRequest  = "request"  // the chat request, before routing
+	HookResponse = "response" // a complete chat response with status 200
+	HookChunk    = "chunk"    // the data of one chunk of a streamed chat response
+)
+
+// Plugin rewrites the JSON bodies its hooks are given. A hook returns the
+// body as it should continue; returning its input leaves it unchanged.
+// Plugins are called concurrently. A plugin that holds resources also
+// implements io.Closer, and is closed when a config reload replaces it.
+type Plugin interface {
+	// Request rewrites a chat completions request. A *Rejection refuses it.
+	Request(ctx context.Context, model string, body []byte) ([]byte, error)
+	// Response rewrites a non-streamed chat completions response.
+	Response(ctx context.Context, model string, body []byte) ([]byte, error)
+	// Chunk rewrites the JSON data of one chunk of a streamed response.
+	Chunk(ctx context.Context, model string, data []byte) ([]byte, error)
+}
+
+// Base implements every hook as a no-op, for plugins to embed when they
+// only need some of them.
+type Base struct{}
+
+func (Base) Request(_ context.Context, _ string, body []byte) ([]byte, error)  { return body, nil }
+func (Base) Response(_ context.Context, _ string, body []byte) ([]byte, error) { return body, nil }
+func (Base) Chunk(_ context.Context, _ string, data []byte) ([]byte, error)    { return data, nil }
+
+// Rejection is returned by a request hook to refuse the request; the client
+// gets Message with status 400.
+type Rejection struct {
+	Message string
+}
+
+func (r *Rejection) Error() string { return r.Message }
+
+// Factory creates a plugin from its config entry.
+type Factory func(cfg config.PluginConfig) (Plugin, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{"exec": newExec}
+)
+
+// Register makes a plugin type available to the plugins config. It is
+// meant to be called from the init function of the file defining the
+// plugin, and panics if the type is already registered.
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, dup := factories[typ]; dup {
+		panic("plugin: type " + typ + " registered twice")
+	}
+	factories[typ] = factory
+}
+
+// Types returns the registered plugin types, sorted.
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+type entry struct {
+	cfg    config.PluginConfig
+	plugin Plugin
+}
+
+// Chain runs the configured plugins in config order. A nil *Chain runs
+// none, so callers never need nil checks.
+type Chain struct {
+	entries []entry
+
+	mu      sync.Mutex
+	holds   int  // requests that may still call the plugins
+	closing bool // Close was called; plugins close when holds drops to 0
+	closed  bool
+}
+
+// NewChain creates the plugins of cfgs. It returns nil when there are none.
+func NewChain(cfgs []config.PluginConfig) (*Chain, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+	c := &Chain{}
+	for _, pc := range cfgs {
+		factoriesMu.RLock()
+		factory := factories[pc.Type]
+		factoriesMu.RUnlock()
+		if factory == nil {
+			c.closePlugins()
+			return nil, fmt.Errorf("%s: unknown plugin type %q (registered: %v)", pc.Name, pc.Type, Types())
+		}
+		p, err := factory(pc)
+		if err != nil {
+			c.closePlugins()
+			return nil, fmt.Errorf("%s: %w", pc.Name, err)
+		}
+		c.entries = append(c.entries, entry{pc, p})
+	}
+	return c, nil
+}
+
+// Runs reports whether any plugin has hook for model.
+func (c *Chain) Runs(hook, model string) bool {
+	if c == nil {
+		return false
+	}
+	for _, e := range c.entries {
+		if e.cfg.Runs(hook, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// Request runs the request hooks for model. A rejection is returned as it
+// is; other failures are skipped for fail_open plugins and returned
+// otherwise.
+func (c *Chain) Request(ctx context.Context, model string, body []byte) ([]byte, error) {
+	if c == nil {
+		return body, nil
+	}
+	for _, e := range c.entries {
+		if !e.cfg.Runs(HookRequest, model) {
+			continue
+		}
+		out, err := e.plugin.Request(ctx, model, body)
+		var rejection *Rejection
+		switch {
+		case errors.As(err, &rejection):
+			return nil, fmt.Errorf("%s: %w", e.cfg.Name, err)
+		case err != nil && e.cfg.FailOpen:
+			fmt.Printf("  ✗ plugin %s failed, request forwarded as it was: %v\n", e.cfg.Name, err)
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", e.cfg.Name, err)
+		default:
+			body = out
+		}
+	}
+	return body, nil
+}
+
+// Response runs the response hooks for model. A failing plugin is logged
+// and leaves the body as it was given.
+func (c *Chain) Response(ctx context.Context, model string, body []byte) []byte {
+	return c.run(ctx, HookResponse, model, body)
+}
+
+// Chunk runs the chunk hooks for model on the data of one stream chunk. A
+// failing plugin is logged and leaves the data as it was given.
+func (c *Chain) Chunk(ctx context.Context, model string, data []byte) []byte {
+	return c.run(ctx, HookChunk, model, data)
+}
+
+func (c *Chain) run(ctx context.Context, hook, model string, body []byte) []byte {
+	if c == nil {
+		return body
+	}
+	for _, e := range c.entries {
+		if !e.cfg.Runs(hook, model) {
+			continue
+		}
+		var out []byte
+		var err error
+		if hook == HookResponse {
+			out, err = e.plugin.Response(ctx, model, body)
+		} else {
+			out, err = e.plugin.Chunk(ctx, model, body)
+		}
+		if err != nil {
+			fmt.Printf("  ✗ plugin %s %s hook failed: %v\n", e.cfg.Name, hook, err)
+			continue
+		}
+		body = out
+	}
+	return body
+}
+
+// Hold keeps the plugins open until the returned function is called, so a
+// request started before a reload finishes with the plugins it began with.
+func (c *Chain) Hold() (release func()) {
+	if c == nil {
+		return func() {}
+	}
+	c.mu.Lock()
+	c.holds++
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.holds--
+		if c.closing && c.holds == 0 {
+			c.closePlugins()
+		}
+	}
+}
+
+// Close closes the plugins that hold resources once no request holds the
+// chain any more.
+func (c *Chain) Close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closing = true
+	if c.holds == 0 {
+		c.closePlugins()
+	}
+}
+
+func (c *Chain) closePlugins() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, e := range c.entries {
+		if closer, ok := e.plugin.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+// Anthropic speaks the Messages API. Requests are prepared like any other
+// and then translated, tools included, with the configured prompt-cache
+// breakpoints; thinking blocks in the response come back as
+// reasoning_content.
+type Anthropic struct {
+	*upstream
+	format transform.ReasoningFormat
+	cache  transform.CacheControlRules
+	debug  bool
+}
+
+func NewAnthropic(cfg config.ProviderConfig, format transform.ReasoningFormat, debug bool) *Anthropic {
+	return &Anthropic{
+		upstream: newUpstream(cfg),
+		format:   format,
+		cache:    transform.CacheControlRules(cfg.CacheControl),
+		debug:    debug,
+	}
+}
+
+func (a *Anthropic) TransformRequest(body []byte) []byte {
+	// Thinking blocks of earlier turns need their signatures, which the
+	// client never has: history reasoning is dropped
+	body = transform.PrepareRequestMessages(body, false, true, a.format)
+	body = a.sanitize(body, a.debug)
+	req, err := transform.ChatToAnthropic(body)
+	if err != nil {
+		return body
+	}
+	if a.cache != (transform.CacheControlRules{}) {
+		if n := transform.AddCacheControl(req, a.cache); n > 0 && a.debug {
+			fmt.Printf("  ✎ cache_control: %d breakpoints added\n", n)
+		}
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func (a *Anthropic) TransformStreamDelta(choice map[string]any, state *transform.StreamState) {
+	transform.TransformDelta(choice, state, a.format, a.debug)
+}
+
+func (a *Anthropic) TransformResponse(body []byte) []byte {
+	return transform.TransformFullResponse(body, a.format)
+}
+
+func (a *Anthropic) ChatPath(string, bool) string { return "/messages" }
+
+func (a *Anthropic) Authorize(header http.Header, key string) {
+	if key != "" {
+		header.Del("Authorization")
+		header.Set("X-Api-Key", key)
+	}
+	if header.Get("Anthropic-Version") == "" {
+		header.Set("Anthropic-Version", "2023-06-01")
+	}
+}
+
+func (a *Anthropic) TranslateResponse(resp *http.Response, _ string) error {
+	return translateResponse(resp, transform.AnthropicToChat, &transform.AnthropicStream{})
+}
@@ -9,36 +9,32 @@ import (
 // Historical reasoning is cleaned to save bandwidth per their docs.
 // reasoning_effort is injected from config if the client doesn't send it.
 type DeepSeek struct {
-	name            string
-	baseURL         string
-	apiKey          string
+	*upstream
+	format          transform.ReasoningFormat
 	reasoningEffort string // from config: "high" or "max"
 	debug           bool
 }
 
-func NewDeepSeek(cfg config.ProviderConfig, debug bool) *DeepSeek {
+func NewDeepSeek(cfg config.ProviderConfig, format transform.ReasoningFormat, debug bool) *DeepSeek {
 	return &DeepSeek{
-		name:            cfg.Name,
-		baseURL:         cfg.BaseURL,
-		apiKey:          cfg.APIKey,
+		upstream:        newUpstream(cfg),
+		format:          format,
 		reasoningEffort: cfg.ReasoningEffort,
 		debug:           debug,
 	}
 }
 
-func (d *DeepSeek) Name() string    { return d.name }
-func (d *DeepSeek) BaseURL() string { return d.baseURL }
-func (d *DeepSeek) APIKey() string  { return d.apiKey }
-
 func (d *DeepSeek) TransformRequest(body []byte) []byte {
-	body = transform.PrepareRequestMessages(body, true, true)
-	return transform.InjectReasoningEffort(body, d.reasoningEffort, d.debug)
+	body = transform.PrepareRequestMessages(body, true, true, d.format)
+	body = transform.InjectReasoningEffort(body, d.reasoningEffort, d.debug)
+	body = transform.MapEffortDeepSeek(body, d.debug)
+	return d.sanitize(body, d.debug)
 }
 
 func (d *DeepSeek) TransformStreamDelta(choice map[string]any, state *transform.StreamState) {
-	transform.TransformDelta(choice, state, d.debug)
+	transform.TransformDelta(choice, state, d.format, d.debug)
 }
 
 func (d *DeepSeek) TransformResponse(body []byte) []byte {
-	return transform.TransformFullResponse(body)
+	return transform.TransformFullResponse(body, d.format)
 }
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"llm-local-proxy/transform"
+)
+
+// Translator is implemented by providers whose API is not OpenAI's. Chat
+// requests to them go to their own path with their own credentials, and a
+// successful response is translated back to an OpenAI chat completion or
+// stream before the proxy reads it.
+type Translator interface {
+	// ChatPath returns the path under the base URL that chat requests for
+	// model are posted to.
+	ChatPath(model string, stream bool) string
+	// Authorize sets the headers carrying key, which may be empty.
+	Authorize(header http.Header, key string)
+	// TranslateResponse replaces the body of a successful chat response for
+	// model with its OpenAI translation.
+	TranslateResponse(resp *http.Response, model string) error
+}
+
+// ChatPath returns the path chat requests for model are posted to under
+// p's base URL.
+func ChatPath(p Provider, model string, stream bool) string {
+	if t, ok := p.(Translator); ok {
+		return t.ChatPath(model, stream)
+	}
+	return "/chat/completions"
+}
+
+// Authorize sets the credentials for key on header the way p's API
+// expects them.
+func Authorize(p Provider, header http.Header, key string) {
+	if t, ok := p.(Translator); ok {
+		t.Authorize(header, key)
+	} else if key != "" {
+		header.Set("Authorization", "Bearer "+key)
+	}
+}
+
+// eventStream converts the data of a provider's stream events to the data
+// of OpenAI chunks.
+type eventStream interface {
+	Event(data []byte) [][]byte
+	End() [][]byte
+}
+
+// translateResponse replaces the body of resp with its translation: an
+// event stream event by event as it arrives, any other body at once with
+// convert.
+func translateResponse(resp *http.Response, convert func([]byte) ([]byte, error), stream eventStream) error {
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		pr, pw := io.Pipe()
+		upstream := resp.Body
+		go func() {
+			write := func(events [][]byte) error {
+				for _, data := range events {
+					if _, err := fmt.Fprintf(pw, "data: %s\n\n", data); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			var err error
+			scanner := transform.NewSSEScanner(upstream, nil, 0)
+			for err == nil && scanner.Scan() {
+				if data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:")); ok {
+					err = write(stream.Event(bytes.TrimSpace(data)))
+				}
+			}
+			if err == nil {
+				err = scanner.Err()
+			}
+			if err == nil {
+				err = write(stream.End())
+			}
+			pw.CloseWithError(err)
+		}()
+		resp.Body = translatedBody{pr, upstream}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if body, err = convert(body); err != nil {
+		return fmt.Errorf("translating the response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// translatedBody is a translated stream; closing it also stops the
+// translation by closing the upstream body.
+type translatedBody struct {
+	*io.PipeReader
+	upstream io.Closer
+}
+
+func (b translatedBody) Close() error {
+	b.PipeReader.Close()
+	return b.upstream.Close()
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+// Gemini speaks the generateContent API, which takes the model and the
+// streaming mode in the URL. Requests are prepared like any other and then
+// translated, tools included; thought parts in the response come back as
+// reasoning_content.
+type Gemini struct {
+	*upstream
+	format transform.ReasoningFormat
+	debug  bool
+}
+
+func NewGemini(cfg config.ProviderConfig, format transform.ReasoningFormat, debug bool) *Gemini {
+	return &Gemini{
+		upstream: newUpstream(cfg),
+		format:   format,
+		debug:    debug,
+	}
+}
+
+func (g *Gemini) TransformRequest(body []byte) []byte {
+	body = transform.PrepareRequestMessages(body, false, true, g.format)
+	body = g.sanitize(body, g.debug)
+	req, err := transform.ChatToGemini(body)
+	if err != nil {
+		return body
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func (g *Gemini) TransformStreamDelta(choice map[string]any, state *transform.StreamState) {
+	transform.TransformDelta(choice, state, g.format, g.debug)
+}
+
+func (g *Gemini) TransformResponse(body []byte) []byte {
+	return transform.TransformFullResponse(body, g.format)
+}
+
+func (g *Gemini) ChatPath(model string, stream bool) string {
+	path := "/models/" + url.PathEscape(strings.TrimPrefix(model, "models/"))
+	if stream {
+		return path + ":streamGenerateContent?alt=sse"
+	}
+	return path + ":generateContent"
+}
+
+func (g *Gemini) Authorize(header http.Header, key string) {
+	if key != "" {
+		header.Del("Authorization")
+		header.Set("X-Goog-Api-Key", key)
+	}
+}
+
+func (g *Gemini) TranslateResponse(resp *http.Response, model string) error {
+	convert := func(body []byte) ([]byte, error) { return transform.GeminiToChat(body, model) }
+	return translateResponse(resp, convert, &transform.GeminiStream{Model: model})
+}
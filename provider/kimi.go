@@ -9,34 +9,30 @@ import (
 // Docs recommend preserving all reasoning_content in context (no history cleanup).
 // When thinking is enabled, reasoning_content is required on all assistant messages.
 type Kimi struct {
-	name    string
-	baseURL string
-	apiKey  string
-	debug   bool
+	*upstream
+	format transform.ReasoningFormat
+	debug  bool
 }
 
-func NewKimi(cfg config.ProviderConfig, debug bool) *Kimi {
+func NewKimi(cfg config.ProviderConfig, format transform.ReasoningFormat, debug bool) *Kimi {
 	return &Kimi{
-		name:    cfg.Name,
-		baseURL: cfg.BaseURL,
-		apiKey:  cfg.APIKey,
-		debug:   debug,
+		upstream: newUpstream(cfg),
+		format:   format,
+		debug:    debug,
 	}
 }
 
-func (k *Kimi) Name() string    { return k.name }
-func (k *Kimi) BaseURL() string { return k.baseURL }
-func (k *Kimi) APIKey() string  { return k.apiKey }
-
 func (k *Kimi) TransformRequest(body []byte) []byte {
-	// Kimi: restore reasoning from <thought> tags, preserve all history reasoning
-	return transform.PrepareRequestMessages(body, true, false)
+	// Kimi: restore reasoning_content from content, preserve all history reasoning
+	body = transform.PrepareRequestMessages(body, true, false, k.format)
+	body = transform.MapEffortThinking(body, k.debug)
+	return k.sanitize(body, k.debug)
 }
 
 func (k *Kimi) TransformStreamDelta(choice map[string]any, state *transform.StreamState) {
-	transform.TransformDelta(choice, state, k.debug)
+	transform.TransformDelta(choice, state, k.format, k.debug)
 }
 
 func (k *Kimi) TransformResponse(body []byte) []byte {
-	return transform.TransformFullResponse(body)
+	return transform.TransformFullResponse(body, k.format)
 }
@@ -2,8 +2,10 @@ package provider
 
 import (
 	"fmt"
+	"time"
 
 	"llm-local-proxy/config"
+	"llm-local-proxy/script"
 	"llm-local-proxy/transform"
 )
 
@@ -11,10 +13,43 @@ import (
 type Provider interface {
 	// Name returns the provider identifier from config.
 	Name() string
-	// BaseURL returns the upstream API base URL.
+	// BaseURL returns the primary upstream API base URL (for display).
 	BaseURL() string
-	// APIKey returns the authentication key.
-	APIKey() string
+	// NextEndpoint selects the base URL / API key to use for one upstream call.
+	NextEndpoint() *Endpoint
+	// EndpointFor selects the endpoint for one upstream call of the
+	// conversation with the given hash. Sticky providers keep choosing the
+	// same endpoint for it while that one is available; others, and a nil
+	// hash, fall back to NextEndpoint.
+	EndpointFor(conversation []byte) *Endpoint
+	// Endpoints returns usage stats for the provider's endpoint pool.
+	Endpoints() []EndpointStats
+	// Pool returns the provider's endpoints.
+	Pool() []*Endpoint
+	// Healthy reports whether any endpoint passed its last health probe.
+	Healthy() bool
+	// HostOverride returns the Host header / TLS server name to use instead of
+	// the one derived from BaseURL, or "" for the default.
+	HostOverride() string
+	// ReasoningMode returns the provider's reasoning mode, or "" to use the
+	// global one.
+	ReasoningMode() string
+	// EmulatesJSONSchema reports whether a json_schema response_format is
+	// emulated with a system prompt instead of forwarded.
+	EmulatesJSONSchema() bool
+	// UpstreamStream returns "always" or "never" when requests to the
+	// upstream stream regardless of the client, or "" to follow the client.
+	UpstreamStream() string
+	// Prefill returns the message field that asks the upstream to continue
+	// a trailing assistant message, or "" when it has none. Such upstreams
+	// reply with the continuation only.
+	Prefill() string
+	// CompletionsURL returns the upstream's own text completion endpoint,
+	// which takes prompt and suffix, or "" when it has none.
+	CompletionsURL() string
+	// SyntheticStream returns the characters per delta and the pause
+	// between deltas of streams replayed from complete responses.
+	SyntheticStream() (chunkSize int, delay time.Duration)
 	// TransformRequest modifies the request body before forwarding.
 	TransformRequest(body []byte) []byte
 	// TransformStreamDelta processes a single SSE choice delta.
@@ -25,22 +60,39 @@ type Provider interface {
 
 // Registry maps model names to providers.
 type Registry struct {
-	byModel map[string]Provider
-	debug   bool
+	byModel   map[string]Provider
+	providers []Provider
+	scripts   map[string]Scripts
+	debug     bool
+}
+
+// Scripts are a provider's transform scripts; either may be nil.
+type Scripts struct {
+	Request  *script.Script
+	Response *script.Script
 }
 
 // NewRegistry builds a provider registry from configuration.
 func NewRegistry(cfg config.Config) (Registry, error) {
 	r := Registry{
 		byModel: make(map[string]Provider),
+		scripts: make(map[string]Scripts),
 		debug:   cfg.Debug,
 	}
 
 	for _, pc := range cfg.Providers {
-		p, err := newProvider(pc, cfg.Debug)
+		p, err := newProvider(pc, reasoningFormat(cfg.ReasoningFormat), cfg.Debug)
 		if err != nil {
 			return Registry{}, fmt.Errorf("provider %q: %w", pc.Name, err)
 		}
+		r.providers = append(r.providers, p)
+		scripts, err := loadScripts(pc.Scripts)
+		if err != nil {
+			return Registry{}, fmt.Errorf("provider %q: %w", pc.Name, err)
+		}
+		if scripts != (Scripts{}) {
+			r.scripts[pc.Name] = scripts
+		}
 		for _, model := range pc.Models {
 			r.byModel[model] = p
 		}
@@ -60,22 +112,76 @@ func (r Registry) Resolve(model string) Provider {
 	return nil
 }
 
+// Named returns the provider with the given name, or nil.
+func (r Registry) Named(name string) Provider {
+	for _, p := range r.providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Providers returns all configured providers in config order.
+func (r Registry) Providers() []Provider {
+	return r.providers
+}
+
+// Scripts returns the transform scripts of the named provider.
+func (r Registry) Scripts(name string) Scripts {
+	return r.scripts[name]
+}
+
 // Debug returns whether debug mode is enabled.
 func (r Registry) Debug() bool {
 	return r.debug
 }
 
-func newProvider(pc config.ProviderConfig, debug bool) (Provider, error) {
+func newProvider(pc config.ProviderConfig, format transform.ReasoningFormat, debug bool) (Provider, error) {
 	switch pc.Type {
 	case "deepseek":
-		return NewDeepSeek(pc, debug), nil
+		return NewDeepSeek(pc, format, debug), nil
 	case "kimi":
-		return NewKimi(pc, debug), nil
+		return NewKimi(pc, format, debug), nil
 	case "zhipu":
-		return NewZhipu(pc, debug), nil
+		return NewZhipu(pc, format, debug), nil
+	case "anthropic":
+		return NewAnthropic(pc, format, debug), nil
+	case "gemini":
+		return NewGemini(pc, format, debug), nil
 	case "passthrough":
 		return NewPassthrough(pc), nil
 	default:
 		return nil, fmt.Errorf("unknown provider type %q", pc.Type)
 	}
 }
+
+func loadScripts(c config.ScriptsConfig) (Scripts, error) {
+	var s Scripts
+	var err error
+	if c.Request != "" {
+		if s.Request, err = script.Load(c.Request); err != nil {
+			return Scripts{}, fmt.Errorf("scripts.request: %w", err)
+		}
+	}
+	if c.Response != "" {
+		if s.Response, err = script.Load(c.Response); err != nil {
+			return Scripts{}, fmt.Errorf("scripts.response: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// reasoningFormat resolves the configured reasoning format.
+func reasoningFormat(c config.ReasoningFormatConfig) transform.ReasoningFormat {
+	switch c.Style {
+	case "blockquote":
+		return transform.BlockquoteFormat()
+	case "custom":
+		return transform.CustomFormat(c.Prefix, c.Suffix)
+	}
+	if c.Tag != "" {
+		return transform.TagFormat(c.Tag)
+	}
+	return transform.DefaultReasoningFormat
+}
@@ -0,0 +1,314 @@
+package provider
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+// Endpoint is one base URL / API key combination of a provider's pool.
+type Endpoint struct {
+	BaseURL string
+	APIKey  string
+	weight  int
+
+	requests    atomic.Int64
+	rateLimited atomic.Int64
+	coolUntil   atomic.Int64 // unix nanos; skipped by selection until then
+	unhealthy   atomic.Bool  // set by health checks; skipped by selection
+	healthError atomic.Pointer[string]
+}
+
+// EndpointStats is a snapshot of an endpoint's usage counters.
+type EndpointStats struct {
+	BaseURL     string `json:"base_url"`
+	Key         string `json:"key"` // masked API key
+	Weight      int    `json:"weight"`
+	Requests    int64  `json:"requests"`
+	RateLimited int64  `json:"rate_limited"`
+	CoolingDown bool   `json:"cooling_down"`
+	Healthy     bool   `json:"healthy"`
+	HealthError string `json:"health_error,omitempty"`
+}
+
+// MarkRateLimited records a 429 and takes the endpoint out of rotation for d.
+func (e *Endpoint) MarkRateLimited(d time.Duration) {
+	e.rateLimited.Add(1)
+	e.coolUntil.Store(time.Now().Add(d).UnixNano())
+}
+
+func (e *Endpoint) coolingDown(now time.Time) bool {
+	return now.UnixNano() < e.coolUntil.Load()
+}
+
+// SetHealth records the outcome of a health probe; a nil error means healthy.
+func (e *Endpoint) SetHealth(err error) {
+	e.unhealthy.Store(err != nil)
+	if err != nil {
+		msg := err.Error()
+		e.healthError.Store(&msg)
+	} else {
+		e.healthError.Store(nil)
+	}
+}
+
+// Healthy reports whether the last health probe succeeded (true before any probe).
+func (e *Endpoint) Healthy() bool {
+	return !e.unhealthy.Load()
+}
+
+// available reports whether selection should prefer this endpoint.
+func (e *Endpoint) available(now time.Time) bool {
+	return e.Healthy() && !e.coolingDown(now)
+}
+
+// Stats returns the endpoint's counters.
+func (e *Endpoint) Stats() EndpointStats {
+	stats := EndpointStats{
+		BaseURL:     e.BaseURL,
+		Key:         MaskKey(e.APIKey),
+		Weight:      e.weight,
+		Requests:    e.requests.Load(),
+		RateLimited: e.rateLimited.Load(),
+		CoolingDown: e.coolingDown(time.Now()),
+		Healthy:     e.Healthy(),
+	}
+	if msg := e.healthError.Load(); msg != nil {
+		stats.HealthError = *msg
+	}
+	return stats
+}
+
+// MaskKey shortens a secret for display, keeping only its last 4 characters.
+func MaskKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+// builtinParams lists what each provider type is known to reject, per its
+// API documentation.
+var builtinParams = map[string]transform.ParamRules{
+	"deepseek": {
+		Drop:  []string{"logit_bias", "n"},
+		Clamp: map[string][2]float64{"temperature": {0, 2}, "top_p": {0, 1}},
+	},
+	"kimi": {
+		Drop:  []string{"logit_bias"},
+		Clamp: map[string][2]float64{"temperature": {0, 1}, "top_p": {0, 1}},
+	},
+	"zhipu": {
+		Drop:  []string{"logit_bias", "n", "presence_penalty", "frequency_penalty", "logprobs", "top_logprobs"},
+		Clamp: map[string][2]float64{"temperature": {0, 1}, "top_p": {0.01, 0.99}},
+	},
+	"anthropic": {
+		Clamp: map[string][2]float64{"temperature": {0, 1}, "top_p": {0, 1}},
+	},
+	"gemini": {
+		Clamp: map[string][2]float64{"temperature": {0, 2}, "top_p": {0, 1}},
+	},
+}
+
+// builtinDeveloperRoles lists the role developer messages are sent as to
+// provider types that predate it.
+var builtinDeveloperRoles = map[string]string{
+	"deepseek":  "system",
+	"kimi":      "system",
+	"zhipu":     "system",
+	"anthropic": "system",
+	"gemini":    "system",
+}
+
+// emulatesJSONSchema lists the provider types that support response_format
+// json_object but not json_schema.
+var emulatesJSONSchema = map[string]bool{
+	"deepseek":  true,
+	"kimi":      true,
+	"zhipu":     true,
+	"anthropic": true,
+	"gemini":    true,
+}
+
+// upstream holds the connection settings shared by every provider adapter.
+type upstream struct {
+	name          string
+	hostOverride  string
+	reasoningMode string
+	params        transform.ParamRules // parameters the upstream rejects or limits
+	roles         transform.RoleRules  // message roles the upstream accepts
+	prefill       string               // field marking a trailing assistant message to continue; "" = none
+	emulateSchema bool                 // json_schema response_format is emulated
+	stream        string               // "always" / "never" stream from upstream; "" = as requested
+	synthetic     config.SyntheticStream
+	completions   string // native /completions URL; "" = none
+	sticky        bool   // conversations stay on one endpoint
+	endpoints     []*Endpoint
+
+	mu      sync.Mutex
+	current []int // smooth weighted round-robin state, parallel to endpoints
+}
+
+func newUpstream(cfg config.ProviderConfig) *upstream {
+	u := &upstream{
+		name:          cfg.Name,
+		hostOverride:  cfg.HostOverride,
+		reasoningMode: cfg.ReasoningMode,
+		params: builtinParams[cfg.Type].Merge(transform.ParamRules{
+			Drop:  cfg.Sanitize.Drop,
+			Clamp: cfg.Sanitize.Clamp,
+		}),
+		roles: transform.RoleRules{
+			Developer: cmp.Or(cfg.Roles.Developer, builtinDeveloperRoles[cfg.Type]),
+			Merge:     cfg.Roles.Merge,
+			Alternate: cfg.Roles.Alternate,
+		},
+		emulateSchema: cfg.JSONSchema == "emulate" || cfg.JSONSchema == "" && emulatesJSONSchema[cfg.Type],
+		stream:        cfg.UpstreamStream,
+		synthetic:     cfg.SyntheticStream,
+		completions:   cfg.CompletionsURL,
+		sticky:        cfg.Sticky,
+	}
+	for _, ec := range cfg.EndpointList() {
+		u.endpoints = append(u.endpoints, &Endpoint{
+			BaseURL: ec.BaseURL,
+			APIKey:  ec.APIKey,
+			weight:  max(ec.Weight, 1),
+		})
+	}
+	switch {
+	case cfg.Prefill == "none":
+	case cfg.Prefill != "":
+		u.prefill = cfg.Prefill
+	case cfg.Type == "kimi":
+		u.prefill = "partial"
+	case cfg.Type == "deepseek" && strings.HasSuffix(strings.TrimSuffix(cfg.BaseURL, "/"), "/beta"):
+		u.prefill = "prefix" // only the beta API has prefix completion
+	}
+	if u.roles.Developer == "developer" {
+		u.roles.Developer = "" // kept as it is
+	}
+	u.current = make([]int, len(u.endpoints))
+	return u
+}
+
+func (u *upstream) Name() string             { return u.name }
+func (u *upstream) BaseURL() string          { return u.endpoints[0].BaseURL }
+func (u *upstream) HostOverride() string     { return u.hostOverride }
+func (u *upstream) ReasoningMode() string    { return u.reasoningMode }
+func (u *upstream) EmulatesJSONSchema() bool { return u.emulateSchema }
+func (u *upstream) UpstreamStream() string   { return u.stream }
+func (u *upstream) CompletionsURL() string   { return u.completions }
+func (u *upstream) Prefill() string          { return u.prefill }
+
+func (u *upstream) SyntheticStream() (int, time.Duration) {
+	return u.synthetic.ChunkSize, time.Duration(u.synthetic.ChunkDelay)
+}
+
+// sanitize normalizes message roles, marks a prefill and emulates
+// json_schema where needed, then strips and clamps request parameters the
+// upstream would reject.
+func (u *upstream) sanitize(body []byte, debug bool) []byte {
+	body = transform.NormalizeRoles(body, u.roles, debug)
+	if u.prefill != "" {
+		body = transform.MarkPrefill(body, u.prefill, debug)
+	}
+	if u.emulateSchema {
+		body = transform.EmulateJSONSchema(body, debug)
+	}
+	return transform.Sanitize(body, u.params, debug)
+}
+
+// NextEndpoint picks an endpoint by smooth weighted round-robin, skipping
+// unhealthy endpoints and those cooling down after a 429 unless all of them are.
+func (u *upstream) NextEndpoint() *Endpoint {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	best, total := -1, 0
+	for _, onlyAvailable := range []bool{true, false} {
+		for i, e := range u.endpoints {
+			if onlyAvailable && !e.available(now) {
+				continue
+			}
+			u.current[i] += e.weight
+			total += e.weight
+			if best < 0 || u.current[i] > u.current[best] {
+				best = i
+			}
+		}
+		if best >= 0 {
+			break
+		}
+	}
+
+	u.current[best] -= total
+	e := u.endpoints[best]
+	e.requests.Add(1)
+	return e
+}
+
+// EndpointFor picks the available endpoint that ranks highest for the
+// conversation by weighted rendezvous hashing, so a conversation moves only
+// when its endpoint becomes unavailable, and then only its own
+// conversations move.
+func (u *upstream) EndpointFor(conversation []byte) *Endpoint {
+	if !u.sticky || conversation == nil || len(u.endpoints) == 1 {
+		return u.NextEndpoint()
+	}
+	now := time.Now()
+	var best *Endpoint
+	bestScore := 0.0
+	for _, e := range u.endpoints {
+		if !e.available(now) {
+			continue
+		}
+		sum := sha256.Sum256(append([]byte(e.BaseURL+"\x00"+e.APIKey+"\x00"), conversation...))
+		// A uniform value in (0, 1) turned into a weighted score
+		x := (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+		if score := float64(e.weight) / -math.Log(x); best == nil || score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	if best == nil {
+		return u.NextEndpoint()
+	}
+	best.requests.Add(1)
+	return best
+}
+
+// Healthy reports whether at least one endpoint passed its last health probe.
+func (u *upstream) Healthy() bool {
+	for _, e := range u.endpoints {
+		if e.Healthy() {
+			return true
+		}
+	}
+	return false
+}
+
+// Pool returns the provider's endpoints, e.g. for health probing.
+func (u *upstream) Pool() []*Endpoint {
+	return u.endpoints
+}
+
+// Endpoints returns usage stats for every endpoint in the pool.
+func (u *upstream) Endpoints() []EndpointStats {
+	stats := make([]EndpointStats, len(u.endpoints))
+	for i, e := range u.endpoints {
+		stats[i] = e.Stats()
+	}
+	return stats
+}
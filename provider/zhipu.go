@@ -8,34 +8,30 @@ import (
 // Zhipu (GLM) uses reasoning_content for models with deep thinking capability.
 // Historical reasoning is cleaned; field is not strictly required.
 type Zhipu struct {
-	name    string
-	baseURL string
-	apiKey  string
-	debug   bool
+	*upstream
+	format transform.ReasoningFormat
+	debug  bool
 }
 
-func NewZhipu(cfg config.ProviderConfig, debug bool) *Zhipu {
+func NewZhipu(cfg config.ProviderConfig, format transform.ReasoningFormat, debug bool) *Zhipu {
 	return &Zhipu{
-		name:    cfg.Name,
-		baseURL: cfg.BaseURL,
-		apiKey:  cfg.APIKey,
-		debug:   debug,
+		upstream: newUpstream(cfg),
+		format:   format,
+		debug:    debug,
 	}
 }
 
-func (z *Zhipu) Name() string    { return z.name }
-func (z *Zhipu) BaseURL() string { return z.baseURL }
-func (z *Zhipu) APIKey() string  { return z.apiKey }
-
 func (z *Zhipu) TransformRequest(body []byte) []byte {
-	// Zhipu: restore reasoning from <thought> tags, clean history
-	return transform.PrepareRequestMessages(body, false, true)
+	// Zhipu: restore reasoning_content from content, clean history
+	body = transform.PrepareRequestMessages(body, false, true, z.format)
+	body = transform.MapEffortThinking(body, z.debug)
+	return z.sanitize(body, z.debug)
 }
 
 func (z *Zhipu) TransformStreamDelta(choice map[string]any, state *transform.StreamState) {
-	transform.TransformDelta(choice, state, z.debug)
+	transform.TransformDelta(choice, state, z.format, z.debug)
 }
 
 func (z *Zhipu) TransformResponse(body []byte) []byte {
-	return transform.TransformFullResponse(body)
+	return transform.TransformFullResponse(body, z.format)
 }
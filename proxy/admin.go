@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/provider"
+)
+
+// AdminBackend is the running server as seen by the admin API. Keys and
+// budgets are looked up per request because a reload replaces them.
+type AdminBackend interface {
+	Config() config.Config
+	Keys() *KeyStore
+	Budgets() *Budgets
+	Reload() error
+}
+
+// Admin serves the /admin/ API for runtime control. Every endpoint requires
+// the configured admin token as a bearer token; without one the API is off.
+type Admin struct {
+	backend AdminBackend
+	stats   *Stats
+	mux     *http.ServeMux
+}
+
+func NewAdmin(backend AdminBackend, stats *Stats) *Admin {
+	a := &Admin{backend: backend, stats: stats, mux: http.NewServeMux()}
+	a.mux.HandleFunc("POST /admin/reload", a.reload)
+	a.mux.HandleFunc("GET /admin/stats", a.serveStats)
+	a.mux.HandleFunc("GET /admin/config", a.serveConfig)
+	a.mux.HandleFunc("GET /admin/budgets", a.serveBudgets)
+	a.mux.HandleFunc("GET /admin/keys", a.listKeys)
+	a.mux.HandleFunc("POST /admin/keys", a.createKey)
+	a.mux.HandleFunc("DELETE /admin/keys/{name}", a.deleteKey)
+	return a
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := a.backend.Config().Admin.Token
+	if token == "" {
+		writeError(w, http.StatusForbidden, "invalid_request_error", "admin_disabled",
+			"The admin API is disabled. Set admin.token in the config to enable it.")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) != 1 {
+		fmt.Printf("[%s] ✗ admin: unauthorized %s %s from %s\n", time.Now().Format("15:04:05"), r.Method, r.URL.Path, r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Invalid admin token.")
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *Admin) reload(w http.ResponseWriter, r *http.Request) {
+	if err := a.backend.Reload(); err != nil {
+		fmt.Printf("[%s] ✗ admin: reload failed: %v\n", time.Now().Format("15:04:05"), err)
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "reload_failed", err.Error())
+		return
+	}
+	fmt.Printf("[%s] ✓ admin: config reloaded\n", time.Now().Format("15:04:05"))
+	writeJSON(w, http.StatusOK, map[string]any{"status": "reloaded"})
+}
+
+func (a *Admin) serveStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.stats.Snapshot())
+}
+
+func (a *Admin) serveConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, redactConfig(a.backend.Config()))
+}
+
+func (a *Admin) serveBudgets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"budgets": a.backend.Budgets().Status()})
+}
+
+func (a *Admin) listKeys(w http.ResponseWriter, r *http.Request) {
+	keys := a.backend.Keys().List()
+	if keys == nil {
+		keys = []config.VirtualKey{}
+	}
+	for i := range keys {
+		keys[i].Key = provider.MaskKey(keys[i].Key)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+// createKey adds a virtual key, generating its secret when none is given.
+// The full secret is only ever returned in this response.
+func (a *Admin) createKey(w http.ResponseWriter, r *http.Request) {
+	var k config.VirtualKey
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_json", "Invalid JSON body: "+err.Error())
+		return
+	}
+	if k.Key == "" {
+		k.Key = generateKey()
+	}
+	if err := a.backend.Keys().Add(k); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_key", err.Error())
+		return
+	}
+	fmt.Printf("[%s] ✓ admin: added key %q\n", time.Now().Format("15:04:05"), k.Name)
+	writeJSON(w, http.StatusCreated, k)
+}
+
+func (a *Admin) deleteKey(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !a.backend.Keys().Remove(name) {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "key_not_found", fmt.Sprintf("No key named %q.", name))
+		return
+	}
+	fmt.Printf("[%s] ✓ admin: removed key %q\n", time.Now().Format("15:04:05"), name)
+	writeJSON(w, http.StatusOK, map[string]any{"deleted": name})
+}
+
+func generateKey() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "sk-proxy-" + hex.EncodeToString(b)
+}
+
+// redactConfig masks every secret in cfg, deep-copying what it changes.
+func redactConfig(cfg config.Config) config.Config {
+	cfg.Providers = slices.Clone(cfg.Providers)
+	for i := range cfg.Providers {
+		p := &cfg.Providers[i]
+		p.APIKey = provider.MaskKey(p.APIKey)
+		p.APIKeys = slices.Clone(p.APIKeys)
+		for j := range p.APIKeys {
+			p.APIKeys[j] = provider.MaskKey(p.APIKeys[j])
+		}
+		p.Endpoints = slices.Clone(p.Endpoints)
+		for j := range p.Endpoints {
+			p.Endpoints[j].APIKey = provider.MaskKey(p.Endpoints[j].APIKey)
+		}
+		p.Headers = redactHeaders(p.Headers)
+	}
+	cfg.Headers = redactHeaders(cfg.Headers)
+	cfg.Keys = slices.Clone(cfg.Keys)
+	for i := range cfg.Keys {
+		cfg.Keys[i].Key = provider.MaskKey(cfg.Keys[i].Key)
+	}
+	cfg.Admin.Token = provider.MaskKey(cfg.Admin.Token)
+	cfg.Moderation.APIKey = provider.MaskKey(cfg.Moderation.APIKey)
+	if u, err := url.Parse(cfg.OutboundProxy); err == nil {
+		cfg.OutboundProxy = u.Redacted()
+	}
+	return cfg
+}
+
+// redactHeaders masks the values header rules set, which often carry
+// credentials.
+func redactHeaders(hr config.HeaderRules) config.HeaderRules {
+	for _, rw := range []*config.HeaderRewrite{&hr.Request, &hr.Response} {
+		if rw.Set == nil {
+			continue
+		}
+		set := make(map[string]string, len(rw.Set))
+		for name, v := range rw.Set {
+			set[name] = provider.MaskKey(v)
+		}
+		rw.Set = set
+	}
+	return hr
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
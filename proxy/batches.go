@@ -0,0 +1,360 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"llm-local-proxy/provider"
+	"llm-local-proxy/transform"
+)
+
+// maxBatchObjects bounds how many file and batch ids are remembered; the
+// oldest are forgotten first.
+const maxBatchObjects = 10000
+
+// maxBatchObjectBody bounds the JSON responses read for the ids they contain.
+const maxBatchObjectBody = 4 << 20
+
+// batchObjects remembers which endpoint holds each file and batch created
+// through the proxy. Uploads, batches and their results live in one
+// upstream account, and the follow-up requests carry only an id, so they
+// are pinned to the endpoint that answered first instead of rotating.
+type batchObjects struct {
+	mu    sync.Mutex
+	byID  map[string]batchObject
+	order []string // ids in the order they were learned
+}
+
+// batchObject is where a file or batch lives.
+type batchObject struct {
+	p      provider.Provider
+	ep     *provider.Endpoint
+	output bool // a batch's output or error file, whose downloads are charged
+}
+
+// batchInfo holds the fields of a file or batch object, or of a list of
+// them, that name other objects.
+type batchInfo struct {
+	ID           string      `json:"id"`
+	Object       string      `json:"object"`
+	InputFileID  string      `json:"input_file_id"`
+	OutputFileID string      `json:"output_file_id"`
+	ErrorFileID  string      `json:"error_file_id"`
+	Data         []batchInfo `json:"data"`
+}
+
+func newBatchObjects() *batchObjects {
+	return &batchObjects{byID: make(map[string]batchObject)}
+}
+
+// Inherit carries the ids learned by the handler being replaced on reload,
+// moved to the reloaded provider and endpoint with the same base URL and
+// key where there is one.
+func (b *batchObjects) Inherit(old *batchObjects, registry provider.Registry) {
+	old.mu.Lock()
+	order := append([]string(nil), old.order...)
+	byID := make(map[string]batchObject, len(old.byID))
+	for id, obj := range old.byID {
+		byID[id] = obj
+	}
+	old.mu.Unlock()
+
+	for _, id := range order {
+		obj := byID[id]
+		for _, p := range registry.Providers() {
+			if p.Name() != obj.p.Name() {
+				continue
+			}
+			for _, ep := range p.Pool() {
+				if ep.BaseURL == obj.ep.BaseURL && ep.APIKey == obj.ep.APIKey {
+					obj.p, obj.ep = p, ep
+				}
+			}
+		}
+		b.put(id, obj)
+	}
+}
+
+func (b *batchObjects) put(id string, obj batchObject) {
+	if id == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if prev, ok := b.byID[id]; ok {
+		obj.output = obj.output || prev.output
+		b.byID[id] = obj
+		return
+	}
+	b.byID[id] = obj
+	b.order = append(b.order, id)
+	if len(b.order) > maxBatchObjects {
+		delete(b.byID, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+func (b *batchObjects) get(id string) (batchObject, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj, ok := b.byID[id]
+	return obj, ok
+}
+
+// objectID returns the id in a "/files/{id}..." or "/batches/{id}..." path.
+func objectID(path string) string {
+	parts := strings.Split(strings.TrimPrefix(stripVersionPrefix(path), "/"), "/")
+	if len(parts) < 2 || parts[0] != "files" && parts[0] != "batches" {
+		return ""
+	}
+	return parts[1]
+}
+
+// lookup finds where the object a request refers to lives: the id in its
+// path, or the input file of a batch being created.
+func (b *batchObjects) lookup(path string, head []byte) (batchObject, bool) {
+	if id := objectID(path); id != "" {
+		return b.get(id)
+	}
+	if stripVersionPrefix(path) == "/batches" {
+		if id, ok := transform.StringField(head, "input_file_id"); ok {
+			return b.get(id)
+		}
+	}
+	return batchObject{}, false
+}
+
+// learn records the objects named in a file or batch response body.
+func (b *batchObjects) learn(info batchInfo, obj batchObject) {
+	if info.Object == "file" || info.Object == "batch" {
+		b.put(info.ID, obj)
+	}
+	b.put(info.InputFileID, obj)
+	out := obj
+	out.output = true
+	b.put(info.OutputFileID, out)
+	b.put(info.ErrorFileID, out)
+	for _, item := range info.Data {
+		b.learn(item, obj)
+	}
+}
+
+// observe looks at a successful response of the files and batches
+// endpoints: the objects in JSON bodies are learned, and the downloaded
+// results of a batch are charged to ex as they are relayed.
+func (b *batchObjects) observe(resp *http.Response, path string, obj batchObject, ex *Exchange) {
+	path = stripVersionPrefix(path)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(path, "/files") && !strings.HasPrefix(path, "/batches") {
+		return
+	}
+	if strings.HasSuffix(path, "/content") {
+		if known, ok := b.get(objectID(path)); ok && known.output {
+			resp.Body = &batchUsage{ReadCloser: resp.Body, ex: ex}
+		}
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return
+	}
+	data, err := readAll(io.LimitReader(resp.Body, maxBatchObjectBody))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+	var info batchInfo
+	if err == nil && len(data) < maxBatchObjectBody && json.Unmarshal(data, &info) == nil {
+		b.learn(info, obj)
+	}
+}
+
+// batchUsage sums the usage of the result lines of a batch output file
+// into ex as the download passes through.
+type batchUsage struct {
+	io.ReadCloser
+	ex   *Exchange
+	line []byte
+}
+
+func (u *batchUsage) Read(p []byte) (int, error) {
+	n, err := u.ReadCloser.Read(p)
+	chunk := p[:n]
+	for {
+		i := bytes.IndexByte(chunk, '\n')
+		if i < 0 {
+			u.line = append(u.line, chunk...)
+			break
+		}
+		u.add(append(u.line, chunk[:i]...))
+		u.line, chunk = u.line[:0], chunk[i+1:]
+	}
+	if err == io.EOF {
+		u.add(u.line)
+		u.line = u.line[:0]
+	}
+	return n, err
+}
+
+func (u *batchUsage) add(line []byte) {
+	var result struct {
+		Response struct {
+			Body map[string]any `json:"body"`
+		} `json:"response"`
+	}
+	if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &result) != nil {
+		return
+	}
+	if usage, ok := transform.UsageFromMap(result.Response.Body); ok {
+		u.ex.Usage = u.ex.Usage.Add(usage)
+	}
+	if model, _ := result.Response.Body["model"].(string); model != "" && u.ex.Model == "" {
+		u.ex.Model = model
+	}
+}
+
+// apiVersion returns the trailing version segment of a base URL, such as
+// "v4" for ".../api/paas/v4", or "" when it has none.
+func apiVersion(target *url.URL) string {
+	seg := target.Path[strings.LastIndex(target.Path, "/")+1:]
+	if len(seg) < 2 || seg[0] != 'v' || strings.Trim(seg[1:], "0123456789") != "" {
+		return ""
+	}
+	return seg
+}
+
+// upstreamPath puts the upstream's own version in front of a batch request
+// path, so "/v1/chat/completions" reads "/v4/chat/completions" for an
+// upstream whose base URL ends in "/v4".
+func upstreamPath(path, version string) string {
+	if version == "" {
+		return path
+	}
+	return "/" + version + stripVersionPrefix(path)
+}
+
+// rewriteBatchRequest adapts the requests of the batch workflow to the
+// upstream they go to. A batch input file, uploaded as multipart, has
+// the model aliases, the provider's request transformation and the version
+// of the url resolved on every line while it streams; the endpoint of a
+// batch being created gets the upstream's version. Other bodies are
+// returned as they are.
+func (h *Handler) rewriteBatchRequest(r *http.Request, body io.Reader, head []byte, complete bool, p provider.Provider, version string) io.Reader {
+	path := stripVersionPrefix(r.URL.Path)
+	if r.Method != http.MethodPost {
+		return body
+	}
+	switch {
+	case path == "/files":
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "multipart/form-data" || params["boundary"] == "" {
+			return body
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(copyBatchUpload(pw, body, params["boundary"], func(line []byte) []byte {
+				return h.rewriteBatchLine(line, p, version)
+			}))
+		}()
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+		return pr
+	case path == "/batches" && complete:
+		endpoint, ok := transform.StringField(head, "endpoint")
+		if !ok || version == "" {
+			return body
+		}
+		rewritten, ok := transform.ReplaceStringField(head, "endpoint", upstreamPath(endpoint, version))
+		if !ok {
+			return body
+		}
+		r.ContentLength = int64(len(rewritten))
+		r.Header.Set("Content-Length", fmt.Sprint(len(rewritten)))
+		return bytes.NewReader(rewritten)
+	}
+	return body
+}
+
+// copyBatchUpload copies a multipart upload from src to dst, passing every
+// line of its "file" part through rewrite.
+func copyBatchUpload(dst io.Writer, src io.Reader, boundary string, rewrite func([]byte) []byte) error {
+	mr := multipart.NewReader(src, boundary)
+	mw := multipart.NewWriter(dst)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return mw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		out, err := mw.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+		if part.FormName() != "file" {
+			if _, err := io.Copy(out, part); err != nil {
+				return err
+			}
+			continue
+		}
+		lines := bufio.NewReader(part)
+		for {
+			line, err := lines.ReadBytes('\n')
+			if len(line) > 0 {
+				eol := line[len(bytes.TrimRight(line, "\r\n")):]
+				if _, werr := out.Write(append(rewrite(line[:len(line)-len(eol)]), eol...)); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// rewriteBatchLine prepares one request of a batch input file the way the
+// proxy would prepare it live: the model alias is resolved and, for chat
+// completions, the provider's transformation applied; the url gets the
+// upstream's version. Lines that are not batch requests are kept.
+func (h *Handler) rewriteBatchLine(line []byte, p provider.Provider, version string) []byte {
+	var req map[string]json.RawMessage
+	if json.Unmarshal(line, &req) != nil || req["body"] == nil {
+		return line
+	}
+	var path string
+	json.Unmarshal(req["url"], &path)
+	body := []byte(req["body"])
+	if model, _ := transform.StringField(body, "model"); h.aliases[model] != "" {
+		body = transform.RewriteModel(body, h.aliases[model])
+	}
+	if stripVersionPrefix(path) == "/chat/completions" {
+		body = p.TransformRequest(body)
+	}
+	if !json.Valid(body) {
+		return line
+	}
+	req["body"] = body
+	if path != "" {
+		req["url"], _ = json.Marshal(upstreamPath(path, version))
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		return line
+	}
+	return out
+}
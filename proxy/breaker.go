@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+)
+
+const defaultOpenDuration = 30 * time.Second
+
+// errCircuitOpen is returned instead of contacting an upstream whose circuit is open.
+var errCircuitOpen = errors.New("circuit open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakers tracks one circuit per provider name. After threshold consecutive
+// failures a circuit opens and requests fail fast; once openFor has elapsed a
+// single probe is let through (half-open) to decide whether to close it again.
+type breakers struct {
+	threshold int // 0 = disabled
+	openFor   time.Duration
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreakers(c config.CircuitBreakerConfig) *breakers {
+	return &breakers{
+		threshold: c.FailureThreshold,
+		openFor:   c.OpenDuration.Or(defaultOpenDuration),
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+// allow reports whether a request to the named provider may proceed.
+func (b *breakers) allow(name string) bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.get(name)
+	switch c.state {
+	case breakerOpen:
+		if time.Since(c.openedAt) < b.openFor {
+			return false
+		}
+		c.state = breakerHalfOpen
+		c.probing = true
+		fmt.Printf("  ◐ circuit half-open for %s, probing\n", name)
+		return true
+	case breakerHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the provider's circuit with the outcome of one attempt.
+func (b *breakers) record(name string, failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.get(name)
+	c.probing = false
+	if !failed {
+		if c.state != breakerClosed {
+			fmt.Printf("  ● circuit closed for %s\n", name)
+		}
+		c.state = breakerClosed
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.state == breakerHalfOpen || c.failures >= b.threshold {
+		if c.state != breakerOpen {
+			fmt.Printf("  ○ circuit open for %s after %d failures\n", name, c.failures)
+		}
+		c.state = breakerOpen
+		c.openedAt = time.Now()
+	}
+}
+
+// abandon ends an attempt that says nothing about the provider's health,
+// such as one the client cancelled, letting another probe through.
+func (b *breakers) abandon(name string) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.get(name).probing = false
+}
+
+func (b *breakers) get(name string) *circuit {
+	c, ok := b.circuits[name]
+	if !ok {
+		c = &circuit{}
+		b.circuits[name] = c
+	}
+	return c
+}
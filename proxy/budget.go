@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+// Budgets enforces daily and monthly token/cost budgets globally and per
+// virtual key. Usage is charged after each response, so the request that
+// crosses a limit completes and the following ones are rejected until the
+// window resets. Usage is kept in memory and starts over on restart.
+type Budgets struct {
+	global  config.BudgetConfig
+	keys    *KeyStore
+	pricing map[string]config.ModelPrice
+	next    http.Handler
+
+	mu    sync.Mutex
+	usage map[string]*budgetUsage // "" = global, otherwise key name
+}
+
+// budgetUsage is the consumption within the current day and month windows.
+type budgetUsage struct {
+	Day           string  `json:"day"`
+	Month         string  `json:"month"`
+	DailyTokens   int64   `json:"daily_tokens"`
+	MonthlyTokens int64   `json:"monthly_tokens"`
+	DailyCost     float64 `json:"daily_cost"`
+	MonthlyCost   float64 `json:"monthly_cost"`
+}
+
+// roll resets the windows that have ended.
+func (u *budgetUsage) roll(now time.Time) {
+	day, month := now.Format(time.DateOnly), now.Format("2006-01")
+	if u.Day != day {
+		u.Day, u.DailyTokens, u.DailyCost = day, 0, 0
+	}
+	if u.Month != month {
+		u.Month, u.MonthlyTokens, u.MonthlyCost = month, 0, 0
+	}
+}
+
+// exceeded describes the first limit in b that u has reached, or "".
+func (u *budgetUsage) exceeded(b config.BudgetConfig) string {
+	switch {
+	case b.DailyTokens > 0 && u.DailyTokens >= b.DailyTokens:
+		return fmt.Sprintf("daily token budget (%d)", b.DailyTokens)
+	case b.MonthlyTokens > 0 && u.MonthlyTokens >= b.MonthlyTokens:
+		return fmt.Sprintf("monthly token budget (%d)", b.MonthlyTokens)
+	case b.DailyCost > 0 && u.DailyCost >= b.DailyCost:
+		return fmt.Sprintf("daily cost budget (%.2f)", b.DailyCost)
+	case b.MonthlyCost > 0 && u.MonthlyCost >= b.MonthlyCost:
+		return fmt.Sprintf("monthly cost budget (%.2f)", b.MonthlyCost)
+	}
+	return ""
+}
+
+func NewBudgets(cfg config.Config, keys *KeyStore, next http.Handler) *Budgets {
+	return &Budgets{
+		global:  cfg.Budget,
+		keys:    keys,
+		pricing: cfg.Pricing,
+		next:    next,
+		usage:   make(map[string]*budgetUsage),
+	}
+}
+
+func (b *Budgets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, ex := withExchange(r.Context(), clientID(r, ""))
+
+	if scope, limit := b.check(ex.KeyName); limit != "" {
+		fmt.Printf("[%s] ✗ %s exceeded %s\n", time.Now().Format("15:04:05"), scope, limit)
+		writeError(w, http.StatusTooManyRequests, "insufficient_quota", "budget_exceeded",
+			fmt.Sprintf("The %s for %s has been exceeded. It resets at the start of the next period.", limit, scope))
+		return
+	}
+
+	b.next.ServeHTTP(w, r.WithContext(ctx))
+
+	if ex.Usage.TotalTokens > 0 {
+		b.charge(ex.KeyName, ex.Usage, b.cost(ex.Model, ex.Usage))
+	}
+}
+
+// Inherit takes over the usage counted by old, so a config reload does not
+// reset the current budget windows.
+func (b *Budgets) Inherit(old *Budgets) {
+	old.mu.Lock()
+	usage := make(map[string]*budgetUsage, len(old.usage))
+	for scope, u := range old.usage {
+		cp := *u
+		usage[scope] = &cp
+	}
+	old.mu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.usage = usage
+}
+
+// check returns the scope and limit that block new requests, if any.
+func (b *Budgets) check(keyName string) (string, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if limit := b.get("", now).exceeded(b.global); limit != "" {
+		return "the proxy", limit
+	}
+	if key, ok := b.keys.ByName(keyName); ok {
+		if limit := b.get(keyName, now).exceeded(key.Budget); limit != "" {
+			return fmt.Sprintf("key %q", keyName), limit
+		}
+	}
+	return "", ""
+}
+
+func (b *Budgets) charge(keyName string, u transform.Usage, cost float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	scopes := []string{""}
+	if keyName != "" {
+		scopes = append(scopes, keyName)
+	}
+	for _, scope := range scopes {
+		bu := b.get(scope, now)
+		bu.DailyTokens += int64(u.TotalTokens)
+		bu.MonthlyTokens += int64(u.TotalTokens)
+		bu.DailyCost += cost
+		bu.MonthlyCost += cost
+	}
+}
+
+// cost prices usage by the model's entry in pricing, falling back to "*".
+func (b *Budgets) cost(model string, u transform.Usage) float64 {
+	price, ok := b.pricing[model]
+	if !ok {
+		price = b.pricing["*"]
+	}
+	return (float64(u.PromptTokens)*price.Input + float64(u.CompletionTokens)*price.Output) / 1e6
+}
+
+// get returns the rolled-over usage for scope. Caller holds mu.
+func (b *Budgets) get(scope string, now time.Time) *budgetUsage {
+	bu, ok := b.usage[scope]
+	if !ok {
+		bu = &budgetUsage{}
+		b.usage[scope] = bu
+	}
+	bu.roll(now)
+	return bu
+}
+
+type budgetStatus struct {
+	Scope  string              `json:"scope"`
+	Limits config.BudgetConfig `json:"limits"`
+	Usage  budgetUsage         `json:"usage"`
+}
+
+// Status returns the global budget followed by every key with usage or limits.
+func (b *Budgets) Status() []budgetStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	status := []budgetStatus{{Scope: "global", Limits: b.global, Usage: *b.get("", now)}}
+
+	for _, k := range b.keys.List() {
+		status = append(status, budgetStatus{
+			Scope:  "key:" + k.Name,
+			Limits: k.Budget,
+			Usage:  *b.get(k.Name, now),
+		})
+	}
+	return status
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer keeps the odd huge body from being pinned in the pool.
+const maxPooledBuffer = 1 << 20
+
+// bufferPool recycles the scratch buffers used to read bodies and to build
+// SSE lines, which would otherwise be allocated afresh per request and per
+// chunk across all concurrent streams.
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// scanBufferPool recycles the initial buffers of SSE line scanners.
+var scanBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 64*1024)
+		return &buf
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// readAll reads r to the end into a pooled buffer and returns an exactly
+// sized copy, sparing the repeated growth of io.ReadAll.
+func readAll(r io.Reader) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	_, err := buf.ReadFrom(r)
+	return bytes.Clone(buf.Bytes()), err
+}
+
+func getScanBuffer() *[]byte {
+	return scanBufferPool.Get().(*[]byte)
+}
+
+func putScanBuffer(buf *[]byte) {
+	scanBufferPool.Put(buf)
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// ChaosOptions sets the probability (0..1) of each injected fault.
+type ChaosOptions struct {
+	ErrorRate      float64       // reply 500 without contacting the upstream
+	DisconnectRate float64       // drop the connection partway through the response
+	SlowRate       float64       // delay an individual streamed chunk by SlowDelay
+	SlowDelay      time.Duration // default 2s
+	MalformedRate  float64       // truncate an individual SSE data line
+}
+
+// Enabled reports whether any fault is configured.
+func (o ChaosOptions) Enabled() bool {
+	return o.ErrorRate > 0 || o.DisconnectRate > 0 || o.SlowRate > 0 || o.MalformedRate > 0
+}
+
+// Validate checks that every rate is a probability.
+func (o ChaosOptions) Validate() error {
+	for _, r := range []float64{o.ErrorRate, o.DisconnectRate, o.SlowRate, o.MalformedRate} {
+		if r < 0 || r > 1 {
+			return errors.New("chaos rates must be between 0 and 1")
+		}
+	}
+	if o.SlowDelay < 0 {
+		return errors.New("chaos slow delay must not be negative")
+	}
+	return nil
+}
+
+const defaultChaosSlowDelay = 2 * time.Second
+
+// Chaos injects provider-like misbehavior into responses sent to clients,
+// so their retry and stream-recovery logic can be exercised against the proxy.
+type Chaos struct {
+	opts ChaosOptions
+	next http.Handler
+}
+
+func NewChaos(opts ChaosOptions, next http.Handler) *Chaos {
+	if opts.SlowDelay == 0 {
+		opts.SlowDelay = defaultChaosSlowDelay
+	}
+	return &Chaos{opts: opts, next: next}
+}
+
+func (c *Chaos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if hit(c.opts.ErrorRate) {
+		fmt.Printf("[%s] ⚡ chaos: injected 500 for %s %s\n", time.Now().Format("15:04:05"), r.Method, r.URL.Path)
+		writeError(w, http.StatusInternalServerError, "server_error", "chaos_injected",
+			"The server had an error while processing your request (injected by chaos mode).")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	cw := &chaosWriter{ResponseWriter: w, ctx: ctx, opts: c.opts, cutAfter: -1}
+	if hit(c.opts.DisconnectRate) {
+		// Let a few chunks through so the client sees a stream that starts fine
+		cw.cutAfter = 1 + rand.IntN(8)
+		cw.cancel = cancel
+	}
+	c.next.ServeHTTP(cw, r.WithContext(ctx))
+
+	if cw.cut {
+		fmt.Printf("[%s] ⚡ chaos: dropped connection after %d writes\n", time.Now().Format("15:04:05"), cw.writes)
+		// Aborts the connection without a clean end of the chunked body
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// chaosWriter applies per-chunk faults to the response.
+type chaosWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   ChaosOptions
+
+	writes   int
+	cutAfter int // write index at which the connection drops; -1 = never
+	cut      bool
+}
+
+func (cw *chaosWriter) Write(p []byte) (int, error) {
+	if cw.cut {
+		return 0, http.ErrAbortHandler
+	}
+	stream := isEventStream(cw.Header())
+
+	// Non-streamed bodies are cut on their first write
+	if cw.cancel != nil && (cw.writes >= cw.cutAfter || !stream) {
+		cw.ResponseWriter.Write(p[:len(p)/2])
+		cw.Flush()
+		cw.cut = true
+		cw.cancel()
+		return 0, http.ErrAbortHandler
+	}
+	cw.writes++
+
+	if !stream {
+		return cw.ResponseWriter.Write(p)
+	}
+	if hit(cw.opts.SlowRate) {
+		sleepCtx(cw.ctx, cw.opts.SlowDelay)
+	}
+	if bytes.HasPrefix(p, []byte("data: {")) && hit(cw.opts.MalformedRate) {
+		// Truncated JSON, as seen when a provider splits or mangles an event
+		if _, err := cw.ResponseWriter.Write(append(p[:len(p)/2:len(p)/2], '\n')); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *chaosWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *chaosWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func hit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCompareModels bounds the models of one comparison.
+const maxCompareModels = 8
+
+// isComparePath reports whether path is served by serveCompare.
+func isComparePath(path string) bool {
+	return stripVersionPrefix(path) == "/compare"
+}
+
+// compareResult is the answer of one model of a comparison.
+type compareResult struct {
+	Model     string          `json:"model"`
+	Status    int             `json:"status"`
+	LatencyMS int64           `json:"latency_ms"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// serveCompare sends one chat request to several models at once, given as
+// "models" in place of "model", each through the whole pipeline as if the
+// client had asked it alone. Without streaming it returns all answers when
+// the slowest is done; with streaming the models' chunks are relayed as
+// they arrive, each SSE event named after its model.
+func (h *Handler) serveCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method_not_allowed", "Use POST.")
+		return
+	}
+	body, err := readAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
+		return
+	}
+	r.Body.Close()
+	var req map[string]any
+	if json.Unmarshal(body, &req) != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_request", "The request body is not valid JSON.")
+		return
+	}
+	var models []string
+	list, _ := req["models"].([]any)
+	for _, m := range list {
+		if name, _ := m.(string); name != "" && !strings.ContainsAny(name, "\r\n") && !slices.Contains(models, name) {
+			models = append(models, name)
+		}
+	}
+	if len(models) == 0 || len(models) > maxCompareModels {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_models",
+			fmt.Sprintf("Give between 1 and %d model names in \"models\".", maxCompareModels))
+		return
+	}
+	ex := exchangeFrom(r.Context())
+	for _, model := range models {
+		if !ex.key.AllowsModel(model) {
+			writeError(w, http.StatusForbidden, "invalid_request_error", "model_not_allowed",
+				fmt.Sprintf("The model %q is not allowed for this API key.", model))
+			return
+		}
+	}
+	stream, _ := req["stream"].(bool)
+	delete(req, "models")
+	fmt.Printf("  ⇶ comparing %s\n", strings.Join(models, ", "))
+
+	var events chan compareEvent
+	if stream {
+		events = make(chan compareEvent)
+	}
+	results := make([]compareResult, len(models))
+	exchanges := make([]*Exchange, len(models))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		req["model"] = model
+		subBody, _ := json.Marshal(req)
+		// Every model gets its own Exchange; their usage is added up below
+		sub := &Exchange{Client: ex.Client, KeyName: ex.KeyName, key: ex.key, debug: ex.debug}
+		ctx := context.WithValue(r.Context(), exchangeKey{}, sub)
+		subReq := r.Clone(ctx)
+		subReq.Body = io.NopCloser(bytes.NewReader(subBody))
+		subReq.ContentLength = int64(len(subBody))
+		exchanges[i] = sub
+		cw := &compareWriter{header: make(http.Header), model: model, events: events}
+		wg.Go(func() {
+			start := time.Now()
+			h.serveChat(cw, subReq)
+			cw.finish()
+			results[i] = compareResult{
+				Model:     model,
+				Status:    cmp.Or(cw.status, http.StatusOK),
+				LatencyMS: time.Since(start).Milliseconds(),
+				Response:  cw.response(),
+			}
+		})
+	}
+
+	if stream {
+		go func() {
+			wg.Wait()
+			close(events)
+		}()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		for e := range events {
+			fmt.Fprintf(w, "event: %s\n%s\n\n", e.model, e.data)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		io.WriteString(w, "data: [DONE]\n\n")
+	} else {
+		wg.Wait()
+	}
+
+	for _, sub := range exchanges {
+		ex.Usage = ex.Usage.Add(sub.Usage)
+	}
+	ex.Status = http.StatusOK
+	if !stream {
+		writeJSON(w, http.StatusOK, struct {
+			Object  string          `json:"object"`
+			Results []compareResult `json:"results"`
+		}{"comparison", results})
+	}
+}
+
+// compareEvent is one SSE event of one model's stream.
+type compareEvent struct {
+	model string
+	data  string // the event's data lines
+}
+
+// compareWriter receives the response of one model of a comparison. With
+// events set, the SSE events of a streamed response are sent there as
+// they complete; anything else is kept whole.
+type compareWriter struct {
+	header http.Header
+	model  string
+	status int
+	events chan<- compareEvent
+	buf    bytes.Buffer
+}
+
+func (cw *compareWriter) Header() http.Header { return cw.header }
+
+func (cw *compareWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compareWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.buf.Write(p)
+	if cw.events != nil && isEventStream(cw.header) {
+		for {
+			event, _, ok := bytes.Cut(cw.buf.Bytes(), []byte("\n\n"))
+			if !ok {
+				break
+			}
+			cw.send(event)
+			cw.buf.Next(len(event) + 2)
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compareWriter) Flush() {}
+
+// send passes on the data lines of one SSE event; comments such as
+// keepalive pings are dropped.
+func (cw *compareWriter) send(event []byte) {
+	var data []string
+	for line := range strings.Lines(string(event)) {
+		if line = strings.TrimRight(line, "\r\n"); strings.HasPrefix(line, "data:") {
+			data = append(data, line)
+		}
+	}
+	if len(data) > 0 {
+		cw.events <- compareEvent{cw.model, strings.Join(data, "\n")}
+	}
+}
+
+// finish passes on what is left of a streamed comparison: the rest of the
+// stream, or a response that was not streamed, such as an error.
+func (cw *compareWriter) finish() {
+	if cw.events == nil || cw.buf.Len() == 0 {
+		return
+	}
+	if isEventStream(cw.header) {
+		cw.send(cw.buf.Bytes())
+	} else {
+		cw.events <- compareEvent{cw.model, "data: " + string(cw.body())}
+	}
+	cw.buf.Reset()
+}
+
+// response returns the response of a comparison that is not streamed.
+func (cw *compareWriter) response() json.RawMessage {
+	if cw.events != nil {
+		return nil
+	}
+	return cw.body()
+}
+
+// body returns the response kept whole as one line of JSON, or as a JSON
+// string when it is something else.
+func (cw *compareWriter) body() json.RawMessage {
+	var compact bytes.Buffer
+	if json.Compact(&compact, cw.buf.Bytes()) == nil {
+		return compact.Bytes()
+	}
+	text, _ := json.Marshal(cw.buf.String())
+	return text
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"llm-local-proxy/provider"
+	"llm-local-proxy/transform"
+)
+
+func isCompletionsPath(path string) bool {
+	return stripVersionPrefix(path) == "/completions"
+}
+
+// serveCompletions accepts a legacy text completion request, including
+// fill-in-the-middle ones with a suffix. Providers with a completions_url
+// get it as it is; for upstreams that only offer chat completions the
+// request is translated and runs through the chat pipeline, and its
+// response is translated back. Text completions have no place for
+// reasoning, so the reasoning mode defaults to drop.
+func (h *Handler) serveCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method_not_allowed", "Use POST.")
+		return
+	}
+	body, err := readAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
+		return
+	}
+	r.Body.Close()
+	if p := h.nativeCompletions(r.Context(), body); p != nil {
+		h.serveNativeCompletions(w, r, p, body)
+		return
+	}
+	chat, echo, err := transform.CompletionsToChat(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_request", err.Error())
+		return
+	}
+	fmt.Println("  ⇄ completions → chat/completions")
+
+	stream := transform.NewCompletionStream(echo)
+	tw := &translateWriter{
+		ResponseWriter: w,
+		body: func(resp []byte) ([]byte, error) {
+			return transform.ChatToCompletion(resp, echo)
+		},
+		event: func(data []byte) []byte {
+			if string(data) != "[DONE]" {
+				data = stream.Chunk(data)
+			}
+			return fmt.Appendf(nil, "data: %s\n\n", data)
+		},
+	}
+	h.serveTranslated(tw, r, chat, reasoningDrop)
+}
+
+// nativeCompletions returns the provider of the requested model if it has
+// its own text completion endpoint.
+func (h *Handler) nativeCompletions(ctx context.Context, body []byte) provider.Provider {
+	model, _ := transform.StringField(body, "model")
+	if target, ok := h.aliases[model]; ok {
+		model = target
+	}
+	if p := h.resolve(ctx, model); p != nil && p.CompletionsURL() != "" {
+		return p
+	}
+	return nil
+}
+
+// serveNativeCompletions sends a text completion request to the
+// provider's completions_url with an endpoint key from its pool, under the
+// same aliases, concurrency limits, retries, circuit breaker and accounting
+// as chat requests, and relays the response.
+func (h *Handler) serveNativeCompletions(w http.ResponseWriter, r *http.Request, p provider.Provider, body []byte) {
+	ex := exchangeFrom(r.Context())
+	ex.requestBytes = len(body)
+	defer ex.finishUsage()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	body, alias := h.resolveAlias(body)
+	model, _ := transform.StringField(body, "model")
+	if !allowModel(w, ex.key, model) {
+		return
+	}
+	h.logRequestParams(body)
+	release, err := h.concurrency.acquire(ctx, model)
+	if err != nil {
+		fmt.Printf("  ✗ %v\n", err)
+		writeError(w, http.StatusServiceUnavailable, "server_error", "overloaded", "The proxy is overloaded: "+err.Error())
+		return
+	}
+	defer release()
+
+	fmt.Printf("  → provider: %s (%s)\n", p.Name(), p.CompletionsURL())
+	resp, err := h.sendTo(ctx, r, p, p.CompletionsURL(), body)
+	if errors.Is(err, errCircuitOpen) {
+		fmt.Printf("  ✗ circuit open for %s, failing fast\n", p.Name())
+		writeUpstreamError(w, p, err)
+		return
+	}
+	if err != nil {
+		fmt.Printf("  ✗ upstream error: %v\n", err)
+		writeUpstreamError(w, p, err)
+		return
+	}
+	defer resp.Body.Close()
+	ex.Model, ex.Provider, ex.Status = model, p.Name(), resp.StatusCode
+	h.headers.response(p.Name(), resp.Header)
+	copyResponseHeaders(w, resp)
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		respBody, _ := readAll(resp.Body)
+		respBody = normalizeErrorBody(w.Header(), resp, respBody)
+		if u, ok := transform.UsageFromBody(respBody); ok {
+			ex.Usage = ex.Usage.Add(u)
+		}
+		if alias != "" {
+			respBody = transform.RewriteResponseModel(respBody, alias)
+		}
+		ex.responseBytes = len(respBody)
+		w.WriteHeader(resp.StatusCode)
+		w.Write(respBody)
+		return
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	w, stopKeepalive := newKeepaliveWriter(w, h.keepalive)
+	defer stopKeepalive()
+	idle := newIdleReader(resp.Body, h.streamIdle, cancel)
+	defer idle.Stop()
+	werr := h.relayCompletionStream(w, idle, ex, alias)
+	switch {
+	case idle.TimedOut():
+		fmt.Printf("  ✗ stream idle for %v, aborted\n", h.streamIdle)
+		ex.Status = http.StatusGatewayTimeout
+		writeStreamError(w, "server_error", "stream_idle_timeout",
+			fmt.Sprintf("The upstream sent nothing for %v; the stream was aborted.", h.streamIdle))
+	case werr != nil || r.Context().Err() != nil:
+		cancel()
+		fmt.Printf("  ✗ client disconnected after %d bytes, upstream request cancelled\n", ex.responseBytes)
+	}
+}
+
+// relayCompletionStream copies a text completion stream line by line,
+// reporting the alias as the model and recording the usage chunk. It
+// returns the error of a failed write to the client.
+func (h *Handler) relayCompletionStream(w http.ResponseWriter, body io.Reader, ex *Exchange, alias string) error {
+	flusher, _ := w.(http.Flusher)
+	scanBuf := getScanBuffer()
+	defer putScanBuffer(scanBuf)
+	scanner := transform.NewSSEScanner(body, *scanBuf, h.sseMaxLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if payload, ok := bytes.CutPrefix(line, []byte("data: ")); ok && string(payload) != "[DONE]" {
+			if alias != "" {
+				if rewritten, ok := transform.ReplaceStringField(payload, "model", alias); ok {
+					line = append([]byte("data: "), rewritten...)
+				}
+			}
+			if transform.FieldIsSet(payload, "usage") && !transform.FieldIsNull(payload, "usage") {
+				if u, ok := transform.UsageFromBody(payload); ok {
+					ex.Usage = u
+				}
+			}
+		}
+		n, err := fmt.Fprintf(w, "%s\n", line)
+		ex.responseBytes += n
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 && flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
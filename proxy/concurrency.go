@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+)
+
+const (
+	defaultMaxQueue     = 100
+	defaultQueueTimeout = 60 * time.Second
+)
+
+var (
+	errQueueFull    = errors.New("concurrency queue is full")
+	errQueueTimeout = errors.New("timed out waiting in concurrency queue")
+)
+
+// fifoSemaphore admits up to limit holders; further callers wait in arrival
+// order, bounded by maxQueue.
+type fifoSemaphore struct {
+	limit    int
+	maxQueue int
+
+	mu      sync.Mutex
+	active  int
+	waiters list.List // of chan struct{}, closed when the waiter is admitted
+}
+
+func newFIFOSemaphore(limit, maxQueue int) *fifoSemaphore {
+	return &fifoSemaphore{limit: limit, maxQueue: maxQueue}
+}
+
+func (s *fifoSemaphore) acquire(ctx context.Context, timeout time.Duration) error {
+	s.mu.Lock()
+	if s.active < s.limit && s.waiters.Len() == 0 {
+		s.active++
+		s.mu.Unlock()
+		return nil
+	}
+	if s.waiters.Len() >= s.maxQueue {
+		s.mu.Unlock()
+		return errQueueFull
+	}
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(ready)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-ready:
+		// Admitted while giving up: hand the slot on
+		s.active--
+		s.admitNext()
+	default:
+		s.waiters.Remove(elem)
+	}
+	return err
+}
+
+func (s *fifoSemaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.admitNext()
+}
+
+// admitNext wakes the oldest waiter if a slot is free. Caller holds mu.
+func (s *fifoSemaphore) admitNext() {
+	for s.active < s.limit && s.waiters.Len() > 0 {
+		ready := s.waiters.Remove(s.waiters.Front()).(chan struct{})
+		s.active++
+		close(ready)
+	}
+}
+
+// concurrencyLimiter holds the global and per-model semaphores.
+type concurrencyLimiter struct {
+	global   *fifoSemaphore
+	perModel map[string]*fifoSemaphore
+	timeout  time.Duration
+}
+
+// newConcurrencyLimiter returns nil when no limits are configured.
+func newConcurrencyLimiter(c config.ConcurrencyConfig) *concurrencyLimiter {
+	if c.MaxConcurrent <= 0 && len(c.PerModel) == 0 {
+		return nil
+	}
+	maxQueue := c.MaxQueue
+	if maxQueue <= 0 {
+		maxQueue = defaultMaxQueue
+	}
+
+	cl := &concurrencyLimiter{
+		perModel: make(map[string]*fifoSemaphore),
+		timeout:  c.QueueTimeout.Or(defaultQueueTimeout),
+	}
+	if c.MaxConcurrent > 0 {
+		cl.global = newFIFOSemaphore(c.MaxConcurrent, maxQueue)
+	}
+	for model, limit := range c.PerModel {
+		if limit > 0 {
+			cl.perModel[model] = newFIFOSemaphore(limit, maxQueue)
+		}
+	}
+	return cl
+}
+
+// acquire waits for a per-model slot and then a global one, returning the
+// function that releases both.
+func (cl *concurrencyLimiter) acquire(ctx context.Context, model string) (func(), error) {
+	if cl == nil {
+		return func() {}, nil
+	}
+
+	var held []*fifoSemaphore
+	release := func() {
+		for _, s := range held {
+			s.release()
+		}
+	}
+
+	for _, s := range []*fifoSemaphore{cl.perModel[model], cl.global} {
+		if s == nil {
+			continue
+		}
+		if err := s.acquire(ctx, cl.timeout); err != nil {
+			release()
+			return nil, err
+		}
+		held = append(held, s)
+	}
+	return release, nil
+}
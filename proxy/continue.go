@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"llm-local-proxy/provider"
+	"llm-local-proxy/transform"
+)
+
+const defaultContinuePrompt = "Continue exactly where you stopped, without repeating anything you already wrote."
+
+// continuation resumes responses cut off by the token limit
+// (finish_reason "length") or, for streams, by the upstream dropping the
+// connection: the partial answer is sent back as an assistant message with
+// a request to continue, and the parts are stitched into one answer for the
+// client. A nil *continuation does nothing.
+type continuation struct {
+	h      *Handler
+	ctx    context.Context
+	r      *http.Request
+	p      provider.Provider
+	prompt string
+
+	request   map[string]any  // decoded body of the original request
+	remaining int             // continuations left after "length"
+	resumes   int             // resumes left after a dropped stream
+	text      strings.Builder // content generated so far
+	toolCalls bool            // a part called tools; those are not continued
+	cut       bool            // the current part ended with finish_reason "length"
+	finished  bool            // the current part reported any other finish_reason
+	usage     map[string]any  // summed usage of the finished parts
+	logprobs  any             // logprobs of the finished parts
+
+	idle    *idleReader // watchdog of the latest part
+	closers []func()
+}
+
+// newContinuation returns nil unless auto_continue is configured and the
+// request body decodes.
+func (h *Handler) newContinuation(ctx context.Context, r *http.Request, p provider.Provider, body []byte) *continuation {
+	if h.autoContinue.MaxContinuations <= 0 && h.autoContinue.MaxResumes <= 0 {
+		return nil
+	}
+	var request map[string]any
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil
+	}
+	prompt := h.autoContinue.Prompt
+	if prompt == "" {
+		prompt = defaultContinuePrompt
+	}
+	return &continuation{h: h, ctx: ctx, r: r, p: p, prompt: prompt, request: request,
+		remaining: h.autoContinue.MaxContinuations, resumes: h.autoContinue.MaxResumes}
+}
+
+// observe records a streamed choice and hides a "length" finish that will
+// be continued.
+func (c *continuation) observe(choices []any) {
+	if c == nil || len(choices) == 0 {
+		return
+	}
+	choice, _ := choices[0].(map[string]any)
+	delta, _ := choice["delta"].(map[string]any)
+	if s, ok := delta["content"].(string); ok {
+		c.text.WriteString(s)
+	}
+	if delta["tool_calls"] != nil {
+		c.toolCalls = true
+	}
+	switch {
+	case choice["finish_reason"] == "length" && c.remaining > 0 && !c.toolCalls:
+		c.cut = true
+		choice["finish_reason"] = nil
+	case choice["finish_reason"] != nil:
+		c.finished = true
+	}
+}
+
+// observeUsage folds the usage of a chunk into the parts' total. The usage
+// of a part that is continued is removed from the chunk; the last part's
+// chunk reports the total.
+func (c *continuation) observeUsage(data map[string]any) {
+	u, ok := data["usage"].(map[string]any)
+	if c == nil || !ok {
+		return
+	}
+	c.usage = sumUsage(c.usage, u)
+	if c.cut {
+		delete(data, "usage")
+	} else {
+		data["usage"] = c.usage
+	}
+}
+
+// resume starts the next part when the current one was cut off, or when
+// it ended before finishing (interrupted) and the client is still there,
+// and returns its stream. When that fails, a held back "length" finish is
+// written to w and nil is returned.
+func (c *continuation) resume(w io.Writer, interrupted bool) io.Reader {
+	if c == nil {
+		return nil
+	}
+	cut := c.cut
+	switch {
+	case cut:
+		c.remaining--
+		fmt.Printf("  ↪ cut off at the token limit, continuing (%d left)\n", c.remaining)
+	case interrupted && !c.finished && !c.toolCalls && c.resumes > 0 && c.ctx.Err() == nil:
+		c.resumes--
+		fmt.Printf("  ↪ upstream stream dropped, resuming (%d left)\n", c.resumes)
+	default:
+		return nil
+	}
+	c.cut, c.finished = false, false
+
+	body, err := c.send()
+	if err != nil {
+		fmt.Printf("  ✗ continuation failed: %v\n", err)
+		if cut {
+			fmt.Fprintf(w, "data: %s\n\n", `{"choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`)
+		}
+		return nil
+	}
+	c.idle = newIdleReader(body, c.h.streamIdle, func() { body.Close() })
+	c.closers = append(c.closers, c.idle.Stop, func() { body.Close() })
+	return c.idle
+}
+
+// timedOut reports whether the latest continued part went idle.
+func (c *continuation) timedOut() bool {
+	return c != nil && c.idle != nil && c.idle.TimedOut()
+}
+
+// complete continues a non-streaming response while it is cut off, and
+// returns the last response with the content of all parts and their
+// summed usage.
+func (c *continuation) complete(respBody []byte) []byte {
+	if c == nil {
+		return respBody
+	}
+	part := respBody // the latest part as the upstream sent it
+	for c.remaining > 0 {
+		var resp map[string]any
+		if json.Unmarshal(part, &resp) != nil {
+			break
+		}
+		choices, _ := resp["choices"].([]any)
+		if len(choices) == 0 {
+			break
+		}
+		choice, _ := choices[0].(map[string]any)
+		msg, _ := choice["message"].(map[string]any)
+		content, _ := msg["content"].(string)
+		if choice["finish_reason"] != "length" || msg["tool_calls"] != nil {
+			break
+		}
+		c.text.WriteString(content)
+		c.logprobs = transform.AppendLogprobs(c.logprobs, choice["logprobs"])
+		if u, ok := resp["usage"].(map[string]any); ok {
+			c.usage = sumUsage(c.usage, u)
+		}
+		c.remaining--
+		fmt.Printf("  ↪ cut off at the token limit, continuing (%d left)\n", c.remaining)
+
+		body, err := c.send()
+		if err != nil {
+			fmt.Printf("  ✗ continuation failed: %v\n", err)
+			break
+		}
+		next, _ := readAll(body)
+		body.Close()
+		if stitched, ok := c.stitch(next); ok {
+			part, respBody = next, stitched
+			continue
+		}
+		break
+	}
+	return respBody
+}
+
+// stitch puts the content and logprobs of all parts and the summed usage
+// into the response of the latest part.
+func (c *continuation) stitch(next []byte) ([]byte, bool) {
+	var resp map[string]any
+	if json.Unmarshal(next, &resp) != nil {
+		return nil, false
+	}
+	choices, _ := resp["choices"].([]any)
+	if len(choices) == 0 {
+		return nil, false
+	}
+	choice, _ := choices[0].(map[string]any)
+	msg, _ := choice["message"].(map[string]any)
+	content, _ := msg["content"].(string)
+	msg["content"] = c.text.String() + content
+	if c.logprobs != nil {
+		choice["logprobs"] = transform.AppendLogprobs(transform.AppendLogprobs(nil, c.logprobs), choice["logprobs"])
+	}
+	if u, ok := resp["usage"].(map[string]any); ok {
+		resp["usage"] = sumUsage(c.usage, u)
+	}
+	stitched, err := json.Marshal(resp)
+	return stitched, err == nil
+}
+
+// send requests the next part: the original messages, the answer so far
+// and the continue prompt.
+func (c *continuation) send() (io.ReadCloser, error) {
+	messages, _ := c.request["messages"].([]any)
+	messages = append(slices.Clone(messages),
+		map[string]any{"role": "assistant", "content": c.text.String()},
+		map[string]any{"role": "user", "content": c.prompt},
+	)
+	req := make(map[string]any, len(c.request))
+	for k, v := range c.request {
+		req[k] = v
+	}
+	req["messages"] = messages
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.h.send(c.ctx, c.r, c.p, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// close releases the streams of continued parts.
+func (c *continuation) close() {
+	if c == nil {
+		return
+	}
+	for _, f := range c.closers {
+		f()
+	}
+}
+
+// sumUsage adds the numeric fields of two usage objects.
+func sumUsage(a, b map[string]any) map[string]any {
+	sum := make(map[string]any, len(b))
+	for k, v := range b {
+		sum[k] = v
+	}
+	for k, v := range a {
+		x, ok1 := v.(float64)
+		y, ok2 := sum[k].(float64)
+		if ok1 && ok2 {
+			sum[k] = x + y
+		}
+	}
+	return sum
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+)
+
+// openUpstreamConns counts live TCP connections to upstreams (and outbound
+// proxies), for spotting leaked connections during long streaming sessions.
+var openUpstreamConns atomic.Int64
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// countingDial wraps dial so every connection is counted until closed.
+func countingDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		openUpstreamConns.Add(1)
+		return &countedConn{Conn: conn}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	closed atomic.Bool
+}
+
+func (c *countedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		openUpstreamConns.Add(-1)
+	}
+	return c.Conn.Close()
+}
+
+// DebugRuntime reports goroutine, upstream connection and memory figures.
+func DebugRuntime(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"goroutines":     runtime.NumGoroutine(),
+		"upstream_conns": openUpstreamConns.Load(),
+		"memory": map[string]any{
+			"heap_alloc":     m.HeapAlloc,
+			"heap_inuse":     m.HeapInuse,
+			"heap_objects":   m.HeapObjects,
+			"stack_inuse":    m.StackInuse,
+			"sys":            m.Sys,
+			"total_alloc":    m.TotalAlloc,
+			"num_gc":         m.NumGC,
+			"pause_total_ns": m.PauseTotalNs,
+		},
+	})
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/provider"
+	"llm-local-proxy/transform"
+)
+
+const defaultEmbeddingsMaxBatch = 64
+
+// embeddingBatcher coalesces single-input embeddings requests that arrive
+// within a short window into one upstream call with all inputs, and splits
+// the vectors back out to the waiting clients. A nil *embeddingBatcher
+// batches nothing.
+type embeddingBatcher struct {
+	window   time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	pending map[string]*embeddingBatch // by provider, client credentials and request options
+}
+
+// embeddingBatch is one upstream call being collected.
+type embeddingBatch struct {
+	h       *Handler
+	p       provider.Provider
+	r       *http.Request  // first request; its headers go upstream
+	options map[string]any // the request without its input
+	inputs  []string
+	waiters []chan embeddingResult
+	timer   *time.Timer
+}
+
+// embeddingResult is the response for one batched request.
+type embeddingResult struct {
+	status int
+	body   []byte
+	usage  transform.Usage
+}
+
+// newEmbeddingBatcher returns nil unless embeddings_batch has a window.
+func newEmbeddingBatcher(cfg config.EmbeddingsBatchConfig) *embeddingBatcher {
+	if cfg.Window <= 0 {
+		return nil
+	}
+	return &embeddingBatcher{
+		window:   time.Duration(cfg.Window),
+		maxBatch: cmp.Or(cfg.MaxBatch, defaultEmbeddingsMaxBatch),
+		pending:  make(map[string]*embeddingBatch),
+	}
+}
+
+// serve answers a request for a single input through a batch and reports
+// true; other requests (several inputs, token arrays) are left to the
+// caller. body is the complete request body.
+func (b *embeddingBatcher) serve(h *Handler, w http.ResponseWriter, r *http.Request, p provider.Provider, body []byte) bool {
+	if b == nil || r.Method != http.MethodPost {
+		return false
+	}
+	var req map[string]any
+	if json.Unmarshal(body, &req) != nil {
+		return false
+	}
+	input, ok := req["input"].(string)
+	if list, isList := req["input"].([]any); isList && len(list) == 1 {
+		input, ok = list[0].(string)
+	}
+	if !ok {
+		return false
+	}
+	delete(req, "input")
+	// Requests batch together only when everything but the input matches,
+	// and only with requests from the same caller: the first request's
+	// headers go upstream, so another client's key would be used otherwise
+	options, _ := json.Marshal(req)
+	key := p.Name() + "\x00" + clientCredentials(r) + "\x00" + string(options)
+
+	ch := make(chan embeddingResult, 1)
+	b.mu.Lock()
+	batch := b.pending[key]
+	if batch == nil {
+		batch = &embeddingBatch{h: h, p: p, r: r, options: req}
+		b.pending[key] = batch
+		batch.timer = time.AfterFunc(b.window, func() { b.flush(key, batch) })
+	}
+	batch.inputs = append(batch.inputs, input)
+	batch.waiters = append(batch.waiters, ch)
+	full := len(batch.inputs) >= b.maxBatch
+	b.mu.Unlock()
+	if full {
+		b.flush(key, batch)
+	}
+
+	ex := exchangeFrom(r.Context())
+	select {
+	case res := <-ch:
+		ex.Status, ex.Usage = res.status, res.usage
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(res.status)
+		w.Write(res.body)
+	case <-r.Context().Done():
+	}
+	return true
+}
+
+// clientCredentials returns the credential headers r carries, in a fixed
+// order.
+func clientCredentials(r *http.Request) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(credentialHeaders)) {
+		for _, v := range r.Header.Values(name) {
+			b.WriteString(name + ": " + v + "\n")
+		}
+	}
+	return b.String()
+}
+
+// flush sends batch unless it was already sent.
+func (b *embeddingBatcher) flush(key string, batch *embeddingBatch) {
+	b.mu.Lock()
+	if b.pending[key] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	batch.timer.Stop()
+	b.mu.Unlock()
+	go batch.send()
+}
+
+// send makes the upstream call and hands every waiter its share.
+func (batch *embeddingBatch) send() {
+	fmt.Printf("  ∑ %d embeddings requests batched → %s\n", len(batch.inputs), batch.p.Name())
+	req := make(map[string]any, len(batch.options)+1)
+	for k, v := range batch.options {
+		req[k] = v
+	}
+	req["input"] = batch.inputs
+	body, _ := json.Marshal(req)
+
+	// The batch outlives the request that opened it
+	ctx := context.WithoutCancel(batch.r.Context())
+	resp, err := batch.h.sendTo(ctx, batch.r, batch.p, "/embeddings", body)
+	if err != nil {
+		fmt.Printf("  ✗ upstream error: %v\n", err)
+		status, code, message := upstreamError(batch.p, err)
+		batch.fail(status, errorJSON("server_error", code, message))
+		return
+	}
+	respBody, _ := readAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		batch.fail(resp.StatusCode, normalizeErrorBody(make(http.Header), resp, respBody))
+		return
+	}
+
+	var data map[string]any
+	if json.Unmarshal(respBody, &data) != nil {
+		batch.fail(http.StatusBadGateway, respBody)
+		return
+	}
+	items, _ := data["data"].([]any)
+	byIndex := make(map[int]any, len(items))
+	for _, it := range items {
+		item, _ := it.(map[string]any)
+		idx, _ := item["index"].(float64)
+		item["index"] = 0
+		byIndex[int(idx)] = item
+	}
+	u, _ := transform.UsageFromBody(respBody)
+	shares := splitTokens(u.PromptTokens, batch.inputs)
+	for i, ch := range batch.waiters {
+		one := make(map[string]any, len(data))
+		for k, v := range data {
+			one[k] = v
+		}
+		one["data"] = []any{byIndex[i]}
+		one["usage"] = map[string]any{"prompt_tokens": shares[i], "total_tokens": shares[i]}
+		out, _ := json.Marshal(one)
+		ch <- embeddingResult{status: http.StatusOK, body: out,
+			usage: transform.Usage{PromptTokens: shares[i], TotalTokens: shares[i]}}
+	}
+}
+
+// fail hands every waiter the same error response.
+func (batch *embeddingBatch) fail(status int, body []byte) {
+	for _, ch := range batch.waiters {
+		ch <- embeddingResult{status: status, body: body}
+	}
+}
+
+// splitTokens divides the batch's prompt tokens among the inputs in
+// proportion to their length; the last input gets the rounding remainder.
+func splitTokens(tokens int, inputs []string) []int {
+	total := 0
+	for _, in := range inputs {
+		total += len(in)
+	}
+	shares := make([]int, len(inputs))
+	left := tokens
+	for i, in := range inputs[:len(inputs)-1] {
+		if total > 0 {
+			shares[i] = tokens * len(in) / total
+		}
+		left -= shares[i]
+	}
+	shares[len(inputs)-1] = left
+	return shares
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	"llm-local-proxy/provider"
+)
+
+// writeError sends an error in the OpenAI error envelope, which SDKs know how
+// to surface: {"error": {"message", "type", "code"}}.
+func writeError(w http.ResponseWriter, status int, errType, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(errorJSON(errType, code, message), '\n'))
+}
+
+// writeStreamError ends a stream whose status line was already sent with a
+// final SSE event carrying the same envelope.
+func writeStreamError(w io.Writer, errType, code, message string) {
+	fmt.Fprintf(w, "data: %s\n\n", errorJSON(errType, code, message))
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// errorJSON returns the error envelope.
+func errorJSON(errType, code, message string) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    code,
+		},
+	})
+	return data
+}
+
+// writeUpstreamError reports a request to p that got no response: an open
+// circuit, a timeout or a failed connection. It returns the status sent.
+func writeUpstreamError(w http.ResponseWriter, p provider.Provider, err error) int {
+	status, code, message := upstreamError(p, err)
+	writeError(w, status, "server_error", code, message)
+	return status
+}
+
+// upstreamError returns the status, code and message reporting a request
+// to p that failed with err.
+func upstreamError(p provider.Provider, err error) (int, string, string) {
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		return http.StatusServiceUnavailable, "circuit_open",
+			fmt.Sprintf("Provider %s is temporarily unavailable after repeated failures (circuit open).", p.Name())
+	case isTimeout(err):
+		return http.StatusGatewayTimeout, "upstream_timeout", fmt.Sprintf("Provider %s did not respond in time.", p.Name())
+	default:
+		return http.StatusBadGateway, "upstream_connection_failed", fmt.Sprintf("Could not reach provider %s.", p.Name())
+	}
+}
+
+// isTimeout reports whether err is a deadline or network timeout.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout()
+}
+
+// maxErrorMessage bounds the upstream text quoted in a normalized error.
+const maxErrorMessage = 1000
+
+// maxRelayedError bounds the relayed error bodies that are normalized;
+// longer ones pass through as they are.
+const maxRelayedError = 64 << 10
+
+// normalizeErrorBody puts the body of an upstream error response in the
+// error envelope unless it already is, setting the Content-Type in header
+// to match: a bare message, plain text or the HTML error page of a gateway
+// in front of the upstream become the message. Other bodies are returned
+// as they are.
+func normalizeErrorBody(header http.Header, resp *http.Response, body []byte) []byte {
+	if resp.StatusCode < 400 {
+		return body
+	}
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	isJSON := json.Unmarshal(body, &parsed) == nil
+	var envelope struct {
+		Message string `json:"message"`
+	}
+	if isJSON && json.Unmarshal(parsed.Error, &envelope) == nil && envelope.Message != "" {
+		return body
+	}
+
+	var message string
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case isJSON && json.Unmarshal(parsed.Error, &message) == nil && message != "":
+	case isJSON && parsed.Message != "":
+		message = parsed.Message
+	case !isJSON && mediaType != "text/html" && len(bytes.TrimSpace(body)) > 0:
+		message = strings.ToValidUTF8(string(bytes.TrimSpace(body[:min(len(body), maxErrorMessage)])), "")
+	default:
+		message = "The upstream returned " + resp.Status + "."
+	}
+	header.Set("Content-Type", "application/json")
+	return errorJSON(errorType(resp.StatusCode), "upstream_error", message)
+}
+
+// normalizeRelayedError normalizes the body of an upstream error response
+// relayed by the passthrough. Compressed and long bodies are left alone.
+func normalizeRelayedError(resp *http.Response) {
+	if resp.StatusCode < 400 || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	body, err := readAll(io.LimitReader(resp.Body, maxRelayedError))
+	if err != nil || len(body) == maxRelayedError {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return
+	}
+	resp.Body.Close()
+	body = normalizeErrorBody(resp.Header, resp, body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", fmt.Sprint(len(body)))
+}
+
+// errorType returns the error type OpenAI uses for a status code.
+func errorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	}
+	return "invalid_request_error"
+}
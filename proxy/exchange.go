@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+// Exchange collects what the handler learned about one proxied request, for
+// middleware that acts after the response (rate limiting, budgets, stats).
+type Exchange struct {
+	Client   string // client identity, see clientID
+	KeyName  string // virtual key the client authenticated with, if any
+	Model    string // model that served the request (after any failover)
+	Provider string // provider that served the request
+	Status   int
+
+	UpstreamRequestID string // id the upstream gave its response, if any
+	Variant           string // "experiment/variant" the request was assigned to, if any
+
+	Usage          transform.Usage
+	UsageEstimated bool // Usage was estimated from body sizes, not reported upstream
+
+	key            config.VirtualKey            // key the client authenticated with; the zero key allows every model
+	upstreamLimits map[string]UpstreamRateLimit // provider → rate limit state its responses reported
+	debug          *debugDump                   // set when the request is dumped
+	conversation   []byte                       // conversation hash for sticky providers; nil = not tracked
+	requestBytes   int
+	responseBytes  int
+}
+
+type exchangeKey struct{}
+
+// withExchange attaches a new Exchange to the request context.
+func withExchange(ctx context.Context, client string) (context.Context, *Exchange) {
+	if ex, ok := ctx.Value(exchangeKey{}).(*Exchange); ok {
+		return ctx, ex
+	}
+	ex := &Exchange{Client: client}
+	return context.WithValue(ctx, exchangeKey{}, ex), ex
+}
+
+// exchangeFrom returns the request's Exchange, or a detached one when no
+// middleware installed it, so callers never need nil checks.
+func exchangeFrom(ctx context.Context) *Exchange {
+	if ex, ok := ctx.Value(exchangeKey{}).(*Exchange); ok {
+		return ex
+	}
+	return &Exchange{}
+}
+
+// finishUsage estimates usage from body sizes when the upstream didn't report it.
+func (ex *Exchange) finishUsage() {
+	if ex.Usage.TotalTokens > 0 || ex.Status != 200 {
+		return
+	}
+	ex.Usage = transform.Usage{
+		PromptTokens:     transform.EstimateTokens(ex.requestBytes),
+		CompletionTokens: transform.EstimateTokens(ex.responseBytes),
+	}
+	ex.Usage.TotalTokens = ex.Usage.PromptTokens + ex.Usage.CompletionTokens
+	ex.UsageEstimated = true
+}
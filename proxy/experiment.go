@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+// experimentSet holds the experiments by the model they apply to.
+type experimentSet map[string]config.Experiment
+
+func newExperiments(experiments []config.Experiment) experimentSet {
+	if len(experiments) == 0 {
+		return nil
+	}
+	byModel := make(experimentSet, len(experiments))
+	for _, e := range experiments {
+		byModel[e.Model] = e
+	}
+	return byModel
+}
+
+// assignVariant sends a request for a model under experiment to the
+// variant of its conversation: the model is replaced and the variant's
+// params forced. The response carries the variant in X-Proxy-Variant. It
+// returns the model the client asked for, to be reported back in the
+// response, or "" when no experiment applies.
+func (h *Handler) assignVariant(w http.ResponseWriter, r *http.Request, body []byte, ex *Exchange) ([]byte, string) {
+	if len(h.experiments) == 0 {
+		return body, ""
+	}
+	var req struct {
+		Model    string            `json:"model"`
+		Messages []json.RawMessage `json:"messages"`
+	}
+	json.Unmarshal(body, &req)
+	e, ok := h.experiments[req.Model]
+	if !ok {
+		return body, ""
+	}
+
+	v := e.Variants[len(e.Variants)-1]
+	bucket := conversationBucket(r, ex, req.Messages)
+	for _, candidate := range e.Variants {
+		if bucket < candidate.Percent {
+			v = candidate
+			break
+		}
+		bucket -= candidate.Percent
+	}
+	ex.Variant = e.Name + "/" + v.Name
+	w.Header().Set("X-Proxy-Variant", ex.Variant)
+	fmt.Printf("  ⚗ experiment %s: variant %s → %s\n", e.Name, v.Name, v.Model)
+	body = transform.RewriteModel(body, v.Model)
+	return transform.ApplyParams(body, nil, v.Params), req.Model
+}
+
+// conversationBucket places the conversation of a request in [0, 100).
+func conversationBucket(r *http.Request, ex *Exchange, messages []json.RawMessage) float64 {
+	sum := conversationHash(r, ex, messages)
+	return float64(binary.BigEndian.Uint64(sum[:8])%10000) / 100
+}
@@ -0,0 +1,24 @@
+package proxy
+
+import "llm-local-proxy/transform"
+
+// plainChunk reports whether a stream chunk passes through processSSE
+// unchanged apart from the model alias, so it can be forwarded without a
+// JSON round-trip: no reasoning to merge or drop, no finish or usage to
+// observe, and nothing the configured tool call and logprobs handling
+// rewrites. Features that inspect every chunk (continuation, stream usage,
+// the reasoning store, debug output) are checked by the caller.
+func (h *Handler) plainChunk(data []byte, states transform.StreamStates, mode string, legacy bool) bool {
+	if mode != reasoningNative {
+		if states.Open() || transform.FieldIsSet(data, "reasoning_content") || transform.FieldIsNull(data, "content") {
+			return false
+		}
+	}
+	if transform.FieldIsSet(data, "finish_reason") || transform.FieldIsSet(data, "usage") {
+		return false
+	}
+	if (h.repairTools || legacy) && transform.FieldIsSet(data, "tool_calls") {
+		return false
+	}
+	return !h.stripLogprobs || !transform.FieldIsSet(data, "logprobs")
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"cmp"
+	"regexp"
+	"strings"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+const (
+	defaultGuardrailWindow   = 64
+	defaultGuardrailRedacted = "[REDACTED]"
+	defaultGuardrailBlocked  = "\n\n[The answer was stopped by the content policy.]"
+)
+
+// guardrailSet holds the compiled guardrail rules.
+type guardrailSet struct {
+	rules  []transform.Guardrail
+	window int
+}
+
+// newGuardrails compiles the configured rules; keywords become one
+// case-insensitive alternation. Patterns were checked by Config.Validate.
+func newGuardrails(cfg config.GuardrailsConfig) guardrailSet {
+	var rules []transform.Guardrail
+	for _, rule := range cfg.Rules {
+		var alternatives []string
+		if rule.Pattern != "" {
+			alternatives = append(alternatives, "(?:"+rule.Pattern+")")
+		}
+		if len(rule.Keywords) > 0 {
+			quoted := make([]string, len(rule.Keywords))
+			for i, k := range rule.Keywords {
+				quoted[i] = regexp.QuoteMeta(k)
+			}
+			alternatives = append(alternatives, "(?i:"+strings.Join(quoted, "|")+")")
+		}
+		re, err := regexp.Compile(strings.Join(alternatives, "|"))
+		if err != nil {
+			continue
+		}
+		g := transform.Guardrail{
+			Name:    cmp.Or(rule.Name, rule.Pattern, strings.Join(rule.Keywords, ", ")),
+			Pattern: re,
+			Action:  rule.Action,
+			Message: rule.Message,
+		}
+		switch {
+		case rule.Message != "":
+		case rule.Action == transform.GuardrailRedact:
+			g.Message = defaultGuardrailRedacted
+		case rule.Action == transform.GuardrailBlock:
+			g.Message = defaultGuardrailBlocked
+		}
+		rules = append(rules, g)
+	}
+	return guardrailSet{rules: rules, window: cmp.Or(cfg.Window, defaultGuardrailWindow)}
+}
+
+// forResponse returns the checker of one response, or nil when no rules
+// are configured.
+func (s guardrailSet) forResponse() *transform.Guardrails {
+	return transform.NewGuardrails(s.rules, s.window)
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// NewGzip compresses responses for clients that accept gzip. Responses that
+// already carry a Content-Encoding, like compressed upstream responses
+// relayed as they are, pass through unchanged; streams are flushed through
+// the compressor so events still arrive as they are written.
+func NewGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(name, "gzip") {
+			// "q=0", "q=0.0", ... refuse it
+			q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			return !ok || strings.Trim(q, "0.") != ""
+		}
+	}
+	return false
+}
+
+// gzipWriter decides on compression when the header is written.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer // nil when the response is sent uncompressed
+	decided bool
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	if !gw.decided {
+		gw.decided = true
+		h := gw.Header()
+		h.Add("Vary", "Accept-Encoding")
+		if h.Get("Content-Encoding") == "" && status != http.StatusNoContent && status != http.StatusNotModified {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			gw.gz = gzipWriters.Get().(*gzip.Writer)
+			gw.gz.Reset(gw.ResponseWriter)
+		}
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+func (gw *gzipWriter) Write(p []byte) (int, error) {
+	if !gw.decided {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.gz != nil {
+		return gw.gz.Write(p)
+	}
+	return gw.ResponseWriter.Write(p)
+}
+
+func (gw *gzipWriter) Flush() {
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// close ends the gzip stream and recycles the compressor.
+func (gw *gzipWriter) close() {
+	if gw.gz == nil {
+		return
+	}
+	gw.gz.Close()
+	gw.gz.Reset(nil)
+	gzipWriters.Put(gw.gz)
+}
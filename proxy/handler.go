@@ -3,114 +3,702 @@ package proxy
 import (
 	"bufio"
 	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"llm-local-proxy/config"
+	"llm-local-proxy/plugin"
 	"llm-local-proxy/provider"
 	"llm-local-proxy/transform"
 )
 
-var httpClient = &http.Client{
-	Timeout: 5 * time.Minute,
-}
+// defaultTruncationReserve is the completion budget assumed when truncating
+// requests without max_tokens.
+const defaultTruncationReserve = 4096
 
 // Handler routes incoming requests to upstream providers.
 type Handler struct {
-	registry provider.Registry
+	registry      provider.Registry
+	client        *http.Client
+	retry         retryPolicy
+	queue         *rateLimitQueue
+	concurrency   *concurrencyLimiter
+	breakers      *breakers
+	fallbacks     map[string][]string
+	aliases       map[string]string
+	streamIdle    time.Duration
+	keepalive     time.Duration   // SSE ping interval toward the client; 0 = off
+	sseMaxLine    int             // longest upstream SSE line; 0 = transform.DefaultSSEMaxLine
+	streamUsage   bool            // every stream ends with a usage chunk
+	cacheHeaders  bool            // prompt cache usage is reported in response headers
+	reasoning     string          // global reasoning mode; "" = merge
+	store         *ReasoningStore // nil unless reasoning_store is enabled
+	prompts       []config.SystemPromptRule
+	overrides     []config.ParamOverride
+	rewrites      []rewriteRule
+	pii           []transform.PIIRule // masked in request messages; empty = disabled
+	piiUnmask     bool                // restore masked values in responses
+	moderation    *moderator          // nil unless moderation is configured
+	images        *imageProcessor     // checks, inlines and shrinks image_url parts
+	plugins       *plugin.Chain       // nil unless plugins are configured
+	guardrails    guardrailSet        // checked against response content
+	headers       headerRules         // rewrites of upstream request and response headers
+	mirror        *mirror             // nil unless mirror rules are configured
+	experiments   experimentSet       // by model
+	hedge         config.HedgeConfig
+	sticky        bool // some provider keeps conversations on one endpoint
+	truncation    config.TruncationConfig
+	summarizer    *summarizer // nil unless summarization is configured
+	repairTools   bool        // hold back tool call arguments and repair invalid JSON
+	stripLogprobs bool        // remove logprobs from responses
+	jsonRetries   int         // corrective retries for invalid JSON mode output
+	autoContinue  config.AutoContinueConfig
+	embeddings    *embeddingBatcher // nil unless embeddings_batch is configured
+	batches       *batchObjects     // where the files and batches of the Batch API live
+	models        *modelList        // nil unless model_list is enabled
+	requestDebug  bool              // honor X-Proxy-Debug on individual requests
+	debugLog      *DebugLog         // where dumps go instead of the console; nil = console
+
+	hostClients sync.Map // host override → *http.Client with matching TLS ServerName
+}
+
+func NewHandler(cfg config.Config, registry provider.Registry, client *http.Client) *Handler {
+	return &Handler{
+		registry:      registry,
+		client:        client,
+		retry:         newRetryPolicy(cfg.Retry),
+		queue:         newRateLimitQueue(cfg.RateLimitQueue),
+		concurrency:   newConcurrencyLimiter(cfg.Concurrency),
+		breakers:      newBreakers(cfg.CircuitBreaker),
+		fallbacks:     cfg.Fallbacks,
+		aliases:       cfg.ModelAliases,
+		streamIdle:    cfg.Timeouts.StreamIdle.Or(defaultStreamIdleTimeout),
+		keepalive:     time.Duration(cfg.SSEKeepalive),
+		sseMaxLine:    cfg.SSEMaxLine,
+		streamUsage:   cfg.StreamUsage,
+		cacheHeaders:  cfg.CacheHeaders,
+		reasoning:     cfg.ReasoningMode,
+		store:         NewReasoningStore(cfg.ReasoningStore, cfg.Debug),
+		prompts:       cfg.SystemPrompts,
+		overrides:     cfg.ParamOverrides,
+		rewrites:      newRewriteRules(cfg.Rewrites),
+		pii:           newPIIRules(cfg.PII),
+		piiUnmask:     cfg.PII.Unmask,
+		moderation:    newModerator(cfg.Moderation, client),
+		images:        newImageProcessor(cfg.Images),
+		guardrails:    newGuardrails(cfg.Guardrails),
+		headers:       newHeaderRules(cfg),
+		mirror:        newMirror(cfg.Mirror),
+		experiments:   newExperiments(cfg.Experiments),
+		hedge:         cfg.Hedge,
+		sticky:        slices.ContainsFunc(cfg.Providers, func(p config.ProviderConfig) bool { return p.Sticky }),
+		truncation:    cfg.Truncation,
+		summarizer:    newSummarizer(cfg.Summarization),
+		repairTools:   cfg.RepairToolCalls,
+		stripLogprobs: cfg.StripLogprobs,
+		jsonRetries:   cfg.JSONMode.Retries,
+		autoContinue:  cfg.AutoContinue,
+		embeddings:    newEmbeddingBatcher(cfg.EmbeddingsBatch),
+		batches:       newBatchObjects(),
+		models:        newModelList(cfg, registry),
+		requestDebug:  cfg.RequestDebug,
+	}
+}
+
+// SetDebugLog sends request dumps to l instead of the console. In debug
+// mode every request is then dumped.
+func (h *Handler) SetDebugLog(l *DebugLog) {
+	h.debugLog = l
 }
 
-func NewHandler(registry provider.Registry) *Handler {
-	return &Handler{registry: registry}
+// Inherit carries the reasoning store and the known batch objects of the
+// handler being replaced on reload.
+func (h *Handler) Inherit(old *Handler) {
+	h.store.Inherit(old.store)
+	h.batches.Inherit(old.batches, h.registry)
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("[%s] %s %s\n", time.Now().Format("15:04:05"), r.Method, r.URL.Path)
+	pr, routed := pathRouteFrom(r.Context())
+	fmt.Printf("[%s] %s %s\n", time.Now().Format("15:04:05"), r.Method, pr.Prefix+r.URL.Path)
+	if h.requestDebug && wantsDebug(r) || h.debugLog != nil && h.registry.Debug() {
+		dw, dr := startDebug(w, r, h.debugLog)
+		defer dw.finish()
+		w, r = dw, dr
+	}
+
+	if isTokenizePath(r.URL.Path) {
+		serveTokenize(w, r)
+		return
+	}
+	if h.models.handles(r) && !routed {
+		h.models.serve(h, w, r)
+		return
+	}
+	if isPreviewPath(r.URL.Path) {
+		h.serveTransformPreview(w, r)
+		return
+	}
+	if isComparePath(r.URL.Path) {
+		h.serveCompare(w, r)
+		return
+	}
+	if isPassthroughPath(r.URL.Path) {
+		h.servePassthrough(w, r)
+		return
+	}
+	if acceptsNDJSON(r) {
+		w = &ndjsonWriter{ResponseWriter: w}
+	}
+	if isPlainTextPath(r.URL.Path) {
+		h.servePlainText(w, r)
+		return
+	}
+	if isResponsesPath(r.URL.Path) {
+		h.serveResponses(w, r)
+		return
+	}
+	if isCompletionsPath(r.URL.Path) {
+		h.serveCompletions(w, r)
+		return
+	}
+	h.serveChat(w, r)
+}
 
-	body, err := io.ReadAll(r.Body)
+// serveChat handles a chat completions request.
+func (h *Handler) serveChat(w http.ResponseWriter, r *http.Request) {
+	body, err := readAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
 		return
 	}
 	r.Body.Close()
 
-	// Resolve provider by model in request body
-	p := h.resolveProvider(body)
-	if p == nil {
-		http.Error(w, "no provider matched for requested model", http.StatusBadGateway)
+	mode := requestedReasoningMode(r)
+	if !config.ValidReasoningMode(mode) {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_reasoning_mode",
+			fmt.Sprintf("Unknown reasoning mode %q (use merge, drop or native)", mode))
 		return
 	}
-	fmt.Printf("  → provider: %s (%s)\n", p.Name(), p.BaseURL())
 
-	// Log key request parameters
-	h.logRequestParams(body)
+	ex := exchangeFrom(r.Context())
+	ex.requestBytes = len(body)
+	defer ex.finishUsage()
 
-	// Transform request body (provider-specific)
-	body = p.TransformRequest(body)
+	// Cancelled on return, or by the stream idle watchdog
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-	// Build upstream URL
-	targetURL := p.BaseURL() + "/chat/completions"
-	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL, bytes.NewReader(body))
-	if err != nil {
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+	defer h.plugins.Hold()()
+	body, failed := h.runRequestPlugins(ctx, w, body)
+	if failed {
 		return
 	}
 
-	// Copy and fix headers
-	copyHeaders(proxyReq.Header, r.Header)
-	if apiKey := p.APIKey(); apiKey != "" {
-		proxyReq.Header.Set("Authorization", "Bearer "+apiKey)
+	// Redirect aliased model names; responses report the alias back
+	body, alias := h.resolveAlias(body)
+	// Experiments are reported back the same way
+	body, requested := h.assignVariant(w, r, body, ex)
+	alias = cmp.Or(alias, requested)
+	if model, _ := transform.StringField(body, "model"); !allowModel(w, ex.key, model) {
+		return
+	}
+	h.stickConversation(r, body, ex)
+
+	// Older SDKs send functions/function_call; responses are converted back
+	body, legacy := transform.LegacyFunctionsToTools(body)
+	if legacy {
+		fmt.Println("  ↺ legacy functions converted to tools")
+	}
+
+	// Resolve provider by model in request body, followed by any fallbacks
+	model, routes := h.resolveRoutes(ctx, body)
+	if len(routes) == 0 {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", "No provider serves the requested model.")
+		return
 	}
-	proxyReq.Header.Set("User-Agent", "claude-code/1.0")
-	proxyReq.Header.Del("Accept-Encoding") // Disable compression for real-time content modification
-	proxyReq.Header.Del("Content-Length")  // Let http.Client recalculate
-	proxyReq.ContentLength = int64(len(body))
 
-	// Send request upstream
-	resp, err := httpClient.Do(proxyReq)
+	// Log key request parameters
+	h.logRequestParams(body)
+	if body, failed = h.adaptImages(ctx, w, r, model, body); failed {
+		return
+	}
+	if body, failed = h.prepareImages(ctx, w, body); failed {
+		return
+	}
+	body = h.store.Attach(body)
+	body, pii := h.maskPII(body)
+	if h.moderate(ctx, w, body) {
+		return
+	}
+	h.mirrorRequest(r, model, body, ex.KeyName)
+	body = h.summarizer.summarize(ctx, h, r, body)
+
+	// Wait for a concurrency slot; held until the response is fully relayed
+	release, err := h.concurrency.acquire(ctx, model)
 	if err != nil {
-		fmt.Printf("  ✗ upstream error: %v\n", err)
-		http.Error(w, "Upstream connection failed", http.StatusBadGateway)
+		fmt.Printf("  ✗ %v\n", err)
+		writeError(w, http.StatusServiceUnavailable, "server_error", "overloaded", "The proxy is overloaded: "+err.Error())
 		return
 	}
-	defer resp.Body.Close()
+	defer release()
 
-	// Forward response headers (skip conflicting ones)
-	for k, vv := range resp.Header {
-		if k == "Content-Length" || k == "Content-Encoding" {
+	var p provider.Provider
+	var resp *http.Response
+	var sent []byte // body of the request that produced resp
+	for i, rt := range routes {
+		if rt.fallback {
+			fmt.Printf("  ⤳ failover to %s\n", rt.model)
+		}
+		reqBody := h.routeBody(body, rt, ex.KeyName)
+		fmt.Printf("  → provider: %s (%s)\n", rt.provider.Name(), rt.provider.BaseURL())
+
+		cresp, err := h.sendHedged(ctx, r, rt, reqBody)
+		if i < len(routes)-1 && (err != nil || retryable(cresp.StatusCode)) {
+			if err != nil {
+				fmt.Printf("  ✗ %s failed: %v\n", rt.provider.Name(), err)
+			} else {
+				fmt.Printf("  ✗ %s failed: %s\n", rt.provider.Name(), cresp.Status)
+				cresp.Body.Close()
+			}
 			continue
 		}
-		for _, v := range vv {
-			w.Header().Add(k, v)
+
+		if errors.Is(err, errCircuitOpen) {
+			fmt.Printf("  ✗ circuit open for %s, failing fast\n", rt.provider.Name())
+			writeUpstreamError(w, rt.provider, err)
+			return
+		}
+		if err != nil {
+			fmt.Printf("  ✗ upstream error: %v\n", err)
+			writeUpstreamError(w, rt.provider, err)
+			return
 		}
+		p, resp, sent = rt.provider, cresp, reqBody
+		ex.Model, ex.Provider = rt.model, rt.provider.Name()
+		break
+	}
+	defer resp.Body.Close()
+	ex.Status = resp.StatusCode
+	mode = h.reasoningMode(mode, p)
+	cont := h.newContinuation(ctx, r, p, sent)
+	defer cont.close()
+
+	h.headers.response(p.Name(), resp.Header)
+	copyResponseHeaders(w, resp)
+
+	// An upstream that continues a prefill replies with the continuation
+	// only; the prefill is put back before it
+	var prefill string
+	if p.Prefill() != "" {
+		prefill = transform.PrefillText(sent)
 	}
-	w.WriteHeader(resp.StatusCode)
 
 	// Route response handling
 	isSSE := strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream")
 	if resp.StatusCode != http.StatusOK || !isSSE {
 		// Non-streaming response
-		respBody, _ := io.ReadAll(resp.Body)
-		w.Write(p.TransformResponse(respBody))
+		respBody, _ := readAll(resp.Body)
+		respBody = normalizeErrorBody(w.Header(), resp, respBody)
+		if resp.StatusCode == http.StatusOK {
+			respBody = cont.complete(respBody)
+			var invalid error
+			if respBody, invalid = h.enforceJSON(ctx, r, p, sent, respBody, ex); invalid != nil {
+				ex.Status = http.StatusBadGateway
+				writeError(w, http.StatusBadGateway, "server_error", "invalid_json_output",
+					"The model's reply does not match the requested response_format: "+invalid.Error())
+				return
+			}
+		}
+		if u, ok := transform.UsageFromBody(respBody); ok {
+			ex.Usage = ex.Usage.Add(u)
+		}
+		if prefill != "" && resp.StatusCode == http.StatusOK {
+			respBody = transform.PrependContent(respBody, prefill)
+		}
+		respBody = pii.RestoreBody(respBody)
+		respBody = h.guardrails.forResponse().CheckBody(respBody)
+		if msg, ok := responseMessage(respBody); ok && resp.StatusCode == http.StatusOK {
+			h.store.Save(msg)
+		}
+		switch mode {
+		case reasoningDrop:
+			respBody = p.TransformResponse(transform.DropReasoning(respBody))
+		case reasoningMerge:
+			respBody = p.TransformResponse(respBody)
+		}
+		if alias != "" {
+			respBody = transform.RewriteResponseModel(respBody, alias)
+		}
+		if h.stripLogprobs {
+			respBody = transform.StripLogprobs(respBody)
+		}
+		if h.repairTools && resp.StatusCode == http.StatusOK {
+			var failed []transform.ToolCallError
+			if respBody, failed = transform.RepairToolCalls(respBody, h.registry.Debug()); len(failed) > 0 {
+				ex.Status = http.StatusBadGateway
+				writeToolCallFailure(w, failed)
+				return
+			}
+		}
+		if legacy {
+			respBody = transform.ToolCallsToFunctionCall(respBody)
+		}
+		if resp.StatusCode == http.StatusOK {
+			respBody = h.plugins.Response(ctx, ex.Model, respBody)
+		}
+		ex.responseBytes = len(respBody)
+		if h.cacheHeaders {
+			setCacheHeaders(w.Header(), ex.Usage)
+		}
+		w.WriteHeader(resp.StatusCode)
+		w.Write(respBody)
 		return
 	}
 
 	// SSE streaming response
-	h.processSSE(w, resp.Body, p)
+	if h.cacheHeaders {
+		declareCacheTrailers(w.Header())
+	}
+	w.WriteHeader(resp.StatusCode)
+	w, stopKeepalive := newKeepaliveWriter(w, h.keepalive)
+	defer stopKeepalive()
+	idle := newIdleReader(resp.Body, h.streamIdle, cancel)
+	defer idle.Stop()
+	werr := h.processSSE(ctx, w, idle, p, ex, mode, alias, prefill, legacy, cont, pii.NewStreams())
+	if h.cacheHeaders {
+		setCacheHeaders(w.Header(), ex.Usage)
+	}
+	switch {
+	case idle.TimedOut() || cont.timedOut():
+		fmt.Printf("  ✗ stream idle for %v, aborted\n", h.streamIdle)
+		ex.Status = http.StatusGatewayTimeout
+		writeStreamError(w, "server_error", "stream_idle_timeout",
+			fmt.Sprintf("The upstream sent nothing for %v; the stream was aborted.", h.streamIdle))
+	case werr != nil || r.Context().Err() != nil:
+		// Stop the generation now instead of when the handler unwinds
+		cancel()
+		fmt.Printf("  ✗ client disconnected after %d bytes, upstream request cancelled\n", ex.responseBytes)
+	}
+}
+
+// Reasoning modes select what happens to upstream reasoning_content.
+const (
+	reasoningMerge  = "merge"  // embed it into content using the reasoning format
+	reasoningDrop   = "drop"   // strip it, leaving only the final answer
+	reasoningNative = "native" // leave the field untouched for clients that understand it
+)
+
+// requestedReasoningMode returns the mode the client asked for with the
+// X-Reasoning-Mode header or the "reasoning" query parameter, or "".
+func requestedReasoningMode(r *http.Request) string {
+	mode := r.Header.Get("X-Reasoning-Mode")
+	if mode == "" {
+		mode = r.URL.Query().Get("reasoning")
+	}
+	return strings.ToLower(mode)
+}
+
+// reasoningMode resolves the effective mode for a response from p: the
+// client's choice, else the provider's, else the global one.
+func (h *Handler) reasoningMode(requested string, p provider.Provider) string {
+	for _, mode := range []string{requested, p.ReasoningMode(), h.reasoning} {
+		if mode != "" {
+			return mode
+		}
+	}
+	return reasoningMerge
 }
 
-// resolveProvider parses the model field from the request body and finds the matching provider.
-func (h *Handler) resolveProvider(body []byte) provider.Provider {
+// send transforms the body for provider p and performs the upstream call,
+// applying the circuit breaker and retry policy.
+func (h *Handler) send(ctx context.Context, r *http.Request, p provider.Provider, body []byte) (*http.Response, error) {
+	stream, includeUsage := transform.IsStream(body)
+	model, _ := transform.StringField(body, "model")
+	body, forced := h.upstreamBody(p, body)
+	resp, err := h.sendTo(ctx, r, p, provider.ChatPath(p, model, stream != forced), body)
+	if t, ok := p.(provider.Translator); ok && err == nil && resp.StatusCode == http.StatusOK {
+		if err = t.TranslateResponse(resp, model); err != nil {
+			resp = nil
+		}
+	}
+	if err == nil && forced && resp.StatusCode == http.StatusOK {
+		h.convertStream(ctx, resp, p, stream, includeUsage)
+	}
+	if err == nil && resp.StatusCode == http.StatusOK && !strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		h.runResponseScript(resp, p) // streams run it per chunk in processSSE
+	}
+	return resp, err
+}
+
+// upstreamBody returns body as it is sent to provider p: with the
+// provider's streaming mode and transformations applied. forced reports
+// that streaming was switched either way, to be converted back in
+// convertStream.
+func (h *Handler) upstreamBody(p provider.Provider, body []byte) (_ []byte, forced bool) {
+	stream, _ := transform.IsStream(body)
+	forced = p.UpstreamStream() == "always" && !stream || p.UpstreamStream() == "never" && stream
+	if forced {
+		body = transform.SetStream(body, !stream)
+	} else if stream && h.streamUsage {
+		body = transform.SetStream(body, true) // asks for usage
+	}
+	return p.TransformRequest(body), forced
+}
+
+// sendTo posts body to path under the provider's base URL, after the
+// provider's request script, applying the circuit breaker and retry policy.
+func (h *Handler) sendTo(ctx context.Context, r *http.Request, p provider.Provider, path string, body []byte) (*http.Response, error) {
+	body, edits := h.runRequestScript(r, p, path, body)
+	return sendWithRetry(ctx, h.retry, h.queue, func() (*http.Response, error) {
+		ex := exchangeFrom(ctx)
+		ep := p.EndpointFor(ex.conversation)
+		proxyReq, err := newUpstreamRequest(ctx, r, p, ep, path, body)
+		if err != nil {
+			return nil, err
+		}
+		h.headers.request(p.Name(), proxyReq.Header)
+		edits.apply(proxyReq.Header)
+		if !h.breakers.allow(p.Name()) {
+			return nil, errCircuitOpen
+		}
+		debugUpstream(ex, proxyReq, body)
+		resp, err := h.clientFor(p).Do(proxyReq)
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+			// The client went away; no verdict on the upstream
+			h.breakers.abandon(p.Name())
+		} else {
+			h.breakers.record(p.Name(), err != nil || resp.StatusCode >= 500)
+		}
+		if err == nil {
+			observeUpstream(ex, p, ep, resp)
+			debugUpstreamResponse(ex, resp)
+		}
+		if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+			// Rotate away from this key until its limit is likely lifted
+			ep.MarkRateLimited(retryAfter(resp, rateLimitCooldown))
+		}
+		return resp, err
+	})
+}
+
+// newUpstreamRequest builds the request to path under the endpoint's base
+// URL, carrying over client headers with auth and encoding fixed up. An
+// absolute URL as path, such as a provider's completions_url, is used as
+// it is with the endpoint's key.
+func newUpstreamRequest(ctx context.Context, r *http.Request, p provider.Provider, ep *provider.Endpoint, path string, body []byte) (*http.Request, error) {
+	targetURL := ep.BaseURL + path
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		targetURL = path
+	}
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy and fix headers
+	copyHeaders(proxyReq.Header, r.Header)
+	provider.Authorize(p, proxyReq.Header, ep.APIKey)
+	proxyReq.Header.Set("User-Agent", "claude-code/1.0")
+	proxyReq.Header.Del("Accept-Encoding")  // Disable compression for real-time content modification
+	proxyReq.Header.Del("Content-Length")   // Let http.Client recalculate
+	proxyReq.Header.Del("X-Reasoning-Mode") // Consumed by the proxy
+	proxyReq.Header.Del("X-Proxy-Debug")
+	proxyReq.ContentLength = int64(len(body))
+	if host := p.HostOverride(); host != "" {
+		proxyReq.Host = host
+	}
+	return proxyReq, nil
+}
+
+// copyResponseHeaders forwards the upstream's response headers, skipping
+// those the proxy's rewriting invalidates.
+func copyResponseHeaders(w http.ResponseWriter, resp *http.Response) {
+	for k, vv := range resp.Header {
+		if k == "Content-Length" || k == "Content-Encoding" {
+			continue
+		}
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+}
+
+// clientFor returns the upstream client for p. Providers with a host override
+// get a dedicated transport so the TLS SNI matches the overridden Host header.
+func (h *Handler) clientFor(p provider.Provider) *http.Client {
+	return hostClient(&h.hostClients, h.client, p.HostOverride())
+}
+
+// hostClient returns base for an empty host, and otherwise a copy of base
+// whose TLS ServerName is host, kept in clients for reuse.
+func hostClient(clients *sync.Map, base *http.Client, host string) *http.Client {
+	if host == "" {
+		return base
+	}
+	if c, ok := clients.Load(host); ok {
+		return c.(*http.Client)
+	}
+
+	baseTransport, ok := base.Transport.(*http.Transport)
+	if !ok {
+		return base
+	}
+	transport := baseTransport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	serverName := host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		serverName = hostname
+	}
+	transport.TLSClientConfig.ServerName = serverName
+
+	c, _ := clients.LoadOrStore(host, &http.Client{
+		Transport: transport,
+		Timeout:   base.Timeout,
+	})
+	return c.(*http.Client)
+}
+
+// resolveAlias rewrites an aliased model in the request body to its target.
+// It returns the alias the client used, or "" when there was none.
+func (h *Handler) resolveAlias(body []byte) ([]byte, string) {
+	if len(h.aliases) == 0 {
+		return body, ""
+	}
 	var req struct {
 		Model string `json:"model"`
 	}
-	if json.Unmarshal(body, &req) == nil {
-		return h.registry.Resolve(req.Model)
+	json.Unmarshal(body, &req)
+	target, ok := h.aliases[req.Model]
+	if !ok {
+		return body, ""
 	}
-	return nil
+	fmt.Printf("  ↪ alias %s → %s\n", req.Model, target)
+	return transform.RewriteModel(body, target), req.Model
+}
+
+// route is a model name paired with the provider that serves it.
+type route struct {
+	model    string
+	provider provider.Provider
+	fallback bool // model differs from the one the client requested
+}
+
+// resolveRoutes parses the model field from the request body and returns it
+// with the matching provider followed by those of its configured fallback
+// models, healthy providers first. A request a path route pinned to a
+// provider has that provider as its only route. Models the client's virtual
+// key may not use are left out.
+func (h *Handler) resolveRoutes(ctx context.Context, body []byte) (string, []route) {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return "", nil
+	}
+	key := exchangeFrom(ctx).key
+	if pr, ok := pathRouteFrom(ctx); ok {
+		if !key.AllowsModel(req.Model) {
+			return req.Model, nil
+		}
+		return req.Model, []route{{model: req.Model, provider: h.registry.Named(pr.Provider)}}
+	}
+
+	var healthy, unhealthy []route
+	for i, model := range append([]string{req.Model}, h.fallbacks[req.Model]...) {
+		p := h.registry.Resolve(model)
+		if p == nil {
+			continue
+		}
+		if !key.AllowsModel(model) {
+			fmt.Printf("  ✗ key %q may not use model %s, skipped\n", key.Name, model)
+			continue
+		}
+		rt := route{model: model, provider: p, fallback: i > 0}
+		if p.Healthy() {
+			healthy = append(healthy, rt)
+		} else {
+			unhealthy = append(unhealthy, rt)
+		}
+	}
+	// Unhealthy providers are tried last rather than dropped, since the
+	// health check may lag behind a recovery
+	return req.Model, append(healthy, unhealthy...)
+}
+
+// routeBody returns body as it is sent along rt, before the provider's own
+// transformations: with the fallback model, system prompts, parameter
+// overrides, rewrite rules and truncation applied.
+func (h *Handler) routeBody(body []byte, rt route, keyName string) []byte {
+	if rt.fallback {
+		body = transform.RewriteModel(body, rt.model)
+		body = h.stripFallbackImages(body, rt)
+	}
+	body = h.injectSystemPrompts(body, rt)
+	body = h.applyOverrides(body, rt, keyName)
+	body = h.applyRewrites(body, rt, keyName)
+	return h.truncate(body, rt.model)
+}
+
+// injectSystemPrompts applies the configured system prompt rules matching rt.
+// skip_if_present looks at the client's messages, not at earlier rules' output.
+func (h *Handler) injectSystemPrompts(body []byte, rt route) []byte {
+	if len(h.prompts) == 0 {
+		return body
+	}
+	clientSystem := transform.HasSystemMessage(body)
+	for _, sp := range h.prompts {
+		if sp.Matches(rt.model, rt.provider.Name()) && !(sp.SkipIfPresent && clientSystem) {
+			body = transform.InjectSystemPrompt(body, sp.Text, sp.Position == "append")
+		}
+	}
+	return body
+}
+
+// applyOverrides applies the configured parameter overrides matching rt and
+// the client's virtual key.
+func (h *Handler) applyOverrides(body []byte, rt route, keyName string) []byte {
+	for _, o := range h.overrides {
+		if o.Matches(rt.model, rt.provider.Name(), keyName) {
+			body = transform.ApplyParams(body, o.Defaults, o.Force)
+		}
+	}
+	return body
+}
+
+// truncate drops old messages from body so it fits the context window of
+// model, leaving room for the completion.
+func (h *Handler) truncate(body []byte, model string) []byte {
+	window := h.truncation.Window(model)
+	if window == 0 {
+		return body
+	}
+	var req struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	}
+	json.Unmarshal(body, &req)
+	reserve := cmp.Or(req.MaxCompletionTokens, req.MaxTokens, h.truncation.Reserve, defaultTruncationReserve)
+
+	body, dropped := transform.TruncateMessages(body, window-reserve, h.truncation.Trim)
+	if dropped > 0 {
+		fmt.Printf("  ✂ dropped %d oldest messages to fit %s context window (%d tokens)\n", dropped, model, window)
+	}
+	return body
 }
 
 // logRequestParams prints key parameters from the incoming request body.
@@ -152,6 +740,13 @@ func (h *Handler) logRequestParams(body []byte) {
 	if tools, ok := req["tools"].([]any); ok {
 		fmt.Printf("    %-20s %d\n", "tools:", len(tools))
 	}
+
+	// Text completion prompt and FIM suffix sizes
+	for _, key := range []string{"prompt", "suffix"} {
+		if s, ok := req[key].(string); ok {
+			fmt.Printf("    %-20s %d chars\n", key+":", len([]rune(s)))
+		}
+	}
 }
 
 // stripVersionPrefix removes "/v1", "/v2", etc. from the path prefix.
@@ -167,64 +762,273 @@ func stripVersionPrefix(path string) string {
 }
 
 // processSSE handles SSE streaming, applying provider-specific delta transformation.
-func (h *Handler) processSSE(w http.ResponseWriter, body io.Reader, p provider.Provider) {
+// In drop mode reasoning_content is stripped first, and chunks left empty are
+// not sent; in native mode deltas are relayed without transformation.
+// A non-empty alias replaces the model reported in each chunk, and legacy
+// turns tool call deltas into function_call ones. Cut off answers are
+// continued through cont into the same stream, and pii restores masked
+// values in the content. It returns the error of a failed write to the
+// client, which means the client went away.
+func (h *Handler) processSSE(ctx context.Context, w http.ResponseWriter, body io.Reader, p provider.Provider, ex *Exchange, mode, alias, prefill string, legacy bool, cont *continuation, pii *transform.PIIStreams) error {
 	flusher, _ := w.(http.Flusher)
-	reader := bufio.NewReader(body)
-	state := &transform.StreamState{}
+	// A resumed part gets a new scanner over the same buffer
+	scanBuf := getScanBuffer()
+	defer putScanBuffer(scanBuf)
+	scanner := transform.NewSSEScanner(body, *scanBuf, h.sseMaxLine)
+	states := transform.StreamStates{}
 	debug := h.registry.Debug()
+	done := false // the upstream sent [DONE]
+	if debug {
+		defer func() {
+			if text := states.Echo(); text != "" {
+				end := "[DONE]"
+				if !done {
+					end = "(no [DONE])"
+				}
+				transform.Logf("  ↳ stream from %s (%s):\n%s%s\n", p.Name(), ex.Model, text, end)
+			}
+		}()
+	}
+	skipped := false // a dropped chunk's trailing blank line is dropped too
+	var capture messageCapture
+	defer func() { h.store.Save(capture.message()) }()
+	var toolArgs transform.ToolArgsBuffer
+	var toolErrs []transform.ToolCallError // written after the current line
+	var usage *streamUsage
+	if h.streamUsage {
+		usage = &streamUsage{}
+	}
+	// Per-line scratch buffers, reused for every chunk of the stream
+	rawBuf, lineBuf := getBuffer(), getBuffer()
+	defer putBuffer(rawBuf)
+	defer putBuffer(lineBuf)
+	enc := json.NewEncoder(lineBuf)
+	// Chunks are decoded only when something has to look at or change them
+	guard := h.guardrails.forResponse()
+	mayPassThrough := cont == nil && usage == nil && h.store == nil && pii == nil && guard == nil && !debug && prefill == ""
+	prefilled := make(map[float64]bool) // choice indexes the prefill was put before
+	chunkPlugins := h.plugins.Runs(plugin.HookChunk, ex.Model)
 
-	closeReasoning := func() {
-		if !state.IsReasoning {
-			return
+	flushGuard := func() {
+		if held := guard.FlushSSE(); held != "" {
+			w.Write([]byte(held))
+			if flusher != nil {
+				flusher.Flush()
+			}
 		}
-		w.Write([]byte(transform.ClosingTagSSE()))
-		if flusher != nil {
-			flusher.Flush()
+	}
+	closeReasoning := func() {
+		for _, idx := range slices.Sorted(maps.Keys(states)) {
+			state := states[idx]
+			if !state.IsReasoning {
+				continue
+			}
+			w.Write([]byte(transform.ClosingTagSSE(state, idx)))
+			if flusher != nil {
+				flusher.Flush()
+			}
+			state.IsReasoning = false
 		}
-		state.IsReasoning = false
 	}
 
 	for {
-		line, err := reader.ReadBytes('\n')
-		if len(line) == 0 && err != nil {
+		if !scanner.Scan() {
+			if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+				fmt.Printf("  ✗ SSE line longer than %d bytes, stream aborted\n", cmp.Or(h.sseMaxLine, transform.DefaultSSEMaxLine))
+				ex.Status = http.StatusBadGateway
+				writeStreamError(w, "server_error", "sse_line_too_long",
+					"An upstream event exceeded the proxy's sse_max_line limit; the stream was aborted.")
+				return nil
+			}
+			// The partial line of a dropped connection is discarded
+			if next := cont.resume(w, true); next != nil {
+				scanner = transform.NewSSEScanner(next, *scanBuf, h.sseMaxLine)
+				continue
+			}
+			flushGuard()
 			closeReasoning()
+			h.flushToolArgs(w, &toolArgs, legacy)
 			break
 		}
+		// Lines are passed on with "\n" endings whatever the upstream used
+		rawBuf.Reset()
+		rawBuf.Write(scanner.Bytes())
+		rawBuf.WriteByte('\n')
+		line := rawBuf.Bytes()
+		if skipped && len(bytes.TrimSpace(line)) == 0 {
+			skipped = false
+			continue
+		}
+		skipped = false
 
 		if bytes.HasPrefix(line, []byte("data: ")) {
 			dataBytes := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data: ")))
+			if string(dataBytes) != "[DONE]" && len(dataBytes) > 0 {
+				if out := h.scriptChunk(p, dataBytes); !bytes.Equal(out, dataBytes) {
+					dataBytes = out
+					line = append(append([]byte("data: "), out...), '\n')
+				}
+			}
 
 			if string(dataBytes) == "[DONE]" {
+				if next := cont.resume(w, false); next != nil {
+					scanner = transform.NewSSEScanner(next, *scanBuf, h.sseMaxLine)
+					skipped = true
+					continue
+				}
+				flushGuard()
 				closeReasoning()
-				if debug {
-					fmt.Println("\n[DONE]")
+				h.flushToolArgs(w, &toolArgs, legacy)
+				usage.finish(w, ex, alias)
+				done = true
+			} else if mayPassThrough && h.plainChunk(dataBytes, states, mode, legacy) {
+				if alias != "" {
+					if newData, ok := transform.ReplaceStringField(dataBytes, "model", alias); ok {
+						line = append([]byte("data: "), newData...)
+						line = append(line, '\n')
+					}
 				}
 			} else {
 				var data map[string]any
 				if json.Unmarshal(dataBytes, &data) == nil {
-					if choices, ok := data["choices"].([]any); ok && len(choices) > 0 {
+					choices, _ := data["choices"].([]any)
+					cont.observe(choices)
+					cont.observeUsage(data)
+					if u, ok := transform.UsageFromMap(data); ok {
+						ex.Usage = u
+					}
+					usage.observe(data)
+					if prefill != "" {
+						prependPrefill(choices, prefill, prefilled)
+					}
+					if h.store != nil && len(choices) > 0 {
 						if choice, ok := choices[0].(map[string]any); ok {
-							p.TransformStreamDelta(choice, state)
+							capture.add(choice)
 						}
 					}
-					if newData, err := json.Marshal(data); err == nil {
-						line = append([]byte("data: "), newData...)
-						line = append(line, '\n')
+					if mode == reasoningDrop {
+						dropReasoning(choices)
+					}
+					if h.stripLogprobs {
+						stripLogprobs(choices)
+					}
+					for _, c := range choices {
+						if choice, ok := c.(map[string]any); ok {
+							pii.RestoreDelta(choice)
+							guard.CheckDelta(choice)
+						}
+					}
+					if h.repairTools && len(choices) > 0 {
+						if choice, ok := choices[0].(map[string]any); ok {
+							toolArgs.Hold(choice)
+							if choice["finish_reason"] != nil && toolArgs.Pending() {
+								toolErrs = releaseToolArgs(choice, &toolArgs, debug)
+							}
+						}
+					}
+					if (mode == reasoningDrop || h.repairTools || cont != nil) && !hasDelta(choices) && data["usage"] == nil {
+						skipped = true
+						continue
+					}
+					if legacy {
+						legacyFunctionCalls(choices)
+					}
+					if _, ok := data["model"]; ok && alias != "" {
+						data["model"] = alias
+					}
+					if mode != reasoningNative {
+						for _, c := range choices {
+							if choice, ok := c.(map[string]any); ok {
+								p.TransformStreamDelta(choice, states.For(choice))
+							}
+						}
+					}
+					// The encoder ends the line with "\n"
+					lineBuf.Reset()
+					lineBuf.WriteString("data: ")
+					if enc.Encode(data) == nil {
+						line = lineBuf.Bytes()
 					}
 				}
 			}
 		}
 
-		w.Write(line)
+		if chunkPlugins {
+			line = h.pluginChunk(ctx, ex.Model, line)
+		}
+		if _, werr := w.Write(line); werr != nil {
+			return werr // client gone; the caller cancels the upstream request
+		}
+		ex.responseBytes += len(line)
+		if len(toolErrs) > 0 && len(bytes.TrimSpace(line)) == 0 {
+			writeToolCallErrors(w, toolErrs)
+			toolErrs = nil
+		}
+		if guard.Blocked() {
+			// The rest of the answer is not sent; the caller cancels the
+			// upstream request
+			w.Write([]byte("\ndata: [DONE]\n\n"))
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		}
 		if flusher != nil {
 			flusher.Flush()
 		}
+	}
+	return nil
+}
 
-		if err != nil {
-			closeReasoning()
-			break
+// prependPrefill puts the prefill before the first content delta of every
+// choice, so the reply streams in whole.
+func prependPrefill(choices []any, prefill string, done map[float64]bool) {
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		delta, _ := choice["delta"].(map[string]any)
+		idx, _ := choice["index"].(float64)
+		if content, ok := delta["content"].(string); ok && !done[idx] {
+			delta["content"] = prefill + content
+			done[idx] = true
+		}
+	}
+}
+
+// dropReasoning strips reasoning_content from every choice.
+func dropReasoning(choices []any) {
+	for _, c := range choices {
+		if choice, ok := c.(map[string]any); ok {
+			transform.DropReasoningDelta(choice)
+		}
+	}
+}
+
+// stripLogprobs removes logprobs from every choice.
+func stripLogprobs(choices []any) {
+	for _, c := range choices {
+		if choice, ok := c.(map[string]any); ok {
+			transform.StripLogprobsDelta(choice)
+		}
+	}
+}
+
+// legacyFunctionCalls converts tool call deltas to legacy function_call.
+func legacyFunctionCalls(choices []any) {
+	for _, c := range choices {
+		if choice, ok := c.(map[string]any); ok {
+			transform.ToolCallDeltaToFunctionCall(choice)
+		}
+	}
+}
+
+// hasDelta reports whether any choice has something to send.
+func hasDelta(choices []any) bool {
+	for _, c := range choices {
+		if choice, ok := c.(map[string]any); ok && transform.HasDelta(choice) {
+			return true
 		}
 	}
+	return false
 }
 
 func copyHeaders(dst, src http.Header) {
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"cmp"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HAR is an HTTP Archive (HAR 1.2) document, as read by browser devtools,
+// Fiddler, mitmproxy and similar tools.
+type HAR struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+// NewHAR converts recordings made by Recorder to a HAR document. Streamed
+// responses become their concatenated chunks; the wait time is that of the
+// first chunk. Recordings older than the url, request_header and
+// duration_ms fields get a localhost URL, no request headers, and a time
+// taken from the last chunk.
+func NewHAR(recs []Recording, creatorVersion string) HAR {
+	entries := make([]harEntry, 0, len(recs))
+	for _, rec := range recs {
+		entries = append(entries, harEntryOf(rec))
+	}
+	return HAR{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "llm-local-proxy", Version: creatorVersion},
+		Entries: entries,
+	}}
+}
+
+func harEntryOf(rec Recording) harEntry {
+	rawURL := cmp.Or(rec.URL, "http://localhost"+rec.Path)
+	req := harRequest{
+		Method:      rec.Method,
+		URL:         rawURL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(rec.RequestHeader),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(rec.Request),
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		for _, name := range slices.Sorted(maps.Keys(u.Query())) {
+			for _, v := range u.Query()[name] {
+				req.QueryString = append(req.QueryString, harNameValue{Name: name, Value: v})
+			}
+		}
+	}
+	if len(rec.Request) > 0 {
+		req.PostData = &harPostData{
+			MimeType: cmp.Or(rec.RequestHeader.Get("Content-Type"), "application/json"),
+			Text:     string(rec.Request),
+		}
+	}
+
+	text, total, wait := rec.Body, rec.DurationMS, rec.DurationMS
+	if rec.Stream {
+		var b strings.Builder
+		for _, c := range rec.Chunks {
+			b.WriteString(c.Data)
+		}
+		text, wait = b.String(), 0
+		if len(rec.Chunks) > 0 {
+			wait = rec.Chunks[0].OffsetMS
+			total = max(total, rec.Chunks[len(rec.Chunks)-1].OffsetMS)
+		}
+	}
+
+	return harEntry{
+		StartedDateTime: rec.Time.Format(time.RFC3339Nano),
+		Time:            total,
+		Request:         req,
+		Response: harResponse{
+			Status:      rec.Status,
+			StatusText:  http.StatusText(rec.Status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(rec.Header),
+			Content:     harContent{Size: len(text), MimeType: rec.Header.Get("Content-Type"), Text: text},
+			HeadersSize: -1,
+			BodySize:    len(text),
+		},
+		Timings: harTimings{Wait: wait, Receive: total - wait},
+	}
+}
+
+// harHeaders lists h in name order.
+func harHeaders(h http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for _, name := range slices.Sorted(maps.Keys(h)) {
+		for _, v := range h[name] {
+			headers = append(headers, harNameValue{Name: name, Value: v})
+		}
+	}
+	return headers
+}
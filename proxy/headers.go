@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"net/http"
+
+	"llm-local-proxy/config"
+)
+
+// headerRules are the configured header rewrites: the global ones, then
+// those of the provider.
+type headerRules struct {
+	global     config.HeaderRules
+	byProvider map[string]config.HeaderRules
+}
+
+func newHeaderRules(cfg config.Config) headerRules {
+	hr := headerRules{global: cfg.Headers, byProvider: make(map[string]config.HeaderRules)}
+	for _, p := range cfg.Providers {
+		hr.byProvider[p.Name] = p.Headers
+	}
+	return hr
+}
+
+// request rewrites the headers of a request to provider.
+func (hr headerRules) request(provider string, header http.Header) {
+	rewriteHeaders(header, hr.global.Request)
+	rewriteHeaders(header, hr.byProvider[provider].Request)
+}
+
+// response rewrites the headers of a response from provider before they
+// are passed on to the client.
+func (hr headerRules) response(provider string, header http.Header) {
+	rewriteHeaders(header, hr.global.Response)
+	rewriteHeaders(header, hr.byProvider[provider].Response)
+}
+
+func rewriteHeaders(header http.Header, rw config.HeaderRewrite) {
+	for from, to := range rw.Rename {
+		values := header.Values(from)
+		if len(values) == 0 || http.CanonicalHeaderKey(from) == http.CanonicalHeaderKey(to) {
+			continue
+		}
+		header.Del(from)
+		for _, v := range values {
+			header.Add(to, v)
+		}
+	}
+	for _, name := range rw.Remove {
+		header.Del(name)
+	}
+	for name, v := range rw.Set {
+		header.Set(name, v)
+	}
+}
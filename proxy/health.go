@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/provider"
+)
+
+const (
+	defaultHealthTimeout = 10 * time.Second
+	defaultHealthPath    = "/models"
+)
+
+// HealthChecker periodically probes every upstream endpoint and records the
+// result on it, so endpoint selection and failover skip unhealthy upstreams.
+// It also serves the current status as JSON.
+type HealthChecker struct {
+	registry    provider.Registry
+	client      *http.Client
+	hostClients sync.Map // host override → *http.Client with matching TLS ServerName
+	interval    time.Duration
+	timeout     time.Duration
+	path        string
+}
+
+func NewHealthChecker(cfg config.Config, registry provider.Registry, client *http.Client) *HealthChecker {
+	path := cfg.HealthCheck.Path
+	if path == "" {
+		path = defaultHealthPath
+	}
+	return &HealthChecker{
+		registry: registry,
+		client:   client,
+		interval: cfg.HealthCheck.Interval.Std(),
+		timeout:  cfg.HealthCheck.Timeout.Or(defaultHealthTimeout),
+		path:     path,
+	}
+}
+
+// Run probes all endpoints every interval until ctx is done.
+// Returns immediately when health checks are disabled.
+func (hc *HealthChecker) Run(ctx context.Context) {
+	if hc.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+
+	for {
+		hc.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc *HealthChecker) checkAll(ctx context.Context) {
+	for _, p := range hc.registry.Providers() {
+		for _, ep := range p.Pool() {
+			err := hc.probe(ctx, p, ep)
+			if wasHealthy := ep.Healthy(); wasHealthy != (err == nil) {
+				if err != nil {
+					fmt.Printf("  ✗ health: %s (%s) unhealthy: %v\n", p.Name(), ep.BaseURL, err)
+				} else {
+					fmt.Printf("  ✓ health: %s (%s) recovered\n", p.Name(), ep.BaseURL)
+				}
+			}
+			ep.SetHealth(err)
+		}
+	}
+}
+
+func (hc *HealthChecker) probe(ctx context.Context, p provider.Provider, ep *provider.Endpoint) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.BaseURL+hc.path, nil)
+	if err != nil {
+		return err
+	}
+	provider.Authorize(p, req.Header, ep.APIKey)
+	if host := p.HostOverride(); host != "" {
+		req.Host = host
+	}
+
+	resp, err := hostClient(&hc.hostClients, hc.client, p.HostOverride()).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// 429 means the upstream is up, just busy
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// Live answers liveness probes: the process is up and serving HTTP.
+func Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// Ready answers readiness probes. The proxy is ready when the last config
+// reload, as reported by reloadErr, did not fail and at least one provider
+// has a reachable endpoint; with health checks disabled every endpoint
+// counts as reachable.
+func (hc *HealthChecker) Ready(reloadErr func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := struct {
+			Status    string          `json:"status"`
+			Config    string          `json:"config"`
+			Providers map[string]bool `json:"providers"`
+		}{Status: "ready", Config: "ok", Providers: make(map[string]bool)}
+
+		code := http.StatusOK
+		if err := reloadErr(); err != nil {
+			status.Config, code = err.Error(), http.StatusServiceUnavailable
+		}
+		reachable := false
+		for _, p := range hc.registry.Providers() {
+			status.Providers[p.Name()] = p.Healthy()
+			reachable = reachable || p.Healthy()
+		}
+		if !reachable {
+			code = http.StatusServiceUnavailable
+		}
+		if code != http.StatusOK {
+			status.Status = "not ready"
+		}
+		writeJSON(w, code, status)
+	})
+}
+
+type providerHealth struct {
+	Name      string                   `json:"name"`
+	Healthy   bool                     `json:"healthy"`
+	Endpoints []provider.EndpointStats `json:"endpoints"`
+}
+
+// ServeHTTP reports the health of every provider and endpoint.
+func (hc *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Enabled   bool             `json:"enabled"`
+		Providers []providerHealth `json:"providers"`
+	}{Enabled: hc.interval > 0}
+
+	for _, p := range hc.registry.Providers() {
+		status.Providers = append(status.Providers, providerHealth{
+			Name:      p.Name(),
+			Healthy:   p.Healthy(),
+			Endpoints: p.Endpoints(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
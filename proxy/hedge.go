@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// hedgeResult is the outcome of one copy of a hedged request.
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+	hedge  bool // the second copy
+}
+
+// ok reports whether the copy got an answer worth relaying.
+func (hr hedgeResult) ok() bool {
+	return hr.err == nil && hr.resp.StatusCode < 500
+}
+
+// sendHedged sends body for rt like send, but when the provider has not
+// sent response headers within hedge.after, a second copy is sent to the
+// provider's next endpoint and whichever answers first is used. The other
+// copy is cancelled; a failed answer is only used when the other copy
+// fails too, or was never sent.
+func (h *Handler) sendHedged(ctx context.Context, r *http.Request, rt route, body []byte) (*http.Response, error) {
+	if !h.hedge.Applies(rt.model) {
+		return h.send(ctx, r, rt.provider, body)
+	}
+	results := make(chan hedgeResult, 2)
+	start := func(hedge bool) {
+		cctx, cancel := context.WithCancel(ctx)
+		go func() {
+			resp, err := h.send(cctx, r, rt.provider, body)
+			results <- hedgeResult{resp: resp, err: err, cancel: cancel, hedge: hedge}
+		}()
+	}
+	start(false)
+
+	timer := time.NewTimer(time.Duration(h.hedge.After))
+	defer timer.Stop()
+	due := timer.C // nil once the copy is sent
+	pending := 1
+	var failed *hedgeResult
+	for {
+		select {
+		case <-due:
+			fmt.Printf("  ⑂ no answer from %s after %v, hedging\n", rt.provider.Name(), h.hedge.After.Std())
+			start(true)
+			due = nil
+			pending++
+		case res := <-results:
+			pending--
+			if !res.ok() && pending > 0 {
+				// A failure only counts once the other copy failed too
+				failed = &res
+				continue
+			}
+			if failed != nil {
+				discard(*failed)
+			}
+			if pending > 0 {
+				go func() { discard(<-results) }()
+			}
+			if res.hedge && res.ok() {
+				fmt.Printf("  ⑂ hedged copy to %s answered first\n", rt.provider.Name())
+			}
+			if res.err != nil {
+				res.cancel()
+				return nil, res.err
+			}
+			res.resp.Body = &cancelBody{ReadCloser: res.resp.Body, cancel: res.cancel}
+			return res.resp, nil
+		}
+	}
+}
+
+// discard cancels a copy that lost the race and releases its response.
+func discard(res hedgeResult) {
+	res.cancel()
+	if res.resp != nil {
+		res.resp.Body.Close()
+	}
+}
+
+// cancelBody releases the context of a hedged copy once its response has
+// been read.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
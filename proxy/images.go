@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+const (
+	defaultImageFetchTimeout = 10 * time.Second
+	maxImageFetchBytes       = 20 << 20
+)
+
+// imageProcessor checks the image_url parts of chat requests and, when
+// configured, inlines remote images and shrinks inline ones, or keeps them
+// from text-only models.
+type imageProcessor struct {
+	cfg    config.ImagesConfig
+	client *http.Client // fetches remote images; nil unless inline is enabled
+
+	mu           sync.Mutex
+	descriptions map[[32]byte]string // by URL hash, for text_only_action describe
+}
+
+func newImageProcessor(cfg config.ImagesConfig) *imageProcessor {
+	ip := &imageProcessor{cfg: cfg, descriptions: make(map[[32]byte]string)}
+	if cfg.Inline {
+		dialer := &net.Dialer{Timeout: 5 * time.Second}
+		if !cfg.AllowPrivate {
+			dialer.Control = publicAddressesOnly
+		}
+		ip.client = &http.Client{
+			// Direct connections only, so the dialer sees the image's host
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			Timeout:   cfg.FetchTimeout.Or(defaultImageFetchTimeout),
+		}
+	}
+	return ip
+}
+
+// imageError is an image_url part the request cannot be sent with.
+type imageError struct {
+	code string // invalid_image or image_fetch_failed
+	err  error
+}
+
+func (e *imageError) Error() string { return e.err.Error() }
+
+// process returns body with its images prepared for the upstream. Requests
+// without image parts are returned as they are.
+func (ip *imageProcessor) process(ctx context.Context, body []byte) ([]byte, error) {
+	if !bytes.Contains(body, []byte(`"image_url"`)) {
+		return body, nil
+	}
+	var data map[string]any
+	if json.Unmarshal(body, &data) != nil {
+		return body, nil
+	}
+	messages, _ := data["messages"].([]any)
+	changed := false
+	for _, m := range messages {
+		msg, _ := m.(map[string]any)
+		parts, _ := msg["content"].([]any)
+		for _, p := range parts {
+			part, _ := p.(map[string]any)
+			if part["type"] != "image_url" {
+				continue
+			}
+			image, _ := part["image_url"].(map[string]any)
+			rawURL, _ := image["url"].(string)
+			newURL, err := ip.prepare(ctx, rawURL)
+			if err != nil {
+				return nil, err
+			}
+			if newURL != rawURL {
+				image["url"] = newURL
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return body, nil
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody, nil
+	}
+	return body, nil
+}
+
+// prepare checks one image URL and returns it as it is to be sent.
+func (ip *imageProcessor) prepare(ctx context.Context, rawURL string) (string, error) {
+	var mediaType string
+	var data []byte
+	switch {
+	case rawURL == "":
+		return "", &imageError{"invalid_image", errors.New("an image_url part has no url")}
+	case strings.HasPrefix(rawURL, "data:"):
+		var err error
+		if mediaType, data, err = transform.ParseDataURL(rawURL); err != nil {
+			return "", &imageError{"invalid_image", err}
+		}
+	case strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://"):
+		if _, err := url.Parse(rawURL); err != nil {
+			return "", &imageError{"invalid_image", fmt.Errorf("invalid image URL: %w", err)}
+		}
+		if !ip.cfg.Inline {
+			return rawURL, nil // the upstream fetches it
+		}
+		var err error
+		if mediaType, data, err = ip.fetch(ctx, rawURL); err != nil {
+			return "", &imageError{"image_fetch_failed", err}
+		}
+	default:
+		return "", &imageError{"invalid_image", errors.New("image URLs must be http(s) or base64 data URLs")}
+	}
+
+	changed := !strings.HasPrefix(rawURL, "data:")
+	if ip.cfg.MaxBytes > 0 || ip.cfg.MaxDimension > 0 {
+		shrunk, shrunkType, shrunkOK, err := transform.ShrinkImage(data, ip.cfg.MaxBytes, ip.cfg.MaxDimension)
+		switch {
+		case err != nil:
+			// Left for the upstream to accept or refuse
+			fmt.Printf("  ✗ image left as it is: %v\n", err)
+		case shrunkOK:
+			fmt.Printf("  ▣ image re-encoded: %s %d bytes → %s %d bytes\n", mediaType, len(data), shrunkType, len(shrunk))
+			mediaType, data, changed = shrunkType, shrunk, true
+		}
+	}
+	if !changed {
+		return rawURL, nil
+	}
+	return transform.DataURL(mediaType, data), nil
+}
+
+// fetch downloads a remote image.
+func (ip *imageProcessor) fetch(ctx context.Context, rawURL string) (string, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := ip.client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("fetch image: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageFetchBytes+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("fetch image: %w", err)
+	}
+	if len(data) > maxImageFetchBytes {
+		return "", nil, fmt.Errorf("image is larger than %d bytes", maxImageFetchBytes)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		mediaType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", nil, fmt.Errorf("%s is not an image", mediaType)
+	}
+	fmt.Printf("  ▣ image fetched: %s, %d bytes\n", mediaType, len(data))
+	return mediaType, data, nil
+}
+
+// publicAddressesOnly refuses connections to loopback, private, link-local
+// and unspecified addresses, so image URLs cannot reach the proxy's own
+// network.
+func publicAddressesOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return fmt.Errorf("image host %s is not a public address", host)
+	}
+	return nil
+}
+
+// prepareImages writes the error for an image the request cannot be sent
+// with and reports whether it did.
+func (h *Handler) prepareImages(ctx context.Context, w http.ResponseWriter, body []byte) ([]byte, bool) {
+	body, err := h.images.process(ctx, body)
+	var ie *imageError
+	if errors.As(err, &ie) {
+		fmt.Printf("  ✗ %v\n", err)
+		writeError(w, http.StatusBadRequest, "invalid_request_error", ie.code, ie.Error()+".")
+		return nil, true
+	}
+	return body, false
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"llm-local-proxy/config"
+)
+
+// IPFilter rejects clients whose source address is not permitted by the
+// configured allow/deny CIDR lists. Deny rules take precedence over allow rules;
+// an empty allow list permits every address that is not denied.
+type IPFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	next  http.Handler
+}
+
+// NewIPFilter wraps next with source IP filtering.
+// Entries are expected to be validated by config.Load already.
+func NewIPFilter(allow, deny []string, next http.Handler) *IPFilter {
+	return &IPFilter{
+		allow: parsePrefixes(allow),
+		deny:  parsePrefixes(deny),
+		next:  next,
+	}
+}
+
+func (f *IPFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Non-IP transports (Unix sockets) are local by definition and not filtered
+	addr, ok := remoteAddr(r)
+	if ok && !f.permitted(addr) {
+		fmt.Printf("[%s] ✗ rejected client %s: %s %s\n", time.Now().Format("15:04:05"), addr, r.Method, r.URL.Path)
+		writeError(w, http.StatusForbidden, "permission_error", "ip_not_allowed", "Requests from this address are not allowed.")
+		return
+	}
+	f.next.ServeHTTP(w, r)
+}
+
+func (f *IPFilter) permitted(addr netip.Addr) bool {
+	for _, p := range f.deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr extracts the client IP from the connection's remote address.
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
+
+func parsePrefixes(entries []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, s := range entries {
+		if p, err := config.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
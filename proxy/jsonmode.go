@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"llm-local-proxy/provider"
+	"llm-local-proxy/transform"
+)
+
+// jsonNudge is the corrective system message sent after invalid output.
+const jsonNudge = "Your previous reply was not valid: %v. " +
+	"Reply again with only the JSON value, without code fences or commentary."
+
+// enforceJSON validates the reply to a request that asked for JSON with
+// response_format and, while it is invalid, asks p again with the invalid
+// reply and a corrective nudge appended. It returns the response body to
+// deliver, and an error when the retries are exhausted. The usage of
+// invalid replies is added to ex. Replies to schemas p only emulates are
+// repaired first.
+func (h *Handler) enforceJSON(ctx context.Context, r *http.Request, p provider.Provider, reqBody, respBody []byte, ex *Exchange) ([]byte, error) {
+	want, schema := transform.JSONResponseFormat(reqBody)
+	// Emulated schemas are always checked, as the upstream cannot enforce them
+	emulated := schema != nil && p.EmulatesJSONSchema()
+	if !want || h.jsonRetries == 0 && !emulated {
+		return respBody, nil
+	}
+	var data map[string]any
+	if err := json.Unmarshal(reqBody, &data); err != nil {
+		return respBody, nil
+	}
+	messages, _ := data["messages"].([]any)
+
+	for attempt := 1; ; attempt++ {
+		if emulated {
+			respBody = transform.RepairJSONContent(respBody)
+		}
+		msg, ok := responseMessage(respBody)
+		if !ok || len(msg.ToolCalls) > 0 {
+			return respBody, nil
+		}
+		invalid := transform.ValidateJSON(msg.Content, schema)
+		if invalid == nil {
+			return respBody, nil
+		}
+		transform.Logf("  ✗ invalid JSON output: %v\n", invalid)
+		if u, ok := transform.UsageFromBody(respBody); ok {
+			ex.Usage = ex.Usage.Add(u)
+		}
+		if attempt > h.jsonRetries {
+			return respBody, invalid
+		}
+
+		fmt.Printf("  ↺ retrying for valid JSON (%d/%d)\n", attempt, h.jsonRetries)
+		messages = append(messages,
+			map[string]any{"role": "assistant", "content": msg.Content},
+			map[string]any{"role": "system", "content": fmt.Sprintf(jsonNudge, invalid)},
+		)
+		data["messages"] = messages
+		body, _ := json.Marshal(data)
+
+		resp, err := h.send(ctx, r, p, body)
+		if err != nil {
+			return respBody, fmt.Errorf("%v; retry failed: %v", invalid, err)
+		}
+		retryBody, _ := readAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return respBody, fmt.Errorf("%v; retry failed: %s", invalid, resp.Status)
+		}
+		respBody = retryBody
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keepaliveWriter sends an SSE comment line to the client whenever nothing
+// was written for the configured interval, e.g. during long reasoning
+// phases, so reverse proxies and browsers don't time out the connection.
+// Pings are only sent between events.
+type keepaliveWriter struct {
+	http.ResponseWriter
+	interval time.Duration
+	timer    *time.Timer
+
+	mu       sync.Mutex
+	boundary bool // the last write ended an event
+	stopped  bool
+}
+
+// newKeepaliveWriter wraps w; a zero interval returns w unchanged.
+func newKeepaliveWriter(w http.ResponseWriter, interval time.Duration) (http.ResponseWriter, func()) {
+	if interval <= 0 {
+		return w, func() {}
+	}
+	kw := &keepaliveWriter{ResponseWriter: w, interval: interval, boundary: true}
+	kw.timer = time.AfterFunc(interval, kw.ping)
+	return kw, kw.stop
+}
+
+func (kw *keepaliveWriter) Write(p []byte) (int, error) {
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+	kw.timer.Reset(kw.interval)
+	kw.boundary = len(bytes.TrimSpace(p)) == 0 || bytes.HasSuffix(p, []byte("\n\n"))
+	return kw.ResponseWriter.Write(p)
+}
+
+func (kw *keepaliveWriter) Flush() {
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+	if f, ok := kw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (kw *keepaliveWriter) ping() {
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+	if kw.stopped {
+		return
+	}
+	if kw.boundary {
+		kw.ResponseWriter.Write([]byte(": ping\n\n"))
+		if f, ok := kw.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	kw.timer.Reset(kw.interval)
+}
+
+// stop ends the pings.
+func (kw *keepaliveWriter) stop() {
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+	kw.stopped = true
+	kw.timer.Stop()
+}
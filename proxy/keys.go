@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/provider"
+)
+
+// KeyStore holds the proxy's virtual keys. It is safe for concurrent use so
+// keys can be changed at runtime.
+type KeyStore struct {
+	mu   sync.RWMutex
+	keys []config.VirtualKey
+}
+
+func NewKeyStore(keys []config.VirtualKey) *KeyStore {
+	return &KeyStore{keys: slices.Clone(keys)}
+}
+
+// Enabled reports whether any virtual keys exist, i.e. clients must authenticate.
+func (s *KeyStore) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys) > 0
+}
+
+// List returns a copy of all virtual keys.
+func (s *KeyStore) List() []config.VirtualKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.keys)
+}
+
+// Lookup finds the virtual key matching a client's bearer token.
+func (s *KeyStore) Lookup(secret string) (config.VirtualKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(secret)) == 1 {
+			return k, true
+		}
+	}
+	return config.VirtualKey{}, false
+}
+
+// ByName finds a virtual key by its name.
+func (s *KeyStore) ByName(name string) (config.VirtualKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return config.VirtualKey{}, false
+}
+
+// Add registers a new virtual key. Names and secrets must be unique.
+func (s *KeyStore) Add(k config.VirtualKey) error {
+	if err := k.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.keys {
+		if existing.Name == k.Name {
+			return fmt.Errorf("key %q already exists", k.Name)
+		}
+		if existing.Key == k.Key {
+			return fmt.Errorf("key %q: secret already in use", k.Name)
+		}
+	}
+	s.keys = append(s.keys, k)
+	return nil
+}
+
+// Remove deletes the virtual key with the given name.
+func (s *KeyStore) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.keys)
+	s.keys = slices.DeleteFunc(s.keys, func(k config.VirtualKey) bool { return k.Name == name })
+	return len(s.keys) != n
+}
+
+// KeyAuth requires clients to present a virtual key as their bearer token
+// whenever the store holds any keys, enforces the key's model restrictions
+// and records the key name on the Exchange.
+type KeyAuth struct {
+	store *KeyStore
+	next  http.Handler
+}
+
+func NewKeyAuth(store *KeyStore, next http.Handler) *KeyAuth {
+	return &KeyAuth{store: store, next: next}
+}
+
+func (a *KeyAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.store.Enabled() {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+
+	secret := bearerToken(r)
+	key, ok := a.store.Lookup(secret)
+	if !ok {
+		fmt.Printf("[%s] ✗ invalid virtual key %q from %s\n", time.Now().Format("15:04:05"), provider.MaskKey(secret), r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Incorrect API key provided.")
+		return
+	}
+
+	// Checked again once aliases and experiments have resolved the model
+	if model, _ := peekModel(r); model != "" && !allowModel(w, key, model) {
+		return
+	}
+
+	ctx, ex := withExchange(r.Context(), "")
+	ex.Client, ex.KeyName, ex.key = "vkey:"+key.Name, key.Name, key
+
+	// The virtual key is for the proxy only; never forward it upstream
+	r = r.WithContext(ctx)
+	r.Header = r.Header.Clone()
+	r.Header.Del("Authorization")
+	a.next.ServeHTTP(w, r)
+}
+
+// allowModel reports whether key may use model, answering the request with
+// 403 model_not_allowed when it may not.
+func allowModel(w http.ResponseWriter, key config.VirtualKey, model string) bool {
+	if key.AllowsModel(model) {
+		return true
+	}
+	fmt.Printf("[%s] ✗ key %q may not use model %q\n", time.Now().Format("15:04:05"), key.Name, model)
+	writeError(w, http.StatusForbidden, "invalid_request_error", "model_not_allowed",
+		fmt.Sprintf("The model %q is not allowed for this API key.", model))
+	return false
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+const (
+	defaultMirrorMaxInFlight = 8
+	defaultMirrorTimeout     = 2 * time.Minute
+)
+
+// mirror sends copies of chat requests to other models without the client
+// waiting for or seeing their answers.
+type mirror struct {
+	cfg   config.MirrorConfig
+	slots chan struct{} // one per copy awaiting an answer
+}
+
+func newMirror(cfg config.MirrorConfig) *mirror {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	return &mirror{cfg: cfg, slots: make(chan struct{}, cmp.Or(cfg.MaxInFlight, defaultMirrorMaxInFlight))}
+}
+
+// mirrorRequest starts a copy of body, a request for model, for every
+// matching rule that picks it. Copies are not streamed and are not
+// retried on fallback models; when too many are in flight the request is
+// not copied.
+func (h *Handler) mirrorRequest(r *http.Request, model string, body []byte, keyName string) {
+	m := h.mirror
+	if m == nil {
+		return
+	}
+	for _, rule := range m.cfg.Rules {
+		if !rule.Matches(model, keyName) || rand.Float64()*100 >= cmp.Or(rule.Percent, 100) {
+			continue
+		}
+		select {
+		case m.slots <- struct{}{}:
+		default:
+			fmt.Printf("  ✗ mirror to %s skipped: %d copies in flight\n", rule.Target, cap(m.slots))
+			continue
+		}
+		// The copy outlives the client's request, and must not count
+		// towards its usage
+		ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Timeout.Or(defaultMirrorTimeout))
+		cr := r.Clone(ctx)
+		go func() {
+			defer func() { <-m.slots }()
+			defer cancel()
+			h.sendMirror(ctx, cr, rule, body, keyName)
+		}()
+	}
+}
+
+// sendMirror sends one copy and logs how the target did.
+func (h *Handler) sendMirror(ctx context.Context, r *http.Request, rule config.MirrorRule, body []byte, keyName string) {
+	body = transform.SetStream(transform.RewriteModel(body, rule.Target), false)
+	_, routes := h.resolveRoutes(ctx, body)
+	if len(routes) == 0 {
+		fmt.Printf("  ✗ mirror: no provider serves %s\n", rule.Target)
+		return
+	}
+	rt := routes[0]
+	start := time.Now()
+	resp, err := h.send(ctx, r, rt.provider, h.routeBody(body, rt, keyName))
+	if err != nil {
+		fmt.Printf("  ✗ mirror to %s (%s) failed: %v\n", rule.Target, rt.provider.Name(), err)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, err := readAll(resp.Body)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		fmt.Printf("  ✗ mirror to %s (%s) failed: %s after %v\n", rule.Target, rt.provider.Name(), resp.Status, elapsed)
+		return
+	}
+	u, _ := transform.UsageFromBody(respBody)
+	fmt.Printf("  ⧉ mirror to %s (%s): %v, %d prompt + %d completion tokens\n",
+		rule.Target, rt.provider.Name(), elapsed, u.PromptTokens, u.CompletionTokens)
+	if msg, ok := responseMessage(respBody); ok && rule.Log {
+		transform.Logf("  ⧉ mirror answer from %s:\n%s\n", rule.Target, msg.Content)
+	}
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+const (
+	defaultMockChunkSize  = 8
+	defaultMockChunkDelay = 20 * time.Millisecond
+)
+
+// MockTransport answers upstream requests in-process with canned completions
+// in the upstream wire format (including reasoning_content), so the whole
+// transformation pipeline runs without contacting any provider.
+type MockTransport struct {
+	responses []mockResponse
+	chunkSize int
+	delay     time.Duration
+
+	mu   sync.Mutex
+	next int
+}
+
+type mockResponse struct {
+	config.MockResponse
+	tmpl *template.Template
+}
+
+// mockInput is the data available to response templates.
+type mockInput struct {
+	Model    string
+	Prompt   string // content of the last user message
+	Messages []map[string]any
+}
+
+// NewMockTransport compiles the configured responses. With none configured,
+// every request gets an echo of its prompt.
+func NewMockTransport(c config.MockConfig) (*MockTransport, error) {
+	mt := &MockTransport{
+		chunkSize: c.ChunkSize,
+		delay:     c.ChunkDelay.Or(defaultMockChunkDelay),
+	}
+	if mt.chunkSize <= 0 {
+		mt.chunkSize = defaultMockChunkSize
+	}
+
+	specs := c.Responses
+	if len(specs) == 0 {
+		specs = []config.MockResponse{{Template: "Echo: {{.Prompt}}"}}
+	}
+	for i, spec := range specs {
+		mr := mockResponse{MockResponse: spec}
+		if spec.Template != "" {
+			t, err := template.New(fmt.Sprintf("mock[%d]", i)).Parse(spec.Template)
+			if err != nil {
+				return nil, fmt.Errorf("mock.responses[%d]: %w", i, err)
+			}
+			mr.tmpl = t
+		}
+		mt.responses = append(mt.responses, mr)
+	}
+	return mt, nil
+}
+
+func (mt *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		return jsonResponse(req, map[string]any{
+			"object": "list",
+			"data":   []any{map[string]any{"id": "mock", "object": "model", "owned_by": "llm-local-proxy"}},
+		}), nil
+	}
+
+	var body map[string]any
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		json.Unmarshal(data, &body)
+	}
+
+	in := mockInputFrom(body)
+	mr := mt.pick()
+	text := mr.Text
+	if mr.tmpl != nil {
+		var b strings.Builder
+		if err := mr.tmpl.Execute(&b, in); err != nil {
+			text = "mock template error: " + err.Error()
+		} else {
+			text = b.String()
+		}
+	}
+
+	if stream, _ := body["stream"].(bool); stream {
+		return mt.streamResponse(req, body, text, mr), nil
+	}
+
+	msg := map[string]any{"role": "assistant", "content": text}
+	if mr.Reasoning != "" {
+		msg["reasoning_content"] = mr.Reasoning
+	}
+	finish := "stop"
+	if len(mr.ToolCalls) > 0 {
+		msg["tool_calls"] = mockToolCalls(mr.ToolCalls, false)
+		finish = "tool_calls"
+	}
+	return jsonResponse(req, map[string]any{
+		"id":      "chatcmpl-mock",
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   in.Model,
+		"choices": []any{map[string]any{"index": 0, "message": msg, "finish_reason": finish}},
+		"usage":   mockUsage(body, mr.Reasoning+text),
+	}), nil
+}
+
+// pick returns the next scripted response, cycling through the list.
+func (mt *MockTransport) pick() mockResponse {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mr := mt.responses[mt.next%len(mt.responses)]
+	mt.next++
+	return mr
+}
+
+// streamResponse emits reasoning, content and tool calls as SSE chunks of
+// chunkSize characters, delay apart.
+func (mt *MockTransport) streamResponse(req *http.Request, body map[string]any, text string, mr mockResponse) *http.Response {
+	pr, pw := io.Pipe()
+	model, _ := body["model"].(string)
+
+	go func() {
+		defer pw.Close()
+		send := func(delta map[string]any, finish any, usage any) bool {
+			chunk := map[string]any{
+				"id":      "chatcmpl-mock",
+				"object":  "chat.completion.chunk",
+				"created": time.Now().Unix(),
+				"model":   model,
+				"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finish}},
+			}
+			if usage != nil {
+				chunk["usage"] = usage
+			}
+			data, _ := json.Marshal(chunk)
+			if _, err := fmt.Fprintf(pw, "data: %s\n\n", data); err != nil {
+				return false
+			}
+			return sleepCtx(req.Context(), mt.delay)
+		}
+
+		if !send(map[string]any{"role": "assistant", "content": nil}, nil, nil) {
+			return
+		}
+		for _, piece := range transform.SplitRunes(mr.Reasoning, mt.chunkSize) {
+			if !send(map[string]any{"reasoning_content": piece}, nil, nil) {
+				return
+			}
+		}
+		for _, piece := range transform.SplitRunes(text, mt.chunkSize) {
+			if !send(map[string]any{"content": piece}, nil, nil) {
+				return
+			}
+		}
+		finish := "stop"
+		if len(mr.ToolCalls) > 0 {
+			if !send(map[string]any{"tool_calls": mockToolCalls(mr.ToolCalls, true)}, nil, nil) {
+				return
+			}
+			finish = "tool_calls"
+		}
+		if !send(map[string]any{}, finish, mockUsage(body, mr.Reasoning+text)) {
+			return
+		}
+		io.WriteString(pw, "data: [DONE]\n\n")
+	}()
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"text/event-stream"}},
+		Body:       pr,
+		Request:    req,
+	}
+}
+
+func mockInputFrom(body map[string]any) mockInput {
+	in := mockInput{}
+	in.Model, _ = body["model"].(string)
+	msgs, _ := body["messages"].([]any)
+	for _, m := range msgs {
+		msg, ok := m.(map[string]any)
+		if !ok {
+			continue
+		}
+		in.Messages = append(in.Messages, msg)
+		if msg["role"] == "user" {
+			in.Prompt = contentText(msg["content"])
+		}
+	}
+	return in
+}
+
+// contentText flattens a message content (string or array of parts) to text.
+func contentText(content any) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []any:
+		var parts []string
+		for _, p := range c {
+			if part, ok := p.(map[string]any); ok {
+				if t, ok := part["text"].(string); ok {
+					parts = append(parts, t)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+func mockToolCalls(calls []config.MockToolCall, withIndex bool) []any {
+	out := make([]any, len(calls))
+	for i, tc := range calls {
+		// Arguments may be given as a JSON object or as an already-encoded string
+		args := string(tc.Arguments)
+		var s string
+		if json.Unmarshal(tc.Arguments, &s) == nil {
+			args = s
+		}
+		if args == "" {
+			args = "{}"
+		}
+		call := map[string]any{
+			"id":       fmt.Sprintf("call_mock_%d", i),
+			"type":     "function",
+			"function": map[string]any{"name": tc.Name, "arguments": args},
+		}
+		if withIndex {
+			call["index"] = i
+		}
+		out[i] = call
+	}
+	return out
+}
+
+func mockUsage(body map[string]any, output string) transform.Usage {
+	prompt, _ := json.Marshal(body["messages"])
+	u := transform.Usage{
+		PromptTokens:     transform.EstimateTokens(len(prompt)),
+		CompletionTokens: transform.EstimateTokens(len(output)),
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+func jsonResponse(req *http.Request, v any) *http.Response {
+	data, _ := json.Marshal(v)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/provider"
+)
+
+const (
+	defaultModelListRefresh = 5 * time.Minute
+	modelListTimeout        = 10 * time.Second
+)
+
+// modelList serves /models from the proxy instead of relaying it to one
+// upstream: the lists of all providers are fetched, merged and cached, the
+// aliases added, and every entry annotated with the provider serving it,
+// its context window and its price as configured. A nil *modelList leaves
+// /models to the passthrough.
+type modelList struct {
+	cfg      config.ModelListConfig
+	refresh  time.Duration
+	windows  config.TruncationConfig
+	pricing  map[string]config.ModelPrice
+	aliases  map[string]string
+	registry provider.Registry
+
+	mu      sync.Mutex
+	byName  map[string][]map[string]any // provider → its upstream entries as last fetched
+	fetched time.Time
+}
+
+func newModelList(cfg config.Config, registry provider.Registry) *modelList {
+	if !cfg.ModelList.Enabled {
+		return nil
+	}
+	return &modelList{
+		cfg:      cfg.ModelList,
+		refresh:  cfg.ModelList.Refresh.Or(defaultModelListRefresh),
+		windows:  cfg.Truncation,
+		pricing:  cfg.Pricing,
+		aliases:  cfg.ModelAliases,
+		registry: registry,
+		byName:   make(map[string][]map[string]any),
+	}
+}
+
+// handles reports whether r asks for the model list or one of its entries.
+func (l *modelList) handles(r *http.Request) bool {
+	path := stripVersionPrefix(r.URL.Path)
+	return l != nil && r.Method == http.MethodGet && (path == "/models" || strings.HasPrefix(path, "/models/"))
+}
+
+// serve answers with the merged list, or the entry named in the path,
+// leaving out the models the client's virtual key may not use.
+func (l *modelList) serve(h *Handler, w http.ResponseWriter, r *http.Request) {
+	ex := exchangeFrom(r.Context())
+	ex.Status = http.StatusOK
+	models := l.list(h, r.Context())
+	models = slices.DeleteFunc(models, func(m map[string]any) bool {
+		id, _ := m["id"].(string)
+		return !ex.key.AllowsModel(id)
+	})
+
+	id, ok := strings.CutPrefix(stripVersionPrefix(r.URL.Path), "/models/")
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": models})
+		return
+	}
+	for _, m := range models {
+		if m["id"] == id {
+			writeJSON(w, http.StatusOK, m)
+			return
+		}
+	}
+	ex.Status = http.StatusNotFound
+	writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found",
+		fmt.Sprintf("The model %q does not exist.", id))
+}
+
+// list returns the merged entries, fetching the upstream lists again once
+// the cached ones are older than the refresh interval. A provider whose
+// fetch fails keeps its previous entries.
+func (l *modelList) list(h *Handler, ctx context.Context) []map[string]any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.fetched) >= l.refresh {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), modelListTimeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, p := range l.registry.Providers() {
+			wg.Go(func() {
+				entries, err := fetchModels(ctx, h, p)
+				if err != nil {
+					fmt.Printf("  ✗ model list of %s: %v\n", p.Name(), err)
+					return
+				}
+				mu.Lock()
+				l.byName[p.Name()] = entries
+				mu.Unlock()
+			})
+		}
+		wg.Wait()
+		l.fetched = time.Now()
+	}
+	return l.merge()
+}
+
+// merge builds the list from the fetched entries. Caller holds mu.
+func (l *modelList) merge() []map[string]any {
+	byID := make(map[string]map[string]any)
+	for _, p := range l.registry.Providers() {
+		for _, entry := range l.byName[p.Name()] {
+			id, _ := entry["id"].(string)
+			// A model listed by several upstreams is served by one of them
+			if id == "" || byID[id] != nil || l.registry.Resolve(id) != p {
+				continue
+			}
+			m := maps.Clone(entry)
+			m["provider"] = p.Name()
+			byID[id] = m
+		}
+	}
+	for alias, target := range l.aliases {
+		if m, ok := byID[target]; ok && byID[alias] == nil {
+			m = maps.Clone(m)
+			m["id"], m["alias_of"] = alias, target
+			byID[alias] = m
+		}
+	}
+
+	models := make([]map[string]any, 0, len(byID))
+	for id, m := range byID {
+		if !l.cfg.Lists(id) {
+			continue
+		}
+		model := id
+		if target, ok := m["alias_of"].(string); ok {
+			model = target
+		}
+		if w := l.windows.Window(model); w > 0 {
+			m["context_length"] = w
+		}
+		if price, ok := l.price(model); ok {
+			m["pricing"] = price
+		}
+		models = append(models, m)
+	}
+	slices.SortFunc(models, func(a, b map[string]any) int {
+		return cmp.Compare(a["id"].(string), b["id"].(string))
+	})
+	return models
+}
+
+// price returns the configured price of model, or the "*" one.
+func (l *modelList) price(model string) (config.ModelPrice, bool) {
+	if price, ok := l.pricing[model]; ok {
+		return price, true
+	}
+	price, ok := l.pricing["*"]
+	return price, ok
+}
+
+// fetchModels gets the entries of a provider's /models list.
+func fetchModels(ctx context.Context, h *Handler, p provider.Provider) ([]map[string]any, error) {
+	ep := p.NextEndpoint()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(ep.BaseURL, "/")+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	provider.Authorize(p, req.Header, ep.APIKey)
+	if host := p.HostOverride(); host != "" {
+		req.Host = host
+	}
+	resp, err := h.clientFor(p).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	var list struct {
+		Data []map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"llm-local-proxy/config"
+)
+
+const defaultModerationTimeout = 10 * time.Second
+
+// moderator checks the messages of chat requests before they are
+// forwarded. The local keywords and patterns are checked first, so the
+// endpoint is only asked about requests they let through.
+type moderator struct {
+	cfg      config.ModerationConfig
+	client   *http.Client
+	keywords []string // lower-cased
+	patterns []*regexp.Regexp
+}
+
+func newModerator(cfg config.ModerationConfig, client *http.Client) *moderator {
+	if cfg.URL == "" && len(cfg.Keywords) == 0 && len(cfg.Patterns) == 0 {
+		return nil
+	}
+	m := &moderator{cfg: cfg, client: client}
+	for _, k := range cfg.Keywords {
+		m.keywords = append(m.keywords, strings.ToLower(k))
+	}
+	for _, p := range cfg.Patterns {
+		if re, err := regexp.Compile(p); err == nil {
+			m.patterns = append(m.patterns, re)
+		}
+	}
+	return m
+}
+
+// moderationRejection is why a request was blocked.
+type moderationRejection struct {
+	rule       string   // what matched, for the log only
+	categories []string // categories flagged by the endpoint
+}
+
+// check reports why the request must be blocked, or nil when it may be
+// forwarded. The text of every message except the assistant's own is
+// checked. An error means the endpoint could not be asked.
+func (m *moderator) check(ctx context.Context, body []byte) (*moderationRejection, error) {
+	if m == nil {
+		return nil, nil
+	}
+	texts := moderationInput(body)
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	for _, text := range texts {
+		lower := strings.ToLower(text)
+		for i, k := range m.keywords {
+			if strings.Contains(lower, k) {
+				return &moderationRejection{rule: fmt.Sprintf("keyword %q", m.cfg.Keywords[i])}, nil
+			}
+		}
+		for _, re := range m.patterns {
+			if re.MatchString(text) {
+				return &moderationRejection{rule: fmt.Sprintf("pattern %q", re)}, nil
+			}
+		}
+	}
+	if m.cfg.URL == "" {
+		return nil, nil
+	}
+	return m.ask(ctx, texts)
+}
+
+// ask sends texts to the moderations endpoint.
+func (m *moderator) ask(ctx context.Context, texts []string) (*moderationRejection, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout.Or(defaultModerationTimeout))
+	defer cancel()
+	reqBody, _ := json.Marshal(struct {
+		Model string   `json:"model,omitempty"`
+		Input []string `json:"input"`
+	}{m.cfg.Model, texts})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.URL, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.cfg.APIKey)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation endpoint: %s", resp.Status)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("moderation endpoint: %w", err)
+	}
+	var rejection *moderationRejection
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		if rejection == nil {
+			rejection = &moderationRejection{rule: "moderation endpoint"}
+		}
+		for category, flagged := range r.Categories {
+			if flagged && !slices.Contains(rejection.categories, category) {
+				rejection.categories = append(rejection.categories, category)
+			}
+		}
+	}
+	if rejection != nil {
+		slices.Sort(rejection.categories)
+		rejection.rule += " (" + strings.Join(rejection.categories, ", ") + ")"
+	}
+	return rejection, nil
+}
+
+// moderationInput returns the text of the request's messages, leaving out
+// those of the assistant.
+func moderationInput(body []byte) []string {
+	var req struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return nil
+	}
+	var texts []string
+	for _, msg := range req.Messages {
+		if msg["role"] == "assistant" {
+			continue
+		}
+		switch content := msg["content"].(type) {
+		case string:
+			if content != "" {
+				texts = append(texts, content)
+			}
+		case []any:
+			for _, part := range content {
+				if p, _ := part.(map[string]any); p["type"] == "text" {
+					if text, _ := p["text"].(string); text != "" {
+						texts = append(texts, text)
+					}
+				}
+			}
+		}
+	}
+	return texts
+}
+
+// moderate checks body and answers the request itself when it must not be
+// forwarded, reporting whether it did. Keywords and patterns are not
+// disclosed to the client, only the categories the endpoint flagged.
+func (h *Handler) moderate(ctx context.Context, w http.ResponseWriter, body []byte) bool {
+	rejection, err := h.moderation.check(ctx, body)
+	switch {
+	case err != nil && h.moderation.cfg.FailOpen:
+		fmt.Printf("  ✗ moderation failed, forwarding anyway: %v\n", err)
+	case err != nil:
+		fmt.Printf("  ✗ moderation failed: %v\n", err)
+		writeError(w, http.StatusServiceUnavailable, "server_error", "moderation_unavailable",
+			"Content moderation is unavailable; the request was not forwarded.")
+		return true
+	case rejection != nil:
+		message := "The request was blocked by content moderation."
+		if len(rejection.categories) > 0 {
+			message = fmt.Sprintf("The request was blocked by content moderation (flagged: %s).", strings.Join(rejection.categories, ", "))
+		}
+		fmt.Printf("  ✗ blocked by %s\n", rejection.rule)
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "content_blocked", message)
+		return true
+	}
+	return false
+}
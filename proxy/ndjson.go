@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// acceptsNDJSON reports whether the client asks for newline-delimited JSON
+// instead of server-sent events.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, _ := mime.ParseMediaType(accept); mediaType == "application/x-ndjson" {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonWriter reframes an event stream as newline-delimited JSON: the data
+// of every event becomes one line. Comments such as keepalive pings and the
+// final "[DONE]" are dropped, as every line must be a JSON value; the end of
+// the body ends the stream. Other responses pass through unchanged.
+type ndjsonWriter struct {
+	http.ResponseWriter
+	status int
+	stream bool
+	line   bytes.Buffer // the unfinished line of a stream
+}
+
+func (nw *ndjsonWriter) WriteHeader(status int) {
+	if nw.status != 0 {
+		return
+	}
+	nw.status = status
+	if strings.HasPrefix(nw.Header().Get("Content-Type"), "text/event-stream") {
+		nw.stream = true
+		nw.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	nw.ResponseWriter.WriteHeader(status)
+}
+
+func (nw *ndjsonWriter) Write(p []byte) (int, error) {
+	nw.WriteHeader(http.StatusOK)
+	if !nw.stream {
+		return nw.ResponseWriter.Write(p)
+	}
+	nw.line.Write(p)
+	var out []byte
+	for {
+		line, err := nw.line.ReadBytes('\n')
+		if err != nil {
+			// Put back the unfinished line
+			rest := bytes.Clone(line)
+			nw.line.Reset()
+			nw.line.Write(rest)
+			break
+		}
+		data, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), []byte("data:"))
+		if data = bytes.TrimSpace(data); !ok || string(data) == "[DONE]" {
+			continue
+		}
+		out = append(append(out, data...), '\n')
+	}
+	if len(out) > 0 {
+		if _, err := nw.ResponseWriter.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (nw *ndjsonWriter) Flush() {
+	if f, ok := nw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (nw *ndjsonWriter) Unwrap() http.ResponseWriter {
+	return nw.ResponseWriter
+}
@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"llm-local-proxy/provider"
+	"llm-local-proxy/transform"
+)
+
+// passthroughPrefixes are the endpoints, after the version prefix, that
+// need no transformation and are relayed as they are.
+var passthroughPrefixes = []string{"/embeddings", "/models", "/files", "/batches", "/moderations", "/audio", "/realtime"}
+
+// modelPeekSize bounds how much of a relayed body is looked at for its model.
+const modelPeekSize = 64 * 1024
+
+func isPassthroughPath(path string) bool {
+	path = stripVersionPrefix(path)
+	for _, prefix := range passthroughPrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isBatchPath reports whether a path belongs to the batch workflow, whose
+// files and batches are tracked in batchObjects.
+func isBatchPath(path string) bool {
+	path = stripVersionPrefix(path)
+	return path == "/files" || strings.HasPrefix(path, "/files/") ||
+		path == "/batches" || strings.HasPrefix(path, "/batches/")
+}
+
+// requestModel finds the model in the first bytes of a relayed body: the
+// "model" field of a JSON body, or the "model" form field of a multipart
+// upload such as an audio transcription. A batch input file names it in
+// the body of its first request, and a WebSocket session in the query.
+func requestModel(r *http.Request, head []byte) string {
+	if model := r.URL.Query().Get("model"); model != "" && isUpgrade(r) {
+		return model
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		model, _ := transform.StringField(head, "model")
+		return model
+	}
+	// Parts cut off at the end of head just end the search
+	mr := multipart.NewReader(bytes.NewReader(head), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return ""
+		}
+		switch part.FormName() {
+		case "model":
+			value, _ := io.ReadAll(io.LimitReader(part, 256))
+			return strings.TrimSpace(string(value))
+		case "file":
+			line, _ := bufio.NewReader(part).ReadBytes('\n')
+			var req struct {
+				Body struct {
+					Model string `json:"model"`
+				} `json:"body"`
+			}
+			if json.Unmarshal(line, &req) == nil && req.Body.Model != "" {
+				return req.Body.Model
+			}
+		}
+	}
+}
+
+// servePassthrough relays a request to an endpoint that needs no
+// transformation as a streaming reverse proxy: the body, JSON, multipart or
+// binary, is neither buffered nor rewritten, and the client's
+// Accept-Encoding is kept, so compressed responses pass through as they
+// are. The provider is picked by the model in the first bytes of the body,
+// falling back to the "*" provider and then the first configured one.
+// Requests are not retried, as the body is consumed on the way.
+// WebSocket upgrades, such as Realtime API sessions, are relayed in both
+// directions until either side closes.
+func (h *Handler) servePassthrough(w http.ResponseWriter, r *http.Request) {
+	body := bufio.NewReaderSize(r.Body, modelPeekSize)
+	head, peekErr := body.Peek(modelPeekSize)
+	model := requestModel(r, head)
+	if target, ok := h.aliases[model]; ok && isBatchPath(r.URL.Path) {
+		model = target
+	}
+	if model != "" && !allowModel(w, exchangeFrom(r.Context()).key, model) {
+		return
+	}
+	p := h.resolve(r.Context(), model)
+	if providers := h.registry.Providers(); p == nil && len(providers) > 0 {
+		p = providers[0]
+	}
+	// Files and batches stay with the endpoint that holds them
+	obj, pinned := h.batches.lookup(r.URL.Path, head)
+	if pinned {
+		p = obj.p
+	}
+	if p == nil {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", "No provider serves the requested model.")
+		return
+	}
+
+	ex := exchangeFrom(r.Context())
+	ex.Model, ex.Provider = model, p.Name()
+	if !h.breakers.allow(p.Name()) {
+		fmt.Printf("  ✗ circuit open for %s, failing fast\n", p.Name())
+		writeUpstreamError(w, p, errCircuitOpen)
+		return
+	}
+	// Small embeddings requests, read completely by the peek, may be batched
+	if peekErr != nil && stripVersionPrefix(r.URL.Path) == "/embeddings" && h.embeddings.serve(h, w, r, p, head) {
+		return
+	}
+	ep := obj.ep
+	if !pinned {
+		ep = p.NextEndpoint()
+	}
+	target, err := url.Parse(ep.BaseURL)
+	if err != nil {
+		writeUpstreamError(w, p, err)
+		return
+	}
+	fmt.Printf("  ⇄ passthrough → %s (%s)\n", p.Name(), ep.BaseURL)
+	var relayed io.Reader = body
+	if isBatchPath(r.URL.Path) {
+		relayed = h.rewriteBatchRequest(r, body, head, peekErr != nil, p, apiVersion(target))
+	}
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.Path = strings.TrimSuffix(target.Path, "/") + stripVersionPrefix(r.URL.Path)
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = p.HostOverride()
+			provider.Authorize(p, pr.Out.Header, ep.APIKey)
+			pr.Out.Header.Set("User-Agent", "claude-code/1.0")
+			pr.Out.Header.Del("X-Reasoning-Mode")
+			pr.Out.Header.Del("X-Proxy-Debug")
+			stripRealtimeKey(pr.Out.Header)
+			h.headers.request(p.Name(), pr.Out.Header)
+			debugUpstream(ex, pr.Out, nil)
+		},
+		Transport: h.clientFor(p).Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			ex.Status = resp.StatusCode
+			h.breakers.record(p.Name(), resp.StatusCode >= 500)
+			observeUpstream(ex, p, ep, resp)
+			debugUpstreamResponse(ex, resp)
+			if resp.StatusCode == http.StatusTooManyRequests {
+				ep.MarkRateLimited(retryAfter(resp, rateLimitCooldown))
+			}
+			h.batches.observe(resp, r.URL.Path, batchObject{p: p, ep: ep}, ex)
+			watchUsage(resp, ex)
+			normalizeRelayedError(resp)
+			h.headers.response(p.Name(), resp.Header)
+			if conn, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+				resp.Body = newRealtimeConn(conn, p.Name())
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, out *http.Request, err error) {
+			fmt.Printf("  ✗ upstream error: %v\n", err)
+			if out.Context().Err() != nil || errors.Is(err, context.Canceled) {
+				h.breakers.abandon(p.Name())
+			} else {
+				h.breakers.record(p.Name(), true)
+			}
+			ex.Status = writeUpstreamError(w, p, err)
+		},
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{relayed, r.Body}
+	rp.ServeHTTP(w, r)
+}
+
+// usageScanSize is how much of the start and of the end of a relayed JSON
+// response is kept to look for its usage.
+const usageScanSize = 8 << 10
+
+// watchUsage charges the usage object of a successful, uncompressed JSON
+// response, such as that of embeddings or a transcription, to ex as the
+// response is relayed, for the stats and budgets.
+func watchUsage(resp *http.Response, ex *Exchange) {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	if _, ok := resp.Body.(*batchUsage); ok {
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return
+	}
+	resp.Body = &relayedUsage{ReadCloser: resp.Body, ex: ex}
+}
+
+// relayedUsage keeps the start and the end of a JSON body as it passes
+// through, where APIs put the usage object, without holding the rest of it.
+type relayedUsage struct {
+	io.ReadCloser
+	ex      *Exchange
+	head    []byte
+	tail    []byte
+	trimmed bool // tail no longer follows on from head
+	done    bool
+}
+
+func (u *relayedUsage) Read(p []byte) (int, error) {
+	n, err := u.ReadCloser.Read(p)
+	chunk := p[:n]
+	if room := usageScanSize - len(u.head); room > 0 {
+		k := min(room, len(chunk))
+		u.head, chunk = append(u.head, chunk[:k]...), chunk[k:]
+	}
+	u.tail = append(u.tail, chunk...)
+	if extra := len(u.tail) - usageScanSize; extra > 0 {
+		u.tail = append(u.tail[:0], u.tail[extra:]...)
+		u.trimmed = true
+	}
+	if err == io.EOF && !u.done {
+		u.done = true
+		u.charge()
+	}
+	return n, err
+}
+
+func (u *relayedUsage) charge() {
+	parts := [][]byte{u.tail, u.head}
+	if !u.trimmed {
+		parts = [][]byte{append(u.head, u.tail...)}
+	}
+	for _, part := range parts {
+		i := bytes.LastIndex(part, []byte(`"usage"`))
+		if i < 0 {
+			continue
+		}
+		rest, ok := bytes.CutPrefix(bytes.TrimLeft(part[i+len(`"usage"`):], " \t\r\n"), []byte(":"))
+		var usage map[string]any
+		if !ok || json.NewDecoder(bytes.NewReader(rest)).Decode(&usage) != nil {
+			continue
+		}
+		if got, ok := transform.UsageFromMap(map[string]any{"usage": usage}); ok && got.TotalTokens > 0 {
+			u.ex.Usage = u.ex.Usage.Add(got)
+			return
+		}
+	}
+}
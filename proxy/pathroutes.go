@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/provider"
+)
+
+type pathRouteKey struct{}
+
+// PathRouter strips the prefix of the path route a request falls under and
+// pins the request to that route's provider, ahead of the middleware, which
+// then see the path the proxy serves.
+type PathRouter struct {
+	routes []config.PathRoute // longest prefix first, so the most specific one matches
+	next   http.Handler
+}
+
+// NewPathRouter routes requests under the prefixes of routes before passing
+// them to next.
+func NewPathRouter(routes []config.PathRoute, next http.Handler) *PathRouter {
+	routes = slices.Clone(routes)
+	slices.SortStableFunc(routes, func(a, b config.PathRoute) int { return cmp.Compare(len(b.Prefix), len(a.Prefix)) })
+	return &PathRouter{routes: routes, next: next}
+}
+
+func (pr *PathRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range pr.routes {
+		rest, ok := strings.CutPrefix(r.URL.Path, route.Prefix)
+		if !ok || rest != "" && rest[0] != '/' {
+			continue
+		}
+		// As http.StripPrefix does, without sharing the URL
+		r2 := r.WithContext(context.WithValue(r.Context(), pathRouteKey{}, route))
+		u := *r.URL
+		u.Path, u.RawPath = cmp.Or(rest, "/"), ""
+		r2.URL = &u
+		pr.next.ServeHTTP(w, r2)
+		return
+	}
+	pr.next.ServeHTTP(w, r)
+}
+
+// pathRouteFrom returns the path route the request came in under.
+func pathRouteFrom(ctx context.Context) (config.PathRoute, bool) {
+	route, ok := ctx.Value(pathRouteKey{}).(config.PathRoute)
+	return route, ok
+}
+
+// resolve returns the provider of model: the one a path route pinned the
+// request to, else the one serving model.
+func (h *Handler) resolve(ctx context.Context, model string) provider.Provider {
+	if route, ok := pathRouteFrom(ctx); ok {
+		return h.registry.Named(route.Provider)
+	}
+	return h.registry.Resolve(model)
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+// newPIIRules returns the rules masking what cfg selects. Patterns were
+// checked by Config.Validate.
+func newPIIRules(cfg config.PIIConfig) []transform.PIIRule {
+	var rules []transform.PIIRule
+	if cfg.Emails {
+		rules = append(rules, transform.PIIRule{Label: "EMAIL", Pattern: transform.EmailPattern})
+	}
+	if cfg.Phones {
+		rules = append(rules, transform.PIIRule{Label: "PHONE", Pattern: transform.PhonePattern})
+	}
+	for _, p := range cfg.Patterns {
+		if re, err := regexp.Compile(p); err == nil {
+			rules = append(rules, transform.PIIRule{Label: "PII", Pattern: re})
+		}
+	}
+	return rules
+}
+
+// maskPII masks personal data in the messages of a chat request. It returns
+// the vault restoring the originals in the response, or nil unless unmask
+// is configured.
+func (h *Handler) maskPII(body []byte) ([]byte, *transform.PIIVault) {
+	body, vault := transform.MaskPII(body, h.pii, h.piiUnmask)
+	if vault == nil {
+		return body, nil
+	}
+	fmt.Printf("  ✂ masked PII: %s\n", vault.Summary())
+	if !h.piiUnmask {
+		return body, nil
+	}
+	return body, vault
+}
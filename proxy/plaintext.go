@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"llm-local-proxy/transform"
+)
+
+func isPlainTextPath(path string) bool {
+	return stripVersionPrefix(path) == "/chat/text"
+}
+
+// servePlainText accepts a chat request and streams back only the text of
+// the answer as text/plain, for curl and integrations that would rather
+// not parse SSE. The request is always streamed. Reasoning is dropped by
+// default; in merge mode it is marked off in the text as reasoning_format
+// configures. Errors keep their JSON body and status.
+func (h *Handler) servePlainText(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method_not_allowed", "Use POST.")
+		return
+	}
+	body, err := readAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
+		return
+	}
+	r.Body.Close()
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_request", "The request body is not valid JSON.")
+		return
+	}
+	fmt.Println("  ⇄ chat/completions → text/plain")
+
+	tw := &translateWriter{
+		ResponseWriter: w,
+		body:           chatText,
+		event: func(data []byte) []byte {
+			if string(data) == "[DONE]" {
+				return []byte("\n")
+			}
+			return deltaText(data)
+		},
+		contentType: "text/plain; charset=utf-8",
+	}
+	tw.Header().Set("X-Content-Type-Options", "nosniff")
+	h.serveTranslated(tw, r, transform.SetStream(body, true), reasoningDrop)
+}
+
+// deltaText returns the content of the deltas of a chat stream chunk. An
+// error event ends the text with its message.
+func deltaText(data []byte) []byte {
+	var chunk struct {
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &chunk) != nil {
+		return nil
+	}
+	if chunk.Error != nil {
+		return fmt.Appendf(nil, "\n[error: %s]\n", chunk.Error.Message)
+	}
+	var text []byte
+	for _, choice := range chunk.Choices {
+		text = append(text, choice.Delta.Content...)
+	}
+	return text
+}
+
+// chatText returns the content of a chat completion, for upstream
+// responses that were not streamed after all.
+func chatText(data []byte) ([]byte, error) {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	var text []byte
+	for _, choice := range resp.Choices {
+		text = append(text, choice.Message.Content...)
+	}
+	return append(text, '\n'), nil
+}
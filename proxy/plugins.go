@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"llm-local-proxy/plugin"
+	"llm-local-proxy/transform"
+)
+
+// SetPlugins installs the plugins whose hooks rewrite chat requests and
+// responses.
+func (h *Handler) SetPlugins(c *plugin.Chain) {
+	h.plugins = c
+}
+
+// runRequestPlugins runs the request hooks on body. It writes the error for
+// a request a plugin refused or failed on and reports whether it did.
+func (h *Handler) runRequestPlugins(ctx context.Context, w http.ResponseWriter, body []byte) ([]byte, bool) {
+	model, _ := transform.StringField(body, "model")
+	if !h.plugins.Runs(plugin.HookRequest, model) {
+		return body, false
+	}
+	body, err := h.plugins.Request(ctx, model, body)
+	var rejection *plugin.Rejection
+	switch {
+	case errors.As(err, &rejection):
+		fmt.Printf("  ✗ rejected by plugin %v\n", err)
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "plugin_rejected", rejection.Message)
+		return nil, true
+	case err != nil:
+		fmt.Printf("  ✗ plugin %v\n", err)
+		writeError(w, http.StatusServiceUnavailable, "server_error", "plugin_failed",
+			"A request plugin of the proxy failed; the request was not forwarded.")
+		return nil, true
+	}
+	return body, false
+}
+
+// pluginChunk runs the chunk hooks on an SSE data line. Other lines and
+// [DONE] are returned as they are.
+func (h *Handler) pluginChunk(ctx context.Context, model string, line []byte) []byte {
+	data, ok := bytes.CutPrefix(line, []byte("data: "))
+	if !ok {
+		return line
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "[DONE]" {
+		return line
+	}
+	out := h.plugins.Chunk(ctx, model, data)
+	if bytes.Equal(out, data) {
+		return line
+	}
+	return append(append([]byte("data: "), bytes.TrimSpace(out)...), '\n')
+}
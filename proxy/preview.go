@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"llm-local-proxy/provider"
+	"llm-local-proxy/transform"
+)
+
+// isPreviewPath reports whether path is served by serveTransformPreview.
+func isPreviewPath(path string) bool {
+	return stripVersionPrefix(path) == "/debug/transform"
+}
+
+// previewRoute is what would be sent to one provider.
+type previewRoute struct {
+	Provider string            `json:"provider"`
+	Model    string            `json:"model"`
+	Fallback bool              `json:"fallback,omitempty"`
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Header   map[string]string `json:"headers"`
+	Body     json.RawMessage   `json:"body"`
+}
+
+// serveTransformPreview accepts a chat request and returns the requests the
+// proxy would send upstream for it, one per route in the order they would
+// be tried, without sending any. Alias resolution, PII masking, system
+// prompts, parameter overrides, truncation and the provider's own request
+// transformations are applied; steps that call out to other services,
+// summarization and the moderation endpoint, are not.
+func (h *Handler) serveTransformPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method_not_allowed", "Use POST.")
+		return
+	}
+	body, err := readAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
+		return
+	}
+	r.Body.Close()
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_request", "The request body is not valid JSON.")
+		return
+	}
+	fmt.Println("  ⇄ transform preview, not forwarded")
+
+	body, alias := h.resolveAlias(body)
+	if model, _ := transform.StringField(body, "model"); !allowModel(w, exchangeFrom(r.Context()).key, model) {
+		return
+	}
+	body, _ = transform.LegacyFunctionsToTools(body)
+	model, routes := h.resolveRoutes(r.Context(), body)
+	if len(routes) == 0 {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", "No provider serves the requested model.")
+		return
+	}
+	body = h.store.Attach(body)
+	body, _ = transform.MaskPII(body, h.pii, h.piiUnmask)
+
+	keyName := exchangeFrom(r.Context()).KeyName
+	previews := make([]previewRoute, 0, len(routes))
+	for _, rt := range routes {
+		routed := h.routeBody(body, rt, keyName)
+		model, _ := transform.StringField(routed, "model")
+		stream, _ := transform.IsStream(routed)
+		reqBody, forced := h.upstreamBody(rt.provider, routed)
+		// The first endpoint of the pool stands for all of them, without
+		// taking a turn in the load balancing
+		ep := rt.provider.Pool()[0]
+		path := provider.ChatPath(rt.provider, model, stream != forced)
+		req, err := newUpstreamRequest(r.Context(), r, rt.provider, ep, path, reqBody)
+		if err != nil {
+			writeUpstreamError(w, rt.provider, err)
+			return
+		}
+		h.headers.request(rt.provider.Name(), req.Header)
+		header := make(map[string]string, len(req.Header))
+		for name := range req.Header {
+			header[name] = req.Header.Get(name)
+		}
+		if auth, ok := header["Authorization"]; ok {
+			token, bearer := strings.CutPrefix(auth, "Bearer ")
+			if header["Authorization"] = provider.MaskKey(token); bearer {
+				header["Authorization"] = "Bearer " + header["Authorization"]
+			}
+		}
+		for _, name := range []string{"X-Api-Key", "X-Goog-Api-Key"} {
+			if key, ok := header[name]; ok {
+				header[name] = provider.MaskKey(key)
+			}
+		}
+		if req.Host != "" {
+			header["Host"] = req.Host
+		}
+		previews = append(previews, previewRoute{
+			Provider: rt.provider.Name(),
+			Model:    rt.model,
+			Fallback: rt.fallback,
+			Method:   req.Method,
+			URL:      req.URL.String(),
+			Header:   header,
+			Body:     reqBody,
+		})
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Model  string         `json:"model"`
+		Alias  string         `json:"alias,omitempty"`
+		Routes []previewRoute `json:"routes"`
+	}{model, alias, previews})
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+
+	"llm-local-proxy/transform"
+)
+
+// Response headers carrying the upstream's prompt cache usage, when
+// cache_headers is enabled.
+const (
+	cacheHitHeader  = "X-Proxy-Cache-Hit-Tokens"
+	cacheMissHeader = "X-Proxy-Cache-Miss-Tokens"
+)
+
+// declareCacheTrailers announces the cache headers as trailers of a
+// streamed response, whose usage is only known once it has been sent. It
+// must be called before the header is written.
+func declareCacheTrailers(header http.Header) {
+	header.Add("Trailer", cacheHitHeader)
+	header.Add("Trailer", cacheMissHeader)
+}
+
+// setCacheHeaders sets the cache headers from u, unless the upstream did
+// not report its cache usage.
+func setCacheHeaders(header http.Header, u transform.Usage) {
+	if !u.CacheReported() {
+		return
+	}
+	header.Set(cacheHitHeader, strconv.Itoa(u.CacheHitTokens))
+	header.Set(cacheMissHeader, strconv.Itoa(u.CacheMissTokens))
+}
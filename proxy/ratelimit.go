@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/provider"
+)
+
+// idleBucketTTL is how long an untouched client's buckets are kept.
+const idleBucketTTL = 10 * time.Minute
+
+// RateLimiter applies per-client token buckets for requests per minute and
+// tokens per minute. Token usage is only known after the response, so the
+// token bucket admits requests while positive and is charged afterwards.
+type RateLimiter struct {
+	rpm   int
+	tpm   int
+	keyBy string
+	next  http.Handler
+
+	mu        sync.Mutex
+	clients   map[string]*clientBuckets
+	lastPrune time.Time
+}
+
+type clientBuckets struct {
+	requests bucket
+	tokens   bucket
+	seen     time.Time
+}
+
+// bucket is a token bucket refilled continuously at capacity per minute.
+type bucket struct {
+	level float64
+	last  time.Time
+}
+
+func (b *bucket) refill(now time.Time, capacity int) {
+	if b.last.IsZero() {
+		b.level = float64(capacity)
+	} else {
+		b.level = math.Min(float64(capacity), b.level+now.Sub(b.last).Minutes()*float64(capacity))
+	}
+	b.last = now
+}
+
+// waitFor returns how long until the bucket holds at least need.
+func (b *bucket) waitFor(need float64, capacity int) time.Duration {
+	missing := need - b.level
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / float64(capacity) * float64(time.Minute))
+}
+
+func NewRateLimiter(cfg config.RateLimitConfig, next http.Handler) *RateLimiter {
+	return &RateLimiter{
+		rpm:     cfg.RequestsPerMinute,
+		tpm:     cfg.TokensPerMinute,
+		keyBy:   cfg.KeyBy,
+		next:    next,
+		clients: make(map[string]*clientBuckets),
+	}
+}
+
+func (rl *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, ex := withExchange(r.Context(), clientID(r, ""))
+	id := clientID(r, rl.keyBy)
+	if ex.KeyName != "" && rl.keyBy != "ip" {
+		id = ex.Client
+	}
+
+	if limit, wait := rl.admit(id); limit != "" {
+		secs := max(int(math.Ceil(wait.Seconds())), 1)
+		fmt.Printf("[%s] ✗ rate limited %s (%s), retry in %ds\n", time.Now().Format("15:04:05"), displayClient(id), limit, secs)
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeError(w, http.StatusTooManyRequests, limit, "rate_limit_exceeded",
+			fmt.Sprintf("Rate limit reached for %s per minute. Please try again in %ds.", limit, secs))
+		return
+	}
+
+	rl.next.ServeHTTP(w, r.WithContext(ctx))
+
+	if rl.tpm > 0 && ex.Usage.TotalTokens > 0 {
+		rl.mu.Lock()
+		if cb, ok := rl.clients[id]; ok {
+			cb.tokens.level -= float64(ex.Usage.TotalTokens)
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// admit takes one request from the client's buckets. On rejection it returns
+// which limit was hit ("requests" or "tokens") and how long to wait.
+func (rl *RateLimiter) admit(id string) (string, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.prune(now)
+
+	cb, ok := rl.clients[id]
+	if !ok {
+		cb = &clientBuckets{}
+		rl.clients[id] = cb
+	}
+	cb.seen = now
+
+	if rl.rpm > 0 {
+		cb.requests.refill(now, rl.rpm)
+		if cb.requests.level < 1 {
+			return "requests", cb.requests.waitFor(1, rl.rpm)
+		}
+	}
+	if rl.tpm > 0 {
+		cb.tokens.refill(now, rl.tpm)
+		if cb.tokens.level <= 0 {
+			return "tokens", cb.tokens.waitFor(1, rl.tpm)
+		}
+	}
+	if rl.rpm > 0 {
+		cb.requests.level--
+	}
+	return "", 0
+}
+
+// prune drops clients that haven't been seen for a while. Caller holds mu.
+func (rl *RateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) < time.Minute {
+		return
+	}
+	rl.lastPrune = now
+	for id, cb := range rl.clients {
+		if now.Sub(cb.seen) > idleBucketTTL {
+			delete(rl.clients, id)
+		}
+	}
+}
+
+// clientID identifies the caller: by the bearer key it sent (falling back to
+// source IP when it sent none), or always by source IP when keyBy is "ip".
+// Callers authenticated with a virtual key are identified by its name instead.
+func clientID(r *http.Request, keyBy string) string {
+	if keyBy != "ip" {
+		if key := bearerToken(r); key != "" {
+			return "key:" + key
+		}
+	}
+	if addr, ok := remoteAddr(r); ok {
+		return "ip:" + addr.String()
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// bearerToken returns the client's Authorization bearer token, or the key
+// a WebSocket client sent as a subprotocol, if any.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if isUpgrade(r) {
+		return realtimeKey(r)
+	}
+	return ""
+}
+
+// displayClient masks the key in a client ID for logging.
+func displayClient(id string) string {
+	if key, ok := strings.CutPrefix(id, "key:"); ok {
+		return "key:" + provider.MaskKey(key)
+	}
+	return id
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// realtimeKeyProtocol prefixes the API key browsers put in
+// Sec-WebSocket-Protocol, as they cannot set an Authorization header on a
+// WebSocket.
+const realtimeKeyProtocol = "openai-insecure-api-key."
+
+// isUpgrade reports whether r asks to switch to another protocol, such as
+// a WebSocket.
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// realtimeKey returns the API key a WebSocket client sent as a subprotocol.
+func realtimeKey(r *http.Request) string {
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for proto := range strings.SplitSeq(value, ",") {
+			if key, ok := strings.CutPrefix(strings.TrimSpace(proto), realtimeKeyProtocol); ok {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+// stripRealtimeKey removes the key subprotocol, so the client's key never
+// reaches the upstream.
+func stripRealtimeKey(h http.Header) {
+	var protos []string
+	for _, value := range h.Values("Sec-WebSocket-Protocol") {
+		for proto := range strings.SplitSeq(value, ",") {
+			if proto = strings.TrimSpace(proto); proto != "" && !strings.HasPrefix(proto, realtimeKeyProtocol) {
+				protos = append(protos, proto)
+			}
+		}
+	}
+	h.Del("Sec-WebSocket-Protocol")
+	if len(protos) > 0 {
+		h.Set("Sec-WebSocket-Protocol", strings.Join(protos, ", "))
+	}
+}
+
+// realtimeConn is the upstream side of a relayed WebSocket session. It
+// counts the bytes in each direction and logs the session when closed.
+type realtimeConn struct {
+	io.ReadWriteCloser
+	provider string
+	opened   time.Time
+	sent     atomic.Int64 // client → upstream
+	received atomic.Int64 // upstream → client
+	once     sync.Once
+}
+
+func newRealtimeConn(conn io.ReadWriteCloser, provider string) *realtimeConn {
+	fmt.Printf("  ⇄ websocket session with %s opened\n", provider)
+	return &realtimeConn{ReadWriteCloser: conn, provider: provider, opened: time.Now()}
+}
+
+func (c *realtimeConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *realtimeConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+func (c *realtimeConn) Close() error {
+	c.once.Do(func() {
+		fmt.Printf("  ⇄ websocket session with %s closed after %v (%d bytes sent, %d received)\n",
+			c.provider, time.Since(c.opened).Round(time.Millisecond), c.sent.Load(), c.received.Load())
+	})
+	return c.ReadWriteCloser.Close()
+}
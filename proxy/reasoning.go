@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"llm-local-proxy/config"
+)
+
+const (
+	defaultReasoningStoreEntries = 10000
+	defaultReasoningStoreTTL     = time.Hour
+)
+
+// ReasoningStore remembers the reasoning_content of upstream responses keyed
+// by a hash of the assistant message, so it can be reattached when clients
+// send the message back without it (e.g. during tool-call loops).
+type ReasoningStore struct {
+	maxEntries int
+	ttl        time.Duration
+	debug      bool
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List // most recently used first
+}
+
+type reasoningEntry struct {
+	key       string
+	reasoning string
+	expires   time.Time
+}
+
+// NewReasoningStore returns a store for cfg, or nil when it is disabled.
+func NewReasoningStore(cfg config.ReasoningStoreConfig, debug bool) *ReasoningStore {
+	if !cfg.Enabled {
+		return nil
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultReasoningStoreEntries
+	}
+	return &ReasoningStore{
+		maxEntries: maxEntries,
+		ttl:        cfg.TTL.Or(defaultReasoningStoreTTL),
+		debug:      debug,
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Inherit copies the unexpired entries of old, so a config reload does not
+// break tool-call loops in progress.
+func (s *ReasoningStore) Inherit(old *ReasoningStore) {
+	if s == nil || old == nil {
+		return
+	}
+	old.mu.Lock()
+	var entries []reasoningEntry
+	for e := old.lru.Back(); e != nil; e = e.Prev() {
+		entries = append(entries, *e.Value.(*reasoningEntry))
+	}
+	old.mu.Unlock()
+
+	now := time.Now()
+	for _, ent := range entries {
+		if now.Before(ent.expires) {
+			s.put(ent.key, ent.reasoning, ent.expires)
+		}
+	}
+}
+
+// Save records the reasoning behind an assistant message.
+func (s *ReasoningStore) Save(msg assistantMessage) {
+	if s == nil || strings.TrimSpace(msg.Reasoning) == "" {
+		return
+	}
+	s.put(msg.key(), msg.Reasoning, time.Now().Add(s.ttl))
+}
+
+func (s *ReasoningStore) put(key, reasoning string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		ent := e.Value.(*reasoningEntry)
+		ent.reasoning, ent.expires = reasoning, expires
+		s.lru.MoveToFront(e)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&reasoningEntry{key: key, reasoning: reasoning, expires: expires})
+	for s.lru.Len() > s.maxEntries {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*reasoningEntry).key)
+	}
+}
+
+func (s *ReasoningStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return "", false
+	}
+	ent := e.Value.(*reasoningEntry)
+	if time.Now().After(ent.expires) {
+		s.lru.Remove(e)
+		delete(s.entries, key)
+		return "", false
+	}
+	s.lru.MoveToFront(e)
+	return ent.reasoning, true
+}
+
+// Attach restores reasoning_content on the assistant messages of the current
+// turn (after the last user message) that were sent back without it.
+func (s *ReasoningStore) Attach(body []byte) []byte {
+	if s == nil {
+		return body
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	messages, _ := data["messages"].([]any)
+
+	changed := false
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg, _ := messages[i].(map[string]any)
+		if msg["role"] == "user" {
+			break
+		}
+		if msg["role"] != "assistant" {
+			continue
+		}
+		if rc, _ := msg["reasoning_content"].(string); rc != "" {
+			continue
+		}
+		if reasoning, ok := s.get(assistantMessageFrom(msg).key()); ok {
+			msg["reasoning_content"] = reasoning
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	if s.debug {
+		fmt.Println("  ↺ reattached stored reasoning_content")
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// assistantMessage is the part of an assistant message the store cares about.
+type assistantMessage struct {
+	Reasoning string     `json:"reasoning_content"`
+	Content   string     `json:"content"`
+	ToolCalls []toolCall `json:"tool_calls"`
+}
+
+type toolCall struct {
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+func assistantMessageFrom(msg map[string]any) assistantMessage {
+	var m assistantMessage
+	if b, err := json.Marshal(msg); err == nil {
+		json.Unmarshal(b, &m)
+	}
+	return m
+}
+
+// key hashes the content and tool calls of the message. Tool calls are
+// identified by ID when the upstream assigned one, since clients may
+// re-serialize the arguments.
+func (m assistantMessage) key() string {
+	h := sha256.New()
+	h.Write([]byte(strings.TrimSpace(m.Content)))
+	for _, tc := range m.ToolCalls {
+		if tc.ID != "" {
+			fmt.Fprintf(h, "\x00%s", tc.ID)
+		} else {
+			fmt.Fprintf(h, "\x00%s\x00%s", tc.Function.Name, tc.Function.Arguments)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseMessage returns the first choice's message of a non-streaming response.
+func responseMessage(body []byte) (assistantMessage, bool) {
+	var resp struct {
+		Choices []struct {
+			Message assistantMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(body, &resp) != nil || len(resp.Choices) == 0 {
+		return assistantMessage{}, false
+	}
+	return resp.Choices[0].Message, true
+}
+
+// messageCapture assembles the first choice's message from stream deltas.
+type messageCapture struct {
+	reasoning strings.Builder
+	content   strings.Builder
+	calls     []toolCall
+}
+
+func (c *messageCapture) add(choice map[string]any) {
+	delta, _ := choice["delta"].(map[string]any)
+	if s, ok := delta["reasoning_content"].(string); ok {
+		c.reasoning.WriteString(s)
+	}
+	if s, ok := delta["content"].(string); ok {
+		c.content.WriteString(s)
+	}
+	calls, _ := delta["tool_calls"].([]any)
+	for _, raw := range calls {
+		tc, _ := raw.(map[string]any)
+		idx, _ := tc["index"].(float64)
+		for int(idx) >= len(c.calls) {
+			c.calls = append(c.calls, toolCall{})
+		}
+		call := &c.calls[int(idx)]
+		if id, ok := tc["id"].(string); ok && id != "" {
+			call.ID = id
+		}
+		fn, _ := tc["function"].(map[string]any)
+		if name, ok := fn["name"].(string); ok {
+			call.Function.Name += name
+		}
+		if args, ok := fn["arguments"].(string); ok {
+			call.Function.Arguments += args
+		}
+	}
+}
+
+func (c *messageCapture) message() assistantMessage {
+	return assistantMessage{
+		Reasoning: c.reasoning.String(),
+		Content:   c.content.String(),
+		ToolCalls: c.calls,
+	}
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recording is one request/response pair as stored in a JSONL recording.
+// Streaming responses keep every chunk written to the client with its offset
+// from the start of the response, so replay can reproduce chunk boundaries.
+type Recording struct {
+	Time    time.Time       `json:"time"`
+	Method  string          `json:"method"`
+	Path    string          `json:"path"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Status  int             `json:"status"`
+	Header  http.Header     `json:"header,omitempty"`
+	Stream  bool            `json:"stream"`
+	Body    string          `json:"body,omitempty"`
+	Chunks  []RecordedChunk `json:"chunks,omitempty"`
+
+	// For HAR export; missing from older recordings
+	URL           string      `json:"url,omitempty"`            // as requested by the client
+	RequestHeader http.Header `json:"request_header,omitempty"` // credentials masked
+	DurationMS    int64       `json:"duration_ms,omitempty"`    // until the response was complete
+}
+
+// RecordedChunk is one write of a streamed response.
+type RecordedChunk struct {
+	OffsetMS int64  `json:"t_ms"`
+	Data     string `json:"data"`
+}
+
+// Recorder writes every exchange passing through it to a timestamped JSONL
+// file in dir, for building regression fixtures and offline analysis.
+type Recorder struct {
+	next http.Handler
+
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewRecorder creates dir if needed and opens a new recording file in it.
+func NewRecorder(dir string, next http.Handler) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create record dir: %w", err)
+	}
+	name := filepath.Join(dir, time.Now().Format("2006-01-02T15-04-05")+".jsonl")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open recording: %w", err)
+	}
+	return &Recorder{next: next, file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Path returns the file being recorded to.
+func (rec *Recorder) Path() string {
+	return rec.file.Name()
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := readAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	reqHeader := r.Header.Clone()
+	for name := range reqHeader {
+		if credentialHeaders[name] {
+			reqHeader[name] = []string{"[REDACTED]"}
+		}
+	}
+
+	cw := &captureWriter{ResponseWriter: w, start: time.Now()}
+	rec.next.ServeHTTP(cw, r)
+
+	entry := Recording{
+		Time:          cw.start,
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		Status:        cw.status,
+		Header:        w.Header().Clone(),
+		URL:           requestURL(r),
+		RequestHeader: reqHeader,
+		DurationMS:    time.Since(cw.start).Milliseconds(),
+	}
+	if json.Valid(body) {
+		entry.Request = body
+	}
+	if isEventStream(w.Header()) {
+		entry.Stream = true
+		entry.Chunks = cw.chunks
+	} else {
+		var b bytes.Buffer
+		for _, c := range cw.chunks {
+			b.WriteString(c.Data)
+		}
+		entry.Body = b.String()
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.enc.Encode(entry); err != nil {
+		fmt.Printf("  ✗ record: %v\n", err)
+	}
+}
+
+// Close flushes and closes the recording file.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.file.Close()
+}
+
+// captureWriter tees everything written to the client.
+type captureWriter struct {
+	http.ResponseWriter
+	start  time.Time
+	status int
+	chunks []RecordedChunk
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.chunks = append(cw.chunks, RecordedChunk{
+		OffsetMS: time.Since(cw.start).Milliseconds(),
+		Data:     string(p),
+	})
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *captureWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// requestURL reconstructs the absolute URL the client requested.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func isEventStream(h http.Header) bool {
+	return strings.Contains(h.Get("Content-Type"), "text/event-stream")
+}
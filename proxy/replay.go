@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Replayer serves responses from recordings made with Recorder instead of
+// contacting any upstream. Requests are matched to recordings by a hash of
+// method, path and canonicalized body, or simply in recorded order.
+type Replayer struct {
+	bySequence bool
+
+	mu     sync.Mutex
+	byHash map[string][]Recording // served in order; the last one repeats
+	seq    []Recording
+	next   int
+}
+
+// NewReplayer loads every *.jsonl file in dir (in name order).
+// match is "hash" (default) or "sequence".
+func NewReplayer(dir, match string) (*Replayer, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	rp := &Replayer{
+		bySequence: match == "sequence",
+		byHash:     make(map[string][]Recording),
+	}
+	for _, name := range files {
+		if err := rp.load(name); err != nil {
+			return nil, fmt.Errorf("load %s: %w", name, err)
+		}
+	}
+	if len(rp.seq) == 0 {
+		return nil, fmt.Errorf("no recordings found in %s", dir)
+	}
+	return rp, nil
+}
+
+func (rp *Replayer) load(name string) error {
+	recs, err := ReadRecordings(name)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		h := requestHash(rec.Method, rec.Path, rec.Request)
+		rp.byHash[h] = append(rp.byHash[h], rec)
+		rp.seq = append(rp.seq, rec)
+	}
+	return nil
+}
+
+// ReadRecordings reads the recordings of a JSONL file made by Recorder.
+func ReadRecordings(name string) ([]Recording, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []Recording
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rec Recording
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, sc.Err()
+}
+
+// Len returns the number of loaded recordings.
+func (rp *Replayer) Len() int {
+	return len(rp.seq)
+}
+
+func (rp *Replayer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fmt.Printf("[%s] %s %s (replay)\n", time.Now().Format("15:04:05"), r.Method, r.URL.Path)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
+		return
+	}
+
+	rec, ok := rp.match(r.Method, r.URL.Path, body)
+	if !ok {
+		fmt.Println("  ✗ no matching recording")
+		writeError(w, http.StatusNotFound, "invalid_request_error", "recording_not_found", "No recorded response matches this request.")
+		return
+	}
+
+	for k, vv := range rec.Header {
+		if k == "Content-Length" || k == "Date" {
+			continue
+		}
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(rec.Status)
+
+	if !rec.Stream {
+		io.WriteString(w, rec.Body)
+		return
+	}
+
+	// Re-stream with the original chunk boundaries and timing
+	flusher, _ := w.(http.Flusher)
+	start := time.Now()
+	for _, c := range rec.Chunks {
+		if wait := time.Duration(c.OffsetMS)*time.Millisecond - time.Since(start); wait > 0 {
+			if !sleepCtx(r.Context(), wait) {
+				return
+			}
+		}
+		io.WriteString(w, c.Data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func (rp *Replayer) match(method, path string, body []byte) (Recording, bool) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.bySequence {
+		if rp.next >= len(rp.seq) {
+			return Recording{}, false
+		}
+		rec := rp.seq[rp.next]
+		rp.next++
+		return rec, true
+	}
+
+	h := requestHash(method, path, body)
+	recs := rp.byHash[h]
+	if len(recs) == 0 {
+		return Recording{}, false
+	}
+	rec := recs[0]
+	if len(recs) > 1 {
+		rp.byHash[h] = recs[1:]
+	}
+	return rec, true
+}
+
+// requestHash identifies a request independent of JSON key order and spacing.
+func requestHash(method, path string, body []byte) string {
+	var v any
+	if json.Unmarshal(body, &v) == nil {
+		body, _ = json.Marshal(v)
+	}
+	sum := sha256.Sum256([]byte(method + " " + path + "\n" + string(body)))
+	return hex.EncodeToString(sum[:])
+}
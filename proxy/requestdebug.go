@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"llm-local-proxy/transform"
+)
+
+// maxDebugBody bounds each body printed by a request dump.
+const maxDebugBody = 1 << 20
+
+// wantsDebug reports whether the client asks for a dump of its request with
+// the X-Proxy-Debug header.
+func wantsDebug(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.Header.Get("X-Proxy-Debug"))
+	return v
+}
+
+// debugDump is what is logged about one request being debugged: the
+// client's request, every request sent upstream for it with the status and
+// headers of the response, and the response as relayed to the client.
+// Without a debug log each part is printed as it happens; with one, the
+// whole dump is written as one JSON document when the request is done.
+type debugDump struct {
+	ID       string         `json:"id"`
+	Time     time.Time      `json:"time"`
+	Request  debugMessage   `json:"request"`
+	Upstream []debugMessage `json:"upstream,omitempty"`
+	Response debugMessage   `json:"response"`
+
+	log *DebugLog  // nil = print to the console
+	mu  sync.Mutex // guards Upstream
+}
+
+// debugMessage is one request or response of a dump. Header values and
+// the body have secrets masked.
+type debugMessage struct {
+	Method string      `json:"method,omitempty"`
+	URL    string      `json:"url,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// credentialHeaders are masked in dumps whatever their value looks like.
+var credentialHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"X-Api-Key":           true,
+	"X-Goog-Api-Key":      true,
+	"Api-Key":             true,
+	"Cookie":              true,
+}
+
+func newDebugMessage(header http.Header, body []byte) debugMessage {
+	m := debugMessage{Header: make(http.Header, len(header))}
+	for name, values := range header {
+		for _, v := range values {
+			if credentialHeaders[http.CanonicalHeaderKey(name)] {
+				v = "[REDACTED]"
+			}
+			m.Header.Add(name, transform.Redact(v))
+		}
+	}
+	if len(body) > maxDebugBody {
+		m.Body = transform.Redact(string(body[:maxDebugBody])) + fmt.Sprintf("\n… truncated at %d bytes", maxDebugBody)
+	} else {
+		m.Body = transform.Redact(string(body))
+	}
+	return m
+}
+
+// startDebug dumps the client's request and returns the writer that dumps
+// the response once it is complete. Everything the request leads to is
+// logged under a new id, which the response carries in X-Proxy-Debug-Id so
+// the dump can be found in the log.
+func startDebug(w http.ResponseWriter, r *http.Request, log *DebugLog) (*debugWriter, *http.Request) {
+	b := make([]byte, 6)
+	rand.Read(b)
+	dump := &debugDump{ID: hex.EncodeToString(b), Time: time.Now(), log: log}
+
+	ctx, ex := withExchange(r.Context(), "")
+	ex.debug = dump
+	r = r.WithContext(ctx)
+	body, _ := readAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	dump.Request = newDebugMessage(r.Header, body)
+	dump.Request.Method, dump.Request.URL = r.Method, r.URL.RequestURI()
+	dump.print("client request "+r.Method+" "+r.URL.RequestURI(), dump.Request)
+	w.Header().Set("X-Proxy-Debug-Id", dump.ID)
+	return &debugWriter{ResponseWriter: w, dump: dump}, r
+}
+
+// print writes one part of the dump to the console, unless it goes to a
+// debug log.
+func (d *debugDump) print(what string, m debugMessage) {
+	if d.log != nil {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "  ◇ debug %s: %s\n", d.ID, what)
+	for _, name := range slices.Sorted(maps.Keys(m.Header)) {
+		for _, v := range m.Header[name] {
+			fmt.Fprintf(&b, "    %s: %s\n", name, v)
+		}
+	}
+	for line := range strings.Lines(m.Body) {
+		fmt.Fprintf(&b, "    %s", line)
+	}
+	if m.Body != "" && !strings.HasSuffix(m.Body, "\n") {
+		b.WriteByte('\n')
+	}
+	fmt.Print(b.String())
+}
+
+// debugUpstream dumps a request sent upstream for a request being debugged.
+func debugUpstream(ex *Exchange, req *http.Request, body []byte) {
+	d := ex.debug
+	if d == nil {
+		return
+	}
+	m := newDebugMessage(req.Header, body)
+	m.Method, m.URL = req.Method, req.URL.String()
+	d.print(fmt.Sprintf("upstream request %s %s", req.Method, req.URL), m)
+	d.mu.Lock()
+	d.Upstream = append(d.Upstream, m)
+	d.mu.Unlock()
+}
+
+// debugUpstreamResponse dumps the status and headers of an upstream
+// response; its body is dumped as relayed to the client.
+func debugUpstreamResponse(ex *Exchange, resp *http.Response) {
+	d := ex.debug
+	if d == nil {
+		return
+	}
+	m := newDebugMessage(resp.Header, nil)
+	m.Status = resp.StatusCode
+	d.print("upstream response "+resp.Status, m)
+	d.mu.Lock()
+	d.Upstream = append(d.Upstream, m)
+	d.mu.Unlock()
+}
+
+// debugWriter keeps a copy of the response to a request being debugged,
+// streamed or not, and dumps it when the request is done.
+type debugWriter struct {
+	http.ResponseWriter
+	dump   *debugDump
+	status int
+	header http.Header // as sent to the client
+	body   bytes.Buffer
+}
+
+func (dw *debugWriter) WriteHeader(status int) {
+	if dw.status == 0 {
+		dw.status = status
+		dw.header = dw.Header().Clone()
+	}
+	dw.ResponseWriter.WriteHeader(status)
+}
+
+func (dw *debugWriter) Write(p []byte) (int, error) {
+	if dw.status == 0 {
+		dw.WriteHeader(http.StatusOK)
+	}
+	if room := maxDebugBody + 1 - dw.body.Len(); room > 0 {
+		dw.body.Write(p[:min(len(p), room)])
+	}
+	return dw.ResponseWriter.Write(p)
+}
+
+func (dw *debugWriter) Flush() {
+	if f, ok := dw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (dw *debugWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
+
+// finish dumps the response, and writes the whole dump to the debug log.
+func (dw *debugWriter) finish() {
+	d := dw.dump
+	d.Response = newDebugMessage(dw.header, dw.body.Bytes())
+	d.Response.Status = cmp.Or(dw.status, http.StatusOK)
+	d.print(fmt.Sprintf("response %d", d.Response.Status), d.Response)
+	if d.log != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.log.write(d)
+	}
+}
+
+// DebugLog receives request dumps as JSON lines, one document per request,
+// instead of the console, where the dumps of concurrent streams would be
+// interleaved with each other and the normal log.
+type DebugLog struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// OpenDebugLog opens path for appending, creating it if needed.
+func OpenDebugLog(path string) (*DebugLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open debug log: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	return &DebugLog{file: f, enc: enc}, nil
+}
+
+// Path returns the file being written to.
+func (l *DebugLog) Path() string {
+	return l.file.Name()
+}
+
+func (l *DebugLog) write(d *debugDump) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(d); err != nil {
+		fmt.Printf("  ✗ debug log: %v\n", err)
+	}
+}
+
+// Close closes the file.
+func (l *DebugLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
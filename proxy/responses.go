@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"llm-local-proxy/transform"
+)
+
+func isResponsesPath(path string) bool {
+	return stripVersionPrefix(path) == "/responses"
+}
+
+// serveResponses accepts a Responses API request for upstreams that only
+// speak chat completions: the request is translated and runs through the
+// chat pipeline, and its response, JSON or SSE, is translated back.
+// Reasoning comes back as reasoning items, so the reasoning mode defaults
+// to native.
+func (h *Handler) serveResponses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method_not_allowed", "Use POST.")
+		return
+	}
+	body, err := readAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
+		return
+	}
+	r.Body.Close()
+	chat, err := transform.ResponsesToChat(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_request", err.Error())
+		return
+	}
+	fmt.Println("  ⇄ responses → chat/completions")
+
+	model, _ := transform.StringField(body, "model")
+	stream := transform.NewResponsesStream(model)
+	tw := &translateWriter{
+		ResponseWriter: w,
+		body:           transform.ChatToResponse,
+		event: func(data []byte) []byte {
+			if string(data) == "[DONE]" {
+				return stream.Done()
+			}
+			return stream.Chunk(data)
+		},
+	}
+	h.serveTranslated(tw, r, chat, reasoningNative)
+}
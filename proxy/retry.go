@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"llm-local-proxy/config"
+)
+
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+
+	// rateLimitCooldown is how long a 429'd endpoint leaves the rotation
+	// when the upstream doesn't send Retry-After.
+	rateLimitCooldown = 10 * time.Second
+
+	defaultMaxQueued = 100
+)
+
+// retryPolicy decides whether and when a failed upstream attempt is retried.
+type retryPolicy struct {
+	maxAttempts int
+	initial     time.Duration
+	max         time.Duration
+	jitter      float64
+}
+
+func newRetryPolicy(c config.RetryConfig) retryPolicy {
+	return retryPolicy{
+		maxAttempts: max(c.MaxAttempts, 1),
+		initial:     c.InitialBackoff.Or(defaultInitialBackoff),
+		max:         c.MaxBackoff.Or(defaultMaxBackoff),
+		jitter:      c.Jitter,
+	}
+}
+
+// backoff returns the delay before retry number n (1-based), doubling each
+// time up to the cap, with the configured fraction randomized.
+func (rp retryPolicy) backoff(n int) time.Duration {
+	d := rp.initial << (n - 1)
+	if d <= 0 || d > rp.max {
+		d = rp.max
+	}
+	if rp.jitter > 0 {
+		spread := float64(d) * rp.jitter
+		d = time.Duration(float64(d) - spread + rand.Float64()*2*spread)
+	}
+	return d
+}
+
+// retryable reports whether an upstream status is worth retrying.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// sendWithRetry performs the upstream call, retrying connection errors and
+// retryable statuses. Nothing has been written to the client at this point,
+// so retries are safe for both streaming and non-streaming requests.
+// With a queue, 429 responses are waited out per Retry-After without
+// consuming retry attempts, until the queue's max wait is used up.
+func sendWithRetry(ctx context.Context, rp retryPolicy, q *rateLimitQueue, do func() (*http.Response, error)) (*http.Response, error) {
+	var queued time.Duration
+	for attempt := 1; ; {
+		resp, err := do()
+
+		if ctx.Err() != nil || errors.Is(err, errCircuitOpen) {
+			return resp, err
+		}
+
+		if err == nil && resp.StatusCode == http.StatusTooManyRequests && q != nil {
+			// Retry-After: 0 or a past date would turn the queue into a
+			// busy loop that never uses up its max wait
+			wait := max(retryAfter(resp, 0), rp.backoff(1))
+			if queued+wait <= q.maxWait && q.enter() {
+				drain(resp)
+				fmt.Printf("  ⏳ rate limited, queued for %v\n", wait.Round(time.Millisecond))
+				ok := sleepCtx(ctx, wait)
+				q.leave()
+				if !ok {
+					return nil, ctx.Err()
+				}
+				queued += wait
+				continue
+			}
+		}
+
+		if attempt >= rp.maxAttempts {
+			return resp, err
+		}
+
+		delay := rp.backoff(attempt)
+		var reason string
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case retryable(resp.StatusCode):
+			reason = resp.Status
+			if resp.StatusCode == http.StatusTooManyRequests {
+				delay = max(delay, retryAfter(resp, 0))
+			}
+			drain(resp)
+		default:
+			return resp, nil
+		}
+
+		fmt.Printf("  ↻ retry %d/%d in %v: %s\n", attempt, rp.maxAttempts-1, delay.Round(time.Millisecond), reason)
+		if !sleepCtx(ctx, delay) {
+			return nil, ctx.Err()
+		}
+		attempt++
+	}
+}
+
+// rateLimitQueue bounds how many requests may wait out upstream 429s at once.
+type rateLimitQueue struct {
+	maxWait time.Duration
+	slots   chan struct{}
+}
+
+// newRateLimitQueue returns nil when queueing on 429 is disabled.
+func newRateLimitQueue(c config.RateLimitQueueConfig) *rateLimitQueue {
+	if c.MaxWait <= 0 {
+		return nil
+	}
+	size := c.MaxQueued
+	if size <= 0 {
+		size = defaultMaxQueued
+	}
+	return &rateLimitQueue{
+		maxWait: c.MaxWait.Std(),
+		slots:   make(chan struct{}, size),
+	}
+}
+
+// enter takes a queue slot, reporting false when the queue is full.
+func (q *rateLimitQueue) enter() bool {
+	select {
+	case q.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *rateLimitQueue) leave() {
+	<-q.slots
+}
+
+// drain discards and closes a response that won't be forwarded.
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// sleepCtx waits for d, returning false if ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryAfter parses the Retry-After header (seconds or HTTP date),
+// returning def when it is absent or invalid.
+func retryAfter(resp *http.Response, def time.Duration) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return def
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return def
+}
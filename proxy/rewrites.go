@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"llm-local-proxy/config"
+)
+
+// rewriteRule is a rewrites config entry with its path parsed.
+type rewriteRule struct {
+	config.RewriteRule
+	path []config.PathStep
+}
+
+func newRewriteRules(cfgs []config.RewriteRule) []rewriteRule {
+	rules := make([]rewriteRule, 0, len(cfgs))
+	for _, c := range cfgs {
+		steps, _ := config.ParseJSONPath(c.Path) // validated by config.Load
+		rules = append(rules, rewriteRule{c, steps})
+	}
+	return rules
+}
+
+// applyRewrites applies the configured rewrite rules matching rt and the
+// client's virtual key.
+func (h *Handler) applyRewrites(body []byte, rt route, keyName string) []byte {
+	var data any
+	changed := 0
+	for _, rw := range h.rewrites {
+		if !rw.Matches(rt.model, rt.provider.Name(), keyName) {
+			continue
+		}
+		if data == nil && json.Unmarshal(body, &data) != nil {
+			return body
+		}
+		var count int
+		data, count = rewrite(data, rw.path, rw.Action, rw.Value, rw.To)
+		changed += count
+	}
+	if changed == 0 {
+		return body
+	}
+	if h.registry.Debug() {
+		fmt.Printf("  ✎ rewrote %d value(s) of the request body\n", changed)
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// rewrite applies action at every location path selects under node and
+// returns the node, which differs from the one given when an array lost
+// elements, with how many locations changed. set creates missing objects
+// along key steps and decodes value anew for every location, so no two of
+// them, nor two requests, share it; locations that do not exist are
+// otherwise skipped.
+func rewrite(node any, path []config.PathStep, action string, value json.RawMessage, to string) (any, int) {
+	step, last := path[0], len(path) == 1
+	switch n := node.(type) {
+	case map[string]any:
+		if step.All {
+			count := 0
+			for k, child := range n {
+				var c int
+				if last {
+					c = rewriteKey(n, k, action, value, to)
+				} else {
+					n[k], c = rewrite(child, path[1:], action, value, to)
+				}
+				count += c
+			}
+			return n, count
+		}
+		if !step.IsKey {
+			return n, 0
+		}
+		if last {
+			return n, rewriteKey(n, step.Key, action, value, to)
+		}
+		child, ok := n[step.Key]
+		if !ok {
+			if action != "set" || !path[1].IsKey {
+				return n, 0
+			}
+			child = map[string]any{}
+		}
+		child, count := rewrite(child, path[1:], action, value, to)
+		if ok || count > 0 {
+			n[step.Key] = child
+		}
+		return n, count
+	case []any:
+		if step.IsKey {
+			return n, 0
+		}
+		var indexes []int
+		switch {
+		case step.All:
+			for i := range n {
+				indexes = append(indexes, i)
+			}
+		case step.Index < 0 && step.Index+len(n) >= 0:
+			indexes = []int{step.Index + len(n)}
+		case step.Index >= 0 && step.Index < len(n):
+			indexes = []int{step.Index}
+		}
+		count := 0
+		// From the end, so deleting an element leaves the others in place
+		for _, i := range slices.Backward(indexes) {
+			switch {
+			case !last:
+				var c int
+				n[i], c = rewrite(n[i], path[1:], action, value, to)
+				count += c
+			case action == "set":
+				n[i] = decodeValue(value)
+				count++
+			case action == "delete":
+				n = slices.Delete(n, i, i+1)
+				count++
+			}
+		}
+		return n, count
+	}
+	return node, 0
+}
+
+func rewriteKey(obj map[string]any, key, action string, value json.RawMessage, to string) int {
+	v, ok := obj[key]
+	switch action {
+	case "set":
+		obj[key] = decodeValue(value)
+		return 1
+	case "delete":
+		if ok {
+			delete(obj, key)
+			return 1
+		}
+	case "rename":
+		if ok && key != to {
+			delete(obj, key)
+			obj[to] = v
+			return 1
+		}
+	}
+	return 0
+}
+
+func decodeValue(raw json.RawMessage) any {
+	var v any
+	json.Unmarshal(raw, &v) // validated by config.Load
+	return v
+}
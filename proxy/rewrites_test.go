@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"llm-local-proxy/config"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		path   string
+		action string
+		value  string
+		to     string
+		want   string
+		count  int
+	}{
+		{"set key", `{"a":1}`, "$.a", "set", `2`, "", `{"a":2}`, 1},
+		{"set creates objects", `{}`, "$.a.b", "set", `true`, "", `{"a":{"b":true}}`, 1},
+		{"set skips missing array", `{}`, "$.a[0]", "set", `1`, "", `{}`, 0},
+		{"delete key", `{"a":1,"b":2}`, "$.a", "delete", "", "", `{"b":2}`, 1},
+		{"delete missing key", `{"a":1}`, "$.b", "delete", "", "", `{"a":1}`, 0},
+		{"rename key", `{"a":1}`, "$.a", "rename", "", "b", `{"b":1}`, 1},
+		{"rename to itself", `{"a":1}`, "$.a", "rename", "", "a", `{"a":1}`, 0},
+		{"first index", `{"l":[1,2,3]}`, "$.l[0]", "set", `0`, "", `{"l":[0,2,3]}`, 1},
+		{"last index", `{"l":[1,2,3]}`, "$.l[-1]", "set", `0`, "", `{"l":[1,2,0]}`, 1},
+		{"index past the end", `{"l":[1,2,3]}`, "$.l[3]", "set", `0`, "", `{"l":[1,2,3]}`, 0},
+		{"negative index past the start", `{"l":[1,2,3]}`, "$.l[-4]", "delete", "", "", `{"l":[1,2,3]}`, 0},
+		{"delete index", `{"l":[1,2,3]}`, "$.l[1]", "delete", "", "", `{"l":[1,3]}`, 1},
+		{"delete every element", `{"l":[1,2,3]}`, "$.l[*]", "delete", "", "", `{"l":[]}`, 3},
+		{"index on an object", `{"l":{"0":1}}`, "$.l[0]", "set", `2`, "", `{"l":{"0":1}}`, 0},
+		{"key on an array", `{"l":[1]}`, "$.l.x", "set", `2`, "", `{"l":[1]}`, 0},
+		{"every array element", `{"m":[{"n":1},{"n":2}]}`, "$.m[*].n", "delete", "", "", `{"m":[{},{}]}`, 2},
+		{"every object value", `{"o":{"a":{"x":1},"b":{"x":2}}}`, "$.o.*.x", "set", `0`, "", `{"o":{"a":{"x":0},"b":{"x":0}}}`, 2},
+		{"delete every object value", `{"o":{"a":1,"b":2}}`, "$.o.*", "delete", "", "", `{"o":{}}`, 2},
+		{"wildcard on empty array", `{"l":[]}`, "$.l[*]", "set", `1`, "", `{"l":[]}`, 0},
+		{"wildcard on a scalar", `{"l":1}`, "$.l[*]", "set", `1`, "", `{"l":1}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data any
+			if err := json.Unmarshal([]byte(tt.body), &data); err != nil {
+				t.Fatal(err)
+			}
+			path, err := config.ParseJSONPath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, count := rewrite(data, path, tt.action, json.RawMessage(tt.value), tt.to)
+			got, _ := json.Marshal(data)
+			if string(got) != tt.want || count != tt.count {
+				t.Errorf("got %s (%d changed), want %s (%d changed)", got, count, tt.want, tt.count)
+			}
+		})
+	}
+}
+
+func TestRewriteSetCopiesValue(t *testing.T) {
+	var data any
+	json.Unmarshal([]byte(`{"m":[{},{}]}`), &data)
+	path, _ := config.ParseJSONPath("$.m[*].meta")
+	data, _ = rewrite(data, path, "set", json.RawMessage(`{"a":[1]}`), "")
+
+	// Changing the value at one location must leave the other alone
+	first := data.(map[string]any)["m"].([]any)[0].(map[string]any)["meta"].(map[string]any)
+	first["a"].([]any)[0] = 2
+	got, _ := json.Marshal(data)
+	if want := `{"m":[{"meta":{"a":[2]}},{"meta":{"a":[1]}}]}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
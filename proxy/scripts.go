@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"llm-local-proxy/provider"
+)
+
+// headerEdits are the header changes a request script made.
+type headerEdits struct {
+	set map[string]string
+	del []string
+}
+
+func (e headerEdits) apply(header http.Header) {
+	for _, k := range e.del {
+		header.Del(k)
+	}
+	for k, v := range e.set {
+		header.Set(k, v)
+	}
+}
+
+// runRequestScript runs p's request script on a body about to be posted to
+// path. The script sees the client's headers and may change them. A
+// failing script is logged and the request goes out as it was.
+func (h *Handler) runRequestScript(r *http.Request, p provider.Provider, path string, body []byte) ([]byte, headerEdits) {
+	s := h.registry.Scripts(p.Name()).Request
+	if s == nil {
+		return body, headerEdits{}
+	}
+	before := make(map[string]any, len(r.Header))
+	headers := make(map[string]any, len(r.Header))
+	for k := range r.Header {
+		before[k] = r.Header.Get(k)
+		headers[k] = before[k]
+	}
+	out, err := s.RunJSON("body", body, map[string]any{
+		"headers":  headers,
+		"provider": p.Name(),
+		"path":     path,
+	})
+	if err != nil {
+		fmt.Printf("  ✗ request script of %s failed, request forwarded as it was: %v\n", p.Name(), err)
+		return body, headerEdits{}
+	}
+	edits := headerEdits{set: make(map[string]string)}
+	for k, v := range headers {
+		if v != before[k] {
+			edits.set[k] = fmt.Sprint(v)
+		}
+	}
+	for k := range before {
+		if _, ok := headers[k]; !ok {
+			edits.del = append(edits.del, k)
+		}
+	}
+	return out, edits
+}
+
+// runResponseScript runs p's response script on a complete chat response
+// with status 200, replacing its body. A failing script is logged and the
+// response is passed on as it was.
+func (h *Handler) runResponseScript(resp *http.Response, p provider.Provider) {
+	s := h.registry.Scripts(p.Name()).Response
+	if s == nil {
+		return
+	}
+	body, err := readAll(resp.Body)
+	resp.Body.Close()
+	if err == nil {
+		var out []byte
+		if out, err = s.RunJSON("body", body, map[string]any{"provider": p.Name(), "stream": false}); err == nil {
+			body = out
+		}
+	}
+	if err != nil {
+		fmt.Printf("  ✗ response script of %s failed, response passed on as it was: %v\n", p.Name(), err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// scriptChunk runs p's response script on the data of one stream chunk.
+// The data is returned as it was when p has no script or the script fails.
+func (h *Handler) scriptChunk(p provider.Provider, data []byte) []byte {
+	s := h.registry.Scripts(p.Name()).Response
+	if s == nil {
+		return data
+	}
+	out, err := s.RunJSON("body", data, map[string]any{"provider": p.Name(), "stream": true})
+	if err != nil {
+		fmt.Printf("  ✗ response script of %s failed on a chunk, passed on as it was: %v\n", p.Name(), err)
+		return data
+	}
+	return bytes.TrimSpace(out)
+}
@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRecentErrors is how many failed requests Stats remembers.
+const maxRecentErrors = 20
+
+// Stats counts proxied requests, errors and tokens since startup, in total
+// and per model, provider and virtual key. It outlives config reloads.
+type Stats struct {
+	started time.Time
+
+	mu         sync.Mutex
+	total      Counter
+	inFlight   int
+	byModel    map[string]*Counter
+	byProvider map[string]*Counter
+	byKey      map[string]*Counter
+	byVariant  map[string]*VariantCounter
+	active     map[uint64]*activeRequest
+	nextID     uint64
+	errors     []RecentError // oldest first
+	rateLimits map[string]UpstreamRateLimit
+}
+
+// activeRequest is a request still being served.
+type activeRequest struct {
+	id      uint64
+	model   string
+	client  string
+	path    string
+	stream  bool
+	started time.Time
+	sw      *statusWriter
+}
+
+// ActiveRequest describes an in-flight request in a snapshot.
+type ActiveRequest struct {
+	Model    string `json:"model,omitempty"`
+	Client   string `json:"client"`
+	Path     string `json:"path"`
+	Stream   bool   `json:"stream"`
+	Elapsed  string `json:"elapsed"`
+	BytesOut int64  `json:"bytes_out"`
+}
+
+// RecentError is a request that ended with an error status.
+type RecentError struct {
+	Time   time.Time `json:"time"`
+	Model  string    `json:"model,omitempty"`
+	Client string    `json:"client"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+
+	UpstreamRequestID string `json:"upstream_request_id,omitempty"`
+}
+
+// Counter aggregates a set of requests.
+type Counter struct {
+	Requests         int64 `json:"requests"`
+	Errors           int64 `json:"errors"` // responses with status >= 400
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	CacheHitTokens   int64 `json:"cache_hit_tokens"`  // prompt tokens the upstream read from its prompt cache
+	CacheMissTokens  int64 `json:"cache_miss_tokens"` // prompt tokens of cache-reporting upstreams that were not cached
+}
+
+// CacheHitRate returns the share of the prompt tokens reported on by the
+// upstreams' prompt caches that were read from them, or 0 before any were.
+func (c Counter) CacheHitRate() float64 {
+	if c.CacheHitTokens+c.CacheMissTokens == 0 {
+		return 0
+	}
+	return float64(c.CacheHitTokens) / float64(c.CacheHitTokens+c.CacheMissTokens)
+}
+
+func (c *Counter) add(ex *Exchange, status int) {
+	c.Requests++
+	if status >= 400 {
+		c.Errors++
+	}
+	c.PromptTokens += int64(ex.Usage.PromptTokens)
+	c.CompletionTokens += int64(ex.Usage.CompletionTokens)
+	c.CacheHitTokens += int64(ex.Usage.CacheHitTokens)
+	c.CacheMissTokens += int64(ex.Usage.CacheMissTokens)
+}
+
+// VariantCounter aggregates the requests of one experiment variant, with
+// the latencies to compare it with the other variants by.
+type VariantCounter struct {
+	Counter
+	AvgLatencyMS   int64 `json:"avg_latency_ms"`    // until the response was complete
+	AvgFirstByteMS int64 `json:"avg_first_byte_ms"` // until the first byte of the response
+
+	succeeded int64         // requests the averages are over; errors are left out
+	latency   time.Duration // sums of the successful requests
+	firstByte time.Duration
+}
+
+func (c *VariantCounter) add(ex *Exchange, status int, latency, firstByte time.Duration) {
+	c.Counter.add(ex, status)
+	if status >= 400 {
+		return
+	}
+	c.succeeded++
+	c.latency += latency
+	c.firstByte += firstByte
+	c.AvgLatencyMS = (c.latency / time.Duration(c.succeeded)).Milliseconds()
+	c.AvgFirstByteMS = (c.firstByte / time.Duration(c.succeeded)).Milliseconds()
+}
+
+// StatsSnapshot is a point-in-time copy of Stats.
+type StatsSnapshot struct {
+	Uptime     string                    `json:"uptime"`
+	InFlight   int                       `json:"in_flight"`
+	Total      Counter                   `json:"total"`
+	ByModel    map[string]Counter        `json:"by_model"`
+	ByProvider map[string]Counter        `json:"by_provider"`
+	ByKey      map[string]Counter        `json:"by_key,omitempty"`
+	ByVariant  map[string]VariantCounter `json:"by_variant,omitempty"` // "experiment/variant" → counter
+	Active     []ActiveRequest           `json:"active"`
+	Errors     []RecentError             `json:"recent_errors"`
+
+	// The rate limit state each provider last reported
+	RateLimits map[string]UpstreamRateLimit `json:"upstream_rate_limits"`
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		started:    time.Now(),
+		byModel:    make(map[string]*Counter),
+		byProvider: make(map[string]*Counter),
+		byKey:      make(map[string]*Counter),
+		byVariant:  make(map[string]*VariantCounter),
+		active:     make(map[uint64]*activeRequest),
+		rateLimits: make(map[string]UpstreamRateLimit),
+	}
+}
+
+// Wrap counts every request served by next.
+func (s *Stats) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, ex := withExchange(r.Context(), clientID(r, ""))
+		sw := &statusWriter{ResponseWriter: w}
+		ar := &activeRequest{
+			client:  displayClient(ex.Client),
+			path:    r.URL.Path,
+			started: time.Now(),
+			sw:      sw,
+		}
+		ar.model, ar.stream = peekModel(r)
+
+		s.mu.Lock()
+		s.nextID++
+		ar.id = s.nextID
+		s.active[ar.id] = ar
+		s.inFlight++
+		s.mu.Unlock()
+
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		latency, firstByte := time.Since(ar.started), sw.firstByte.Sub(ar.started)
+		if sw.firstByte.IsZero() {
+			firstByte = latency
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.active, ar.id)
+		s.inFlight--
+		s.total.add(ex, status)
+		if ex.Model != "" {
+			counterFor(s.byModel, ex.Model).add(ex, status)
+		}
+		if ex.Provider != "" {
+			counterFor(s.byProvider, ex.Provider).add(ex, status)
+		}
+		if ex.KeyName != "" {
+			counterFor(s.byKey, ex.KeyName).add(ex, status)
+		}
+		if ex.Variant != "" {
+			if s.byVariant[ex.Variant] == nil {
+				s.byVariant[ex.Variant] = &VariantCounter{}
+			}
+			s.byVariant[ex.Variant].add(ex, status, latency, firstByte)
+		}
+		maps.Copy(s.rateLimits, ex.upstreamLimits)
+		if status >= 400 {
+			model := ex.Model
+			if model == "" {
+				model = ar.model
+			}
+			s.errors = append(s.errors, RecentError{Time: time.Now(), Model: model, Client: ar.client, Path: ar.path, Status: status,
+				UpstreamRequestID: ex.UpstreamRequestID})
+			if len(s.errors) > maxRecentErrors {
+				s.errors = s.errors[len(s.errors)-maxRecentErrors:]
+			}
+		}
+	})
+}
+
+// peekModel reads model and stream from a JSON request body, leaving the
+// body intact for the next handler. Relayed endpoints are left unread so
+// their bodies keep streaming.
+func peekModel(r *http.Request) (string, bool) {
+	if r.Body == nil || r.Method != http.MethodPost || isPassthroughPath(r.URL.Path) {
+		return "", false
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+	var req struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Model, req.Stream
+}
+
+func counterFor(m map[string]*Counter, name string) *Counter {
+	c, ok := m[name]
+	if !ok {
+		c = &Counter{}
+		m[name] = c
+	}
+	return c
+}
+
+// Snapshot returns a copy of the current counters.
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return StatsSnapshot{
+		Uptime:     time.Since(s.started).Round(time.Second).String(),
+		InFlight:   s.inFlight,
+		Total:      s.total,
+		ByModel:    copyCounters(s.byModel),
+		ByProvider: copyCounters(s.byProvider),
+		ByKey:      copyCounters(s.byKey),
+		ByVariant:  copyVariantCounters(s.byVariant),
+		Active:     s.activeList(),
+		Errors:     append([]RecentError{}, s.errors...),
+		RateLimits: maps.Clone(s.rateLimits),
+	}
+}
+
+// activeList returns in-flight requests, oldest first. Caller holds mu.
+func (s *Stats) activeList() []ActiveRequest {
+	reqs := slices.SortedFunc(maps.Values(s.active), func(a, b *activeRequest) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	list := make([]ActiveRequest, 0, len(reqs))
+	for _, ar := range reqs {
+		list = append(list, ActiveRequest{
+			Model:    ar.model,
+			Client:   ar.client,
+			Path:     ar.path,
+			Stream:   ar.stream,
+			Elapsed:  time.Since(ar.started).Round(100 * time.Millisecond).String(),
+			BytesOut: ar.sw.written.Load(),
+		})
+	}
+	return list
+}
+
+func copyCounters(m map[string]*Counter) map[string]Counter {
+	out := make(map[string]Counter, len(m))
+	for k, c := range m {
+		out[k] = *c
+	}
+	return out
+}
+
+func copyVariantCounters(m map[string]*VariantCounter) map[string]VariantCounter {
+	out := make(map[string]VariantCounter, len(m))
+	for k, c := range m {
+		out[k] = *c
+	}
+	return out
+}
+
+// statusWriter remembers the status code sent to the client and when the
+// first byte was, and counts the bytes written so far.
+type statusWriter struct {
+	http.ResponseWriter
+	status    int
+	firstByte time.Time
+	written   atomic.Int64
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.firstByte.IsZero() {
+		sw.firstByte = time.Now()
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.written.Add(int64(n))
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
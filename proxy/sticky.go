@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// conversationHash identifies the conversation of a request. Conversations
+// are told apart by X-Conversation-Id when the client sends one, and
+// otherwise by the client and the messages up to the first user message,
+// which every later turn of the conversation repeats.
+func conversationHash(r *http.Request, ex *Exchange, messages []json.RawMessage) []byte {
+	hash := sha256.New()
+	if id := r.Header.Get("X-Conversation-Id"); id != "" {
+		hash.Write([]byte(id))
+	} else {
+		fmt.Fprintf(hash, "%s\x00%s\x00", ex.KeyName, ex.Client)
+		// Re-encoded, so key order and spacing do not matter
+		for _, m := range messages {
+			var msg map[string]any
+			json.Unmarshal(m, &msg)
+			b, _ := json.Marshal(msg)
+			hash.Write(b)
+			if msg["role"] == "user" {
+				break
+			}
+		}
+	}
+	return hash.Sum(nil)
+}
+
+// stickConversation records the conversation of a chat request, so that
+// sticky providers send all its turns to the same endpoint, where the
+// upstream's prompt cache already holds their common prefix.
+func (h *Handler) stickConversation(r *http.Request, body []byte, ex *Exchange) {
+	if !h.sticky {
+		return
+	}
+	var req struct {
+		Messages []json.RawMessage `json:"messages"`
+	}
+	json.Unmarshal(body, &req)
+	ex.conversation = conversationHash(r, ex, req.Messages)
+}
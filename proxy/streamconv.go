@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"llm-local-proxy/provider"
+	"llm-local-proxy/transform"
+)
+
+// convertStream replaces the body of an upstream response whose streaming
+// was forced by the provider's upstream_stream setting with the form the
+// client asked for: a chat.completion is replayed as a stream at the
+// provider's pace, and a stream is assembled into one chat.completion.
+func (h *Handler) convertStream(ctx context.Context, resp *http.Response, p provider.Provider, stream, includeUsage bool) {
+	if stream {
+		body, _ := readAll(resp.Body)
+		resp.Body.Close()
+		chunkSize, delay := p.SyntheticStream()
+		resp.Body = &pacedReader{ctx: ctx, events: transform.StreamResponse(body, includeUsage, chunkSize), delay: delay}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		resp.Header.Set("Content-Type", "text/event-stream")
+		fmt.Println("  ⇄ upstream response replayed as a stream")
+		return
+	}
+
+	// The stream is read here in full, so it gets its own idle watchdog
+	idle := newIdleReader(resp.Body, h.streamIdle, func() { resp.Body.Close() })
+	converted, err := transform.AssembleStream(idle)
+	idle.Stop()
+	if err != nil {
+		fmt.Printf("  ✗ upstream stream broke off: %v\n", err)
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(converted))
+	resp.ContentLength = int64(len(converted))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(converted)))
+	resp.Header.Set("Content-Type", "application/json")
+	fmt.Println("  ⇄ upstream stream assembled into one response")
+}
+
+// pacedReader serves SSE events one at a time, pausing between them.
+type pacedReader struct {
+	ctx    context.Context
+	events [][]byte
+	delay  time.Duration
+	cur    []byte // rest of the event being read
+	served bool
+}
+
+func (pr *pacedReader) Read(p []byte) (int, error) {
+	if len(pr.cur) == 0 {
+		if len(pr.events) == 0 {
+			return 0, io.EOF
+		}
+		if pr.served && pr.delay > 0 && !sleepCtx(pr.ctx, pr.delay) {
+			return 0, pr.ctx.Err()
+		}
+		pr.cur, pr.events = pr.events[0], pr.events[1:]
+		pr.served = true
+	}
+	n := copy(p, pr.cur)
+	pr.cur = pr.cur[n:]
+	return n, nil
+}
+
+func (pr *pacedReader) Close() error { return nil }
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"llm-local-proxy/transform"
+)
+
+// streamUsage makes sure streaming clients receive a final usage chunk.
+// Upstreams are asked for one with stream_options.include_usage; when the
+// upstream still sends none, it is estimated from the streamed text.
+// A nil *streamUsage does nothing.
+type streamUsage struct {
+	seen bool
+	meta map[string]any // id, created, model of the stream
+	text strings.Builder
+}
+
+// observe records an upstream chunk before it is transformed.
+func (su *streamUsage) observe(data map[string]any) {
+	if su == nil {
+		return
+	}
+	if data["usage"] != nil {
+		su.seen = true
+	}
+	if su.meta == nil {
+		su.meta = make(map[string]any)
+		for _, key := range []string{"id", "created", "model"} {
+			if v, ok := data[key]; ok {
+				su.meta[key] = v
+			}
+		}
+	}
+	choices, _ := data["choices"].([]any)
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		delta, _ := choice["delta"].(map[string]any)
+		for _, key := range []string{"reasoning_content", "content"} {
+			if s, ok := delta[key].(string); ok {
+				su.text.WriteString(s)
+			}
+		}
+		calls, _ := delta["tool_calls"].([]any)
+		for _, raw := range calls {
+			tc, _ := raw.(map[string]any)
+			fn, _ := tc["function"].(map[string]any)
+			for _, key := range []string{"name", "arguments"} {
+				if s, ok := fn[key].(string); ok {
+					su.text.WriteString(s)
+				}
+			}
+		}
+	}
+}
+
+// finish writes an estimated usage chunk if the upstream sent none.
+func (su *streamUsage) finish(w io.Writer, ex *Exchange, alias string) {
+	if su == nil || su.seen {
+		return
+	}
+	u := transform.Usage{
+		PromptTokens:     transform.EstimateTokens(ex.requestBytes),
+		CompletionTokens: transform.TokenizerFor(ex.Model).Count(su.text.String()),
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	ex.Usage, ex.UsageEstimated = u, true
+
+	chunk := map[string]any{"object": "chat.completion.chunk", "choices": []any{}, "usage": u}
+	for k, v := range su.meta {
+		chunk[k] = v
+	}
+	if alias != "" {
+		chunk["model"] = alias
+	}
+	data, _ := json.Marshal(chunk)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	fmt.Printf("  ∑ upstream sent no usage, estimated %d tokens\n", u.TotalTokens)
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/transform"
+)
+
+const (
+	defaultSummaryKeepRecent = 6
+	defaultSummaryPrompt     = "Summarize the conversation below for the assistant that will continue it. " +
+		"Keep every decision, fact, file name, identifier and open task; drop pleasantries. Reply with the summary only."
+	summaryHeader = "Summary of the earlier conversation:\n"
+
+	// maxSummaries bounds the summary cache; it is cleared when full.
+	maxSummaries = 1000
+)
+
+// summarizer replaces the older turns of long conversations with a summary
+// produced by a cheap model. Summaries are cached by a running hash of the
+// messages they cover, so each turn of a growing session only summarizes
+// the messages added since the previous summary.
+type summarizer struct {
+	cfg config.SummarizationConfig
+
+	mu    sync.Mutex
+	cache map[[32]byte]string
+}
+
+func newSummarizer(cfg config.SummarizationConfig) *summarizer {
+	if cfg.Model == "" {
+		return nil
+	}
+	return &summarizer{cfg: cfg, cache: make(map[[32]byte]string)}
+}
+
+// summarize rewrites body when its estimated token count exceeds the
+// threshold. On failure the body is returned unchanged.
+func (s *summarizer) summarize(ctx context.Context, h *Handler, r *http.Request, body []byte) []byte {
+	if s == nil || transform.EstimateTokens(len(body)) <= s.cfg.Threshold {
+		return body
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	messages, _ := data["messages"].([]any)
+
+	start, end := summaryRange(messages, cmp.Or(s.cfg.KeepRecent, defaultSummaryKeepRecent))
+	if end-start < 2 {
+		return body
+	}
+	old := messages[start:end]
+
+	// Running hashes: prefix[k] covers old[:k+1]
+	prefix := make([][32]byte, len(old))
+	var prev [32]byte
+	for i, m := range old {
+		b, _ := json.Marshal(m)
+		prev = sha256.Sum256(append(prev[:], b...))
+		prefix[i] = prev
+	}
+
+	summary, covered := s.lookup(prefix)
+	if covered < len(old) {
+		var err error
+		summary, err = s.request(ctx, h, r, summary, old[covered:])
+		if err != nil {
+			transform.Logf("  ✗ summarization failed, forwarding full history: %v\n", err)
+			return body
+		}
+		s.store(prefix[len(old)-1], summary)
+	}
+	fmt.Printf("  ✎ summarized %d messages (%d new)\n", len(old), len(old)-covered)
+
+	replaced := append([]any{}, messages[:start]...)
+	replaced = append(replaced, map[string]any{"role": "system", "content": summaryHeader + summary})
+	data["messages"] = append(replaced, messages[end:]...)
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// summaryRange returns the messages to summarize: everything after the
+// leading system messages except the last keep, moving the boundary back so
+// tool results stay with the assistant message that requested them.
+func summaryRange(messages []any, keep int) (start, end int) {
+	for start < len(messages) {
+		msg, _ := messages[start].(map[string]any)
+		if msg["role"] != "system" && msg["role"] != "developer" {
+			break
+		}
+		start++
+	}
+	end = len(messages) - keep
+	for end > start {
+		msg, _ := messages[end].(map[string]any)
+		if msg["role"] != "tool" {
+			break
+		}
+		end--
+	}
+	return start, max(end, start)
+}
+
+// lookup returns the cached summary covering the longest prefix of the
+// messages and how many messages it covers.
+func (s *summarizer) lookup(prefix [][32]byte) (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := len(prefix) - 1; k >= 0; k-- {
+		if summary, ok := s.cache[prefix[k]]; ok {
+			return summary, k + 1
+		}
+	}
+	return "", 0
+}
+
+func (s *summarizer) store(key [32]byte, summary string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cache) >= maxSummaries {
+		clear(s.cache)
+	}
+	s.cache[key] = summary
+}
+
+// request asks the summary model to fold messages into the previous summary.
+func (s *summarizer) request(ctx context.Context, h *Handler, r *http.Request, previous string, messages []any) (string, error) {
+	p := h.registry.Resolve(s.cfg.Model)
+	if p == nil {
+		return "", fmt.Errorf("no provider matched summary model %q", s.cfg.Model)
+	}
+
+	var transcript strings.Builder
+	if previous != "" {
+		fmt.Fprintf(&transcript, "[previous summary]\n%s\n\n", previous)
+	}
+	for _, m := range messages {
+		writeTranscript(&transcript, m)
+	}
+	prompt := s.cfg.Prompt
+	if prompt == "" {
+		prompt = defaultSummaryPrompt
+	}
+	body, _ := json.Marshal(map[string]any{
+		"model": s.cfg.Model,
+		"messages": []any{
+			map[string]any{"role": "system", "content": prompt},
+			map[string]any{"role": "user", "content": transcript.String()},
+		},
+	})
+
+	resp, err := h.send(ctx, r, p, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	msg, ok := responseMessage(respBody)
+	if !ok || strings.TrimSpace(msg.Content) == "" {
+		return "", errors.New("empty summary")
+	}
+	return strings.TrimSpace(msg.Content), nil
+}
+
+// writeTranscript renders one message as plain text for the summary model.
+func writeTranscript(b *strings.Builder, m any) {
+	msg, _ := m.(map[string]any)
+	role, _ := msg["role"].(string)
+	switch content := msg["content"].(type) {
+	case string:
+		if content != "" {
+			fmt.Fprintf(b, "%s: %s\n", role, content)
+		}
+	case []any:
+		for _, part := range content {
+			if p, _ := part.(map[string]any); p["type"] == "text" {
+				fmt.Fprintf(b, "%s: %v\n", role, p["text"])
+			}
+		}
+	}
+	for _, tc := range assistantMessageFrom(msg).ToolCalls {
+		fmt.Fprintf(b, "%s called %s(%s)\n", role, tc.Function.Name, tc.Function.Arguments)
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// idleReader cancels the upstream request when no bytes arrive for the
+// configured period, so a stalled stream doesn't hang the client forever
+// while a healthy long stream is never cut off.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+// newIdleReader wraps r; cancel is called once the stream goes idle.
+// A zero timeout disables the watchdog.
+func newIdleReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout}
+	if timeout > 0 {
+		ir.timer = time.AfterFunc(timeout, func() {
+			ir.fired.Store(true)
+			cancel()
+		})
+	}
+	return ir
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 && ir.timer != nil {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+// Stop disarms the watchdog.
+func (ir *idleReader) Stop() {
+	if ir.timer != nil {
+		ir.timer.Stop()
+	}
+}
+
+// TimedOut reports whether the stream was aborted for being idle.
+func (ir *idleReader) TimedOut() bool {
+	return ir.fired.Load()
+}
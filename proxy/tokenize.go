@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"llm-local-proxy/transform"
+)
+
+// isTokenizePath reports whether path is served by serveTokenize.
+func isTokenizePath(path string) bool {
+	return path == "/v1/tokenize" || path == "/utils/count_tokens"
+}
+
+// serveTokenize estimates the prompt tokens of a chat request without
+// sending it upstream, so clients can budget before sending.
+func serveTokenize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method_not_allowed", "Use POST.")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request.")
+		return
+	}
+	var req struct {
+		Model    string `json:"model"`
+		Messages []any  `json:"messages"`
+		Tools    []any  `json:"tools"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_json", "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	tok := transform.TokenizerFor(req.Model)
+	total, per := tok.CountMessages(req.Messages, req.Tools)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"model":         req.Model,
+		"prompt_tokens": total,
+		"messages":      per,
+		"tokenizer":     tok.Family,
+		"estimated":     true,
+	})
+}
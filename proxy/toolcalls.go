@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"llm-local-proxy/transform"
+)
+
+// releaseToolArgs puts the held tool call arguments, repaired, into the
+// delta of the finishing choice. It returns the calls that stayed invalid.
+func releaseToolArgs(choice map[string]any, buf *transform.ToolArgsBuffer, debug bool) []transform.ToolCallError {
+	calls, failed := buf.Flush(debug)
+	delta, _ := choice["delta"].(map[string]any)
+	if delta == nil {
+		delta = make(map[string]any)
+		choice["delta"] = delta
+	}
+	existing, _ := delta["tool_calls"].([]any)
+	delta["tool_calls"] = append(existing, calls...)
+	return failed
+}
+
+// flushToolArgs sends arguments still held when a stream ends without a
+// finish_reason, e.g. because the upstream cut it short.
+func (h *Handler) flushToolArgs(w io.Writer, buf *transform.ToolArgsBuffer, legacy bool) {
+	if !buf.Pending() {
+		return
+	}
+	choice := map[string]any{"index": 0}
+	failed := releaseToolArgs(choice, buf, h.registry.Debug())
+	if legacy {
+		transform.ToolCallDeltaToFunctionCall(choice)
+	}
+	chunk, _ := json.Marshal(map[string]any{"choices": []any{choice}})
+	fmt.Fprintf(w, "data: %s\n\n", chunk)
+	writeToolCallErrors(w, failed)
+}
+
+// writeToolCallErrors flags unrepairable tool calls with an SSE error event,
+// so agent frameworks fail loudly instead of on a silent parse error.
+func writeToolCallErrors(w io.Writer, failed []transform.ToolCallError) {
+	for _, tc := range failed {
+		transform.Logf("  ✗ %v: %s\n", tc, tc.Arguments)
+		event, _ := json.Marshal(toolCallError(tc))
+		fmt.Fprintf(w, "data: %s\n\n", event)
+	}
+}
+
+// toolCallError is the OpenAI-format error body for an unrepairable call.
+func toolCallError(tc transform.ToolCallError) map[string]any {
+	return map[string]any{
+		"error": map[string]any{
+			"message":   tc.Error() + ": " + tc.Arguments,
+			"type":      "server_error",
+			"code":      "invalid_tool_call",
+			"param":     nil,
+			"tool_call": tc,
+		},
+	}
+}
+
+// writeToolCallFailure replaces a non-streaming response whose tool calls
+// could not be repaired with a 502 error.
+func writeToolCallFailure(w http.ResponseWriter, failed []transform.ToolCallError) {
+	for _, tc := range failed {
+		transform.Logf("  ✗ %v: %s\n", tc, tc.Arguments)
+	}
+	w.Header().Del("Content-Length")
+	writeJSON(w, http.StatusBadGateway, toolCallError(failed[0]))
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// serveTranslated runs a request of another API, already translated to the
+// chat body, through the chat pipeline and writes the response through tw.
+// The reasoning mode is mode unless the client picks one.
+func (h *Handler) serveTranslated(tw *translateWriter, r *http.Request, chat []byte, mode string) {
+	r = r.Clone(r.Context())
+	r.URL.Path = "/v1/chat/completions"
+	r.Body = io.NopCloser(bytes.NewReader(chat))
+	r.ContentLength = int64(len(chat))
+	if requestedReasoningMode(r) == "" {
+		r.Header.Set("X-Reasoning-Mode", mode)
+	}
+	h.serveChat(tw, r)
+	tw.finish()
+}
+
+// translateWriter translates the chat response written to it for clients
+// of another API. Successful JSON responses are buffered and converted by
+// body at the end; event streams are converted line by line, event getting
+// the data of every "data:" line and returning the text to send; errors
+// pass through, as the APIs share their format. A contentType replaces the
+// type of converted streams that are no longer SSE, which then drop
+// comments such as keepalive pings.
+type translateWriter struct {
+	http.ResponseWriter
+	body        func([]byte) ([]byte, error)
+	event       func(data []byte) []byte
+	contentType string
+
+	status int
+	stream bool
+	buf    bytes.Buffer // JSON body, or the unfinished line of a stream
+}
+
+func (tw *translateWriter) WriteHeader(status int) {
+	if tw.status != 0 {
+		return
+	}
+	tw.status = status
+	switch {
+	case status != http.StatusOK:
+		tw.ResponseWriter.WriteHeader(status)
+	case strings.HasPrefix(tw.Header().Get("Content-Type"), "text/event-stream"):
+		tw.stream = true
+		if tw.contentType != "" {
+			tw.Header().Set("Content-Type", tw.contentType)
+		}
+		tw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (tw *translateWriter) Write(p []byte) (int, error) {
+	tw.WriteHeader(http.StatusOK)
+	switch {
+	case tw.stream:
+		tw.buf.Write(p)
+		var out []byte
+		for {
+			line, err := tw.buf.ReadBytes('\n')
+			if err != nil {
+				// Put back the unfinished line
+				rest := bytes.Clone(line)
+				tw.buf.Reset()
+				tw.buf.Write(rest)
+				break
+			}
+			out = append(out, tw.convertLine(bytes.TrimRight(line, "\r\n"))...)
+		}
+		if len(out) > 0 {
+			if _, err := tw.ResponseWriter.Write(out); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case tw.status != http.StatusOK:
+		return tw.ResponseWriter.Write(p)
+	default:
+		return tw.buf.Write(p)
+	}
+}
+
+// convertLine converts one line of the chat stream. Comments such as
+// keepalive pings pass through.
+func (tw *translateWriter) convertLine(line []byte) []byte {
+	if bytes.HasPrefix(line, []byte(":")) {
+		if tw.contentType != "" {
+			return nil
+		}
+		return append(line, '\n', '\n')
+	}
+	payload, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return nil
+	}
+	return tw.event(bytes.TrimSpace(payload))
+}
+
+func (tw *translateWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok && tw.status != 0 && (tw.stream || tw.status != http.StatusOK) {
+		f.Flush()
+	}
+}
+
+func (tw *translateWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// finish writes the converted JSON response.
+func (tw *translateWriter) finish() {
+	if tw.status != http.StatusOK || tw.stream {
+		return
+	}
+	body, err := tw.body(tw.buf.Bytes())
+	if err != nil {
+		body = tw.buf.Bytes()
+	}
+	tw.Header().Del("Content-Length")
+	if tw.contentType != "" {
+		tw.Header().Set("Content-Type", tw.contentType)
+	}
+	tw.ResponseWriter.WriteHeader(http.StatusOK)
+	tw.ResponseWriter.Write(body)
+}
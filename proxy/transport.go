@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"llm-local-proxy/config"
+)
+
+const (
+	defaultConnectTimeout        = 30 * time.Second
+	defaultResponseHeaderTimeout = 5 * time.Minute
+	defaultStreamIdleTimeout     = 2 * time.Minute
+)
+
+// NewHTTPClient builds the client used for all upstream requests from config.
+func NewHTTPClient(cfg config.Config) (*http.Client, error) {
+	tlsCfg, err := upstreamTLS(cfg.UpstreamTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	// The default transport already honors HTTP_PROXY / HTTPS_PROXY / NO_PROXY
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	transport.DialContext = countingDial((&net.Dialer{
+		Timeout:   cfg.Timeouts.Connect.Or(defaultConnectTimeout),
+		KeepAlive: 30 * time.Second,
+	}).DialContext)
+	transport.ResponseHeaderTimeout = cfg.Timeouts.ResponseHeader.Or(defaultResponseHeaderTimeout)
+	tuneTransport(transport, cfg.Transport)
+	if cfg.OutboundProxy != "" {
+		proxyURL, err := url.Parse(cfg.OutboundProxy)
+		if err != nil {
+			return nil, fmt.Errorf("outbound_proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// Request timeout is unlimited by default: long reasoning streams are
+	// bounded by the stream idle timeout instead
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeouts.Request.Std(),
+	}, nil
+}
+
+// tuneTransport applies the connection pool settings; zero values keep the
+// defaults of http.DefaultTransport.
+func tuneTransport(t *http.Transport, c config.TransportConfig) {
+	if c.MaxIdleConns > 0 {
+		t.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	t.MaxConnsPerHost = c.MaxConnsPerHost
+	t.IdleConnTimeout = c.IdleConnTimeout.Or(t.IdleConnTimeout)
+	t.TLSHandshakeTimeout = c.TLSHandshakeTimeout.Or(t.TLSHandshakeTimeout)
+
+	switch c.HTTPVersion {
+	case "http1":
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP1(true)
+	case "http2":
+		// Plain http:// upstreams get HTTP/2 with prior knowledge (h2c)
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+}
+
+// upstreamTLS returns the TLS client config, or nil to use Go defaults.
+func upstreamTLS(c config.UpstreamTLSConfig) (*tls.Config, error) {
+	if c == (config.UpstreamTLSConfig{}) {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read upstream ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("upstream ca_file %s: no certificates found", c.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load upstream client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
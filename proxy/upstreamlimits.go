@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"llm-local-proxy/provider"
+)
+
+// requestIDHeaders name the id an upstream gives its response, in order of
+// preference.
+var requestIDHeaders = []string{"X-Request-Id", "Request-Id"}
+
+// UpstreamRateLimit is the rate limit state an upstream last reported, as
+// the x-ratelimit-* (or ratelimit-*) headers of its response.
+type UpstreamRateLimit struct {
+	Time      time.Time         `json:"time"`
+	Key       string            `json:"key,omitempty"` // masked API key of the endpoint
+	RequestID string            `json:"request_id,omitempty"`
+	Headers   map[string]string `json:"headers"`
+}
+
+// observeUpstream records the request id and the rate limit headers of a
+// response from p in ex, and logs them. The headers themselves reach the
+// client with the rest of the response.
+func observeUpstream(ex *Exchange, p provider.Provider, ep *provider.Endpoint, resp *http.Response) {
+	var id string
+	for _, name := range requestIDHeaders {
+		if id = resp.Header.Get(name); id != "" {
+			break
+		}
+	}
+	limits := make(map[string]string)
+	for name, values := range resp.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ratelimit-") || strings.HasPrefix(lower, "ratelimit-") {
+			limits[lower] = strings.Join(values, ", ")
+		}
+	}
+	if id == "" && len(limits) == 0 {
+		return
+	}
+
+	ex.UpstreamRequestID = id
+	if len(limits) > 0 {
+		if ex.upstreamLimits == nil {
+			ex.upstreamLimits = make(map[string]UpstreamRateLimit)
+		}
+		ex.upstreamLimits[p.Name()] = UpstreamRateLimit{
+			Time:      time.Now(),
+			Key:       provider.MaskKey(ep.APIKey),
+			RequestID: id,
+			Headers:   limits,
+		}
+	}
+
+	var line []string
+	if id != "" {
+		line = append(line, "request id "+id)
+	}
+	for _, name := range slices.Sorted(maps.Keys(limits)) {
+		if strings.Contains(name, "remaining") {
+			line = append(line, name+"="+limits[name])
+		}
+	}
+	fmt.Printf("  ↳ %s: %s\n", p.Name(), strings.Join(line, ", "))
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"llm-local-proxy/transform"
+)
+
+const (
+	strippedImageText     = "[image omitted]"
+	defaultDescribePrompt = "Describe the image for someone who cannot see it, including any text it contains. " +
+		"Reply with the description only."
+
+	// maxImageDescriptions bounds the description cache; it is cleared when full.
+	maxImageDescriptions = 1000
+)
+
+// adaptImages prepares the images of a request for a text-only model as
+// text_only_action says: rejected, replaced by a placeholder, or replaced
+// by a description from the describe model. It writes the error for a
+// rejected request and reports whether it did.
+func (h *Handler) adaptImages(ctx context.Context, w http.ResponseWriter, r *http.Request, model string, body []byte) ([]byte, bool) {
+	cfg := h.images.cfg
+	if !cfg.IsTextOnly(model) {
+		return body, false
+	}
+	switch cfg.TextOnlyAction {
+	case "strip":
+		var n int
+		if body, n = transform.ReplaceImages(body, func(string) string { return strippedImageText }); n > 0 {
+			fmt.Printf("  ▣ %d image(s) stripped for text-only model %s\n", n, model)
+		}
+	case "describe":
+		var n int
+		body, n = transform.ReplaceImages(body, func(url string) string {
+			description, err := h.images.describe(ctx, h, r, url)
+			if err != nil {
+				fmt.Printf("  ✗ image description failed, image stripped: %v\n", err)
+				return strippedImageText
+			}
+			return "[image: " + description + "]"
+		})
+		if n > 0 {
+			fmt.Printf("  ▣ %d image(s) described by %s for text-only model %s\n", n, cfg.DescribeModel, model)
+		}
+	default:
+		if _, n := transform.ReplaceImages(body, func(string) string { return "" }); n > 0 {
+			fmt.Printf("  ✗ %d image(s) sent to text-only model %s\n", n, model)
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "images_unsupported",
+				fmt.Sprintf("Model %s does not accept images.", model))
+			return nil, true
+		}
+	}
+	return body, false
+}
+
+// stripFallbackImages replaces the images of a request failing over to a
+// text-only model with placeholders; a fallback is never rejected or held
+// up by a describe request.
+func (h *Handler) stripFallbackImages(body []byte, rt route) []byte {
+	if !h.images.cfg.IsTextOnly(rt.model) {
+		return body
+	}
+	body, n := transform.ReplaceImages(body, func(string) string { return strippedImageText })
+	if n > 0 {
+		fmt.Printf("  ▣ %d image(s) stripped for text-only model %s\n", n, rt.model)
+	}
+	return body
+}
+
+// describe returns a description of the image at url from the describe
+// model. Descriptions are cached by URL, so an image that stays in a
+// conversation is described once.
+func (ip *imageProcessor) describe(ctx context.Context, h *Handler, r *http.Request, url string) (string, error) {
+	key := sha256.Sum256([]byte(url))
+	ip.mu.Lock()
+	description, ok := ip.descriptions[key]
+	ip.mu.Unlock()
+	if ok {
+		return description, nil
+	}
+
+	p := h.registry.Resolve(ip.cfg.DescribeModel)
+	if p == nil {
+		return "", fmt.Errorf("no provider matched describe model %q", ip.cfg.DescribeModel)
+	}
+	// The describe model gets the image as any vision upstream would
+	prepared, err := ip.prepare(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	body, _ := json.Marshal(map[string]any{
+		"model": ip.cfg.DescribeModel,
+		"messages": []any{
+			map[string]any{"role": "system", "content": cmp.Or(ip.cfg.DescribePrompt, defaultDescribePrompt)},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": prepared}},
+			}},
+		},
+	})
+
+	resp, err := h.send(ctx, r, p, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	msg, ok := responseMessage(respBody)
+	if !ok || strings.TrimSpace(msg.Content) == "" {
+		return "", errors.New("empty description")
+	}
+	description = strings.TrimSpace(msg.Content)
+
+	ip.mu.Lock()
+	if len(ip.descriptions) >= maxImageDescriptions {
+		clear(ip.descriptions)
+	}
+	ip.descriptions[key] = description
+	ip.mu.Unlock()
+	return description, nil
+}
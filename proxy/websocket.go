@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// websocketGUID is appended to the client's key to compute the accept key
+// of the opening handshake (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessage bounds a message received from a client, fragments
+// included.
+const maxWebSocketMessage = 16 << 20
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WebSocket close codes.
+const (
+	wsCloseNormal      = 1000
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseTooBig      = 1009
+)
+
+var errWebSocketProtocol = errors.New("websocket: protocol error")
+
+// wsConn is the server side of a WebSocket connection: just enough of RFC
+// 6455 to exchange text messages, without extensions.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex // serializes frames written
+}
+
+// acceptWebSocket completes the opening handshake of r and takes over its
+// connection, selecting protocol as the subprotocol when it is not empty.
+// Requests that are not a WebSocket handshake get an error response.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request, protocol string) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !isUpgrade(r) || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "websocket_required",
+			"This endpoint expects a WebSocket handshake.")
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusUpgradeRequired, "invalid_request_error", "websocket_version",
+			"Only WebSocket version 13 is supported.")
+		return nil, errors.New("unsupported websocket version")
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// HTTP/2 connections cannot be taken over
+		writeError(w, http.StatusInternalServerError, "server_error", "websocket_unavailable",
+			"WebSocket is not available on this connection: "+err.Error())
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if protocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	if _, err := rw.WriteString(resp + "\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// ReadMessage returns the next data message, joining its fragments. Pings
+// are answered and pongs ignored on the way; a close frame is answered and
+// reported as io.EOF.
+func (c *wsConn) ReadMessage() (op byte, data []byte, err error) {
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.WriteMessage(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			// Echo the status code, if any
+			c.WriteMessage(wsClose, payload[:min(len(payload), 2)])
+			return 0, nil, io.EOF
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, c.fail(wsCloseProtocol, errWebSocketProtocol)
+			}
+		default:
+			if op != 0 {
+				return 0, nil, c.fail(wsCloseProtocol, errWebSocketProtocol)
+			}
+			op = opcode
+		}
+		if len(data)+len(payload) > maxWebSocketMessage {
+			return 0, nil, c.fail(wsCloseTooBig, errors.New("websocket: message too big"))
+		}
+		data = append(data, payload...)
+		if fin {
+			return op, data, nil
+		}
+	}
+}
+
+// readFrame reads one frame and unmasks its payload. Frames from clients
+// must be masked.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		// Reserved bits need an extension; unmasked frames come from servers
+		return false, 0, nil, c.fail(wsCloseProtocol, errWebSocketProtocol)
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxWebSocketMessage {
+		return false, 0, nil, c.fail(wsCloseTooBig, errors.New("websocket: message too big"))
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends data as a single unmasked frame. It is safe to call
+// from several goroutines.
+func (c *wsConn) WriteMessage(op byte, data []byte) error {
+	head := []byte{0x80 | op}
+	switch n := len(data); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xffff:
+		head = binary.BigEndian.AppendUint16(append(head, 126), uint16(n))
+	default:
+		head = binary.BigEndian.AppendUint64(append(head, 127), uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.rw.Write(head); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(data); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// CloseWith sends a close frame with code and reason, then closes the
+// connection.
+func (c *wsConn) CloseWith(code uint16, reason string) error {
+	c.WriteMessage(wsClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+	return c.conn.Close()
+}
+
+// Close closes the connection without a closing handshake.
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// fail closes the connection with code and returns err.
+func (c *wsConn) fail(code uint16, err error) error {
+	c.CloseWith(code, "")
+	return err
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm-local-proxy/transform"
+)
+
+// maxBridgeQueue bounds the requests a bridge connection holds while one is
+// being served; more are refused.
+const maxBridgeQueue = 8
+
+// NewWebSocketBridge serves chat completions over WebSocket, for browser
+// clients that struggle with fetch-based SSE. Every text message from the
+// client is a chat request, streamed unless it sets "stream": false, and
+// goes to api as a POST to /v1/chat/completions carrying the handshake's
+// headers, so keys, limits, budgets and stats apply per request. The data
+// of every SSE event is sent back as a message, ending with "[DONE]";
+// non-streamed responses and errors are sent as one message. Requests on
+// a connection are served one at a time, in order.
+func NewWebSocketBridge(api http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := acceptWebSocket(w, r, bridgeProtocol(r))
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		opened := time.Now()
+		fmt.Printf("[%s] ⇄ websocket bridge opened for %s\n", opened.Format("15:04:05"), r.RemoteAddr)
+
+		// The reader never waits on a request being served, so a client
+		// leaving is noticed and its request canceled right away.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		queue := make(chan []byte, maxBridgeQueue)
+		go func() {
+			defer cancel()
+			defer close(queue)
+			for {
+				op, data, err := ws.ReadMessage()
+				if err != nil {
+					return
+				}
+				if op != wsText {
+					ws.CloseWith(wsCloseUnsupported, "text messages only")
+					return
+				}
+				select {
+				case queue <- data:
+				default:
+					sendBridgeError(ws, "too_many_requests", "Too many requests queued on this connection.")
+				}
+			}
+		}()
+
+		served := 0
+		for body := range queue {
+			if ctx.Err() != nil {
+				break
+			}
+			serveBridged(ctx, api, ws, r, body)
+			served++
+		}
+		fmt.Printf("[%s] ⇄ websocket bridge for %s closed after %v (%d requests)\n",
+			time.Now().Format("15:04:05"), r.RemoteAddr, time.Since(opened).Round(time.Millisecond), served)
+	})
+}
+
+// bridgeProtocol picks the subprotocol to answer the handshake with, as
+// browsers drop connections whose offered protocols go unanswered. The key
+// subprotocol is only picked when nothing else is offered.
+func bridgeProtocol(r *http.Request) string {
+	var first string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for proto := range strings.SplitSeq(value, ",") {
+			proto = strings.TrimSpace(proto)
+			if proto != "" && !strings.HasPrefix(proto, realtimeKeyProtocol) {
+				return proto
+			}
+			if first == "" {
+				first = proto
+			}
+		}
+	}
+	return first
+}
+
+// serveBridged passes one chat request received on ws to api and relays the
+// response.
+func serveBridged(ctx context.Context, api http.Handler, ws *wsConn, handshake *http.Request, body []byte) {
+	var req struct {
+		Stream *bool `json:"stream"`
+	}
+	if json.Unmarshal(body, &req) == nil && req.Stream == nil {
+		body = transform.SetStream(body, true)
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	r.RequestURI = r.URL.Path
+	r.RemoteAddr = handshake.RemoteAddr
+	r.Host = handshake.Host
+	r.TLS = handshake.TLS
+	r.Header = bridgeHeader(handshake)
+
+	mw := &wsMessageWriter{ws: ws, header: make(http.Header)}
+	api.ServeHTTP(mw, r)
+	mw.finish()
+}
+
+// bridgeHeader returns the headers of the handshake for the requests of a
+// bridge connection, without those of the WebSocket itself. A key sent as
+// a subprotocol becomes the Authorization header.
+func bridgeHeader(handshake *http.Request) http.Header {
+	h := handshake.Header.Clone()
+	for name := range h {
+		if strings.HasPrefix(name, "Sec-Websocket-") {
+			h.Del(name)
+		}
+	}
+	for _, name := range []string{"Connection", "Upgrade", "Accept-Encoding", "Content-Length"} {
+		h.Del(name)
+	}
+	if h.Get("Authorization") == "" {
+		if key := realtimeKey(handshake); key != "" {
+			h.Set("Authorization", "Bearer "+key)
+		}
+	}
+	h.Set("Content-Type", "application/json")
+	return h
+}
+
+// sendBridgeError sends an error message in the format of the API.
+func sendBridgeError(ws *wsConn, code, message string) {
+	ws.WriteMessage(wsText, errorJSON("invalid_request_error", code, message))
+}
+
+// wsMessageWriter turns the response to a bridged request into messages:
+// the data of every event of a stream as it arrives, or the whole body of
+// any other response once it is complete. Keepalive comments are dropped,
+// as WebSocket connections need none.
+type wsMessageWriter struct {
+	ws     *wsConn
+	header http.Header
+	status int
+	stream bool
+	buf    bytes.Buffer // body, or the unfinished line of a stream
+	err    error        // the client is gone
+}
+
+func (mw *wsMessageWriter) Header() http.Header {
+	return mw.header
+}
+
+func (mw *wsMessageWriter) WriteHeader(status int) {
+	if mw.status != 0 {
+		return
+	}
+	mw.status = status
+	mw.stream = strings.HasPrefix(mw.header.Get("Content-Type"), "text/event-stream")
+}
+
+func (mw *wsMessageWriter) Write(p []byte) (int, error) {
+	mw.WriteHeader(http.StatusOK)
+	if mw.err != nil {
+		return 0, mw.err
+	}
+	mw.buf.Write(p)
+	if !mw.stream {
+		return len(p), nil
+	}
+	for {
+		line, err := mw.buf.ReadBytes('\n')
+		if err != nil {
+			// Put back the unfinished line
+			rest := bytes.Clone(line)
+			mw.buf.Reset()
+			mw.buf.Write(rest)
+			break
+		}
+		data, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), []byte("data:"))
+		if !ok {
+			continue
+		}
+		if mw.err = mw.ws.WriteMessage(wsText, bytes.TrimSpace(data)); mw.err != nil {
+			return 0, mw.err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op: events are sent as soon as their line is complete.
+func (mw *wsMessageWriter) Flush() {}
+
+// finish sends a response that was not streamed.
+func (mw *wsMessageWriter) finish() {
+	if mw.stream || mw.err != nil || mw.buf.Len() == 0 {
+		return
+	}
+	mw.ws.WriteMessage(wsText, bytes.TrimSpace(mw.buf.Bytes()))
+}
@@ -0,0 +1,516 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxSteps     = 1_000_000 // statements and loop iterations per run
+	maxStringLen = 1 << 20   // longest string a script may build
+	maxDepth     = 200       // deepest nesting converted to JSON
+)
+
+// Runtime values: nil, bool, float64, string, map[string]any (an object),
+// *array, builtin, library and *iterator. Arrays are pointers so that
+// appending through one reference is seen through all of them.
+
+type array struct{ items []any }
+
+// builtin is a function provided to scripts.
+type builtin func(st *state, args []any) any
+
+// library is a read-only table of builtins, such as string.
+type library map[string]builtin
+
+// iterator is what pairs and ipairs return, for use in a for ... in loop.
+type iterator struct {
+	keys   []any
+	source any // the object or array iterated
+}
+
+// runtimeError is raised with panic while a script runs and returned by Run.
+type runtimeError struct{ msg string }
+
+type control int
+
+const (
+	ctlNone control = iota
+	ctlBreak
+	ctlReturn
+)
+
+type scope struct {
+	vars   map[string]any
+	parent *scope
+}
+
+func newScope(parent *scope) *scope { return &scope{vars: make(map[string]any), parent: parent} }
+
+// state is one run of a script.
+type state struct {
+	script *Script
+	steps  int
+	line   int // of the statement or call being run, for errors
+}
+
+func (st *state) fail(format string, args ...any) {
+	panic(&runtimeError{fmt.Sprintf("%s:%d: ", st.script.name, st.line) + fmt.Sprintf(format, args...)})
+}
+
+func (st *state) step(line int) {
+	st.line = line
+	if st.steps++; st.steps > maxSteps {
+		st.fail("script exceeded %d steps", maxSteps)
+	}
+}
+
+func (st *state) block(stmts []stmt, sc *scope) control {
+	for _, s := range stmts {
+		if c := st.stmt(s, sc); c != ctlNone {
+			return c
+		}
+	}
+	return ctlNone
+}
+
+func (st *state) stmt(s stmt, sc *scope) control {
+	st.step(s.pos())
+	switch s := s.(type) {
+	case *localStmt:
+		var v any
+		if s.value != nil {
+			v = st.eval(s.value, sc)
+		}
+		sc.vars[s.name] = v
+	case *assignStmt:
+		v := st.eval(s.value, sc)
+		st.line = s.line
+		switch t := s.target.(type) {
+		case *nameExpr:
+			owner := sc
+			for owner.parent != nil {
+				if _, ok := owner.vars[t.name]; ok {
+					break
+				}
+				owner = owner.parent
+			}
+			owner.vars[t.name] = v // the globals when no local has the name
+		case *indexExpr:
+			obj, key := st.eval(t.obj, sc), st.eval(t.key, sc)
+			st.line = s.line
+			st.setIndex(obj, key, v, t.obj)
+		}
+	case *callStmt:
+		st.eval(s.call, sc)
+	case *ifStmt:
+		for i, cond := range s.conds {
+			if truthy(st.eval(cond, sc)) {
+				return st.block(s.blocks[i], newScope(sc))
+			}
+		}
+		if s.orElse != nil {
+			return st.block(s.orElse, newScope(sc))
+		}
+	case *whileStmt:
+		for truthy(st.eval(s.cond, sc)) {
+			st.step(s.line)
+			if c := st.block(s.body, newScope(sc)); c == ctlBreak {
+				break
+			} else if c == ctlReturn {
+				return c
+			}
+		}
+	case *numForStmt:
+		start, stop := st.number(st.eval(s.start, sc), "'for' start"), st.number(st.eval(s.stop, sc), "'for' limit")
+		step := 1.0
+		if s.step != nil {
+			step = st.number(st.eval(s.step, sc), "'for' step")
+		}
+		if step == 0 {
+			st.fail("'for' step is zero")
+		}
+		for i := start; step > 0 && i <= stop || step < 0 && i >= stop; i += step {
+			st.step(s.line)
+			inner := newScope(sc)
+			inner.vars[s.name] = i
+			if c := st.block(s.body, inner); c == ctlBreak {
+				break
+			} else if c == ctlReturn {
+				return c
+			}
+		}
+	case *forInStmt:
+		it, ok := st.eval(s.iter, sc).(*iterator)
+		if !ok {
+			st.fail("'for ... in' needs pairs(t) or ipairs(t)")
+		}
+		for _, k := range it.keys {
+			st.step(s.line)
+			v := st.index(it.source, k)
+			if v == nil {
+				continue // removed during the loop
+			}
+			inner := newScope(sc)
+			inner.vars[s.key] = k
+			if s.value != "" {
+				inner.vars[s.value] = v
+			}
+			if c := st.block(s.body, inner); c == ctlBreak {
+				break
+			} else if c == ctlReturn {
+				return c
+			}
+		}
+	case *doStmt:
+		return st.block(s.body, newScope(sc))
+	case *breakStmt:
+		return ctlBreak
+	case *returnStmt:
+		return ctlReturn
+	}
+	return ctlNone
+}
+
+func (st *state) eval(e expr, sc *scope) any {
+	switch e := e.(type) {
+	case *constExpr:
+		return e.value
+	case *nameExpr:
+		for s := sc; s != nil; s = s.parent {
+			if v, ok := s.vars[e.name]; ok {
+				return v
+			}
+		}
+		return builtins[e.name]
+	case *indexExpr:
+		obj, key := st.eval(e.obj, sc), st.eval(e.key, sc)
+		st.line = e.line
+		if obj == nil {
+			st.fail("attempt to index a nil value%s", what(e.obj))
+		}
+		return st.index(obj, key)
+	case *callExpr:
+		fn := st.eval(e.fn, sc)
+		args := st.evalAll(e.args, sc)
+		st.line = e.line
+		switch fn := fn.(type) {
+		case builtin:
+			return fn(st, args)
+		case nil:
+			st.fail("attempt to call a nil value%s", what(e.fn))
+		}
+		st.fail("attempt to call a %s value%s", typeName(fn), what(e.fn))
+	case *methodExpr:
+		obj := st.eval(e.obj, sc)
+		args := st.evalAll(e.args, sc)
+		st.line = e.line
+		if _, ok := obj.(string); !ok {
+			st.fail("method calls are only supported on strings, not on a %s value%s", typeName(obj), what(e.obj))
+		}
+		fn, ok := stringLib[e.name]
+		if !ok {
+			st.fail("unknown string method %q", e.name)
+		}
+		return fn(st, append([]any{obj}, args...))
+	case *tableExpr:
+		if len(e.items) > 0 {
+			return &array{items: st.evalAll(e.items, sc)}
+		}
+		obj := make(map[string]any, len(e.keys))
+		for i, k := range e.keys {
+			key, ok := st.eval(k, sc).(string)
+			if !ok {
+				st.line = k.pos()
+				st.fail("object keys must be strings")
+			}
+			if v := st.eval(e.vals[i], sc); v != nil {
+				obj[key] = v
+			}
+		}
+		return obj
+	case *unaryExpr:
+		x := st.eval(e.x, sc)
+		st.line = e.line
+		switch e.op {
+		case "not":
+			return !truthy(x)
+		case "-":
+			return -st.number(x, "arithmetic")
+		case "#":
+			switch x := x.(type) {
+			case string:
+				return float64(len(x))
+			case *array:
+				return float64(len(x.items))
+			}
+			st.fail("attempt to get the length of a %s value%s", typeName(x), what(e.x))
+		}
+	case *binaryExpr:
+		return st.binary(e, sc)
+	}
+	return nil
+}
+
+func (st *state) evalAll(exprs []expr, sc *scope) []any {
+	values := make([]any, len(exprs))
+	for i, e := range exprs {
+		values[i] = st.eval(e, sc)
+	}
+	return values
+}
+
+func (st *state) binary(e *binaryExpr, sc *scope) any {
+	l := st.eval(e.l, sc)
+	switch e.op {
+	case "and":
+		if !truthy(l) {
+			return l
+		}
+		return st.eval(e.r, sc)
+	case "or":
+		if truthy(l) {
+			return l
+		}
+		return st.eval(e.r, sc)
+	}
+	r := st.eval(e.r, sc)
+	st.line = e.line
+	switch e.op {
+	case "==":
+		return equal(l, r)
+	case "~=":
+		return !equal(l, r)
+	case "..":
+		s := st.concatPart(l) + st.concatPart(r)
+		if len(s) > maxStringLen {
+			st.fail("string longer than %d bytes", maxStringLen)
+		}
+		return s
+	case "<", "<=", ">", ">=":
+		if ls, ok := l.(string); ok {
+			if rs, ok := r.(string); ok {
+				return compare(e.op, strings.Compare(ls, rs))
+			}
+		}
+		ln, lok := l.(float64)
+		rn, rok := r.(float64)
+		if !lok || !rok {
+			st.fail("attempt to compare %s with %s", typeName(l), typeName(r))
+		}
+		switch {
+		case ln < rn:
+			return compare(e.op, -1)
+		case ln > rn:
+			return compare(e.op, 1)
+		}
+		return compare(e.op, 0)
+	}
+	a, b := st.number(l, "arithmetic"), st.number(r, "arithmetic")
+	switch e.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "//":
+		return math.Floor(a / b)
+	case "%":
+		return a - math.Floor(a/b)*b
+	}
+	return nil
+}
+
+func compare(op string, c int) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+func (st *state) concatPart(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return formatNumber(v)
+	}
+	st.fail("attempt to concatenate a %s value", typeName(v))
+	return ""
+}
+
+func (st *state) number(v any, context string) float64 {
+	n, ok := v.(float64)
+	if !ok {
+		st.fail("attempt to perform %s on a %s value", context, typeName(v))
+	}
+	return n
+}
+
+// index reads obj[key]; keys an object or array does not have read as nil.
+func (st *state) index(obj, key any) any {
+	switch o := obj.(type) {
+	case map[string]any:
+		k, _ := key.(string)
+		return o[k]
+	case *array:
+		if i, ok := arrayIndex(key); ok && i >= 1 && i <= len(o.items) {
+			return o.items[i-1]
+		}
+		return nil
+	case library:
+		k, _ := key.(string)
+		if fn, ok := o[k]; ok {
+			return fn
+		}
+		return nil
+	}
+	st.fail("attempt to index a %s value", typeName(obj))
+	return nil
+}
+
+// setIndex writes obj[key] = v. Setting an object field to nil deletes it;
+// an array grows by setting the index after its last element, and shrinks
+// by setting its last element to nil.
+func (st *state) setIndex(obj, key, v any, objExpr expr) {
+	switch o := obj.(type) {
+	case map[string]any:
+		k, ok := key.(string)
+		if !ok {
+			st.fail("object keys must be strings, not %s", typeName(key))
+		}
+		if v == nil {
+			delete(o, k)
+		} else {
+			o[k] = v
+		}
+		return
+	case *array:
+		i, ok := arrayIndex(key)
+		switch {
+		case !ok:
+			st.fail("array index must be an integer, not %s", typeName(key))
+		case v == nil && i == len(o.items) && i > 0:
+			o.items = o.items[:i-1]
+		case v == nil:
+			st.fail("only the last element of an array can be set to nil; use table.remove")
+		case i >= 1 && i <= len(o.items):
+			o.items[i-1] = v
+		case i == len(o.items)+1:
+			o.items = append(o.items, v)
+		default:
+			st.fail("array index %d out of range (length %d)", i, len(o.items))
+		}
+		return
+	case library:
+		st.fail("libraries cannot be changed")
+	case nil:
+		st.fail("attempt to index a nil value%s", what(objExpr))
+	}
+	st.fail("attempt to index a %s value%s", typeName(obj), what(objExpr))
+}
+
+func arrayIndex(key any) (int, bool) {
+	n, ok := key.(float64)
+	if !ok || n != math.Trunc(n) || math.Abs(n) > 1<<31 {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// what names the variable or field an expression reads, for errors.
+func what(e expr) string {
+	switch e := e.(type) {
+	case *nameExpr:
+		return fmt.Sprintf(" (variable '%s')", e.name)
+	case *indexExpr:
+		if k, ok := e.key.(*constExpr); ok {
+			if s, ok := k.value.(string); ok {
+				return fmt.Sprintf(" (field '%s')", s)
+			}
+		}
+	}
+	return ""
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		return ok && reflect.ValueOf(a).UnsafePointer() == reflect.ValueOf(b).UnsafePointer()
+	case builtin:
+		b, ok := b.(builtin)
+		return ok && reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	case library:
+		b, ok := b.(library)
+		return ok && reflect.ValueOf(a).UnsafePointer() == reflect.ValueOf(b).UnsafePointer()
+	}
+	return a == b
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case map[string]any:
+		return "object"
+	case *array:
+		return "array"
+	case builtin:
+		return "function"
+	case library:
+		return "library"
+	}
+	return "iterator"
+}
+
+// formatNumber prints integers without a fraction, as JSON does.
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of an object in order, so pairs iterates the
+// same way every run.
+func sortedKeys(o map[string]any) []any {
+	names := make([]string, 0, len(o))
+	for k := range o {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	keys := make([]any, len(names))
+	for i, k := range names {
+		keys[i] = k
+	}
+	return keys
+}
@@ -0,0 +1,192 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokNumber
+	tokString
+	tokKeyword
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // name, keyword, operator or string contents
+	num  float64
+	line int
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "if": true, "in": true, "local": true, "nil": true,
+	"not": true, "or": true, "return": true, "then": true, "true": true, "while": true,
+}
+
+// twoCharOps are matched before the single characters they start with.
+var twoCharOps = []string{"==", "~=", "<=", ">=", "..", "//"}
+
+const singleCharOps = "+-*/%#<>=(){}[];:,."
+
+// lex splits source into tokens, ending with a tokEOF.
+func lex(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			if strings.HasPrefix(src[i+2:], "[[") {
+				end := strings.Index(src[i+4:], "]]")
+				if end < 0 {
+					return nil, fmt.Errorf("line %d: unfinished long comment", line)
+				}
+				line += strings.Count(src[i:i+4+end], "\n")
+				i += 4 + end + 2
+				continue
+			}
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case isLetter(c):
+			j := i
+			for j < len(src) && (isLetter(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			kind := tokName
+			if keywords[src[i:j]] {
+				kind = tokKeyword
+			}
+			toks = append(toks, token{kind: kind, text: src[i:j], line: line})
+			i = j
+		case isDigit(c) || c == '.' && i+1 < len(src) && isDigit(src[i+1]):
+			j := i
+			if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+				j += 2
+				for j < len(src) && strings.IndexByte("0123456789abcdefABCDEF", src[j]) >= 0 {
+					j++
+				}
+				n, err := strconv.ParseUint(src[i+2:j], 16, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: malformed number %q", line, src[i:j])
+				}
+				toks = append(toks, token{kind: tokNumber, text: src[i:j], num: float64(n), line: line})
+				i = j
+				continue
+			}
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				j++
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				for j < len(src) && isDigit(src[j]) {
+					j++
+				}
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: malformed number %q", line, src[i:j])
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[i:j], num: n, line: line})
+			i = j
+		case c == '"' || c == '\'':
+			s, n, err := lexString(src[i:], line)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tokString, text: s, line: line})
+			i += n
+		case strings.HasPrefix(src[i:], "[["):
+			end := strings.Index(src[i+2:], "]]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unfinished long string", line)
+			}
+			s := strings.TrimPrefix(src[i+2:i+2+end], "\n") // as in Lua
+			toks = append(toks, token{kind: tokString, text: s, line: line})
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += 2 + end + 2
+		default:
+			op := ""
+			for _, two := range twoCharOps {
+				if strings.HasPrefix(src[i:], two) {
+					op = two
+					break
+				}
+			}
+			if op == "" && strings.IndexByte(singleCharOps, c) >= 0 {
+				op = src[i : i+1]
+			}
+			if op == "" {
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, line: line})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, line: line}), nil
+}
+
+// lexString reads the quoted string at the start of src and returns its
+// contents and the number of bytes it took.
+func lexString(src string, line int) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\n':
+			return "", 0, fmt.Errorf("line %d: unfinished string", line)
+		case '\\':
+			i++
+			if i == len(src) {
+				return "", 0, fmt.Errorf("line %d: unfinished string", line)
+			}
+			switch e := src[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			case 'u':
+				end := strings.IndexByte(src[i:], '}')
+				if !strings.HasPrefix(src[i:], "u{") || end < 0 {
+					return "", 0, fmt.Errorf("line %d: malformed \\u escape", line)
+				}
+				r, err := strconv.ParseUint(src[i+2:i+end], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf("line %d: malformed \\u escape", line)
+				}
+				b.WriteRune(rune(r))
+				i += end
+			default:
+				return "", 0, fmt.Errorf("line %d: invalid escape \\%c", line, e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("line %d: unfinished string", line)
+}
+
+func isLetter(c byte) bool { return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
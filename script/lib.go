@@ -0,0 +1,361 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// builtins are the globals every script sees. They are shared by all runs
+// and never changed; a script assigning one of the names shadows it.
+var builtins map[string]any
+
+// stringLib is also what s:name(...) method calls on strings look up.
+var stringLib library
+
+func init() {
+	stringLib = library{
+		"lower":      func(st *state, a []any) any { return strings.ToLower(st.str(a, 0)) },
+		"upper":      func(st *state, a []any) any { return strings.ToUpper(st.str(a, 0)) },
+		"len":        func(st *state, a []any) any { return float64(len(st.str(a, 0))) },
+		"trim":       func(st *state, a []any) any { return strings.TrimSpace(st.str(a, 0)) },
+		"sub":        strSub,
+		"find":       strFind,
+		"rep":        strRep,
+		"replace":    strReplace,
+		"split":      strSplit,
+		"startswith": func(st *state, a []any) any { return strings.HasPrefix(st.str(a, 0), st.str(a, 1)) },
+		"endswith":   func(st *state, a []any) any { return strings.HasSuffix(st.str(a, 0), st.str(a, 1)) },
+	}
+	builtins = map[string]any{
+		"type":     builtin(func(st *state, a []any) any { return typeName(arg(a, 0)) }),
+		"tostring": builtin(func(st *state, a []any) any { return st.tostring(arg(a, 0)) }),
+		"tonumber": builtin(libToNumber),
+		"array":    builtin(func(st *state, a []any) any { return &array{items: append([]any(nil), a...)} }),
+		"pairs":    builtin(libPairs),
+		"ipairs":   builtin(libIpairs),
+		"print":    builtin(libPrint),
+		"error":    builtin(func(st *state, a []any) any { st.fail("%s", st.tostring(arg(a, 0))); return nil }),
+		"string":   stringLib,
+		"table": library{
+			"insert": tableInsert,
+			"remove": tableRemove,
+			"concat": tableConcat,
+		},
+		"math": library{
+			"floor": func(st *state, a []any) any { return math.Floor(st.num(a, 0)) },
+			"ceil":  func(st *state, a []any) any { return math.Ceil(st.num(a, 0)) },
+			"abs":   func(st *state, a []any) any { return math.Abs(st.num(a, 0)) },
+			"max":   func(st *state, a []any) any { return st.fold(a, math.Max) },
+			"min":   func(st *state, a []any) any { return st.fold(a, math.Min) },
+		},
+		"json": library{
+			"encode": func(st *state, a []any) any { return st.tostring(arg(a, 0)) },
+			"decode": jsonDecode,
+		},
+	}
+}
+
+func arg(args []any, i int) any {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// str, num, arr and optNum check the argument at i.
+
+func (st *state) str(args []any, i int) string {
+	switch v := arg(args, i).(type) {
+	case string:
+		return v
+	case float64:
+		return formatNumber(v)
+	}
+	st.fail("bad argument #%d (string expected, got %s)", i+1, typeName(arg(args, i)))
+	return ""
+}
+
+func (st *state) num(args []any, i int) float64 {
+	n, ok := arg(args, i).(float64)
+	if !ok {
+		st.fail("bad argument #%d (number expected, got %s)", i+1, typeName(arg(args, i)))
+	}
+	return n
+}
+
+func (st *state) optNum(args []any, i int, def float64) float64 {
+	if arg(args, i) == nil {
+		return def
+	}
+	return st.num(args, i)
+}
+
+func (st *state) arr(args []any, i int) *array {
+	a, ok := arg(args, i).(*array)
+	if !ok {
+		st.fail("bad argument #%d (array expected, got %s)", i+1, typeName(arg(args, i)))
+	}
+	return a
+}
+
+func (st *state) fold(args []any, f func(a, b float64) float64) float64 {
+	n := st.num(args, 0)
+	for i := 1; i < len(args); i++ {
+		n = f(n, st.num(args, i))
+	}
+	return n
+}
+
+// tostring prints strings and numbers as they are and tables as JSON.
+func (st *state) tostring(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatNumber(v)
+	case map[string]any, *array:
+		data, err := json.Marshal(st.toJSON(v, 0))
+		if err != nil {
+			st.fail("%v", err)
+		}
+		return string(data)
+	}
+	return typeName(v)
+}
+
+// toJSON copies a script value into the types encoding/json handles.
+func (st *state) toJSON(v any, depth int) any {
+	if depth > maxDepth {
+		st.fail("table nested deeper than %d levels (or containing itself)", maxDepth)
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, x := range v {
+			out[k] = st.toJSON(x, depth+1)
+		}
+		return out
+	case *array:
+		out := make([]any, len(v.items))
+		for i, x := range v.items {
+			out[i] = st.toJSON(x, depth+1)
+		}
+		return out
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			st.fail("%s cannot be written as JSON", formatNumber(v))
+		}
+		return v
+	case nil, bool, string:
+		return v
+	}
+	st.fail("a %s value cannot be written as JSON", typeName(v))
+	return nil
+}
+
+// fromJSON converts decoded JSON in place into script values.
+func fromJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			if x == nil {
+				delete(v, k) // nil fields do not exist in scripts
+				continue
+			}
+			v[k] = fromJSON(x)
+		}
+		return v
+	case []any:
+		for i, x := range v {
+			v[i] = fromJSON(x)
+		}
+		return &array{items: v}
+	}
+	return v
+}
+
+func libToNumber(st *state, a []any) any {
+	switch v := arg(a, 0).(type) {
+	case float64:
+		return v
+	case string:
+		if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return n
+		}
+	}
+	return nil
+}
+
+func libPairs(st *state, a []any) any {
+	switch v := arg(a, 0).(type) {
+	case map[string]any:
+		return &iterator{keys: sortedKeys(v), source: v}
+	case *array:
+		return libIpairs(st, a)
+	}
+	st.fail("bad argument #1 to 'pairs' (table expected, got %s)", typeName(arg(a, 0)))
+	return nil
+}
+
+func libIpairs(st *state, a []any) any {
+	v := st.arr(a, 0)
+	keys := make([]any, len(v.items))
+	for i := range keys {
+		keys[i] = float64(i + 1)
+	}
+	return &iterator{keys: keys, source: v}
+}
+
+func libPrint(st *state, a []any) any {
+	parts := make([]string, len(a))
+	for i, v := range a {
+		parts[i] = st.tostring(v)
+	}
+	fmt.Printf("  ✎ script %s: %s\n", st.script.name, strings.Join(parts, "\t"))
+	return nil
+}
+
+// strSub is string.sub(s, i, j) with Lua's 1-based, inclusive and
+// negative-from-the-end positions, on bytes.
+func strSub(st *state, a []any) any {
+	s := st.str(a, 0)
+	n := float64(len(s))
+	i, j := st.optNum(a, 1, 1), st.optNum(a, 2, -1)
+	if i < 0 {
+		i = math.Max(n+i+1, 1)
+	} else if i == 0 {
+		i = 1
+	}
+	if j < 0 {
+		j = n + j + 1
+	} else if j > n {
+		j = n
+	}
+	if i > j {
+		return ""
+	}
+	return s[int(i)-1 : int(j)]
+}
+
+// strFind is string.find(s, sub, init) for plain substrings; it returns the
+// 1-based position of the match or nil.
+func strFind(st *state, a []any) any {
+	s, sub := st.str(a, 0), st.str(a, 1)
+	init := int(st.optNum(a, 2, 1))
+	if init < 1 {
+		init = 1
+	}
+	if init > len(s)+1 {
+		return nil
+	}
+	if i := strings.Index(s[init-1:], sub); i >= 0 {
+		return float64(init + i)
+	}
+	return nil
+}
+
+func strRep(st *state, a []any) any {
+	s, n := st.str(a, 0), int(st.num(a, 1))
+	if n <= 0 {
+		return ""
+	}
+	if len(s)*n > maxStringLen {
+		st.fail("string longer than %d bytes", maxStringLen)
+	}
+	return strings.Repeat(s, n)
+}
+
+// strReplace is string.replace(s, old, new, n): plain replacement of the
+// first n matches, or all of them when n is omitted.
+func strReplace(st *state, a []any) any {
+	s, old, repl := st.str(a, 0), st.str(a, 1), st.str(a, 2)
+	out := strings.Replace(s, old, repl, int(st.optNum(a, 3, -1)))
+	if len(out) > maxStringLen {
+		st.fail("string longer than %d bytes", maxStringLen)
+	}
+	return out
+}
+
+func strSplit(st *state, a []any) any {
+	parts := strings.Split(st.str(a, 0), st.str(a, 1))
+	items := make([]any, len(parts))
+	for i, p := range parts {
+		items[i] = p
+	}
+	return &array{items: items}
+}
+
+// tableInsert is table.insert(t, v) or table.insert(t, pos, v).
+func tableInsert(st *state, a []any) any {
+	t := st.arr(a, 0)
+	if len(a) < 3 {
+		if v := arg(a, 1); v != nil {
+			t.items = append(t.items, v)
+		}
+		return nil
+	}
+	pos, ok := arrayIndex(arg(a, 1))
+	if !ok || pos < 1 || pos > len(t.items)+1 {
+		st.fail("bad argument #2 to 'insert' (position out of bounds)")
+	}
+	v := arg(a, 2)
+	if v == nil {
+		st.fail("bad argument #3 to 'insert' (value expected)")
+	}
+	t.items = append(t.items, nil)
+	copy(t.items[pos:], t.items[pos-1:])
+	t.items[pos-1] = v
+	return nil
+}
+
+// tableRemove is table.remove(t, pos); it removes the last element when pos
+// is omitted, and returns the removed value.
+func tableRemove(st *state, a []any) any {
+	t := st.arr(a, 0)
+	if len(t.items) == 0 {
+		return nil
+	}
+	pos := len(t.items)
+	if arg(a, 1) != nil {
+		var ok bool
+		pos, ok = arrayIndex(arg(a, 1))
+		if !ok || pos < 1 || pos > len(t.items) {
+			st.fail("bad argument #2 to 'remove' (position out of bounds)")
+		}
+	}
+	v := t.items[pos-1]
+	t.items = append(t.items[:pos-1], t.items[pos:]...)
+	return v
+}
+
+func tableConcat(st *state, a []any) any {
+	t := st.arr(a, 0)
+	sep := ""
+	if arg(a, 1) != nil {
+		sep = st.str(a, 1)
+	}
+	parts := make([]string, len(t.items))
+	for i := range t.items {
+		parts[i] = st.str(t.items, i)
+	}
+	s := strings.Join(parts, sep)
+	if len(s) > maxStringLen {
+		st.fail("string longer than %d bytes", maxStringLen)
+	}
+	return s
+}
+
+func jsonDecode(st *state, a []any) any {
+	var v any
+	if err := json.Unmarshal([]byte(st.str(a, 0)), &v); err != nil {
+		st.fail("json.decode: %v", err)
+	}
+	return fromJSON(v)
+}
@@ -0,0 +1,425 @@
+package script
+
+import "fmt"
+
+// Syntax tree. Every node records its line for error messages.
+
+type node struct{ line int }
+
+func (n node) pos() int { return n.line }
+
+type expr interface{ pos() int }
+
+type stmt interface{ pos() int }
+
+type (
+	constExpr struct {
+		node
+		value any // nil, bool, float64 or string
+	}
+	nameExpr struct {
+		node
+		name string
+	}
+	indexExpr struct {
+		node
+		obj, key expr
+	}
+	callExpr struct {
+		node
+		fn   expr
+		args []expr
+	}
+	// methodExpr is obj:name(args), which only strings support
+	methodExpr struct {
+		node
+		obj  expr
+		name string
+		args []expr
+	}
+	tableExpr struct {
+		node
+		items      []expr // positional entries
+		keys, vals []expr // keyed entries
+	}
+	unaryExpr struct {
+		node
+		op string
+		x  expr
+	}
+	binaryExpr struct {
+		node
+		op   string
+		l, r expr
+	}
+)
+
+type (
+	localStmt struct {
+		node
+		name  string
+		value expr // nil declares the local as nil
+	}
+	assignStmt struct {
+		node
+		target expr // nameExpr or indexExpr
+		value  expr
+	}
+	callStmt struct {
+		node
+		call expr
+	}
+	ifStmt struct {
+		node
+		conds  []expr
+		blocks [][]stmt
+		orElse []stmt
+	}
+	whileStmt struct {
+		node
+		cond expr
+		body []stmt
+	}
+	numForStmt struct {
+		node
+		name              string
+		start, stop, step expr // step nil = 1
+		body              []stmt
+	}
+	forInStmt struct {
+		node
+		key, value string // value "" when only the key is named
+		iter       expr
+		body       []stmt
+	}
+	doStmt struct {
+		node
+		body []stmt
+	}
+	breakStmt  struct{ node }
+	returnStmt struct{ node }
+)
+
+// Operator priorities (left, right) as in Lua; ".." is right associative.
+var binaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {9, 8},
+	"+":  {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "//": {11, 11}, "%": {11, 11},
+}
+
+const unaryPriority = 12
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// syntaxError is raised with panic inside the parser and returned by parse.
+type syntaxError struct{ msg string }
+
+// parse builds the syntax tree of a script.
+func parse(src string) (block []stmt, err error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*syntaxError); ok {
+				block, err = nil, fmt.Errorf("%s", e.msg)
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	block = p.block()
+	if t := p.peek(); t.kind != tokEOF {
+		p.fail(t, "unexpected %s", describe(t))
+	}
+	return block, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the keyword or operator text.
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokKeyword || t.kind == tokOp) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) token {
+	t := p.peek()
+	if !p.accept(text) {
+		p.fail(t, "%q expected near %s", text, describe(t))
+	}
+	return t
+}
+
+func (p *parser) name() string {
+	t := p.next()
+	if t.kind != tokName {
+		p.fail(t, "name expected near %s", describe(t))
+	}
+	return t.text
+}
+
+func (p *parser) fail(t token, format string, args ...any) {
+	panic(&syntaxError{fmt.Sprintf("line %d: ", t.line) + fmt.Sprintf(format, args...)})
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of script"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// block parses statements up to the keyword that ends the block.
+func (p *parser) block() []stmt {
+	var stmts []stmt
+	for {
+		switch t := p.peek(); {
+		case t.kind == tokEOF, p.is("end"), p.is("else"), p.is("elseif"):
+			return stmts
+		case p.accept(";"):
+		default:
+			stmts = append(stmts, p.statement())
+		}
+	}
+}
+
+func (p *parser) statement() stmt {
+	t := p.peek()
+	at := node{t.line}
+	switch {
+	case p.accept("if"):
+		s := &ifStmt{node: at}
+		for {
+			s.conds = append(s.conds, p.expr())
+			p.expect("then")
+			s.blocks = append(s.blocks, p.block())
+			if !p.accept("elseif") {
+				break
+			}
+		}
+		if p.accept("else") {
+			s.orElse = p.block()
+		}
+		p.expect("end")
+		return s
+	case p.accept("while"):
+		s := &whileStmt{node: at, cond: p.expr()}
+		p.expect("do")
+		s.body = p.block()
+		p.expect("end")
+		return s
+	case p.accept("do"):
+		s := &doStmt{node: at, body: p.block()}
+		p.expect("end")
+		return s
+	case p.accept("for"):
+		name := p.name()
+		if p.accept("=") {
+			s := &numForStmt{node: at, name: name, start: p.expr()}
+			p.expect(",")
+			s.stop = p.expr()
+			if p.accept(",") {
+				s.step = p.expr()
+			}
+			p.expect("do")
+			s.body = p.block()
+			p.expect("end")
+			return s
+		}
+		s := &forInStmt{node: at, key: name}
+		if p.accept(",") {
+			s.value = p.name()
+		}
+		p.expect("in")
+		s.iter = p.expr()
+		p.expect("do")
+		s.body = p.block()
+		p.expect("end")
+		return s
+	case p.accept("local"):
+		s := &localStmt{node: at, name: p.name()}
+		if p.accept("=") {
+			s.value = p.expr()
+		}
+		return s
+	case p.accept("return"):
+		return &returnStmt{at}
+	case p.accept("break"):
+		return &breakStmt{at}
+	}
+
+	e := p.suffixed()
+	if p.accept("=") {
+		switch e.(type) {
+		case *nameExpr, *indexExpr:
+		default:
+			p.fail(t, "cannot assign to this expression")
+		}
+		return &assignStmt{node: at, target: e, value: p.expr()}
+	}
+	switch e.(type) {
+	case *callExpr, *methodExpr:
+		return &callStmt{node: at, call: e}
+	}
+	p.fail(p.peek(), "syntax error near %s", describe(p.peek()))
+	return nil
+}
+
+func (p *parser) expr() expr { return p.subexpr(0) }
+
+// subexpr parses an expression whose binary operators bind tighter than
+// limit.
+func (p *parser) subexpr(limit int) expr {
+	var left expr
+	if t := p.peek(); p.is("not") || p.is("-") || p.is("#") {
+		p.next()
+		left = &unaryExpr{node: node{t.line}, op: t.text, x: p.subexpr(unaryPriority)}
+	} else {
+		left = p.simple()
+	}
+	for {
+		t := p.peek()
+		prio, ok := binaryPriority[t.text]
+		if !ok || t.kind != tokOp && t.kind != tokKeyword || prio[0] <= limit {
+			return left
+		}
+		p.next()
+		left = &binaryExpr{node: node{t.line}, op: t.text, l: left, r: p.subexpr(prio[1])}
+	}
+}
+
+func (p *parser) simple() expr {
+	t := p.peek()
+	at := node{t.line}
+	switch {
+	case t.kind == tokNumber:
+		p.next()
+		return &constExpr{at, t.num}
+	case t.kind == tokString:
+		p.next()
+		return &constExpr{at, t.text}
+	case p.accept("nil"):
+		return &constExpr{at, nil}
+	case p.accept("true"):
+		return &constExpr{at, true}
+	case p.accept("false"):
+		return &constExpr{at, false}
+	case p.is("{"):
+		return p.table()
+	}
+	return p.suffixed()
+}
+
+// suffixed parses a name or parenthesized expression followed by any
+// field accesses, indexes and calls.
+func (p *parser) suffixed() expr {
+	t := p.peek()
+	var e expr
+	switch {
+	case t.kind == tokName:
+		p.next()
+		e = &nameExpr{node{t.line}, t.text}
+	case p.accept("("):
+		e = p.expr()
+		p.expect(")")
+	default:
+		p.fail(t, "unexpected %s", describe(t))
+	}
+	for {
+		t := p.peek()
+		at := node{t.line}
+		switch {
+		case p.accept("."):
+			e = &indexExpr{at, e, &constExpr{at, p.name()}}
+		case p.accept("["):
+			e = &indexExpr{at, e, p.expr()}
+			p.expect("]")
+		case p.accept(":"):
+			name := p.name()
+			e = &methodExpr{at, e, name, p.args()}
+		case p.is("("), p.is("{"), t.kind == tokString:
+			e = &callExpr{at, e, p.args()}
+		default:
+			return e
+		}
+	}
+}
+
+// args parses call arguments: a parenthesized list, a string or a table.
+func (p *parser) args() []expr {
+	t := p.peek()
+	switch {
+	case t.kind == tokString:
+		p.next()
+		return []expr{&constExpr{node{t.line}, t.text}}
+	case p.is("{"):
+		return []expr{p.table()}
+	}
+	p.expect("(")
+	var args []expr
+	if !p.accept(")") {
+		for {
+			args = append(args, p.expr())
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect(")")
+	}
+	return args
+}
+
+func (p *parser) table() expr {
+	t := p.expect("{")
+	e := &tableExpr{node: node{t.line}}
+	for !p.accept("}") {
+		switch {
+		case p.accept("["):
+			key := p.expr()
+			p.expect("]")
+			p.expect("=")
+			e.keys, e.vals = append(e.keys, key), append(e.vals, p.expr())
+		case p.peek().kind == tokName && p.toks[p.pos+1].kind == tokOp && p.toks[p.pos+1].text == "=":
+			k := p.next()
+			p.next()
+			e.keys, e.vals = append(e.keys, &constExpr{node{k.line}, k.text}), append(e.vals, p.expr())
+		default:
+			e.items = append(e.items, p.expr())
+		}
+		if !p.accept(",") && !p.accept(";") {
+			p.expect("}")
+			break
+		}
+	}
+	if len(e.items) > 0 && len(e.keys) > 0 {
+		p.fail(t, "a table is either an array or an object, not both")
+	}
+	return e
+}
@@ -0,0 +1,86 @@
+// Package script runs small transform scripts in a sandboxed subset of Lua:
+// locals, if/while/for, tables, strings and a handful of libraries, with no
+// access to files, the network or the clock, and a limit on how many steps
+// a run may take.
+//
+// Tables follow JSON rather than Lua. {} and {k = v} are objects with
+// string keys; {a, b} and array(...) are arrays, indexed from 1. A JSON
+// null reads as nil, and setting a field to nil removes it.
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Script is a parsed script. It may run concurrently.
+type Script struct {
+	name  string
+	block []stmt
+}
+
+// Load parses the script file at path.
+func Load(path string) (*Script, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(filepath.Base(path), string(src))
+}
+
+// Compile parses src; name is used in error messages.
+func Compile(name, src string) (*Script, error) {
+	block, err := parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &Script{name: name, block: block}, nil
+}
+
+// Name returns the name the script was compiled with.
+func (s *Script) Name() string { return s.name }
+
+// Run runs the script with globals, which hold decoded JSON values: nil,
+// bool, float64, string, map[string]any and []any. When Run returns, each
+// global holds what the script left in it, converted back the same way; a
+// script may also set globals that were not given.
+func (s *Script) Run(globals map[string]any) (err error) {
+	root := newScope(nil)
+	for k, v := range globals {
+		root.vars[k] = fromJSON(v)
+	}
+	st := &state{script: s}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*runtimeError); ok {
+				err = fmt.Errorf("%s", e.msg)
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	st.block(s.block, root)
+	for k, v := range root.vars {
+		switch v.(type) {
+		case nil, bool, float64, string, map[string]any, *array:
+			globals[k] = st.toJSON(v, 0)
+		}
+	}
+	return nil
+}
+
+// RunJSON runs the script with the JSON document data as the global name
+// and returns the document as the script left it.
+func (s *Script) RunJSON(name string, data []byte, globals map[string]any) ([]byte, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	globals[name] = doc
+	if err := s.Run(globals); err != nil {
+		return nil, err
+	}
+	return json.Marshal(globals[name])
+}
@@ -0,0 +1,187 @@
+package script
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`x = `, "unexpected"},
+		{`if x then`, `"end" expected`},
+		{`x = (1`, `")" expected`},
+		{`local = 1`, "name expected"},
+		{`x`, "syntax error"},
+		{`1 = x`, "unexpected"},
+		{`f() = 1`, "cannot assign"},
+		{`x = {1, a = 2}`, "either an array or an object"},
+		{`x = "abc`, "unfinished string"},
+		{`x = "a\qb"`, `invalid escape \q`},
+		{`x = "\u12"`, `malformed \u escape`},
+		{`x = 1.2.3`, "malformed number"},
+		{`x = [[abc`, "unfinished long string"},
+		{`--[[ abc`, "unfinished long comment"},
+		{`x = 1 @ 2`, "unexpected character"},
+		{"x = 1\ny = ", "line 2"},
+	}
+	for _, tt := range tests {
+		_, err := Compile("t.lua", tt.src)
+		if err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error", tt.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "t.lua: ") {
+			t.Errorf("Compile(%q) = %v, want an error containing %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		body string
+		want string
+	}{
+		{"unchanged", ``, `{"a":1}`, `{"a":1}`},
+		{"set field", `body.model = "x"`, `{"model":"m"}`, `{"model":"x"}`},
+		{"remove field", `body.a = nil`, `{"a":1,"b":2}`, `{"b":2}`},
+		{"null reads as nil", `if body.a == nil then body.b = true end`, `{"a":null}`, `{"b":true}`},
+		{"index from 1", `body.first = body.l[1]; body.last = body.l[#body.l]`, `{"l":[1,2,3]}`, `{"first":1,"l":[1,2,3],"last":3}`},
+		{"index past the end", `body.x = body.l[4]`, `{"l":[1,2,3]}`, `{"l":[1,2,3]}`},
+		{"append", `body.l[#body.l + 1] = 4`, `{"l":[1]}`, `{"l":[1,4]}`},
+		{"remove last", `body.l[#body.l] = nil`, `{"l":[1,2]}`, `{"l":[1]}`},
+		{"table.insert", `table.insert(body.l, 1, 0); table.insert(body.l, 9)`, `{"l":[1]}`, `{"l":[0,1,9]}`},
+		{"table.remove", `body.x = table.remove(body.l, 1)`, `{"l":[1,2]}`, `{"l":[2],"x":1}`},
+		{"table.concat", `body.s = table.concat(body.l, ",")`, `{"l":["a","b"]}`, `{"l":["a","b"],"s":"a,b"}`},
+		{"empty array stays an array", `body.l = array()`, `{}`, `{"l":[]}`},
+		{"empty object", `body.o = {}`, `{}`, `{"o":{}}`},
+		{"array literal", `body.l = {1, "a", true}`, `{}`, `{"l":[1,"a",true]}`},
+		{"object literal", `body.o = {a = 1, ["b c"] = 2}`, `{}`, `{"o":{"a":1,"b c":2}}`},
+		{"numeric for", `local s = 0 for i = 1, 10, 3 do s = s + i end body.s = s`, `{}`, `{"s":22}`},
+		{"ipairs", `local s = "" for i, v in ipairs(body.l) do s = s .. i .. v end body.s = s`, `{"l":["a","b"]}`, `{"l":["a","b"],"s":"1a2b"}`},
+		{"pairs", `local n = 0 for k, v in pairs(body) do n = n + v end body.n = n`, `{"a":1,"b":2}`, `{"a":1,"b":2,"n":3}`},
+		{"while and break", `local i = 0 while true do i = i + 1 if i == 5 then break end end body.i = i`, `{}`, `{"i":5}`},
+		{"string methods", `body.s = body.s:upper():sub(2, 3)`, `{"s":"hello"}`, `{"s":"EL"}`},
+		{"string.find", `body.i = string.find("abc", "c")`, `{}`, `{"i":3}`},
+		{"string.split", `body.l = string.split("a,b", ",")`, `{}`, `{"l":["a","b"]}`},
+		{"string.replace", `body.s = string.replace(body.s, "a", "b")`, `{"s":"aa"}`, `{"s":"bb"}`},
+		{"numbers", `body.x = 7 // 2; body.y = 7 % 3; body.z = -2 * 3 / 4`, `{}`, `{"x":3,"y":1,"z":-1.5}`},
+		{"tostring of integer", `body.s = tostring(3)`, `{}`, `{"s":"3"}`},
+		{"tonumber", `body.n = tonumber("1.5")`, `{}`, `{"n":1.5}`},
+		{"json roundtrip", `body.o = json.decode(json.encode(body.o))`, `{"o":{"a":[1,{"b":"c"}],"d":1.5}}`, `{"o":{"a":[1,{"b":"c"}],"d":1.5}}`},
+		{"type", `body.t = type(body.l) .. type(body.o) .. type(body.s)`, `{"l":[],"o":{},"s":""}`, `{"l":[],"o":{},"s":"","t":"arrayobjectstring"}`},
+		{"return ends the script", `body.a = 1 if true then return end body.a = 2`, `{}`, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile("t.lua", tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := s.RunJSON("body", []byte(tt.body), map[string]any{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("got %s, want %s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"error", `error("no")`, "no"},
+		{"nil index", `x = body.a.b`, "attempt to index a nil value"},
+		{"nil call", `nothing()`, "attempt to call a nil value"},
+		{"arithmetic", `x = "a" + 1`, "attempt to perform arithmetic"},
+		{"compare", `x = 1 < "a"`, "attempt to compare"},
+		{"concatenate", `x = "a" .. {}`, "attempt to concatenate"},
+		{"array index out of range", `body.l[5] = 1`, "out of range"},
+		{"array hole", `body.l[2] = 2; body.l[1] = nil`, "only the last element"},
+		{"array key", `body.l.x = 1`, "array index must be an integer"},
+		{"object key", `body[1] = 1`, "object keys must be strings"},
+		{"library change", `string.x = 1`, "libraries cannot be changed"},
+		{"zero step", `for i = 1, 2, 0 do end`, "step is zero"},
+		{"bad argument", `x = string.upper({})`, "string expected"},
+		{"json.decode", `x = json.decode("{")`, "json.decode"},
+		{"steps", `while true do end`, "exceeded 1000000 steps"},
+		{"steps in for", `for i = 1, 1e9 do end`, "exceeded 1000000 steps"},
+		{"string.rep", `x = string.rep("ab", 1000000)`, "string longer than"},
+		{"concatenation", `local s = "a" while true do s = s .. s end`, "string longer than"},
+		{"cycle", `local t = {} t.self = t body.t = t`, "nested deeper than 200"},
+		{"function value", `body.f = print`, "cannot be written as JSON"},
+		{"infinity", `body.x = 1 / 0`, "cannot be written as JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile("t.lua", tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.RunJSON("body", []byte(`{"a":null,"l":[1]}`), map[string]any{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunGlobals(t *testing.T) {
+	s, err := Compile("t.lua", `headers["X-A"] = nil; headers["X-B"] = provider .. "/" .. path; seen = true`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals := map[string]any{
+		"headers":  map[string]any{"X-A": "1"},
+		"provider": "p",
+		"path":     "/chat/completions",
+	}
+	if err := s.Run(globals); err != nil {
+		t.Fatal(err)
+	}
+	headers := globals["headers"].(map[string]any)
+	if _, ok := headers["X-A"]; ok || headers["X-B"] != "p//chat/completions" || globals["seen"] != true {
+		t.Errorf("globals after run: %v", globals)
+	}
+}
+
+func TestRunDoesNotShareState(t *testing.T) {
+	s, err := Compile("t.lua", `if n == nil then n = 0 end n = n + 1 body.n = n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		out, err := s.RunJSON("body", []byte(`{}`), map[string]any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != `{"n":1}` {
+			t.Errorf("got %s, want {\"n\":1}", out)
+		}
+	}
+}
+
+func TestRunRepanicsOnGoPanics(t *testing.T) {
+	builtins["boom"] = builtin(func(*state, []any) any { panic("boom") })
+	t.Cleanup(func() { delete(builtins, "boom") })
+	s, err := Compile("t.lua", `boom()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want the builtin's panic", r)
+		}
+	}()
+	err = s.Run(map[string]any{})
+	t.Errorf("Run returned %v, want it to panic", err)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"sync/atomic"
+
+	"llm-local-proxy/config"
+	"llm-local-proxy/plugin"
+	"llm-local-proxy/provider"
+	"llm-local-proxy/proxy"
+	"llm-local-proxy/transform"
+)
+
+// server owns everything built from the config file and swaps it atomically
+// on reload. Listeners, TLS and the -mock/-record/-replay/-chaos modes are
+// fixed at startup; providers, keys, budgets, limits and filters are reloaded.
+type server struct {
+	configPath string
+	debug      bool            // -debug forces debug output on across reloads
+	mock       *http.Client    // non-nil in -mock mode
+	replay     http.Handler    // non-nil in -replay mode
+	pprof      bool            // mount /debug/pprof/ and /debug/runtime
+	debugLog   *proxy.DebugLog // non-nil when debug_log is set at startup
+	stats      *proxy.Stats
+	api        http.Handler // outer, reload-independent part of the API chain
+
+	mu        sync.Mutex // serializes reloads
+	current   atomic.Pointer[generation]
+	reloadErr atomic.Pointer[error] // result of the last reload, nil before the first
+}
+
+// generation is the config-derived state replaced as a whole on reload.
+type generation struct {
+	cfg        config.Config
+	keys       *proxy.KeyStore
+	budgets    *proxy.Budgets
+	upstream   *proxy.Handler
+	plugins    *plugin.Chain
+	api        http.Handler // auth → rate limit → budgets → upstream
+	handler    http.Handler // IP filter + all routes
+	stopHealth context.CancelFunc
+}
+
+// build creates a generation from cfg and starts its health checker.
+func (s *server) build(cfg config.Config) (*generation, error) {
+	if s.debug {
+		cfg.Debug = true
+	}
+
+	if err := transform.SetRedactions(cfg.Redact.Patterns); err != nil {
+		return nil, fmt.Errorf("redact: %w", err)
+	}
+
+	registry, err := provider.NewRegistry(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("providers: %w", err)
+	}
+
+	client := s.mock
+	if client == nil {
+		if client, err = proxy.NewHTTPClient(cfg); err != nil {
+			return nil, fmt.Errorf("upstream client: %w", err)
+		}
+	}
+
+	plugins, err := plugin.NewChain(cfg.Plugins)
+	if err != nil {
+		return nil, fmt.Errorf("plugins: %w", err)
+	}
+
+	rt := &generation{cfg: cfg, keys: proxy.NewKeyStore(cfg.Keys), plugins: plugins}
+
+	health := proxy.NewHealthChecker(cfg, registry, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	rt.stopHealth = cancel
+	go health.Run(ctx)
+
+	// Proxied API traffic: auth → rate limit → budgets → upstream
+	rt.upstream = proxy.NewHandler(cfg, registry, client)
+	rt.upstream.SetDebugLog(s.debugLog)
+	rt.upstream.SetPlugins(plugins)
+	var api http.Handler = rt.upstream
+	if s.replay != nil {
+		api = s.replay
+	}
+	rt.budgets = proxy.NewBudgets(cfg, rt.keys, api)
+	api = rt.budgets
+	if cfg.RateLimit.Enabled() {
+		api = proxy.NewRateLimiter(cfg.RateLimit, api)
+	}
+	rt.api = proxy.NewKeyAuth(rt.keys, api)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", proxy.Live)
+	mux.Handle("/ready", health.Ready(s.ReloadError))
+	mux.Handle("/health/upstreams", health)
+	mux.Handle("/admin/", proxy.NewAdmin(s, s.stats))
+	if len(cfg.Routes) > 0 {
+		mux.Handle("/", proxy.NewPathRouter(cfg.Routes, s.api))
+	} else {
+		mux.Handle("/", s.api)
+	}
+	if cfg.WebSocketBridge {
+		mux.Handle("/ws/v1/chat/completions", proxy.NewWebSocketBridge(s.api))
+	}
+	if s.pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		mux.HandleFunc("/debug/runtime", proxy.DebugRuntime)
+	}
+
+	rt.handler = mux
+	if cfg.GzipResponses {
+		rt.handler = proxy.NewGzip(rt.handler)
+	}
+	if len(cfg.IPAllow) > 0 || len(cfg.IPDeny) > 0 {
+		rt.handler = proxy.NewIPFilter(cfg.IPAllow, cfg.IPDeny, rt.handler)
+	}
+	return rt, nil
+}
+
+// serveAPI dispatches to the current generation's API chain.
+func (s *server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	s.current.Load().api.ServeHTTP(w, r)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.current.Load().handler.ServeHTTP(w, r)
+}
+
+// Reload re-reads the config file and swaps in the new state. Budget usage
+// and stored reasoning carry over; rate limit buckets and keys added at
+// runtime start over. A failed reload keeps the running state and is
+// reported by ReloadError until a later one succeeds.
+func (s *server) Reload() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { s.reloadErr.Store(&err) }()
+
+	cfg, err := config.Load(s.configPath)
+	if err != nil {
+		return err
+	}
+	old := s.current.Load()
+	if cfg.ListenAddr() != old.cfg.ListenAddr() || cfg.UnixSocket != old.cfg.UnixSocket || cfg.TLSCert != old.cfg.TLSCert {
+		fmt.Println("⚠️  监听地址与 TLS 配置的修改需重启后生效")
+	}
+	if cfg.DebugLog != old.cfg.DebugLog {
+		fmt.Println("⚠️  debug_log 的修改需重启后生效")
+	}
+
+	rt, err := s.build(cfg)
+	if err != nil {
+		return err
+	}
+	rt.budgets.Inherit(old.budgets)
+	rt.upstream.Inherit(old.upstream)
+	s.current.Store(rt)
+	old.stopHealth()
+	// Closed once the requests still using them finish
+	old.plugins.Close()
+	return nil
+}
+
+// ReloadError returns the error of the last reload, or nil when it
+// succeeded or none was made.
+func (s *server) ReloadError() error {
+	if err := s.reloadErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (s *server) Config() config.Config   { return s.current.Load().cfg }
+func (s *server) Keys() *proxy.KeyStore   { return s.current.Load().keys }
+func (s *server) Budgets() *proxy.Budgets { return s.current.Load().budgets }
@@ -0,0 +1,324 @@
+package transform
+
+import (
+	"cmp"
+	"encoding/json"
+	"strings"
+)
+
+// Chat requests and responses in Anthropic's Messages API, for providers
+// of type anthropic.
+
+const (
+	defaultAnthropicMaxTokens = 4096 // the API requires max_tokens
+	minAnthropicThinking      = 1024 // the smallest budget_tokens accepted
+)
+
+// ChatToAnthropic converts an OpenAI chat request to a Messages request.
+// System and developer messages become the system prompt, tool results
+// user turns with tool_result blocks, and consecutive turns of one role are
+// merged, as the API requires. Parameters without a counterpart are
+// dropped.
+func ChatToAnthropic(body []byte) (map[string]any, error) {
+	var req chatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	out := map[string]any{
+		"model":      req.Model,
+		"max_tokens": cmp.Or(req.MaxCompletionTokens, req.MaxTokens, defaultAnthropicMaxTokens),
+	}
+	if req.Stream {
+		out["stream"] = true
+	}
+	if req.Temperature != nil {
+		out["temperature"] = min(*req.Temperature, 1) // OpenAI allows up to 2
+	}
+	if req.TopP != nil {
+		out["top_p"] = *req.TopP
+	}
+	if stop := req.stopSequences(); len(stop) > 0 {
+		out["stop_sequences"] = stop
+	}
+	if req.User != "" {
+		out["metadata"] = map[string]any{"user_id": req.User}
+	}
+
+	var system, messages []any
+	add := func(role string, blocks []any) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(messages); n > 0 {
+			if last := messages[n-1].(map[string]any); last["role"] == role {
+				last["content"] = append(last["content"].([]any), blocks...)
+				return
+			}
+		}
+		messages = append(messages, map[string]any{"role": role, "content": blocks})
+	}
+	for _, msg := range req.Messages {
+		switch msg["role"] {
+		case "system", "developer":
+			if text := contentText(msg["content"]); text != "" {
+				system = append(system, map[string]any{"type": "text", "text": text})
+			}
+		case "assistant":
+			blocks := anthropicBlocks(msg["content"])
+			calls, _ := msg["tool_calls"].([]any)
+			for _, c := range calls {
+				call, _ := c.(map[string]any)
+				fn, _ := call["function"].(map[string]any)
+				blocks = append(blocks, map[string]any{
+					"type":  "tool_use",
+					"id":    call["id"],
+					"name":  fn["name"],
+					"input": toolArguments(call),
+				})
+			}
+			add("assistant", blocks)
+		case "tool":
+			result := map[string]any{"type": "tool_result", "tool_use_id": msg["tool_call_id"]}
+			if blocks := anthropicBlocks(msg["content"]); len(blocks) > 0 {
+				result["content"] = blocks
+			}
+			add("user", []any{result})
+		default:
+			add("user", anthropicBlocks(msg["content"]))
+		}
+	}
+	if len(system) > 0 {
+		out["system"] = system
+	}
+	if messages == nil {
+		messages = []any{}
+	}
+	out["messages"] = messages
+
+	if len(req.Tools) > 0 {
+		out["tools"] = ToolsToAnthropic(req.Tools)
+		toolChoice := req.ToolChoice
+		if toolChoice == nil {
+			toolChoice = "auto"
+		}
+		noParallel := req.ParallelToolCalls != nil && !*req.ParallelToolCalls
+		choice, _ := ToolChoiceToAnthropic(toolChoice).(map[string]any)
+		switch {
+		case choice == nil:
+			delete(out, "tools") // "none" is expressed by sending no tools
+		case req.ToolChoice != nil || noParallel:
+			if noParallel {
+				choice["disable_parallel_tool_use"] = true
+			}
+			out["tool_choice"] = choice
+		}
+	}
+	anthropicThinking(out, req)
+	return out, nil
+}
+
+// anthropicThinking turns on extended thinking with the budget for the
+// request's reasoning_effort. Thinking counts toward max_tokens: a limit
+// the client set caps the budget, otherwise room for the answer is added
+// on top. Thinking rules out temperature and a top_p below 0.95, which
+// are dropped, and a forced tool. A turn answering a tool call would need
+// the signed thinking blocks of the call, which the client never has, so
+// neither gets thinking.
+func anthropicThinking(out map[string]any, req chatRequest) {
+	budget, ok := thinkingBudgets[req.ReasoningEffort]
+	if !ok {
+		return
+	}
+	if choice, _ := out["tool_choice"].(map[string]any); choice["type"] == "any" || choice["type"] == "tool" {
+		return
+	}
+	if n := len(req.Messages); n > 0 && req.Messages[n-1]["role"] == "tool" {
+		return
+	}
+	if n := cmp.Or(req.MaxCompletionTokens, req.MaxTokens); n > 0 {
+		budget = min(budget, n-1)
+	} else {
+		out["max_tokens"] = budget + defaultAnthropicMaxTokens
+	}
+	if budget < minAnthropicThinking {
+		return
+	}
+	out["thinking"] = map[string]any{"type": "enabled", "budget_tokens": budget}
+	delete(out, "temperature")
+	if topP, ok := out["top_p"].(float64); ok && topP < 0.95 {
+		delete(out, "top_p")
+	}
+}
+
+// anthropicBlocks converts message content to content blocks: text, and
+// images given as data or http(s) URLs. Empty text, which the API
+// rejects, is left out.
+func anthropicBlocks(content any) []any {
+	switch c := content.(type) {
+	case string:
+		if c == "" {
+			return nil
+		}
+		return []any{map[string]any{"type": "text", "text": c}}
+	case []any:
+		var blocks []any
+		for _, p := range c {
+			part, _ := p.(map[string]any)
+			switch part["type"] {
+			case "text":
+				if s, _ := part["text"].(string); s != "" {
+					blocks = append(blocks, map[string]any{"type": "text", "text": s})
+				}
+			case "image_url":
+				url := imageURL(part)
+				source := map[string]any{"type": "url", "url": url}
+				if mediaType, data, ok := splitDataURL(url); ok {
+					source = map[string]any{"type": "base64", "media_type": mediaType, "data": data}
+				} else if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+					continue
+				}
+				blocks = append(blocks, map[string]any{"type": "image", "source": source})
+			}
+		}
+		return blocks
+	}
+	return nil
+}
+
+// anthropicFinish maps a stop_reason to an OpenAI finish_reason.
+func anthropicFinish(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	}
+	return "stop"
+}
+
+// anthropicUsage converts a usage object. Anthropic counts cached and
+// cache-writing input apart from input_tokens; OpenAI includes them in
+// prompt_tokens.
+func anthropicUsage(u map[string]any) map[string]any {
+	cached := intValue(u["cache_read_input_tokens"])
+	prompt := intValue(u["input_tokens"]) + cached + intValue(u["cache_creation_input_tokens"])
+	return chatUsage(prompt, intValue(u["output_tokens"]), cached)
+}
+
+// AnthropicToChat converts a Messages response to an OpenAI chat
+// completion. Thinking blocks become reasoning_content.
+func AnthropicToChat(body []byte) ([]byte, error) {
+	var resp struct {
+		ID         string         `json:"id"`
+		Model      string         `json:"model"`
+		Content    []any          `json:"content"`
+		StopReason string         `json:"stop_reason"`
+		Usage      map[string]any `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	text, calls := ToolCallsFromAnthropic(resp.Content)
+	var reasoning strings.Builder
+	for _, b := range resp.Content {
+		if block, _ := b.(map[string]any); block["type"] == "thinking" {
+			s, _ := block["thinking"].(string)
+			reasoning.WriteString(s)
+		}
+	}
+	choice := map[string]any{
+		"index":         0,
+		"message":       chatMessage(text, reasoning.String(), calls),
+		"finish_reason": anthropicFinish(resp.StopReason),
+	}
+	return chatCompletion(resp.ID, resp.Model, []any{choice}, anthropicUsage(resp.Usage))
+}
+
+// AnthropicStream converts the events of a streamed Messages response to
+// OpenAI chat completion chunks.
+type AnthropicStream struct {
+	chunks chunkWriter
+	usage  map[string]any // from message_start, completed by message_delta
+	tools  map[int]int    // content block index → tool call index
+}
+
+// Event converts the data of one event. It returns the data of the chunks
+// to send, the last one "[DONE]" at the end of the message.
+func (s *AnthropicStream) Event(data []byte) [][]byte {
+	var ev struct {
+		Type         string         `json:"type"`
+		Index        int            `json:"index"`
+		Message      map[string]any `json:"message"`
+		ContentBlock map[string]any `json:"content_block"`
+		Delta        map[string]any `json:"delta"`
+		Usage        map[string]any `json:"usage"`
+		Error        any            `json:"error"`
+	}
+	if json.Unmarshal(data, &ev) != nil {
+		return nil
+	}
+	switch ev.Type {
+	case "message_start":
+		s.chunks.id, _ = ev.Message["id"].(string)
+		s.chunks.model, _ = ev.Message["model"].(string)
+		s.usage, _ = ev.Message["usage"].(map[string]any)
+		return [][]byte{s.chunks.chunk(0, map[string]any{"content": ""}, nil, nil)}
+	case "content_block_start":
+		block := ev.ContentBlock
+		switch block["type"] {
+		case "tool_use":
+			if s.tools == nil {
+				s.tools = make(map[int]int)
+			}
+			index := len(s.tools)
+			s.tools[ev.Index] = index
+			call := openAIToolCall(index, str(block["id"]), str(block["name"]), nil)
+			call["function"].(map[string]any)["arguments"] = ""
+			return [][]byte{s.chunks.chunk(0, map[string]any{"tool_calls": []any{call}}, nil, nil)}
+		case "text":
+			if text := str(block["text"]); text != "" {
+				return [][]byte{s.chunks.chunk(0, map[string]any{"content": text}, nil, nil)}
+			}
+		}
+	case "content_block_delta":
+		switch ev.Delta["type"] {
+		case "text_delta":
+			return [][]byte{s.chunks.chunk(0, map[string]any{"content": str(ev.Delta["text"])}, nil, nil)}
+		case "thinking_delta":
+			return [][]byte{s.chunks.chunk(0, map[string]any{"reasoning_content": str(ev.Delta["thinking"])}, nil, nil)}
+		case "input_json_delta":
+			call := map[string]any{
+				"index":    s.tools[ev.Index],
+				"function": map[string]any{"arguments": str(ev.Delta["partial_json"])},
+			}
+			return [][]byte{s.chunks.chunk(0, map[string]any{"tool_calls": []any{call}}, nil, nil)}
+		}
+	case "message_delta":
+		usage := make(map[string]any)
+		for k, v := range s.usage {
+			usage[k] = v
+		}
+		for k, v := range ev.Usage {
+			usage[k] = v
+		}
+		finish := anthropicFinish(str(ev.Delta["stop_reason"]))
+		return [][]byte{s.chunks.chunk(0, map[string]any{}, finish, anthropicUsage(usage))}
+	case "message_stop":
+		return [][]byte{streamDone}
+	case "error":
+		data, _ := json.Marshal(map[string]any{"error": ev.Error})
+		return [][]byte{data}
+	}
+	return nil
+}
+
+// End returns what follows the last event: nothing, as message_stop ends
+// the stream.
+func (s *AnthropicStream) End() [][]byte { return nil }
+
+func str(v any) string {
+	s, _ := v.(string)
+	return s
+}
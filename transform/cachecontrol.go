@@ -0,0 +1,130 @@
+package transform
+
+import (
+	"cmp"
+	"encoding/json"
+)
+
+// Prompt-cache breakpoints of Anthropic Messages requests. Anthropic only
+// caches a prompt prefix that ends in a block marked with cache_control,
+// which OpenAI clients never send; these rules place the marks for them.
+
+const (
+	maxCacheBreakpoints   = 4    // per request, as the API allows
+	defaultCacheMinTokens = 1024 // the smallest prefix most models cache
+)
+
+// CacheControlRules says where AddCacheControl places breakpoints. The
+// prefix they end is the tools, then the system prompt, then the messages,
+// in that order, so each breakpoint also covers the ones before it.
+type CacheControlRules struct {
+	Tools     bool   // after the last tool definition
+	System    bool   // after the system prompt
+	History   bool   // after the last message before the final user turn
+	MinTokens int    // estimated prefix size a breakpoint needs; 0 = 1024
+	TTL       string // "5m" or "1h"; "" = the API's default of 5 minutes
+}
+
+// AddCacheControl marks the blocks of an Anthropic Messages request that
+// the rules select with cache_control, and returns how many it marked.
+// Blocks the client marked are kept, and count towards the API's limit of
+// four breakpoints; a prefix estimated below MinTokens is not marked, as
+// the API would not cache it anyway. A string system prompt or message
+// content becomes a single text block to carry the mark.
+func AddCacheControl(req map[string]any, rules CacheControlRules) int {
+	used := countCacheControl(req)
+	minTokens := cmp.Or(rules.MinTokens, defaultCacheMinTokens)
+	prefix, added := 0, 0
+	mark := func(block map[string]any) {
+		if block == nil || block["cache_control"] != nil || used >= maxCacheBreakpoints || EstimateTokens(prefix) < minTokens {
+			return
+		}
+		cc := map[string]any{"type": "ephemeral"}
+		if rules.TTL != "" && rules.TTL != "5m" {
+			cc["ttl"] = rules.TTL
+		}
+		block["cache_control"] = cc
+		used++
+		added++
+	}
+
+	tools, _ := req["tools"].([]any)
+	prefix += jsonSize(tools)
+	if rules.Tools && len(tools) > 0 {
+		last, _ := tools[len(tools)-1].(map[string]any)
+		mark(last)
+	}
+
+	prefix += jsonSize(req["system"])
+	if rules.System {
+		if blocks, ok := contentBlocks(req["system"]); ok && len(blocks) > 0 {
+			req["system"] = blocks
+			last, _ := blocks[len(blocks)-1].(map[string]any)
+			mark(last)
+		}
+	}
+
+	messages, _ := req["messages"].([]any)
+	final := len(messages) - 1
+	for final >= 0 {
+		if msg, _ := messages[final].(map[string]any); msg["role"] == "user" {
+			break
+		}
+		final--
+	}
+	if rules.History && final > 0 {
+		prefix += jsonSize(messages[:final])
+		msg, _ := messages[final-1].(map[string]any)
+		if blocks, ok := contentBlocks(msg["content"]); ok && len(blocks) > 0 {
+			msg["content"] = blocks
+			last, _ := blocks[len(blocks)-1].(map[string]any)
+			mark(last)
+		}
+	}
+	return added
+}
+
+// countCacheControl counts the breakpoints the client set itself.
+func countCacheControl(req map[string]any) int {
+	n := 0
+	count := func(v any) {
+		blocks, _ := v.([]any)
+		for _, b := range blocks {
+			if block, _ := b.(map[string]any); block["cache_control"] != nil {
+				n++
+			}
+		}
+	}
+	count(req["tools"])
+	count(req["system"])
+	messages, _ := req["messages"].([]any)
+	for _, m := range messages {
+		msg, _ := m.(map[string]any)
+		count(msg["content"])
+	}
+	return n
+}
+
+// contentBlocks returns content as a list of blocks, a string becoming one
+// text block. ok is false for content of any other shape.
+func contentBlocks(content any) (blocks []any, ok bool) {
+	switch c := content.(type) {
+	case []any:
+		return c, true
+	case string:
+		if c == "" {
+			return nil, false
+		}
+		return []any{map[string]any{"type": "text", "text": c}}, true
+	}
+	return nil, false
+}
+
+// jsonSize returns the length of v encoded as JSON.
+func jsonSize(v any) int {
+	if v == nil {
+		return 0
+	}
+	b, _ := json.Marshal(v)
+	return len(b)
+}
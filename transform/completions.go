@@ -0,0 +1,169 @@
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	completePrompt = "Continue the text the user sends. Reply with the continuation only, without repeating the text or adding any commentary."
+	fillPrompt     = "Fill in the middle. The user sends the text before and after a gap, marked <PREFIX> and <SUFFIX>. Reply with the text that belongs in the gap only, without repeating either side or adding any commentary or code fences."
+)
+
+// CompletionsToChat converts a legacy text completion request
+// (/completions) to a chat completions request: the prompt, and the suffix
+// of fill-in-the-middle requests, become a user message after a system
+// message asking for the continuation only. It also returns the prompt,
+// which echo puts in front of the completion. Only a single prompt is
+// supported.
+func CompletionsToChat(body []byte) ([]byte, string, error) {
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, "", err
+	}
+	prompt, ok := req["prompt"].(string)
+	if list, isList := req["prompt"].([]any); isList && len(list) == 1 {
+		prompt, ok = list[0].(string)
+	}
+	if !ok {
+		return nil, "", errors.New("prompt must be a single string")
+	}
+
+	system, user := completePrompt, prompt
+	if suffix, _ := req["suffix"].(string); suffix != "" {
+		system, user = fillPrompt, "<PREFIX>"+prompt+"</PREFIX>\n<SUFFIX>"+suffix+"</SUFFIX>"
+	}
+	chat := map[string]any{
+		"model": req["model"],
+		"messages": []any{
+			map[string]any{"role": "system", "content": system},
+			map[string]any{"role": "user", "content": user},
+		},
+	}
+	for _, key := range []string{"max_tokens", "temperature", "top_p", "n", "stop", "stream", "stream_options",
+		"presence_penalty", "frequency_penalty", "logit_bias", "seed", "user"} {
+		if v, ok := req[key]; ok {
+			chat[key] = v
+		}
+	}
+	// logprobs is the number of top tokens in the legacy API
+	if n, ok := req["logprobs"].(float64); ok {
+		chat["logprobs"] = true
+		if n > 0 {
+			chat["top_logprobs"] = n
+		}
+	}
+	out, err := json.Marshal(chat)
+	if err != nil {
+		return nil, "", err
+	}
+	if req["echo"] != true {
+		prompt = ""
+	}
+	return out, prompt, nil
+}
+
+// ChatToCompletion converts a chat.completion response to a text_completion
+// one, with echo in front of every choice's text.
+func ChatToCompletion(body []byte, echo string) ([]byte, error) {
+	var chat map[string]any
+	if err := json.Unmarshal(body, &chat); err != nil {
+		return nil, err
+	}
+	choices, _ := chat["choices"].([]any)
+	out := make([]any, 0, len(choices))
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]any)
+		msg, _ := choice["message"].(map[string]any)
+		text, _ := msg["content"].(string)
+		out = append(out, textChoice(choice, echo+text))
+	}
+	return json.Marshal(textCompletion(chat, out))
+}
+
+// CompletionStream converts the chunks of a streamed chat completion to
+// text_completion chunks.
+type CompletionStream struct {
+	echo   string
+	echoed map[any]bool // choices whose text started with echo
+}
+
+// NewCompletionStream starts a stream that puts echo in front of the text
+// of every choice.
+func NewCompletionStream(echo string) *CompletionStream {
+	return &CompletionStream{echo: echo, echoed: make(map[any]bool)}
+}
+
+// Chunk converts the data of a chat SSE event. Error events are returned
+// as they are.
+func (s *CompletionStream) Chunk(data []byte) []byte {
+	var chunk map[string]any
+	if json.Unmarshal(data, &chunk) != nil || chunk["error"] != nil {
+		return data
+	}
+	choices, _ := chunk["choices"].([]any)
+	out := make([]any, 0, len(choices))
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]any)
+		delta, _ := choice["delta"].(map[string]any)
+		text, _ := delta["content"].(string)
+		if !s.echoed[choice["index"]] {
+			s.echoed[choice["index"]] = true
+			text = s.echo + text
+		}
+		out = append(out, textChoice(choice, text))
+	}
+	converted, err := json.Marshal(textCompletion(chunk, out))
+	if err != nil {
+		return data
+	}
+	return converted
+}
+
+func textChoice(choice map[string]any, text string) map[string]any {
+	return map[string]any{
+		"index":         choice["index"],
+		"text":          text,
+		"logprobs":      legacyLogprobs(choice["logprobs"]),
+		"finish_reason": choice["finish_reason"],
+	}
+}
+
+func textCompletion(chat map[string]any, choices []any) map[string]any {
+	resp := map[string]any{"object": "text_completion", "choices": choices}
+	for _, key := range []string{"id", "created", "model", "system_fingerprint", "usage"} {
+		if v, ok := chat[key]; ok {
+			resp[key] = v
+		}
+	}
+	return resp
+}
+
+// legacyLogprobs converts chat logprobs to the parallel arrays of the
+// legacy API. Text offsets are counted from the start of the completion.
+func legacyLogprobs(raw any) any {
+	lp, _ := raw.(map[string]any)
+	content, _ := lp["content"].([]any)
+	if len(content) == 0 {
+		return nil
+	}
+	var tokens, logprobs, top, offsets []any
+	offset := 0
+	for _, raw := range content {
+		entry, _ := raw.(map[string]any)
+		token, _ := entry["token"].(string)
+		tokens = append(tokens, token)
+		logprobs = append(logprobs, entry["logprob"])
+		offsets = append(offsets, offset)
+		offset += len(token)
+		alternatives := make(map[string]any)
+		list, _ := entry["top_logprobs"].([]any)
+		for _, raw := range list {
+			alt, _ := raw.(map[string]any)
+			alternatives[fmt.Sprint(alt["token"])] = alt["logprob"]
+		}
+		top = append(top, alternatives)
+	}
+	return map[string]any{"tokens": tokens, "token_logprobs": logprobs, "top_logprobs": top, "text_offset": offsets}
+}
@@ -0,0 +1,183 @@
+package transform
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Helpers shared by the Anthropic and Gemini translations, which build
+// OpenAI chat completions and chunks from the providers' own responses.
+
+// chatRequest is the part of an OpenAI chat request the translations read.
+type chatRequest struct {
+	Model               string           `json:"model"`
+	Messages            []map[string]any `json:"messages"`
+	Stream              bool             `json:"stream"`
+	MaxTokens           int              `json:"max_tokens"`
+	MaxCompletionTokens int              `json:"max_completion_tokens"`
+	Temperature         *float64         `json:"temperature"`
+	TopP                *float64         `json:"top_p"`
+	Stop                any              `json:"stop"`
+	N                   int              `json:"n"`
+	PresencePenalty     *float64         `json:"presence_penalty"`
+	FrequencyPenalty    *float64         `json:"frequency_penalty"`
+	Seed                *int             `json:"seed"`
+	User                string           `json:"user"`
+	Tools               []any            `json:"tools"`
+	ToolChoice          any              `json:"tool_choice"`
+	ParallelToolCalls   *bool            `json:"parallel_tool_calls"`
+	ReasoningEffort     string           `json:"reasoning_effort"`
+	ResponseFormat      struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+// stopSequences returns the stop parameter, a string or a list, as a list.
+func (r chatRequest) stopSequences() []any {
+	switch s := r.Stop.(type) {
+	case string:
+		return []any{s}
+	case []any:
+		return s
+	}
+	return nil
+}
+
+// toolNames maps the IDs of the assistant's tool calls to their function
+// names, which tool results are identified by in some dialects.
+func (r chatRequest) toolNames() map[string]string {
+	names := make(map[string]string)
+	for _, msg := range r.Messages {
+		calls, _ := msg["tool_calls"].([]any)
+		for _, c := range calls {
+			call, _ := c.(map[string]any)
+			fn, _ := call["function"].(map[string]any)
+			id, _ := call["id"].(string)
+			name, _ := fn["name"].(string)
+			names[id] = name
+		}
+	}
+	return names
+}
+
+// toolArguments decodes the JSON arguments string of an OpenAI tool call.
+func toolArguments(call map[string]any) map[string]any {
+	fn, _ := call["function"].(map[string]any)
+	s, _ := fn["arguments"].(string)
+	args := map[string]any{}
+	json.Unmarshal([]byte(s), &args)
+	return args
+}
+
+// splitDataURL returns the media type and the base64 data of a data URL.
+func splitDataURL(url string) (mediaType, data string, ok bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", "", false
+	}
+	meta, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", "", false
+	}
+	mediaType, ok = strings.CutSuffix(meta, ";base64")
+	return mediaType, data, ok
+}
+
+// imageURL returns the URL of an image_url content part.
+func imageURL(part map[string]any) string {
+	switch u := part["image_url"].(type) {
+	case string:
+		return u
+	case map[string]any:
+		s, _ := u["url"].(string)
+		return s
+	}
+	return ""
+}
+
+// chatMessage builds the assistant message of a chat completion.
+func chatMessage(text, reasoning string, calls []any) map[string]any {
+	msg := map[string]any{"role": "assistant", "content": text}
+	if text == "" && len(calls) > 0 {
+		msg["content"] = nil
+	}
+	if reasoning != "" {
+		msg["reasoning_content"] = reasoning
+	}
+	if len(calls) > 0 {
+		for _, c := range calls {
+			delete(c.(map[string]any), "index") // only chunks number their calls
+		}
+		msg["tool_calls"] = calls
+	}
+	return msg
+}
+
+// chatUsage builds an OpenAI usage object.
+func chatUsage(prompt, completion, cached int) map[string]any {
+	usage := map[string]any{
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+	}
+	if cached > 0 {
+		usage["prompt_tokens_details"] = map[string]any{"cached_tokens": cached}
+	}
+	return usage
+}
+
+// chatCompletion builds a chat completion from its choices.
+func chatCompletion(id, model string, choices []any, usage map[string]any) ([]byte, error) {
+	resp := map[string]any{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": choices,
+	}
+	if usage != nil {
+		resp["usage"] = usage
+	}
+	return json.Marshal(resp)
+}
+
+// chunkWriter builds the chunks of a translated stream.
+type chunkWriter struct {
+	id      string
+	model   string
+	created int64
+	started map[int]bool // choices whose first chunk, carrying the role, went out
+}
+
+// chunk encodes a chunk with one choice; a nil finish reason is sent as null.
+func (cw *chunkWriter) chunk(index int, delta map[string]any, finish any, usage map[string]any) []byte {
+	if cw.started == nil {
+		cw.started = make(map[int]bool)
+		cw.created = time.Now().Unix()
+	}
+	if !cw.started[index] {
+		cw.started[index] = true
+		delta["role"] = "assistant"
+	}
+	chunk := map[string]any{
+		"id":      cw.id,
+		"object":  "chat.completion.chunk",
+		"created": cw.created,
+		"model":   cw.model,
+		"choices": []any{map[string]any{"index": index, "delta": delta, "finish_reason": finish}},
+	}
+	if usage != nil {
+		chunk["usage"] = usage
+	}
+	data, _ := json.Marshal(chunk)
+	return data
+}
+
+// streamDone ends a translated stream.
+var streamDone = []byte("[DONE]")
+
+func intValue(v any) int {
+	f, _ := v.(float64)
+	return int(f)
+}
@@ -0,0 +1,81 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// effortDisablesThinking reports whether an OpenAI reasoning_effort value
+// asks for no reasoning at all.
+func effortDisablesThinking(effort string) bool {
+	return effort == "none" || effort == "minimal"
+}
+
+// thinkingBudgets are the thinking token budgets reasoning_effort levels
+// map to for upstreams that take a budget instead of a level: Anthropic's
+// budget_tokens and Gemini's thinkingBudget.
+var thinkingBudgets = map[string]int{
+	"low":    1024,
+	"medium": 4096,
+	"high":   16384,
+	"xhigh":  24576,
+	"max":    24576,
+}
+
+// MapEffortDeepSeek translates reasoning_effort into DeepSeek's knobs:
+// none/minimal disable thinking, low/medium/high become "high" and
+// xhigh becomes "max", the only two levels DeepSeek accepts.
+func MapEffortDeepSeek(body []byte, debug bool) []byte {
+	return mapEffort(body, debug, func(data map[string]any, effort string) {
+		switch {
+		case effortDisablesThinking(effort):
+			delete(data, "reasoning_effort")
+			setThinking(data, "disabled")
+		case effort == "low" || effort == "medium":
+			data["reasoning_effort"] = "high"
+		case effort == "xhigh":
+			data["reasoning_effort"] = "max"
+		}
+	})
+}
+
+// MapEffortThinking translates reasoning_effort into the thinking switch
+// used by Kimi and Zhipu, which have no effort levels, and strips the field.
+func MapEffortThinking(body []byte, debug bool) []byte {
+	return mapEffort(body, debug, func(data map[string]any, effort string) {
+		delete(data, "reasoning_effort")
+		if effortDisablesThinking(effort) {
+			setThinking(data, "disabled")
+		} else {
+			setThinking(data, "enabled")
+		}
+	})
+}
+
+// setThinking sets thinking.type unless the client sent thinking itself.
+func setThinking(data map[string]any, typ string) {
+	if _, exists := data["thinking"]; !exists {
+		data["thinking"] = map[string]any{"type": typ}
+	}
+}
+
+// mapEffort applies fn when the request has a string reasoning_effort and
+// logs the result when debug is enabled.
+func mapEffort(body []byte, debug bool, fn func(data map[string]any, effort string)) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	effort, ok := data["reasoning_effort"].(string)
+	if !ok {
+		return body
+	}
+	fn(data, effort)
+	if debug {
+		fmt.Printf("  ↔ reasoning_effort %q → reasoning_effort=%v thinking=%v\n", effort, data["reasoning_effort"], data["thinking"])
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
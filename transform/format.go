@@ -0,0 +1,121 @@
+package transform
+
+import "strings"
+
+// ReasoningFormat describes how reasoning is embedded into content: Open and
+// Close wrap it, and with Quote every reasoning line is also prefixed "> ".
+// The same format is used to find and extract reasoning from the assistant
+// messages clients send back.
+type ReasoningFormat struct {
+	Open  string
+	Close string
+	Quote bool
+}
+
+// DefaultReasoningFormat wraps reasoning in <thought> tags.
+var DefaultReasoningFormat = TagFormat("thought")
+
+// TagFormat wraps reasoning in <tag>…</tag>, e.g. "think" or "reasoning".
+func TagFormat(tag string) ReasoningFormat {
+	return ReasoningFormat{Open: "<" + tag + ">\n", Close: "\n</" + tag + ">\n\n"}
+}
+
+// BlockquoteFormat renders reasoning as a markdown blockquote.
+func BlockquoteFormat() ReasoningFormat {
+	return ReasoningFormat{Close: "\n\n", Quote: true}
+}
+
+// CustomFormat wraps reasoning in arbitrary prefix/suffix strings.
+func CustomFormat(prefix, suffix string) ReasoningFormat {
+	return ReasoningFormat{Open: prefix, Close: suffix}
+}
+
+// wrap returns reasoning fully formatted, for non-streaming responses.
+func (f ReasoningFormat) wrap(reasoning string) string {
+	if f.Quote {
+		lineStart := true
+		reasoning = f.quote(reasoning, &lineStart)
+	}
+	return f.Open + reasoning + f.Close
+}
+
+// quote prefixes every line of s with "> ". lineStart carries whether the
+// next character begins a line across streamed chunks.
+func (f ReasoningFormat) quote(s string, lineStart *bool) string {
+	if !f.Quote || s == "" {
+		return s
+	}
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		if *lineStart {
+			b.WriteString("> ")
+		}
+		b.WriteString(line)
+		*lineStart = strings.HasSuffix(line, "\n")
+	}
+	return b.String()
+}
+
+// Extract finds reasoning embedded in content. For wrapped formats it handles:
+//  1. open…close — normal extraction
+//  2. open only (unclosed) — everything after is treated as reasoning
+//  3. close only (orphan close) — removed as dirty data
+//
+// For blockquotes, the leading quoted lines are the reasoning.
+func (f ReasoningFormat) Extract(content string) (thought, cleaned string, found bool) {
+	if f.Quote {
+		return extractQuote(content)
+	}
+
+	open, close := strings.TrimSpace(f.Open), strings.TrimSpace(f.Close)
+	startIdx := strings.Index(content, open)
+	endIdx := -1
+	if startIdx >= 0 {
+		if i := strings.Index(content[startIdx+len(open):], close); i >= 0 {
+			endIdx = startIdx + len(open) + i
+		}
+	} else {
+		endIdx = strings.Index(content, close)
+	}
+
+	if startIdx >= 0 {
+		prefix := content[:startIdx]
+		if endIdx > startIdx {
+			thought = content[startIdx+len(open) : endIdx]
+			cleaned = prefix + content[endIdx+len(close):]
+			return thought, cleaned, true
+		}
+		// Unclosed: treat everything after open marker as thought
+		thought = content[startIdx+len(open):]
+		cleaned = prefix
+		return thought, cleaned, true
+	}
+
+	if endIdx >= 0 {
+		cleaned = strings.ReplaceAll(content, close, "")
+		return "", cleaned, true
+	}
+
+	return "", content, false
+}
+
+func extractQuote(content string) (thought, cleaned string, found bool) {
+	lines := strings.SplitAfter(content, "\n")
+	var b strings.Builder
+	n := 0
+	for _, line := range lines {
+		rest, ok := strings.CutPrefix(line, ">")
+		if !ok {
+			break
+		}
+		b.WriteString(strings.TrimPrefix(rest, " "))
+		n++
+	}
+	if n == 0 {
+		return "", content, false
+	}
+	return b.String(), strings.Join(lines[n:], ""), true
+}
@@ -0,0 +1,286 @@
+package transform
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+)
+
+// Chat requests and responses in Gemini's generateContent API, for
+// providers of type gemini.
+
+// ChatToGemini converts an OpenAI chat request to a generateContent
+// request, which carries neither the model nor the stream flag: both are
+// part of the URL. System and developer messages become the system
+// instruction, tool results user turns with functionResponse parts, and
+// consecutive turns of one role are merged. Images must be data URLs;
+// others are left out.
+func ChatToGemini(body []byte) (map[string]any, error) {
+	var req chatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	out := make(map[string]any)
+	gen := make(map[string]any)
+	if req.Temperature != nil {
+		gen["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		gen["topP"] = *req.TopP
+	}
+	if n := cmp.Or(req.MaxCompletionTokens, req.MaxTokens); n > 0 {
+		gen["maxOutputTokens"] = n
+	}
+	if stop := req.stopSequences(); len(stop) > 0 {
+		gen["stopSequences"] = stop
+	}
+	if req.N > 1 {
+		gen["candidateCount"] = req.N
+	}
+	if req.PresencePenalty != nil {
+		gen["presencePenalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		gen["frequencyPenalty"] = *req.FrequencyPenalty
+	}
+	if req.Seed != nil {
+		gen["seed"] = *req.Seed
+	}
+	if req.ResponseFormat.Type == "json_object" {
+		gen["responseMimeType"] = "application/json"
+	}
+	if effortDisablesThinking(req.ReasoningEffort) {
+		gen["thinkingConfig"] = map[string]any{"thinkingBudget": 0}
+	} else if budget, ok := thinkingBudgets[req.ReasoningEffort]; ok {
+		// Thought parts are only returned on request
+		gen["thinkingConfig"] = map[string]any{"thinkingBudget": budget, "includeThoughts": true}
+	}
+	if len(gen) > 0 {
+		out["generationConfig"] = gen
+	}
+
+	names := req.toolNames()
+	var system, contents []any
+	add := func(role string, parts []any) {
+		if len(parts) == 0 {
+			return
+		}
+		if n := len(contents); n > 0 {
+			if last := contents[n-1].(map[string]any); last["role"] == role {
+				last["parts"] = append(last["parts"].([]any), parts...)
+				return
+			}
+		}
+		contents = append(contents, map[string]any{"role": role, "parts": parts})
+	}
+	for _, msg := range req.Messages {
+		switch msg["role"] {
+		case "system", "developer":
+			if text := contentText(msg["content"]); text != "" {
+				system = append(system, map[string]any{"text": text})
+			}
+		case "assistant":
+			parts := geminiParts(msg["content"])
+			calls, _ := msg["tool_calls"].([]any)
+			for _, c := range calls {
+				call, _ := c.(map[string]any)
+				fn, _ := call["function"].(map[string]any)
+				parts = append(parts, map[string]any{
+					"functionCall": map[string]any{"name": fn["name"], "args": toolArguments(call)},
+				})
+			}
+			add("model", parts)
+		case "tool":
+			id, _ := msg["tool_call_id"].(string)
+			text := contentText(msg["content"])
+			// The response must be an object; other results are wrapped
+			var response map[string]any
+			if json.Unmarshal([]byte(text), &response) != nil || response == nil {
+				response = map[string]any{"content": text}
+			}
+			add("user", []any{map[string]any{
+				"functionResponse": map[string]any{"name": names[id], "response": response},
+			}})
+		default:
+			add("user", geminiParts(msg["content"]))
+		}
+	}
+	if len(system) > 0 {
+		out["systemInstruction"] = map[string]any{"parts": system}
+	}
+	if contents == nil {
+		contents = []any{}
+	}
+	out["contents"] = contents
+
+	if tools := ToolsToGemini(req.Tools); len(tools) > 0 {
+		out["tools"] = tools
+		if req.ToolChoice != nil {
+			out["toolConfig"] = ToolChoiceToGemini(req.ToolChoice)
+		}
+	}
+	return out, nil
+}
+
+// geminiParts converts message content to parts: text, and images given as
+// data URLs.
+func geminiParts(content any) []any {
+	switch c := content.(type) {
+	case string:
+		if c == "" {
+			return nil
+		}
+		return []any{map[string]any{"text": c}}
+	case []any:
+		var parts []any
+		for _, p := range c {
+			part, _ := p.(map[string]any)
+			switch part["type"] {
+			case "text":
+				if s, _ := part["text"].(string); s != "" {
+					parts = append(parts, map[string]any{"text": s})
+				}
+			case "image_url":
+				if mediaType, data, ok := splitDataURL(imageURL(part)); ok {
+					parts = append(parts, map[string]any{"inlineData": map[string]any{"mimeType": mediaType, "data": data}})
+				}
+			}
+		}
+		return parts
+	}
+	return nil
+}
+
+// geminiCandidate splits the parts of a candidate into its reasoning
+// (thought parts), its text and its function calls, numbered from first.
+func geminiCandidate(candidate map[string]any, first int) (reasoning, text string, calls []any) {
+	content, _ := candidate["content"].(map[string]any)
+	parts, _ := content["parts"].([]any)
+	var answer []any
+	for _, p := range parts {
+		if part, _ := p.(map[string]any); part["thought"] == true {
+			reasoning += str(part["text"])
+		} else {
+			answer = append(answer, p)
+		}
+	}
+	text, calls = ToolCallsFromGemini(answer)
+	for i, c := range calls {
+		call := c.(map[string]any)
+		call["index"] = first + i
+		call["id"] = fmt.Sprintf("call_%d", first+i)
+	}
+	return reasoning, text, calls
+}
+
+// geminiFinish maps a finishReason to an OpenAI finish_reason; a candidate
+// that called functions finished for that.
+func geminiFinish(reason string, called bool) any {
+	switch reason {
+	case "":
+		return nil
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	}
+	if called {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// geminiUsage converts usageMetadata. Thinking tokens are billed as output.
+func geminiUsage(u map[string]any) map[string]any {
+	if u == nil {
+		return nil
+	}
+	completion := intValue(u["candidatesTokenCount"]) + intValue(u["thoughtsTokenCount"])
+	return chatUsage(intValue(u["promptTokenCount"]), completion, intValue(u["cachedContentTokenCount"]))
+}
+
+// geminiResponse is the part of a generateContent response the
+// translations read.
+type geminiResponse struct {
+	ResponseID    string           `json:"responseId"`
+	ModelVersion  string           `json:"modelVersion"`
+	Candidates    []map[string]any `json:"candidates"`
+	UsageMetadata map[string]any   `json:"usageMetadata"`
+}
+
+// GeminiToChat converts a generateContent response for model to an OpenAI
+// chat completion. Thought parts become reasoning_content; function calls,
+// which have no IDs, get IDs from their position.
+func GeminiToChat(body []byte, model string) ([]byte, error) {
+	var resp geminiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	choices := make([]any, 0, len(resp.Candidates))
+	for i, c := range resp.Candidates {
+		reasoning, text, calls := geminiCandidate(c, 0)
+		choices = append(choices, map[string]any{
+			"index":         cmp.Or(intValue(c["index"]), i),
+			"message":       chatMessage(text, reasoning, calls),
+			"finish_reason": cmp.Or(geminiFinish(str(c["finishReason"]), len(calls) > 0), any("stop")),
+		})
+	}
+	return chatCompletion(resp.ResponseID, cmp.Or(resp.ModelVersion, model), choices, geminiUsage(resp.UsageMetadata))
+}
+
+// GeminiStream converts the responses of a streamed generateContent call,
+// each a partial response, to OpenAI chat completion chunks.
+type GeminiStream struct {
+	Model    string // reported when the upstream does not name its model version
+	chunks   chunkWriter
+	calls    map[int]int // function calls so far, by candidate
+	finished bool
+}
+
+// Event converts the data of one event and returns the data of the chunks
+// to send.
+func (s *GeminiStream) Event(data []byte) [][]byte {
+	var resp geminiResponse
+	if json.Unmarshal(data, &resp) != nil {
+		return nil
+	}
+	if s.calls == nil {
+		s.calls = make(map[int]int)
+		s.chunks.id, s.chunks.model = resp.ResponseID, cmp.Or(resp.ModelVersion, s.Model)
+	}
+	var out [][]byte
+	for i, c := range resp.Candidates {
+		index := cmp.Or(intValue(c["index"]), i)
+		reasoning, text, calls := geminiCandidate(c, s.calls[index])
+		s.calls[index] += len(calls)
+		delta := make(map[string]any)
+		if reasoning != "" {
+			delta["reasoning_content"] = reasoning
+		}
+		if text != "" {
+			delta["content"] = text
+		}
+		if len(calls) > 0 {
+			delta["tool_calls"] = calls
+		}
+		finish := geminiFinish(str(c["finishReason"]), s.calls[index] > 0)
+		var usage map[string]any
+		if finish != nil {
+			s.finished = true
+			usage = geminiUsage(resp.UsageMetadata)
+		}
+		if len(delta) > 0 || finish != nil {
+			out = append(out, s.chunks.chunk(index, delta, finish, usage))
+		}
+	}
+	return out
+}
+
+// End returns what follows the last event: "[DONE]" once a candidate has
+// finished, as the stream itself has no end marker.
+func (s *GeminiStream) End() [][]byte {
+	if !s.finished {
+		return nil
+	}
+	return [][]byte{streamDone}
+}
@@ -0,0 +1,181 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"unicode/utf8"
+)
+
+// Guardrail actions.
+const (
+	GuardrailRedact = "redact" // replace the matched span with Message
+	GuardrailBlock  = "block"  // end the answer before the match, with Message
+	GuardrailAlert  = "alert"  // only log the match
+)
+
+// Guardrail is a rule checked against the content of responses.
+type Guardrail struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Action  string
+	Message string
+}
+
+// Guardrails checks the content of one response against rules, per choice
+// index. Streamed text is checked as it arrives; the last window bytes of
+// it are held back, so a match spanning chunks is caught as long as it is
+// no longer than that.
+type Guardrails struct {
+	rules   []Guardrail
+	window  int
+	held    map[int]string
+	alerted map[string]bool // rules already logged for this response
+	blocked bool
+}
+
+// NewGuardrails returns the checker of one response, or nil without rules.
+func NewGuardrails(rules []Guardrail, window int) *Guardrails {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Guardrails{rules: rules, window: window, held: make(map[int]string), alerted: make(map[string]bool)}
+}
+
+// Blocked reports whether a block rule ended the answer.
+func (g *Guardrails) Blocked() bool {
+	return g != nil && g.blocked
+}
+
+// CheckDelta applies the rules to the content of a choice delta. A blocked
+// answer gets finish_reason "content_filter"; the stream should end there.
+// Held back text is released with the choice's finish_reason.
+func (g *Guardrails) CheckDelta(choice map[string]any) {
+	if g == nil || g.blocked {
+		return
+	}
+	idx, _ := choice["index"].(float64)
+	delta, _ := choice["delta"].(map[string]any)
+	content, _ := delta["content"].(string)
+	if content == "" && g.held[int(idx)] == "" {
+		return
+	}
+	text := g.check(int(idx), g.held[int(idx)]+content, choice["finish_reason"] != nil)
+	if delta == nil {
+		delta = map[string]any{}
+		choice["delta"] = delta
+	}
+	delta["content"] = text
+	if g.blocked {
+		choice["finish_reason"] = "content_filter"
+	}
+}
+
+// FlushSSE returns SSE chunks with the text still held back, for streams
+// that end without a finish_reason.
+func (g *Guardrails) FlushSSE() string {
+	if g == nil || g.blocked {
+		return ""
+	}
+	var out string
+	for _, idx := range slices.Sorted(maps.Keys(g.held)) {
+		if g.held[idx] == "" {
+			continue
+		}
+		choice := map[string]any{"index": idx, "delta": map[string]any{"content": g.check(idx, g.held[idx], true)}}
+		if g.blocked {
+			choice["finish_reason"] = "content_filter"
+		}
+		b, _ := json.Marshal(map[string]any{"choices": []any{choice}})
+		out += "data: " + string(b) + "\n\n"
+		if g.blocked {
+			break
+		}
+	}
+	return out
+}
+
+// CheckBody applies the rules to the message content of every choice of a
+// chat completion.
+func (g *Guardrails) CheckBody(body []byte) []byte {
+	if g == nil {
+		return body
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	choices, _ := data["choices"].([]any)
+	changed := false
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		msg, _ := choice["message"].(map[string]any)
+		content, ok := msg["content"].(string)
+		if !ok {
+			continue
+		}
+		g.blocked = false
+		idx, _ := choice["index"].(float64)
+		if text := g.check(int(idx), content, true); text != content {
+			msg["content"] = text
+			changed = true
+		}
+		if g.blocked {
+			choice["finish_reason"] = "content_filter"
+		}
+	}
+	if !changed {
+		return body
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// check returns the part of text that may be sent, with the rules applied,
+// and holds back the rest. With final nothing is held back.
+func (g *Guardrails) check(idx int, text string, final bool) string {
+	cut := len(text)
+	if !final {
+		cut = max(cut-g.window, 0)
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		// A match running into the held back text is checked once it is
+		// complete; moving the cut may make it split another one
+		for moved := true; moved; {
+			moved = false
+			for _, rule := range g.rules {
+				for _, loc := range rule.Pattern.FindAllStringIndex(text, -1) {
+					if loc[0] < cut && loc[1] > cut {
+						cut, moved = loc[0], true
+					}
+				}
+			}
+		}
+	}
+	text, g.held[idx] = text[:cut], text[cut:]
+
+	for _, rule := range g.rules {
+		switch rule.Action {
+		case GuardrailRedact:
+			text = rule.Pattern.ReplaceAllLiteralString(text, rule.Message)
+		case GuardrailBlock:
+			if loc := rule.Pattern.FindStringIndex(text); loc != nil {
+				fmt.Printf("  ✗ guardrail %q blocked the answer\n", rule.Name)
+				g.blocked = true
+				g.held[idx] = ""
+				return text[:loc[0]] + rule.Message
+			}
+		case GuardrailAlert:
+			if !g.alerted[rule.Name] && rule.Pattern.MatchString(text) {
+				fmt.Printf("  ⚠ guardrail %q matched the answer\n", rule.Name)
+				g.alerted[rule.Name] = true
+			}
+		}
+	}
+	return text
+}
@@ -0,0 +1,194 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // decoded for shrinking
+	"image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// jpegQualities are tried in turn until a shrunk image fits its limit.
+var jpegQualities = []int{85, 70, 55, 40}
+
+// ParseDataURL splits a base64 data URL of an image into its media type
+// and the decoded bytes.
+func ParseDataURL(url string) (mediaType string, data []byte, err error) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", nil, errors.New("not a data URL")
+	}
+	meta, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, errors.New("data URL has no data")
+	}
+	mediaType, ok = strings.CutSuffix(meta, ";base64")
+	if !ok {
+		return "", nil, errors.New("data URL is not base64")
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", nil, fmt.Errorf("data URL has media type %q, not an image", mediaType)
+	}
+	data, err = base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("data URL: %w", err)
+	}
+	return mediaType, data, nil
+}
+
+// DataURL encodes an image as a base64 data URL.
+func DataURL(mediaType string, data []byte) string {
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// ReplaceImages replaces the image_url parts of a chat request with text
+// parts holding what replace returns for their URL, and returns how many it
+// replaced. Content left with only text parts becomes a plain string, which
+// text-only upstreams are more likely to accept than a part array.
+func ReplaceImages(body []byte, replace func(url string) string) ([]byte, int) {
+	if !bytes.Contains(body, []byte(`"image_url"`)) {
+		return body, 0
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body, 0
+	}
+	messages, _ := data["messages"].([]any)
+	replaced := 0
+	for _, m := range messages {
+		msg, _ := m.(map[string]any)
+		parts, ok := msg["content"].([]any)
+		if !ok {
+			continue
+		}
+		var texts []string
+		textOnly, hadImages := true, false
+		for i, p := range parts {
+			part, _ := p.(map[string]any)
+			if part["type"] == "image_url" {
+				image, _ := part["image_url"].(map[string]any)
+				url, _ := image["url"].(string)
+				part = map[string]any{"type": "text", "text": replace(url)}
+				parts[i] = part
+				replaced++
+				hadImages = true
+			}
+			if part["type"] != "text" {
+				textOnly = false
+				continue
+			}
+			text, _ := part["text"].(string)
+			texts = append(texts, text)
+		}
+		if textOnly && hadImages {
+			msg["content"] = strings.Join(texts, "\n")
+		}
+	}
+	if replaced == 0 {
+		return body, 0
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody, replaced
+	}
+	return body, 0
+}
+
+// ShrinkImage scales an image down so its longest side is at most maxDim
+// pixels and re-encodes it to at most maxBytes, a zero limit being none.
+// PNGs that fit once scaled stay PNGs; anything else becomes a JPEG of the
+// best quality that fits, scaled down further if even the lowest does not.
+// Images within both limits are returned as they are, with changed false.
+// Only JPEG, PNG and GIF images can be shrunk.
+func ShrinkImage(data []byte, maxBytes, maxDim int) (out []byte, mediaType string, changed bool, err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", false, fmt.Errorf("unsupported image: %w", err)
+	}
+	tooLarge := maxBytes > 0 && len(data) > maxBytes
+	longest := max(cfg.Width, cfg.Height)
+	if !tooLarge && (maxDim == 0 || longest <= maxDim) {
+		return data, "image/" + format, false, nil
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", false, fmt.Errorf("decode %s image: %w", format, err)
+	}
+
+	scale := 1.0
+	if maxDim > 0 && longest > maxDim {
+		scale = float64(maxDim) / float64(longest)
+	}
+	for {
+		w, h := max(int(float64(cfg.Width)*scale), 1), max(int(float64(cfg.Height)*scale), 1)
+		img := scaleDown(src, w, h)
+		var buf bytes.Buffer
+		if format == "png" {
+			png.Encode(&buf, img)
+			if maxBytes == 0 || buf.Len() <= maxBytes {
+				return buf.Bytes(), "image/png", true, nil
+			}
+		}
+		// JPEG has no transparency; transparent pixels turn white
+		opaque := image.NewRGBA(img.Bounds())
+		draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(opaque, opaque.Bounds(), img, image.Point{}, draw.Over)
+		for _, q := range jpegQualities {
+			buf.Reset()
+			if err := jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: q}); err != nil {
+				return nil, "", false, err
+			}
+			if maxBytes == 0 || buf.Len() <= maxBytes {
+				return buf.Bytes(), "image/jpeg", true, nil
+			}
+		}
+		if w == 1 && h == 1 {
+			return nil, "", false, fmt.Errorf("cannot shrink image below %d bytes", maxBytes)
+		}
+		scale *= 0.75
+	}
+}
+
+// scaleDown resizes src to w×h by averaging the source pixels each target
+// pixel covers, which keeps fine detail from aliasing.
+func scaleDown(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	}
+	sw, sh := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	if sw == w && sh == h {
+		return rgba
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := range w {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride+x0*4 : sy*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := y*dst.Stride + x*4
+			for c := range 4 {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
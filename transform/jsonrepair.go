@@ -0,0 +1,176 @@
+package transform
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// RepairJSON fixes the JSON mistakes models commonly make in tool call
+// arguments: markdown code fences, single-quoted strings, Python literals,
+// trailing commas, raw newlines in strings, and output truncated mid-value.
+// It returns s unchanged when it is already valid, and ok=false when it
+// cannot be repaired.
+func RepairJSON(s string) (repaired string, ok bool) {
+	if json.Valid([]byte(s)) {
+		return s, true
+	}
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return "{}", true
+	}
+	trimmed = stripCodeFence(trimmed)
+
+	r := jsonRepairer{}
+	r.scan(trimmed)
+	if out := closeJSON(r.out.String(), r.stack, r.inStr); json.Valid([]byte(out)) {
+		return out, true
+	}
+	// Truncated inside an incomplete member (e.g. a key without value):
+	// fall back to the last complete element
+	for i := len(r.checkpoints) - 1; i >= 0; i-- {
+		cp := r.checkpoints[i]
+		if out := closeJSON(r.out.String()[:cp.pos], cp.stack, false); json.Valid([]byte(out)) {
+			return out, true
+		}
+	}
+	return s, false
+}
+
+func stripCodeFence(s string) string {
+	if !strings.HasPrefix(s, "```") {
+		return s
+	}
+	s = strings.TrimPrefix(s, "```")
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:] // language tag
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "```"))
+}
+
+type jsonCheckpoint struct {
+	pos   int
+	stack []byte
+}
+
+type jsonRepairer struct {
+	out         strings.Builder
+	stack       []byte // open '{' / '['
+	inStr       bool
+	quote       byte // quote character that opened the current string
+	escape      bool
+	checkpoints []jsonCheckpoint // output positions before each comma
+}
+
+func (r *jsonRepairer) scan(s string) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if r.inStr {
+			r.scanString(c)
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			r.inStr, r.quote = true, c
+			r.out.WriteByte('"')
+		case '{', '[':
+			r.stack = append(r.stack, c)
+			r.out.WriteByte(c)
+		case '}', ']':
+			r.trimTrailingComma()
+			if n := len(r.stack); n > 0 {
+				r.stack = r.stack[:n-1]
+			}
+			r.out.WriteByte(c)
+		case ',':
+			r.checkpoints = append(r.checkpoints, jsonCheckpoint{pos: r.out.Len(), stack: slices.Clone(r.stack)})
+			r.out.WriteByte(c)
+		default:
+			if isIdentByte(c) {
+				j := i
+				for j < len(s) && isIdentByte(s[j]) {
+					j++
+				}
+				r.out.WriteString(pythonLiteral(s[i:j]))
+				i = j - 1
+				continue
+			}
+			r.out.WriteByte(c)
+		}
+	}
+}
+
+func (r *jsonRepairer) scanString(c byte) {
+	switch {
+	case r.escape:
+		r.escape = false
+		if c == '\'' {
+			r.out.WriteByte('\'') // \' is not a JSON escape
+			return
+		}
+		r.out.WriteByte('\\')
+		r.out.WriteByte(c)
+	case c == '\\':
+		r.escape = true
+	case c == r.quote:
+		r.inStr = false
+		r.out.WriteByte('"')
+	case c == '"':
+		r.out.WriteString(`\"`) // inside a single-quoted string
+	case c == '\n':
+		r.out.WriteString(`\n`)
+	case c == '\r':
+		r.out.WriteString(`\r`)
+	case c == '\t':
+		r.out.WriteString(`\t`)
+	default:
+		r.out.WriteByte(c)
+	}
+}
+
+func (r *jsonRepairer) trimTrailingComma() {
+	s := strings.TrimRight(r.out.String(), " \t\r\n")
+	if strings.HasSuffix(s, ",") {
+		r.out.Reset()
+		r.out.WriteString(s[:len(s)-1])
+		r.checkpoints = slices.DeleteFunc(r.checkpoints, func(cp jsonCheckpoint) bool { return cp.pos >= r.out.Len() })
+	}
+}
+
+// closeJSON finishes truncated output: an open string is closed, a dangling
+// comma dropped, a key without value given null, and open containers closed.
+func closeJSON(out string, stack []byte, inString bool) string {
+	if inString {
+		out += `"`
+	}
+	out = strings.TrimRight(out, " \t\r\n")
+	out = strings.TrimSuffix(out, ",")
+	if strings.HasSuffix(out, ":") {
+		out += "null"
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == '{' {
+			out += "}"
+		} else {
+			out += "]"
+		}
+	}
+	return out
+}
+
+func isIdentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// pythonLiteral maps Python's True/False/None to JSON.
+func pythonLiteral(word string) string {
+	switch word {
+	case "True":
+		return "true"
+	case "False":
+		return "false"
+	case "None":
+		return "null"
+	}
+	return word
+}
@@ -0,0 +1,131 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// JSONResponseFormat reports whether a request asks for JSON output with
+// response_format, and returns the schema of a json_schema format.
+func JSONResponseFormat(body []byte) (bool, map[string]any) {
+	var req struct {
+		ResponseFormat struct {
+			Type       string `json:"type"`
+			JSONSchema struct {
+				Schema map[string]any `json:"schema"`
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return false, nil
+	}
+	switch req.ResponseFormat.Type {
+	case "json_object":
+		return true, nil
+	case "json_schema":
+		return true, req.ResponseFormat.JSONSchema.Schema
+	}
+	return false, nil
+}
+
+// ValidateJSON checks that content is a JSON value and, with a schema,
+// that it conforms. Only the commonly used keywords are checked: type,
+// enum, const, properties, required, additionalProperties and items.
+func ValidateJSON(content string, schema map[string]any) error {
+	var v any
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return fmt.Errorf("not valid JSON: %v", err)
+	}
+	if schema == nil {
+		if _, ok := v.(map[string]any); !ok {
+			return fmt.Errorf("expected a JSON object")
+		}
+		return nil
+	}
+	return validateSchema(v, schema, "$")
+}
+
+func validateSchema(v any, schema map[string]any, path string) error {
+	if t, ok := schema["type"]; ok && !matchesType(v, t) {
+		return fmt.Errorf("%s: expected %v, got %s", path, t, jsonType(v))
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return jsonEqual(e, v) }) {
+		return fmt.Errorf("%s: %v is not one of %v", path, v, enum)
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		return fmt.Errorf("%s: expected %v", path, c)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, val := range v {
+			sub, ok := props[name].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+				continue
+			}
+			if err := validateSchema(val, sub, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matchesType checks v against a type keyword, a name or a list of names.
+func matchesType(v any, t any) bool {
+	switch t := t.(type) {
+	case string:
+		got := jsonType(v)
+		return got == t || t == "number" && got == "integer"
+	case []any:
+		return slices.ContainsFunc(t, func(name any) bool { return matchesType(v, name) })
+	}
+	return true
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func jsonEqual(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
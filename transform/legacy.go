@@ -0,0 +1,145 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LegacyFunctionsToTools converts the deprecated functions/function_call
+// request shape of older SDKs to tools/tool_choice, including function
+// calls and results in the history. It reports whether the request used
+// the legacy shape, in which case the response must be converted back with
+// ToolCallsToFunctionCall.
+func LegacyFunctionsToTools(body []byte) ([]byte, bool) {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body, false
+	}
+	functions, hasFunctions := data["functions"].([]any)
+	functionCall, hasCall := data["function_call"]
+	if !hasFunctions && !hasCall {
+		return body, false
+	}
+
+	if hasFunctions {
+		tools := make([]any, 0, len(functions))
+		for _, fn := range functions {
+			tools = append(tools, map[string]any{"type": "function", "function": fn})
+		}
+		data["tools"] = tools
+		delete(data, "functions")
+	}
+	if hasCall {
+		switch c := functionCall.(type) {
+		case string: // "none" and "auto" mean the same for tool_choice
+			data["tool_choice"] = c
+		case map[string]any:
+			data["tool_choice"] = map[string]any{"type": "function", "function": map[string]any{"name": c["name"]}}
+		}
+		delete(data, "function_call")
+	}
+
+	// Legacy calls carry no IDs: give each one an ID and the function
+	// result that follows it the same one
+	messages, _ := data["messages"].([]any)
+	callID := ""
+	for i, m := range messages {
+		msg, _ := m.(map[string]any)
+		switch {
+		case msg["role"] == "assistant" && msg["function_call"] != nil:
+			callID = fmt.Sprintf("call_legacy_%d", i)
+			msg["tool_calls"] = []any{map[string]any{
+				"id":       callID,
+				"type":     "function",
+				"function": msg["function_call"],
+			}}
+			delete(msg, "function_call")
+		case msg["role"] == "function":
+			msg["role"] = "tool"
+			msg["tool_call_id"] = callID
+			delete(msg, "name")
+		}
+	}
+
+	newBody, err := json.Marshal(data)
+	if err != nil {
+		return body, false
+	}
+	return newBody, true
+}
+
+// ToolCallsToFunctionCall converts the tool calls of a non-streaming
+// response back to the legacy function_call. Legacy clients expect at most
+// one call, so only the first is kept.
+func ToolCallsToFunctionCall(body []byte) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	choices, _ := data["choices"].([]any)
+	changed := false
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		msg, _ := choice["message"].(map[string]any)
+		calls, _ := msg["tool_calls"].([]any)
+		if len(calls) == 0 {
+			continue
+		}
+		tc, _ := calls[0].(map[string]any)
+		msg["function_call"] = tc["function"]
+		delete(msg, "tool_calls")
+		if choice["finish_reason"] == "tool_calls" {
+			choice["finish_reason"] = "function_call"
+		}
+		changed = true
+	}
+	if !changed {
+		return body
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// ToolCallDeltaToFunctionCall converts the tool call fragments of a SSE
+// choice to the legacy function_call delta. Fragments of calls after the
+// first are dropped.
+func ToolCallDeltaToFunctionCall(choice map[string]any) {
+	if choice["finish_reason"] == "tool_calls" {
+		choice["finish_reason"] = "function_call"
+	}
+	delta, _ := choice["delta"].(map[string]any)
+	calls, ok := delta["tool_calls"].([]any)
+	if !ok {
+		return
+	}
+	delete(delta, "tool_calls")
+	for _, raw := range calls {
+		tc, _ := raw.(map[string]any)
+		switch idx := tc["index"].(type) {
+		case float64: // decoded from upstream
+			if idx != 0 {
+				continue
+			}
+		case int: // released by ToolArgsBuffer
+			if idx != 0 {
+				continue
+			}
+		}
+		fn, ok := tc["function"].(map[string]any)
+		if !ok {
+			continue
+		}
+		// Name and held arguments may arrive as separate fragments
+		if prev, ok := delta["function_call"].(map[string]any); ok {
+			for k, v := range fn {
+				s, _ := v.(string)
+				p, _ := prev[k].(string)
+				prev[k] = p + s
+			}
+			continue
+		}
+		delta["function_call"] = fn
+	}
+}
@@ -0,0 +1,57 @@
+package transform
+
+import "encoding/json"
+
+// AppendLogprobs appends the token lists ("content", "refusal") of the
+// logprobs object src to those of dst and returns the result; either may
+// be nil.
+func AppendLogprobs(dst, src any) any {
+	from, ok := src.(map[string]any)
+	if !ok {
+		return dst
+	}
+	to, _ := dst.(map[string]any)
+	if to == nil {
+		to = make(map[string]any, len(from))
+	}
+	for _, key := range []string{"content", "refusal"} {
+		tokens, ok := from[key].([]any)
+		if !ok {
+			continue
+		}
+		acc, _ := to[key].([]any)
+		to[key] = append(acc, tokens...)
+	}
+	return to
+}
+
+// StripLogprobsDelta removes logprobs from a SSE choice.
+func StripLogprobsDelta(choice map[string]any) {
+	delete(choice, "logprobs")
+}
+
+// StripLogprobs removes logprobs from every choice of a non-streaming
+// response.
+func StripLogprobs(body []byte) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	choices, _ := data["choices"].([]any)
+
+	changed := false
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		if _, ok := choice["logprobs"]; ok {
+			delete(choice, "logprobs")
+			changed = true
+		}
+	}
+
+	if changed {
+		if newBody, err := json.Marshal(data); err == nil {
+			return newBody
+		}
+	}
+	return body
+}
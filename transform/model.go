@@ -0,0 +1,37 @@
+package transform
+
+import "encoding/json"
+
+// RewriteModel replaces the top-level model field of a request body.
+// Returns the body unchanged if it isn't a JSON object.
+func RewriteModel(body []byte, model string) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	if data["model"] == model {
+		return body
+	}
+	data["model"] = model
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// RewriteResponseModel replaces the model field of a response body or stream
+// chunk, leaving bodies without one (e.g. errors) unchanged.
+func RewriteResponseModel(body []byte, model string) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	if _, ok := data["model"]; !ok || data["model"] == model {
+		return body
+	}
+	data["model"] = model
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
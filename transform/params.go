@@ -0,0 +1,28 @@
+package transform
+
+import (
+	"encoding/json"
+	"maps"
+)
+
+// ApplyParams sets each of defaults the request does not already have, then
+// every value of force regardless of what the client sent.
+func ApplyParams(body []byte, defaults, force map[string]any) []byte {
+	if len(defaults) == 0 && len(force) == 0 {
+		return body
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	for name, v := range defaults {
+		if _, exists := data[name]; !exists {
+			data[name] = v
+		}
+	}
+	maps.Copy(data, force)
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
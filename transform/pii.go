@@ -0,0 +1,219 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Built-in PII patterns. Both are heuristics: phone numbers need at least
+// seven digits in the usual groupings, with an optional country code.
+var (
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	PhonePattern = regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?(?:\(\d{1,4}\)[\s.-]?)?|\(\d{2,4}\)[\s.-]?|\b(?:\d{2,4}[\s.-]?)?)\d{3,4}[\s.-]?\d{4}\b`)
+)
+
+// PIIRule masks the matches of Pattern with placeholders named after Label,
+// such as [EMAIL] or [EMAIL_1].
+type PIIRule struct {
+	Label   string
+	Pattern *regexp.Regexp
+}
+
+// PIIVault records what MaskPII masked in a request. When the masking is
+// reversible it restores the originals in the response; a nil vault
+// restores nothing.
+type PIIVault struct {
+	counts    map[string]int    // label → matches masked
+	originals map[string]string // placeholder → original; empty unless reversible
+	replacer  *strings.Replacer
+}
+
+// MaskPII replaces the matches of rules in the text of the request's
+// messages. With reversible, each distinct value gets a numbered
+// placeholder, the same one wherever it appears; otherwise every match
+// becomes the bare label. The vault is nil when nothing was masked.
+func MaskPII(body []byte, rules []PIIRule, reversible bool) ([]byte, *PIIVault) {
+	if len(rules) == 0 {
+		return body, nil
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body, nil
+	}
+	messages, ok := data["messages"].([]any)
+	if !ok {
+		return body, nil
+	}
+
+	v := &PIIVault{counts: make(map[string]int), originals: make(map[string]string)}
+	placeholders := make(map[string]string) // original → placeholder
+	numbers := make(map[string]int)         // label → placeholders handed out
+	mask := func(text string) string {
+		for _, rule := range rules {
+			text = rule.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+				v.counts[rule.Label]++
+				if !reversible {
+					return "[" + rule.Label + "]"
+				}
+				if ph, ok := placeholders[match]; ok {
+					return ph
+				}
+				numbers[rule.Label]++
+				ph := fmt.Sprintf("[%s_%d]", rule.Label, numbers[rule.Label])
+				placeholders[match] = ph
+				v.originals[ph] = match
+				return ph
+			})
+		}
+		return text
+	}
+	for _, m := range messages {
+		if msg, ok := m.(map[string]any); ok && msg["content"] != nil {
+			msg["content"] = mapContentText(msg["content"], mask)
+		}
+	}
+	if len(v.counts) == 0 {
+		return body, nil
+	}
+
+	newBody, err := json.Marshal(data)
+	if err != nil {
+		return body, nil
+	}
+	pairs := make([]string, 0, 2*len(v.originals))
+	for ph, original := range v.originals {
+		pairs = append(pairs, ph, original)
+	}
+	v.replacer = strings.NewReplacer(pairs...)
+	return newBody, v
+}
+
+// mapContentText applies f to the text of message content, which is either
+// a string or an array of content parts.
+func mapContentText(content any, f func(string) string) any {
+	switch c := content.(type) {
+	case string:
+		return f(c)
+	case []any:
+		for _, p := range c {
+			if part, ok := p.(map[string]any); ok && part["type"] == "text" {
+				if text, ok := part["text"].(string); ok {
+					part["text"] = f(text)
+				}
+			}
+		}
+	}
+	return content
+}
+
+// Summary describes what was masked, e.g. "EMAIL×2, PHONE×1".
+func (v *PIIVault) Summary() string {
+	var parts []string
+	for _, label := range slices.Sorted(maps.Keys(v.counts)) {
+		parts = append(parts, fmt.Sprintf("%s×%d", label, v.counts[label]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Reversible reports whether the vault can restore what was masked.
+func (v *PIIVault) Reversible() bool {
+	return v != nil && len(v.originals) > 0
+}
+
+// Restore replaces the placeholders in s with the originals.
+func (v *PIIVault) Restore(s string) string {
+	if !v.Reversible() {
+		return s
+	}
+	return v.replacer.Replace(s)
+}
+
+// RestoreBody restores the placeholders in the message content of every
+// choice of a chat completion.
+func (v *PIIVault) RestoreBody(body []byte) []byte {
+	if !v.Reversible() {
+		return body
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	choices, _ := data["choices"].([]any)
+	changed := false
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		msg, _ := choice["message"].(map[string]any)
+		if content, ok := msg["content"].(string); ok {
+			if restored := v.Restore(content); restored != content {
+				msg["content"] = restored
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return body
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// partial reports whether s is the start, but not the whole, of a
+// placeholder.
+func (v *PIIVault) partial(s string) bool {
+	for ph := range v.originals {
+		if len(s) < len(ph) && strings.HasPrefix(ph, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// PIIStreams restores placeholders in the content deltas of a stream, per
+// choice index. A placeholder may be split across chunks, so text that
+// could be the start of one is held back until the next delta shows
+// whether it is.
+type PIIStreams struct {
+	vault *PIIVault
+	held  map[int]string
+}
+
+// NewStreams returns the restorer of one stream, or nil when the vault
+// restores nothing.
+func (v *PIIVault) NewStreams() *PIIStreams {
+	if !v.Reversible() {
+		return nil
+	}
+	return &PIIStreams{vault: v, held: make(map[int]string)}
+}
+
+// RestoreDelta restores the placeholders in the content of a choice delta.
+// Text held back is released with the choice's finish_reason.
+func (s *PIIStreams) RestoreDelta(choice map[string]any) {
+	if s == nil {
+		return
+	}
+	idx, _ := choice["index"].(float64)
+	delta, _ := choice["delta"].(map[string]any)
+	content, _ := delta["content"].(string)
+	text := s.held[int(idx)] + content
+	s.held[int(idx)] = ""
+	if choice["finish_reason"] == nil {
+		if i := strings.LastIndexByte(text, '['); i >= 0 && s.vault.partial(text[i:]) {
+			text, s.held[int(idx)] = text[:i], text[i:]
+		}
+	}
+	if text == "" && content == "" {
+		return
+	}
+	if delta == nil {
+		delta = map[string]any{}
+		choice["delta"] = delta
+	}
+	delta["content"] = s.vault.Restore(text)
+}
@@ -0,0 +1,99 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// trailingAssistant returns the last message of a request when it is an
+// assistant message with content and no tool calls: a prefill, the start
+// of the reply the client wants the model to continue.
+func trailingAssistant(messages []any) map[string]any {
+	if len(messages) == 0 {
+		return nil
+	}
+	msg, _ := messages[len(messages)-1].(map[string]any)
+	if msg["role"] != "assistant" || msg["tool_calls"] != nil || contentText(msg["content"]) == "" {
+		return nil
+	}
+	return msg
+}
+
+// contentText returns the text of message content, a string or an array
+// of content parts.
+func contentText(content any) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []any:
+		var b strings.Builder
+		for _, part := range c {
+			if p, ok := part.(map[string]any); ok && p["type"] == "text" {
+				text, _ := p["text"].(string)
+				b.WriteString(text)
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// MarkPrefill flags a prefill with field, which tells the upstream to
+// continue it rather than answer after it: DeepSeek's prefix completion
+// takes "prefix", Kimi's partial mode "partial".
+func MarkPrefill(body []byte, field string, debug bool) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	messages, _ := data["messages"].([]any)
+	msg := trailingAssistant(messages)
+	if msg == nil {
+		return body
+	}
+	msg[field] = true
+	if debug {
+		fmt.Printf("  ↻ assistant prefill sent as %s\n", field)
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// PrefillText returns the text of the request's prefill, or "" when the
+// request does not end with one.
+func PrefillText(body []byte) string {
+	var req struct {
+		Messages []any `json:"messages"`
+	}
+	json.Unmarshal(body, &req)
+	return contentText(trailingAssistant(req.Messages)["content"])
+}
+
+// PrependContent adds text before the message content of every choice of
+// a complete response, so a continued prefill reads as the whole reply.
+func PrependContent(body []byte, text string) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	choices, _ := data["choices"].([]any)
+	changed := false
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		if msg, ok := choice["message"].(map[string]any); ok {
+			content, _ := msg["content"].(string)
+			msg["content"] = text + content
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
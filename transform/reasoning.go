@@ -3,48 +3,70 @@ package transform
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 )
 
 // StreamState tracks reasoning state within a single SSE connection.
 type StreamState struct {
 	IsReasoning bool
+	closing     string          // Close of the format that opened the reasoning block
+	lineStart   bool            // next reasoning character starts a line (for quoting)
+	echo        strings.Builder // debug copy of the choice's text, see StreamStates.Echo
 }
 
-// NormalizeThoughtContent extracts <thought> content from a string.
-// Handles three cases:
-//  1. <thought>...</thought> — normal extraction
-//  2. <thought> only (unclosed) — everything after is treated as thought
-//  3. </thought> only (orphan close) — removed as dirty data
-func NormalizeThoughtContent(content string) (thought, cleaned string, found bool) {
-	startIdx := strings.Index(content, "<thought>")
-	endIdx := strings.Index(content, "</thought>")
-
-	if startIdx >= 0 {
-		prefix := content[:startIdx]
-		if endIdx > startIdx {
-			thought = content[startIdx+len("<thought>") : endIdx]
-			cleaned = prefix + content[endIdx+len("</thought>"):]
-			return thought, cleaned, true
-		}
-		// Unclosed: treat everything after open tag as thought
-		thought = content[startIdx+len("<thought>"):]
-		cleaned = prefix
-		return thought, cleaned, true
+// StreamStates tracks the reasoning state of each choice of a stream by
+// choice index, so requests with n > 1 get their own block per choice.
+type StreamStates map[int]*StreamState
+
+// For returns the state of the choice's index, creating it on first use.
+func (s StreamStates) For(choice map[string]any) *StreamState {
+	idx, _ := choice["index"].(float64)
+	state := s[int(idx)]
+	if state == nil {
+		state = &StreamState{}
+		s[int(idx)] = state
 	}
+	return state
+}
 
-	if endIdx >= 0 {
-		cleaned = strings.ReplaceAll(content, "</thought>", "")
-		return "", cleaned, true
+// Echo returns the debug copy of the text streamed so far, choice by
+// choice, and clears it. Streams print it as a whole when they end, so the
+// output of concurrent streams does not interleave.
+func (s StreamStates) Echo() string {
+	var b strings.Builder
+	for _, idx := range slices.Sorted(maps.Keys(s)) {
+		text := s[idx].echo.String()
+		if text == "" {
+			continue
+		}
+		if len(s) > 1 {
+			fmt.Fprintf(&b, "--- choice %d ---\n", idx)
+		}
+		b.WriteString(text)
+		if !strings.HasSuffix(text, "\n") {
+			b.WriteByte('\n')
+		}
+		s[idx].echo.Reset()
 	}
+	return b.String()
+}
 
-	return "", content, false
+// Open reports whether any choice is inside a reasoning block.
+func (s StreamStates) Open() bool {
+	for _, state := range s {
+		if state.IsReasoning {
+			return true
+		}
+	}
+	return false
 }
 
 // TransformDelta converts reasoning_content in a SSE choice delta to
-// <thought> tags merged into the content field.
+// formatted reasoning (<thought> tags by default) merged into the content field.
 // Shared by all reasoning-capable providers (DeepSeek, Kimi, Zhipu).
-func TransformDelta(choice map[string]any, state *StreamState, debug bool) {
+func TransformDelta(choice map[string]any, state *StreamState, format ReasoningFormat, debug bool) {
 	delta, hasDelta := choice["delta"].(map[string]any)
 	if !hasDelta {
 		delta = map[string]any{}
@@ -69,37 +91,39 @@ func TransformDelta(choice map[string]any, state *StreamState, debug bool) {
 	if hasReasoningChunk {
 		if !state.IsReasoning {
 			if debug {
-				fmt.Print("\n--- reasoning start ---\n")
+				state.echo.WriteString("--- reasoning start ---\n")
 			}
-			b.WriteString("<thought>\n")
+			b.WriteString(format.Open)
 			state.IsReasoning = true
+			state.closing = format.Close
+			state.lineStart = true
 		}
-		b.WriteString(rcStr)
+		b.WriteString(format.quote(rcStr, &state.lineStart))
 		if debug {
-			fmt.Print(rcStr)
+			state.echo.WriteString(rcStr)
 		}
 	}
 
 	if state.IsReasoning && hasNonNilContent {
 		if debug {
-			fmt.Print("\n--- reasoning end, content start ---\n")
+			state.echo.WriteString("\n--- reasoning end, content start ---\n")
 		}
-		b.WriteString("\n</thought>\n\n")
+		b.WriteString(format.Close)
 		state.IsReasoning = false
 	}
 
 	if hasNonNilContent {
 		b.WriteString(contentStr)
-		if debug && contentStr != "" {
-			fmt.Print(contentStr)
+		if debug {
+			state.echo.WriteString(contentStr)
 		}
 	}
 
 	if state.IsReasoning && finish {
 		if debug {
-			fmt.Print("\n--- reasoning end (no content) ---\n")
+			state.echo.WriteString("\n--- reasoning end (no content) ---\n")
 		}
-		b.WriteString("\n</thought>\n\n")
+		b.WriteString(format.Close)
 		state.IsReasoning = false
 	}
 
@@ -137,13 +161,15 @@ func InjectReasoningEffort(body []byte, effort string, debug bool) []byte {
 	return body
 }
 
-// ClosingTagSSE returns the SSE data line to inject when a stream ends mid-reasoning.
-func ClosingTagSSE() string {
+// ClosingTagSSE returns the SSE data line to inject when a stream ends
+// mid-reasoning in the choice with the given index.
+func ClosingTagSSE(state *StreamState, index int) string {
 	msg := map[string]any{
 		"choices": []any{
 			map[string]any{
+				"index": index,
 				"delta": map[string]any{
-					"content": "\n</thought>\n\n",
+					"content": state.closing,
 				},
 			},
 		},
@@ -153,7 +179,7 @@ func ClosingTagSSE() string {
 }
 
 // TransformFullResponse merges reasoning_content into content for non-streaming responses.
-func TransformFullResponse(body []byte) []byte {
+func TransformFullResponse(body []byte, format ReasoningFormat) []byte {
 	var data map[string]any
 	if err := json.Unmarshal(body, &data); err != nil {
 		return body
@@ -188,7 +214,7 @@ func TransformFullResponse(body []byte) []byte {
 		}
 
 		contentStr, _ := msg["content"].(string)
-		msg["content"] = "<thought>\n" + rcStr + "\n</thought>\n\n" + contentStr
+		msg["content"] = format.wrap(rcStr) + contentStr
 		delete(msg, "reasoning_content")
 		changed = true
 	}
@@ -201,8 +227,56 @@ func TransformFullResponse(body []byte) []byte {
 	return body
 }
 
+// DropReasoningDelta removes reasoning_content from a SSE choice delta.
+func DropReasoningDelta(choice map[string]any) {
+	delta, _ := choice["delta"].(map[string]any)
+	delete(delta, "reasoning_content")
+}
+
+// HasDelta reports whether a SSE choice carries anything for the client, so
+// chunks emptied by the proxy can be skipped.
+func HasDelta(choice map[string]any) bool {
+	if choice["finish_reason"] != nil {
+		return true
+	}
+	delta, _ := choice["delta"].(map[string]any)
+	for _, v := range delta {
+		if v != nil && v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// DropReasoning removes reasoning_content from every choice of a
+// non-streaming response.
+func DropReasoning(body []byte) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	choices, _ := data["choices"].([]any)
+
+	changed := false
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		msg, _ := choice["message"].(map[string]any)
+		if _, ok := msg["reasoning_content"]; ok {
+			delete(msg, "reasoning_content")
+			changed = true
+		}
+	}
+
+	if changed {
+		if newBody, err := json.Marshal(data); err == nil {
+			return newBody
+		}
+	}
+	return body
+}
+
 // PrepareRequestMessages handles the shared request-side transformation:
-//   - Restores reasoning_content from <thought> tags in assistant messages
+//   - Restores reasoning_content from formatted reasoning in assistant messages
 //   - Optionally cleans reasoning from historical turns (before last user message)
 //   - Optionally ensures reasoning_content field exists on all assistant messages
 //
@@ -210,7 +284,8 @@ func TransformFullResponse(body []byte) []byte {
 //   - body: raw request JSON
 //   - requireField: if true, ensures reasoning_content exists (even empty) on assistant messages (DeepSeek)
 //   - cleanHistory: if true, removes reasoning_content from messages before the last user message
-func PrepareRequestMessages(body []byte, requireField bool, cleanHistory bool) []byte {
+//   - format: how reasoning was embedded into content in responses
+func PrepareRequestMessages(body []byte, requireField bool, cleanHistory bool, format ReasoningFormat) []byte {
 	var data map[string]any
 	if err := json.Unmarshal(body, &data); err != nil {
 		return body
@@ -238,7 +313,7 @@ func PrepareRequestMessages(body []byte, requireField bool, cleanHistory bool) [
 		}
 
 		content, _ := msg["content"].(string)
-		thought, cleanedContent, hasThought := NormalizeThoughtContent(content)
+		thought, cleanedContent, hasThought := format.Extract(content)
 
 		if cleanHistory && i < lastUserIdx {
 			// Historical turn: discard reasoning content
@@ -259,7 +334,7 @@ func PrepareRequestMessages(body []byte, requireField bool, cleanHistory bool) [
 				}
 			}
 		} else {
-			// Current turn: restore reasoning_content from <thought> tags
+			// Current turn: restore reasoning_content from the formatted reasoning
 			if hasThought {
 				extracted := strings.TrimSpace(thought)
 				existing, _ := msg["reasoning_content"].(string)
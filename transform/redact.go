@@ -0,0 +1,55 @@
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+)
+
+// redacted replaces every secret found in logged text.
+const redacted = "[REDACTED]"
+
+// builtinRedactions mask credentials wherever they turn up: Authorization
+// header values, in any quoting, and bearer tokens.
+var builtinRedactions = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(authorization["']?\s*[:=]\s*["']?)[^"'\r\n]+`),
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`),
+}
+
+// redactions are the patterns configured on top of the built-in ones.
+// Logging is process-wide, so they are too.
+var redactions atomic.Pointer[[]*regexp.Regexp]
+
+// SetRedactions replaces the configured patterns masked in logged request
+// and response content.
+func SetRedactions(patterns []string) error {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, re)
+	}
+	redactions.Store(&compiled)
+	return nil
+}
+
+// Redact masks credentials and the configured patterns in s. Built-in
+// patterns keep the name of what they mask, as in "Bearer [REDACTED]".
+func Redact(s string) string {
+	for _, re := range builtinRedactions {
+		s = re.ReplaceAllString(s, "${1}"+redacted)
+	}
+	if extra := redactions.Load(); extra != nil {
+		for _, re := range *extra {
+			s = re.ReplaceAllString(s, redacted)
+		}
+	}
+	return s
+}
+
+// Logf prints request or response content with secrets masked.
+func Logf(format string, args ...any) {
+	fmt.Print(Redact(fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,533 @@
+package transform
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ResponsesToChat converts a Responses API request (/responses) to the
+// equivalent chat completions request: instructions become a system
+// message, input items become messages, function tools and the text
+// format are renamed. Responses chained with previous_response_id are not
+// supported, as the proxy keeps no conversation state.
+func ResponsesToChat(body []byte) ([]byte, error) {
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if req["previous_response_id"] != nil {
+		return nil, errors.New("previous_response_id is not supported; send the whole conversation as input")
+	}
+
+	var messages []any
+	if s, ok := req["instructions"].(string); ok && s != "" {
+		messages = append(messages, map[string]any{"role": "system", "content": s})
+	}
+	switch input := req["input"].(type) {
+	case string:
+		messages = append(messages, map[string]any{"role": "user", "content": input})
+	case []any:
+		messages = append(messages, inputMessages(input)...)
+	}
+
+	chat := map[string]any{"model": req["model"], "messages": messages}
+	for _, key := range []string{"temperature", "top_p", "stream", "user", "parallel_tool_calls", "top_logprobs"} {
+		if v, ok := req[key]; ok {
+			chat[key] = v
+		}
+	}
+	if v, ok := req["max_output_tokens"]; ok {
+		chat["max_tokens"] = v
+	}
+	if reasoning, ok := req["reasoning"].(map[string]any); ok && reasoning["effort"] != nil {
+		chat["reasoning_effort"] = reasoning["effort"]
+	}
+	if text, ok := req["text"].(map[string]any); ok {
+		if format := responseFormat(text["format"]); format != nil {
+			chat["response_format"] = format
+		}
+	}
+	if tools := chatTools(req["tools"]); len(tools) > 0 {
+		chat["tools"] = tools
+		if choice := toolChoice(req["tool_choice"]); choice != nil {
+			chat["tool_choice"] = choice
+		}
+	}
+	if req["stream"] == true {
+		chat["stream_options"] = map[string]any{"include_usage": true}
+	}
+	return json.Marshal(chat)
+}
+
+// inputMessages converts input items to chat messages. Function calls join
+// the assistant message before them, or a new one; reasoning items and
+// items other than messages and function calls are dropped.
+func inputMessages(items []any) []any {
+	var messages []any
+	for _, raw := range items {
+		item, _ := raw.(map[string]any)
+		typ, _ := item["type"].(string)
+		if typ == "" && item["role"] != nil {
+			typ = "message"
+		}
+		switch typ {
+		case "message":
+			role, _ := item["role"].(string)
+			if role == "developer" {
+				role = "system"
+			}
+			messages = append(messages, map[string]any{"role": role, "content": inputContent(item["content"], role == "assistant")})
+		case "function_call":
+			call := map[string]any{"id": item["call_id"], "type": "function",
+				"function": map[string]any{"name": item["name"], "arguments": item["arguments"]}}
+			last, _ := lastMessage(messages).(map[string]any)
+			if last["role"] != "assistant" {
+				last = map[string]any{"role": "assistant", "content": nil}
+				messages = append(messages, last)
+			}
+			calls, _ := last["tool_calls"].([]any)
+			last["tool_calls"] = append(calls, call)
+		case "function_call_output":
+			output, ok := item["output"].(string)
+			if !ok {
+				data, _ := json.Marshal(item["output"])
+				output = string(data)
+			}
+			messages = append(messages, map[string]any{"role": "tool", "tool_call_id": item["call_id"], "content": output})
+		}
+	}
+	return messages
+}
+
+func lastMessage(messages []any) any {
+	if len(messages) == 0 {
+		return nil
+	}
+	return messages[len(messages)-1]
+}
+
+// inputContent converts the content parts of an input message. Assistant
+// messages get their text joined into a string.
+func inputContent(content any, assistant bool) any {
+	parts, ok := content.([]any)
+	if !ok {
+		return content
+	}
+	var text strings.Builder
+	out := make([]any, 0, len(parts))
+	for _, raw := range parts {
+		part, _ := raw.(map[string]any)
+		switch part["type"] {
+		case "input_text", "output_text":
+			s, _ := part["text"].(string)
+			text.WriteString(s)
+			out = append(out, map[string]any{"type": "text", "text": s})
+		case "refusal":
+			s, _ := part["refusal"].(string)
+			text.WriteString(s)
+			out = append(out, map[string]any{"type": "text", "text": s})
+		case "input_image":
+			image := map[string]any{"url": part["image_url"]}
+			if detail, ok := part["detail"]; ok {
+				image["detail"] = detail
+			}
+			out = append(out, map[string]any{"type": "image_url", "image_url": image})
+		case "input_file":
+			file := make(map[string]any)
+			for _, key := range []string{"file_id", "file_data", "filename"} {
+				if v, ok := part[key]; ok {
+					file[key] = v
+				}
+			}
+			out = append(out, map[string]any{"type": "file", "file": file})
+		}
+	}
+	if assistant {
+		return text.String()
+	}
+	return out
+}
+
+// chatTools converts function tools; built-in tools such as web_search
+// have no chat equivalent and are dropped.
+func chatTools(raw any) []any {
+	list, _ := raw.([]any)
+	var tools []any
+	for _, t := range list {
+		tool, _ := t.(map[string]any)
+		if tool["type"] != "function" {
+			continue
+		}
+		fn := map[string]any{"name": tool["name"]}
+		for _, key := range []string{"description", "parameters", "strict"} {
+			if v, ok := tool[key]; ok {
+				fn[key] = v
+			}
+		}
+		tools = append(tools, map[string]any{"type": "function", "function": fn})
+	}
+	return tools
+}
+
+func toolChoice(raw any) any {
+	switch choice := raw.(type) {
+	case string:
+		return choice
+	case map[string]any:
+		if choice["type"] == "function" {
+			return map[string]any{"type": "function", "function": map[string]any{"name": choice["name"]}}
+		}
+	}
+	return nil
+}
+
+// responseFormat converts text.format to a chat response_format.
+func responseFormat(raw any) any {
+	format, _ := raw.(map[string]any)
+	switch format["type"] {
+	case "json_object":
+		return map[string]any{"type": "json_object"}
+	case "json_schema":
+		schema := make(map[string]any)
+		for _, key := range []string{"name", "description", "schema", "strict"} {
+			if v, ok := format[key]; ok {
+				schema[key] = v
+			}
+		}
+		return map[string]any{"type": "json_schema", "json_schema": schema}
+	}
+	return nil
+}
+
+// ChatToResponse converts a chat.completion response to a Responses API
+// response: the reasoning becomes a reasoning item with a summary, the
+// content a message item and each tool call a function_call item.
+func ChatToResponse(body []byte) ([]byte, error) {
+	var chat map[string]any
+	if err := json.Unmarshal(body, &chat); err != nil {
+		return nil, err
+	}
+	id, _ := chat["id"].(string)
+	if id == "" {
+		id = newResponseID()
+	}
+	choices, _ := chat["choices"].([]any)
+	var choice map[string]any
+	if len(choices) > 0 {
+		choice, _ = choices[0].(map[string]any)
+	}
+	msg, _ := choice["message"].(map[string]any)
+
+	var output []any
+	if s, _ := msg["reasoning_content"].(string); s != "" {
+		output = append(output, reasoningItem("rs_"+id, s))
+	}
+	if s, _ := msg["content"].(string); s != "" {
+		output = append(output, messageItem("msg_"+id, s, "completed"))
+	}
+	calls, _ := msg["tool_calls"].([]any)
+	for _, raw := range calls {
+		call, _ := raw.(map[string]any)
+		fn, _ := call["function"].(map[string]any)
+		callID, _ := call["id"].(string)
+		output = append(output, functionCallItem("fc_"+callID, callID, fn["name"], fn["arguments"], "completed"))
+	}
+	usage, _ := chat["usage"].(map[string]any)
+	return json.Marshal(responseObject(id, chat["model"], chat["created"], choice["finish_reason"], output, usage))
+}
+
+func reasoningItem(id, text string) map[string]any {
+	return map[string]any{"id": id, "type": "reasoning",
+		"summary": []any{map[string]any{"type": "summary_text", "text": text}}}
+}
+
+func messageItem(id, text, status string) map[string]any {
+	content := []any{}
+	if status == "completed" {
+		content = append(content, outputText(text))
+	}
+	return map[string]any{"id": id, "type": "message", "status": status, "role": "assistant", "content": content}
+}
+
+func outputText(text string) map[string]any {
+	return map[string]any{"type": "output_text", "text": text, "annotations": []any{}}
+}
+
+func functionCallItem(id, callID string, name, arguments any, status string) map[string]any {
+	return map[string]any{"id": id, "type": "function_call", "status": status,
+		"call_id": callID, "name": name, "arguments": arguments}
+}
+
+// responseObject builds a response from its output items. A completion
+// cut off by the token limit or a content filter is "incomplete".
+func responseObject(chatID string, model, created, finish any, output []any, usage map[string]any) map[string]any {
+	if created == nil {
+		created = time.Now().Unix()
+	}
+	if output == nil {
+		output = []any{}
+	}
+	status, incomplete := "completed", any(nil)
+	switch finish {
+	case "length":
+		status, incomplete = "incomplete", map[string]any{"reason": "max_output_tokens"}
+	case "content_filter":
+		status, incomplete = "incomplete", map[string]any{"reason": "content_filter"}
+	case nil:
+		status = "in_progress"
+	}
+	resp := map[string]any{
+		"id":                 "resp_" + chatID,
+		"object":             "response",
+		"created_at":         created,
+		"status":             status,
+		"model":              model,
+		"output":             output,
+		"incomplete_details": incomplete,
+		"error":              nil,
+	}
+	if usage != nil {
+		resp["usage"] = responsesUsage(usage)
+	}
+	return resp
+}
+
+// responsesUsage renames the fields of a chat usage object.
+func responsesUsage(usage map[string]any) map[string]any {
+	num := func(m any, key string) float64 {
+		fields, _ := m.(map[string]any)
+		f, _ := fields[key].(float64)
+		return f
+	}
+	cached := num(usage["prompt_tokens_details"], "cached_tokens")
+	if cached == 0 {
+		cached = num(usage, "prompt_cache_hit_tokens")
+	}
+	return map[string]any{
+		"input_tokens":          num(usage, "prompt_tokens"),
+		"input_tokens_details":  map[string]any{"cached_tokens": cached},
+		"output_tokens":         num(usage, "completion_tokens"),
+		"output_tokens_details": map[string]any{"reasoning_tokens": num(usage["completion_tokens_details"], "reasoning_tokens")},
+		"total_tokens":          num(usage, "total_tokens"),
+	}
+}
+
+// ResponsesStream converts the chunks of a streamed chat completion into
+// the events of a streamed response. Reasoning, content and every tool
+// call become output items, opened when their first delta arrives and
+// done when the next item starts or the stream ends.
+type ResponsesStream struct {
+	id      string
+	model   any
+	created any
+	seq     int
+	started bool
+	ended   bool
+
+	output []any       // items done so far
+	cur    *streamItem // the open item, if any
+	finish any         // finish_reason of the completion
+	usage  map[string]any
+}
+
+// streamItem is an output item being streamed.
+type streamItem struct {
+	kind      string // "reasoning", "message" or "function_call"
+	id        string
+	index     int // output_index
+	text      strings.Builder
+	callID    string
+	name      any
+	callIndex float64 // index of the tool call in the chat deltas
+}
+
+// NewResponsesStream starts a stream for a request of model; the id and
+// model of the first chunk are used when it has them.
+func NewResponsesStream(model string) *ResponsesStream {
+	return &ResponsesStream{id: newResponseID(), model: model, created: time.Now().Unix()}
+}
+
+// newResponseID returns a random id for completions without one.
+func newResponseID() string {
+	return rand.Text()
+}
+
+// Chunk converts the data of one chat SSE event and returns the events to
+// send, which may be none. An error event fails the response.
+func (s *ResponsesStream) Chunk(data []byte) []byte {
+	var chunk map[string]any
+	if s.ended || json.Unmarshal(data, &chunk) != nil {
+		return nil
+	}
+	if e, ok := chunk["error"].(map[string]any); ok {
+		return s.fail(e)
+	}
+	var out []byte
+	if !s.started {
+		s.started = true
+		if id, _ := chunk["id"].(string); id != "" {
+			s.id = id
+		}
+		if chunk["model"] != nil {
+			s.model = chunk["model"]
+		}
+		if chunk["created"] != nil {
+			s.created = chunk["created"]
+		}
+		out = s.lifecycle(out, "response.created")
+		out = s.lifecycle(out, "response.in_progress")
+	}
+	if usage, ok := chunk["usage"].(map[string]any); ok {
+		s.usage = usage
+	}
+	choices, _ := chunk["choices"].([]any)
+	if len(choices) == 0 {
+		return out
+	}
+	choice, _ := choices[0].(map[string]any)
+	if f := choice["finish_reason"]; f != nil {
+		s.finish = f
+	}
+	delta, _ := choice["delta"].(map[string]any)
+	if text, _ := delta["reasoning_content"].(string); text != "" {
+		out = s.open(out, "reasoning", nil)
+		s.cur.text.WriteString(text)
+		out = s.event(out, "response.reasoning_summary_text.delta", map[string]any{
+			"item_id": s.cur.id, "output_index": s.cur.index, "summary_index": 0, "delta": text})
+	}
+	if text, _ := delta["content"].(string); text != "" {
+		out = s.open(out, "message", nil)
+		s.cur.text.WriteString(text)
+		out = s.event(out, "response.output_text.delta", map[string]any{
+			"item_id": s.cur.id, "output_index": s.cur.index, "content_index": 0, "delta": text})
+	}
+	calls, _ := delta["tool_calls"].([]any)
+	for _, raw := range calls {
+		frag, _ := raw.(map[string]any)
+		out = s.open(out, "function_call", frag)
+		fn, _ := frag["function"].(map[string]any)
+		if args, _ := fn["arguments"].(string); args != "" {
+			s.cur.text.WriteString(args)
+			out = s.event(out, "response.function_call_arguments.delta", map[string]any{
+				"item_id": s.cur.id, "output_index": s.cur.index, "delta": args})
+		}
+	}
+	return out
+}
+
+// Done closes the open item and returns the final event with the whole
+// response: response.completed, or response.incomplete.
+func (s *ResponsesStream) Done() []byte {
+	if s.ended {
+		return nil
+	}
+	var out []byte
+	if !s.started {
+		s.started = true
+		out = s.lifecycle(out, "response.created")
+	}
+	out = s.close(out)
+	s.ended = true
+	if s.finish == nil {
+		s.finish = "stop"
+	}
+	resp := responseObject(s.id, s.model, s.created, s.finish, s.output, s.usage)
+	return s.event(out, "response."+resp["status"].(string), map[string]any{"response": resp})
+}
+
+// fail ends the response with an error event followed by response.failed.
+func (s *ResponsesStream) fail(e map[string]any) []byte {
+	out := s.close(nil)
+	s.ended = true
+	out = s.event(out, "error", map[string]any{"code": e["code"], "message": e["message"], "param": e["param"]})
+	resp := responseObject(s.id, s.model, s.created, nil, s.output, s.usage)
+	resp["status"] = "failed"
+	resp["error"] = map[string]any{"code": e["code"], "message": e["message"]}
+	return s.event(out, "response.failed", map[string]any{"response": resp})
+}
+
+// lifecycle appends a response.created or response.in_progress event.
+func (s *ResponsesStream) lifecycle(dst []byte, typ string) []byte {
+	resp := responseObject(s.id, s.model, s.created, nil, []any{}, nil)
+	return s.event(dst, typ, map[string]any{"response": resp})
+}
+
+// open makes sure the open item is of kind, closing the previous one and
+// announcing a new one when it is not. Tool call fragments open a new item
+// when they belong to another call.
+func (s *ResponsesStream) open(dst []byte, kind string, frag map[string]any) []byte {
+	idx, _ := frag["index"].(float64)
+	if s.cur != nil && s.cur.kind == kind && (kind != "function_call" || s.cur.callIndex == idx) {
+		return dst
+	}
+	dst = s.close(dst)
+	item := &streamItem{kind: kind, index: len(s.output), callIndex: idx}
+	s.cur = item
+	switch kind {
+	case "reasoning":
+		item.id = fmt.Sprintf("rs_%s_%d", s.id, item.index)
+		dst = s.event(dst, "response.output_item.added", map[string]any{
+			"output_index": item.index, "item": map[string]any{"id": item.id, "type": "reasoning", "summary": []any{}}})
+		return s.event(dst, "response.reasoning_summary_part.added", map[string]any{
+			"item_id": item.id, "output_index": item.index, "summary_index": 0,
+			"part": map[string]any{"type": "summary_text", "text": ""}})
+	case "message":
+		item.id = fmt.Sprintf("msg_%s_%d", s.id, item.index)
+		dst = s.event(dst, "response.output_item.added", map[string]any{
+			"output_index": item.index, "item": messageItem(item.id, "", "in_progress")})
+		return s.event(dst, "response.content_part.added", map[string]any{
+			"item_id": item.id, "output_index": item.index, "content_index": 0, "part": outputText("")})
+	default:
+		item.callID, _ = frag["id"].(string)
+		fn, _ := frag["function"].(map[string]any)
+		item.name = fn["name"]
+		item.id = "fc_" + item.callID
+		return s.event(dst, "response.output_item.added", map[string]any{
+			"output_index": item.index, "item": functionCallItem(item.id, item.callID, item.name, "", "in_progress")})
+	}
+}
+
+// close finishes the open item, if any.
+func (s *ResponsesStream) close(dst []byte) []byte {
+	item := s.cur
+	if item == nil {
+		return dst
+	}
+	s.cur = nil
+	text := item.text.String()
+	var done map[string]any
+	switch item.kind {
+	case "reasoning":
+		dst = s.event(dst, "response.reasoning_summary_text.done", map[string]any{
+			"item_id": item.id, "output_index": item.index, "summary_index": 0, "text": text})
+		dst = s.event(dst, "response.reasoning_summary_part.done", map[string]any{
+			"item_id": item.id, "output_index": item.index, "summary_index": 0,
+			"part": map[string]any{"type": "summary_text", "text": text}})
+		done = reasoningItem(item.id, text)
+	case "message":
+		dst = s.event(dst, "response.output_text.done", map[string]any{
+			"item_id": item.id, "output_index": item.index, "content_index": 0, "text": text})
+		dst = s.event(dst, "response.content_part.done", map[string]any{
+			"item_id": item.id, "output_index": item.index, "content_index": 0, "part": outputText(text)})
+		done = messageItem(item.id, text, "completed")
+	default:
+		dst = s.event(dst, "response.function_call_arguments.done", map[string]any{
+			"item_id": item.id, "output_index": item.index, "arguments": text})
+		done = functionCallItem(item.id, item.callID, item.name, text, "completed")
+	}
+	s.output = append(s.output, done)
+	return s.event(dst, "response.output_item.done", map[string]any{"output_index": item.index, "item": done})
+}
+
+// event appends an SSE event of type typ with fields as its data.
+func (s *ResponsesStream) event(dst []byte, typ string, fields map[string]any) []byte {
+	fields["type"] = typ
+	fields["sequence_number"] = s.seq
+	s.seq++
+	data, _ := json.Marshal(fields)
+	return fmt.Appendf(dst, "event: %s\ndata: %s\n\n", typ, data)
+}
@@ -0,0 +1,163 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// alternationOpener is the user message put before a conversation that
+// would otherwise start with the assistant.
+const alternationOpener = "Continue."
+
+// RoleRules describes the message roles a provider accepts.
+type RoleRules struct {
+	Developer string // role developer messages are sent as; "" = unchanged
+	Merge     bool   // consecutive messages of the same role are joined
+	Alternate bool   // user and assistant turns alternate, starting with user
+}
+
+// NormalizeRoles rewrites the messages of a request to the rules: developer
+// messages get the provider's role, consecutive messages of one role are
+// joined, and with Alternate the conversation opens with a user message.
+// Messages with tool calls, and tool results, are never joined. Joining
+// needs matching names unless Alternate is set, which drops them.
+func NormalizeRoles(body []byte, rules RoleRules, debug bool) []byte {
+	if rules == (RoleRules{}) {
+		return body
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	messages, ok := data["messages"].([]any)
+	if !ok {
+		return body
+	}
+
+	changed := false
+	if rules.Developer != "" {
+		for _, m := range messages {
+			if msg, ok := m.(map[string]any); ok && msg["role"] == "developer" {
+				msg["role"] = rules.Developer
+				changed = true
+			}
+		}
+		if changed && debug {
+			fmt.Printf("  ↻ developer messages sent as %s\n", rules.Developer)
+		}
+	}
+
+	if rules.Merge || rules.Alternate {
+		out := make([]any, 0, len(messages))
+		var prev map[string]any
+		for _, m := range messages {
+			msg, _ := m.(map[string]any)
+			if prev != nil && msg != nil && joinable(prev, msg, rules.Alternate) {
+				joinMessage(prev, msg)
+				changed = true
+				if debug {
+					fmt.Printf("  ↻ merged consecutive %s messages\n", msg["role"])
+				}
+				continue
+			}
+			out = append(out, m)
+			prev = msg
+		}
+		messages = out
+	}
+
+	if rules.Alternate {
+		first := 0
+		for first < len(messages) && roleOf(messages[first]) == "system" {
+			first++
+		}
+		if first < len(messages) && roleOf(messages[first]) == "assistant" {
+			opener := map[string]any{"role": "user", "content": alternationOpener}
+			messages = slices.Insert(messages, first, any(opener))
+			changed = true
+			if debug {
+				fmt.Println("  ↻ inserted a user message before the first assistant message")
+			}
+		}
+	}
+
+	if !changed {
+		return body
+	}
+	data["messages"] = messages
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// roleOf returns the role of a message.
+func roleOf(m any) any {
+	msg, _ := m.(map[string]any)
+	return msg["role"]
+}
+
+// joinableFields are the fields of a message that joinMessage can combine.
+var joinableFields = map[string]bool{"role": true, "content": true, "name": true, "reasoning_content": true}
+
+// joinable reports whether msg can be joined into prev: same role, one of
+// system, user or assistant, and nothing but content to combine.
+func joinable(prev, msg map[string]any, ignoreNames bool) bool {
+	role := msg["role"]
+	if prev["role"] != role || role != "system" && role != "user" && role != "assistant" {
+		return false
+	}
+	if !ignoreNames && prev["name"] != msg["name"] {
+		return false
+	}
+	for _, m := range []map[string]any{prev, msg} {
+		for field := range m {
+			if !joinableFields[field] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// joinMessage appends the content of msg to prev.
+func joinMessage(prev, msg map[string]any) {
+	prev["content"] = concatContent(prev["content"], msg["content"])
+	if r, ok := msg["reasoning_content"].(string); ok && r != "" {
+		if p, _ := prev["reasoning_content"].(string); p != "" {
+			r = p + "\n\n" + r
+		}
+		prev["reasoning_content"] = r
+	}
+	if prev["name"] != msg["name"] {
+		delete(prev, "name")
+	}
+}
+
+// concatContent joins two message contents, each a string or an array of
+// content parts: strings become one string, anything else a part array.
+func concatContent(a, b any) any {
+	sa, aIsString := a.(string)
+	sb, bIsString := b.(string)
+	switch {
+	case aIsString && bIsString && sa != "" && sb != "":
+		return sa + "\n\n" + sb
+	case a == nil || aIsString && sa == "":
+		return b
+	case b == nil || bIsString && sb == "":
+		return a
+	}
+	return append(contentParts(a), contentParts(b)...)
+}
+
+// contentParts returns content as an array of content parts.
+func contentParts(content any) []any {
+	switch c := content.(type) {
+	case []any:
+		return c
+	case string:
+		return []any{map[string]any{"type": "text", "text": c}}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ParamRules describes what a provider accepts: Drop lists parameters it
+// rejects, Clamp the [min, max] range of numeric ones.
+type ParamRules struct {
+	Drop  []string
+	Clamp map[string][2]float64
+}
+
+// Merge returns r extended by other; other's ranges take precedence.
+func (r ParamRules) Merge(other ParamRules) ParamRules {
+	clamp := maps.Clone(r.Clamp)
+	if clamp == nil {
+		clamp = make(map[string][2]float64)
+	}
+	maps.Copy(clamp, other.Clamp)
+	return ParamRules{Drop: slices.Concat(r.Drop, other.Drop), Clamp: clamp}
+}
+
+// Sanitize removes the parameters rules drop and clamps out-of-range values,
+// so switching upstreams does not surface as an opaque 400.
+func Sanitize(body []byte, rules ParamRules, debug bool) []byte {
+	if len(rules.Drop) == 0 && len(rules.Clamp) == 0 {
+		return body
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+
+	changed := false
+	for _, name := range rules.Drop {
+		if _, exists := data[name]; exists {
+			delete(data, name)
+			changed = true
+			if debug {
+				fmt.Printf("  ✂ dropped unsupported %s\n", name)
+			}
+		}
+	}
+	for name, rng := range rules.Clamp {
+		v, ok := data[name].(float64)
+		if !ok {
+			continue
+		}
+		if c := min(max(v, rng[0]), rng[1]); c != v {
+			data[name] = c
+			changed = true
+			if debug {
+				fmt.Printf("  ✂ clamped %s %v → %v\n", name, v, c)
+			}
+		}
+	}
+
+	if changed {
+		if newBody, err := json.Marshal(data); err == nil {
+			return newBody
+		}
+	}
+	return body
+}
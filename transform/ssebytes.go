@@ -0,0 +1,97 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// The helpers below inspect SSE chunks without decoding them, so chunks that
+// need no rewriting can be passed on as they are. They look for "key": at
+// any depth; quotes inside JSON strings are escaped, so string content can
+// only ever cause a false match, never hide a real key.
+
+// fieldValues returns the raw bytes starting at the value of every
+// occurrence of key.
+func fieldValues(data []byte, key string) [][]byte {
+	pat := []byte(`"` + key + `"`)
+	var values [][]byte
+	for rest := data; ; {
+		i := bytes.Index(rest, pat)
+		if i < 0 {
+			return values
+		}
+		rest = rest[i+len(pat):]
+		v := bytes.TrimLeft(rest, " \t\r\n")
+		if len(v) > 0 && v[0] == ':' {
+			values = append(values, bytes.TrimLeft(v[1:], " \t\r\n"))
+		}
+	}
+}
+
+// FieldIsSet reports whether key occurs in the JSON data with a value
+// other than null.
+func FieldIsSet(data []byte, key string) bool {
+	for _, v := range fieldValues(data, key) {
+		if !bytes.HasPrefix(v, []byte("null")) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldIsNull reports whether key occurs in the JSON data with a null value.
+func FieldIsNull(data []byte, key string) bool {
+	for _, v := range fieldValues(data, key) {
+		if bytes.HasPrefix(v, []byte("null")) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringField returns the raw string token (with quotes) that is the value
+// of the first occurrence of key, or nil.
+func stringField(data []byte, key string) []byte {
+	values := fieldValues(data, key)
+	if len(values) == 0 || len(values[0]) == 0 || values[0][0] != '"' {
+		return nil
+	}
+	v := values[0]
+	for i := 1; i < len(v); i++ {
+		if v[i] == '\\' {
+			i++
+		} else if v[i] == '"' {
+			return v[:i+1]
+		}
+	}
+	return nil
+}
+
+// StringField returns the string value of the first occurrence of key,
+// e.g. the model of a request whose body is only partly read.
+func StringField(data []byte, key string) (string, bool) {
+	var s string
+	token := stringField(data, key)
+	return s, token != nil && json.Unmarshal(token, &s) == nil
+}
+
+// ReplaceStringField replaces the string value of the first occurrence of
+// key, e.g. the model of a stream chunk, without re-encoding the rest. It
+// reports false when key is missing or its value is not a string.
+func ReplaceStringField(data []byte, key, value string) ([]byte, bool) {
+	token := stringField(data, key)
+	if token == nil {
+		return data, false
+	}
+	// token is a subslice of data
+	start := cap(data) - cap(token)
+	end := start + len(token)
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return data, false
+	}
+	out := make([]byte, 0, len(data)-(end-start)+len(encoded))
+	out = append(out, data[:start]...)
+	out = append(out, encoded...)
+	return append(out, data[end:]...), true
+}
@@ -0,0 +1,51 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// DefaultSSEMaxLine is the longest SSE line read when no limit is set.
+const DefaultSSEMaxLine = 16 * 1024 * 1024
+
+// NewSSEScanner returns a scanner over the lines of an SSE stream that
+// accepts lines up to maxLine bytes (DefaultSSEMaxLine when not positive).
+// buf is the initial buffer, e.g. a recycled one; nil allocates one.
+func NewSSEScanner(r io.Reader, buf []byte, maxLine int) *bufio.Scanner {
+	if maxLine <= 0 {
+		maxLine = DefaultSSEMaxLine
+	}
+	if buf == nil {
+		buf = make([]byte, 0, min(64*1024, maxLine))
+	}
+	// The scanner allows tokens as long as the buffer's capacity
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(buf[:0:min(cap(buf), maxLine)], maxLine)
+	scanner.Split(ScanSSELines)
+	return scanner
+}
+
+// ScanSSELines is a bufio.SplitFunc for SSE streams: lines end with "\r\n",
+// "\n" or a lone "\r", and an unterminated line at the end of the stream is
+// incomplete and dropped, as the SSE spec requires.
+func ScanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	i := bytes.IndexAny(data, "\r\n")
+	switch {
+	case i < 0 && atEOF:
+		return len(data), nil, nil
+	case i < 0:
+		return 0, nil, nil
+	case data[i] == '\n':
+		return i + 1, data[:i], nil
+	case i+1 < len(data):
+		if data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+		return i + 1, data[:i], nil
+	case atEOF:
+		return i + 1, data[:i], nil
+	}
+	// A "\r" at the end of the buffer may be followed by "\n"
+	return 0, nil, nil
+}
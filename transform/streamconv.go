@@ -0,0 +1,245 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// SetStream sets the stream flag of a request. Streamed requests ask for
+// usage in the final chunk; stream_options is removed otherwise, as
+// upstreams reject it on non-streaming requests.
+func SetStream(body []byte, stream bool) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	data["stream"] = stream
+	if stream {
+		opts, _ := data["stream_options"].(map[string]any)
+		if opts == nil {
+			opts = make(map[string]any)
+		}
+		opts["include_usage"] = true
+		data["stream_options"] = opts
+	} else {
+		delete(data, "stream_options")
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// IsStream reports whether a request asks for a streaming response, and
+// whether it wants usage in the final chunk.
+func IsStream(body []byte) (stream, includeUsage bool) {
+	var req struct {
+		Stream        bool `json:"stream"`
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Stream, req.StreamOptions.IncludeUsage
+}
+
+// assembledChoice accumulates the deltas of one choice.
+type assembledChoice struct {
+	reasoning, content bytes.Buffer
+	toolCalls          map[int]map[string]any
+	finish             any
+	logprobs           any
+}
+
+// AssembleStream reads an upstream SSE stream to the end and builds the
+// equivalent chat.completion response, with reasoning_content, tool calls,
+// logprobs and usage. On a read error the response assembled so far is returned
+// together with the error.
+func AssembleStream(r io.Reader) ([]byte, error) {
+	resp := map[string]any{"object": "chat.completion"}
+	choices := make(map[int]*assembledChoice)
+
+	scanner := NewSSEScanner(r, nil, DefaultSSEMaxLine)
+	for scanner.Scan() {
+		payload, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		payload = bytes.TrimSpace(payload)
+		if !ok || len(payload) == 0 || string(payload) == "[DONE]" {
+			continue
+		}
+		var chunk map[string]any
+		if json.Unmarshal(payload, &chunk) != nil {
+			continue
+		}
+		for _, key := range []string{"id", "created", "model", "system_fingerprint"} {
+			if v, ok := chunk[key]; ok && resp[key] == nil {
+				resp[key] = v
+			}
+		}
+		if usage, ok := chunk["usage"].(map[string]any); ok {
+			resp["usage"] = usage
+		}
+		list, _ := chunk["choices"].([]any)
+		for _, c := range list {
+			choice, _ := c.(map[string]any)
+			idx, _ := choice["index"].(float64)
+			ac := choices[int(idx)]
+			if ac == nil {
+				ac = &assembledChoice{toolCalls: make(map[int]map[string]any)}
+				choices[int(idx)] = ac
+			}
+			ac.add(choice)
+		}
+	}
+
+	out := make([]any, 0, len(choices))
+	for _, idx := range slices.Sorted(maps.Keys(choices)) {
+		out = append(out, choices[idx].choice(idx))
+	}
+	resp["choices"] = out
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	return body, scanner.Err()
+}
+
+func (ac *assembledChoice) add(choice map[string]any) {
+	if f := choice["finish_reason"]; f != nil {
+		ac.finish = f
+	}
+	ac.logprobs = AppendLogprobs(ac.logprobs, choice["logprobs"])
+	delta, _ := choice["delta"].(map[string]any)
+	if s, ok := delta["reasoning_content"].(string); ok {
+		ac.reasoning.WriteString(s)
+	}
+	if s, ok := delta["content"].(string); ok {
+		ac.content.WriteString(s)
+	}
+	calls, _ := delta["tool_calls"].([]any)
+	for _, raw := range calls {
+		frag, _ := raw.(map[string]any)
+		idx, _ := frag["index"].(float64)
+		tc := ac.toolCalls[int(idx)]
+		if tc == nil {
+			tc = map[string]any{"type": "function", "function": map[string]any{"name": "", "arguments": ""}}
+			ac.toolCalls[int(idx)] = tc
+		}
+		if id, ok := frag["id"].(string); ok && id != "" {
+			tc["id"] = id
+		}
+		fn, _ := frag["function"].(map[string]any)
+		acc := tc["function"].(map[string]any)
+		for _, key := range []string{"name", "arguments"} {
+			if s, ok := fn[key].(string); ok {
+				acc[key] = acc[key].(string) + s
+			}
+		}
+	}
+}
+
+func (ac *assembledChoice) choice(idx int) map[string]any {
+	msg := map[string]any{"role": "assistant", "content": ac.content.String()}
+	if ac.reasoning.Len() > 0 {
+		msg["reasoning_content"] = ac.reasoning.String()
+	}
+	if len(ac.toolCalls) > 0 {
+		var calls []any
+		for _, i := range slices.Sorted(maps.Keys(ac.toolCalls)) {
+			calls = append(calls, ac.toolCalls[i])
+		}
+		msg["tool_calls"] = calls
+		if ac.content.Len() == 0 {
+			msg["content"] = nil
+		}
+	}
+	return map[string]any{"index": idx, "message": msg, "finish_reason": ac.finish, "logprobs": ac.logprobs}
+}
+
+// StreamResponse renders a chat.completion response as the SSE events a
+// streaming request would have received: per choice a first chunk with the
+// role, the reasoning and content split into deltas of chunkSize characters
+// (0 = one delta each; the first content delta carries the logprobs), a chunk with the tool calls and one with the finish
+// reason, then the usage chunk if asked for, and [DONE].
+func StreamResponse(body []byte, includeUsage bool, chunkSize int) [][]byte {
+	var resp map[string]any
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return [][]byte{body}
+	}
+	var events [][]byte
+	emit := func(choices []any, usage any) {
+		chunk := map[string]any{"object": "chat.completion.chunk", "choices": choices}
+		for _, key := range []string{"id", "created", "model", "system_fingerprint"} {
+			if v, ok := resp[key]; ok {
+				chunk[key] = v
+			}
+		}
+		if usage != nil {
+			chunk["usage"] = usage
+		}
+		data, _ := json.Marshal(chunk)
+		events = append(events, fmt.Appendf(nil, "data: %s\n\n", data))
+	}
+	delta := func(idx any, d map[string]any, finish any) []any {
+		return []any{map[string]any{"index": idx, "delta": d, "finish_reason": finish}}
+	}
+	pieces := func(s string) []string {
+		if chunkSize <= 0 {
+			return []string{s}
+		}
+		return SplitRunes(s, chunkSize)
+	}
+
+	choices, _ := resp["choices"].([]any)
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		idx := choice["index"]
+		msg, _ := choice["message"].(map[string]any)
+
+		emit(delta(idx, map[string]any{"role": "assistant", "content": ""}, nil), nil)
+		for _, key := range []string{"reasoning_content", "content"} {
+			if s, ok := msg[key].(string); ok && s != "" {
+				for i, piece := range pieces(s) {
+					choices := delta(idx, map[string]any{key: piece}, nil)
+					// The token logprobs travel with the first content delta
+					if lp := choice["logprobs"]; key == "content" && i == 0 && lp != nil {
+						choices[0].(map[string]any)["logprobs"] = lp
+					}
+					emit(choices, nil)
+				}
+			}
+		}
+		if calls, ok := msg["tool_calls"].([]any); ok && len(calls) > 0 {
+			indexed := make([]any, len(calls))
+			for i, raw := range calls {
+				tc, _ := raw.(map[string]any)
+				frag := map[string]any{"index": i}
+				for k, v := range tc {
+					frag[k] = v
+				}
+				indexed[i] = frag
+			}
+			emit(delta(idx, map[string]any{"tool_calls": indexed}, nil), nil)
+		}
+		emit(delta(idx, map[string]any{}, choice["finish_reason"]), nil)
+	}
+	if usage, ok := resp["usage"]; ok && includeUsage {
+		emit([]any{}, usage)
+	}
+	return append(events, []byte("data: [DONE]\n\n"))
+}
+
+// SplitRunes cuts s into pieces of at most n characters.
+func SplitRunes(s string, n int) []string {
+	var out []string
+	runes := []rune(s)
+	for len(runes) > 0 {
+		k := min(n, len(runes))
+		out = append(out, string(runes[:k]))
+		runes = runes[k:]
+	}
+	return out
+}
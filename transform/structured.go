@@ -0,0 +1,74 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// schemaPrompt is appended to the system prompt when a json_schema
+// response_format is emulated.
+const schemaPrompt = "Respond with a single JSON value that conforms to the JSON schema%s below. " +
+	"Reply with the JSON only, without code fences or commentary.\n%s"
+
+// EmulateJSONSchema rewrites a json_schema response_format for upstreams
+// that only support json_object: the schema moves into the system prompt
+// and the format is downgraded to json_object.
+func EmulateJSONSchema(body []byte, debug bool) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	rf, _ := data["response_format"].(map[string]any)
+	if rf["type"] != "json_schema" {
+		return body
+	}
+	spec, _ := rf["json_schema"].(map[string]any)
+	schema, err := json.MarshalIndent(spec["schema"], "", "  ")
+	if err != nil {
+		return body
+	}
+	name := ""
+	if n, ok := spec["name"].(string); ok && n != "" {
+		name = fmt.Sprintf(" %q", n)
+	}
+
+	data["response_format"] = map[string]any{"type": "json_object"}
+	newBody, err := json.Marshal(data)
+	if err != nil {
+		return body
+	}
+	if debug {
+		fmt.Printf("  ✎ json_schema%s emulated with a system prompt\n", name)
+	}
+	return InjectSystemPrompt(newBody, fmt.Sprintf(schemaPrompt, name, schema), true)
+}
+
+// RepairJSONContent repairs message content that is not valid JSON, e.g.
+// wrapped in code fences or truncated, in a non-streaming response.
+func RepairJSONContent(body []byte) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	choices, _ := data["choices"].([]any)
+	changed := false
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		msg, _ := choice["message"].(map[string]any)
+		content, ok := msg["content"].(string)
+		if !ok {
+			continue
+		}
+		if repaired, ok := RepairJSON(content); ok && repaired != content {
+			msg["content"] = repaired
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
@@ -0,0 +1,73 @@
+package transform
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// InjectSystemPrompt adds text to the request's system prompt. When the
+// request has a system message, text is joined before its content, or after
+// it with appendText; otherwise a new system message is inserted first.
+func InjectSystemPrompt(body []byte, text string, appendText bool) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	messages, ok := data["messages"].([]any)
+	if !ok {
+		return body
+	}
+
+	if system := systemMessage(messages); system != nil {
+		system["content"] = joinContent(system["content"], text, appendText)
+	} else {
+		data["messages"] = append([]any{map[string]any{"role": "system", "content": text}}, messages...)
+	}
+
+	if newBody, err := json.Marshal(data); err == nil {
+		return newBody
+	}
+	return body
+}
+
+// HasSystemMessage reports whether the request has a system (or developer)
+// message with any text; a blank one gives the model no framing either.
+func HasSystemMessage(body []byte) bool {
+	var req struct {
+		Messages []any `json:"messages"`
+	}
+	json.Unmarshal(body, &req)
+	system := systemMessage(req.Messages)
+	return system != nil && strings.TrimSpace(contentText(system["content"])) != ""
+}
+
+func systemMessage(messages []any) map[string]any {
+	for _, m := range messages {
+		if msg, ok := m.(map[string]any); ok && (msg["role"] == "system" || msg["role"] == "developer") {
+			return msg
+		}
+	}
+	return nil
+}
+
+// joinContent adds text to message content, which is either a string or an
+// array of content parts.
+func joinContent(content any, text string, appendText bool) any {
+	switch c := content.(type) {
+	case string:
+		if strings.TrimSpace(c) == "" {
+			return text
+		}
+		if appendText {
+			return c + "\n\n" + text
+		}
+		return text + "\n\n" + c
+	case []any:
+		part := map[string]any{"type": "text", "text": text}
+		if appendText {
+			return append(c, part)
+		}
+		return append([]any{part}, c...)
+	}
+	return text
+}
@@ -0,0 +1,139 @@
+package transform
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Tokenizer estimates token counts without a model vocabulary. The numbers
+// approximate BPE tokenizers: English words cost about one token per four
+// letters, punctuation one token each, and CJK characters a per-family rate.
+type Tokenizer struct {
+	Family string
+	cjk    float64 // tokens per CJK character
+}
+
+// tokenizerFamilies maps model name prefixes to their CJK rate. Tokenizers
+// trained on Chinese text encode most characters in well under one token.
+var tokenizerFamilies = []struct {
+	prefix string
+	cjk    float64
+}{
+	{"deepseek", 0.6},
+	{"glm", 0.7},
+	{"kimi", 0.7},
+	{"moonshot", 0.7},
+	{"qwen", 0.7},
+	{"gpt-4o", 0.8},
+	{"o1", 0.8}, // o-series models share gpt-4o's vocabulary
+	{"o3", 0.8},
+	{"o4", 0.8},
+}
+
+// TokenizerFor returns the tokenizer estimate for model.
+func TokenizerFor(model string) Tokenizer {
+	m := strings.ToLower(model)
+	for _, f := range tokenizerFamilies {
+		if strings.HasPrefix(m, f.prefix) {
+			return Tokenizer{Family: f.prefix, cjk: f.cjk}
+		}
+	}
+	return Tokenizer{Family: "default", cjk: 1.0}
+}
+
+// Count estimates the tokens of text.
+func (t Tokenizer) Count(text string) int {
+	var tokens float64
+	word := 0
+	flush := func() {
+		if word > 0 {
+			tokens += math.Ceil(float64(word) / 4)
+			word = 0
+		}
+	}
+	for _, r := range text {
+		switch {
+		case r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			word++
+			continue
+		case unicode.IsSpace(r):
+			// Leading spaces merge into the next token
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			tokens += t.cjk
+		case unicode.IsLetter(r):
+			tokens += 0.5
+		default:
+			tokens++
+		}
+		flush()
+	}
+	flush()
+	return int(math.Ceil(tokens))
+}
+
+// Per-message framing overhead of chat formats, as in OpenAI's guidance.
+const (
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+)
+
+// CountMessages estimates the prompt tokens of a chat request: each message
+// with its framing, plus tool definitions. It returns the total and the
+// count of every message.
+func (t Tokenizer) CountMessages(messages []any, tools []any) (int, []int) {
+	total := tokensPerReply
+	per := make([]int, len(messages))
+	for i, m := range messages {
+		msg, _ := m.(map[string]any)
+		n := tokensPerMessage
+		for key, v := range msg {
+			switch v := v.(type) {
+			case string:
+				n += t.Count(v)
+			case nil:
+			default:
+				// Content parts, tool calls: count their JSON form
+				if key == "content" {
+					n += t.countParts(v)
+				} else {
+					b, _ := json.Marshal(v)
+					n += t.Count(string(b))
+				}
+			}
+		}
+		per[i] = n
+		total += n
+	}
+	if len(tools) > 0 {
+		b, _ := json.Marshal(tools)
+		total += t.Count(string(b))
+	}
+	return total, per
+}
+
+// tokensPerImage is the flat cost assumed for an image part, OpenAI's
+// low-detail price.
+const tokensPerImage = 85
+
+// countParts counts the text of array content; images cost a flat rate and
+// other parts are counted by their JSON form.
+func (t Tokenizer) countParts(content any) int {
+	parts, _ := content.([]any)
+	n := 0
+	for _, p := range parts {
+		part, _ := p.(map[string]any)
+		switch part["type"] {
+		case "text":
+			text, _ := part["text"].(string)
+			n += t.Count(text)
+		case "image_url":
+			n += tokensPerImage
+		default:
+			b, _ := json.Marshal(part)
+			n += t.Count(string(b))
+		}
+	}
+	return n
+}
@@ -0,0 +1,157 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ToolCallError describes tool call arguments that are not valid JSON and
+// could not be repaired.
+type ToolCallError struct {
+	Index     int    `json:"index"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+func (e ToolCallError) Error() string {
+	return fmt.Sprintf("tool call %d (%s) has invalid JSON arguments", e.Index, e.Name)
+}
+
+// RepairToolCalls repairs the arguments of every tool call in a
+// non-streaming response and returns the calls it could not repair.
+func RepairToolCalls(body []byte, debug bool) ([]byte, []ToolCallError) {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body, nil
+	}
+	choices, _ := data["choices"].([]any)
+
+	var failed []ToolCallError
+	changed := false
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		msg, _ := choice["message"].(map[string]any)
+		calls, _ := msg["tool_calls"].([]any)
+		for i, raw := range calls {
+			tc, _ := raw.(map[string]any)
+			fn, _ := tc["function"].(map[string]any)
+			args, ok := fn["arguments"].(string)
+			if !ok {
+				continue
+			}
+			repaired, ok := RepairJSON(args)
+			if !ok {
+				id, _ := tc["id"].(string)
+				name, _ := fn["name"].(string)
+				failed = append(failed, ToolCallError{Index: i, ID: id, Name: name, Arguments: args})
+				continue
+			}
+			if repaired != args {
+				fn["arguments"] = repaired
+				changed = true
+				if debug {
+					Logf("  🔧 repaired tool call arguments: %s → %s\n", args, repaired)
+				}
+			}
+		}
+	}
+
+	if changed {
+		if newBody, err := json.Marshal(data); err == nil {
+			return newBody, failed
+		}
+	}
+	return body, failed
+}
+
+// ToolArgsBuffer holds back streamed tool call arguments so they can be
+// validated and repaired as a whole before the client sees them. IDs and
+// names still stream immediately.
+type ToolArgsBuffer struct {
+	calls map[int]*bufferedCall
+}
+
+type bufferedCall struct {
+	id, name string
+	args     strings.Builder
+}
+
+// Hold moves the argument fragments of a choice delta into the buffer.
+func (b *ToolArgsBuffer) Hold(choice map[string]any) {
+	delta, _ := choice["delta"].(map[string]any)
+	calls, _ := delta["tool_calls"].([]any)
+	if len(calls) == 0 {
+		return
+	}
+	if b.calls == nil {
+		b.calls = make(map[int]*bufferedCall)
+	}
+
+	kept := calls[:0]
+	for _, raw := range calls {
+		tc, _ := raw.(map[string]any)
+		idx, _ := tc["index"].(float64)
+		call, ok := b.calls[int(idx)]
+		if !ok {
+			call = &bufferedCall{}
+			b.calls[int(idx)] = call
+		}
+		if id, ok := tc["id"].(string); ok && id != "" {
+			call.id = id
+		}
+		fn, _ := tc["function"].(map[string]any)
+		if name, ok := fn["name"].(string); ok {
+			call.name += name
+		}
+		if args, ok := fn["arguments"].(string); ok {
+			call.args.WriteString(args)
+			delete(fn, "arguments")
+		}
+		// Drop fragments that only carried arguments
+		_, hasID := tc["id"]
+		_, hasType := tc["type"]
+		if hasID || hasType || len(fn) > 0 {
+			kept = append(kept, tc)
+		}
+	}
+	if len(kept) == 0 {
+		delete(delta, "tool_calls")
+	} else {
+		delta["tool_calls"] = kept
+	}
+}
+
+// Pending reports whether any arguments are held.
+func (b *ToolArgsBuffer) Pending() bool {
+	return len(b.calls) > 0
+}
+
+// Flush returns the held arguments, repaired where needed, as tool_calls
+// delta entries, and the calls that could not be repaired (which are
+// delivered unchanged). The buffer is emptied.
+func (b *ToolArgsBuffer) Flush(debug bool) ([]any, []ToolCallError) {
+	var out []any
+	var failed []ToolCallError
+	for _, idx := range slices.Sorted(maps.Keys(b.calls)) {
+		call := b.calls[idx]
+		args := call.args.String()
+		repaired, ok := RepairJSON(args)
+		switch {
+		case !ok:
+			failed = append(failed, ToolCallError{Index: idx, ID: call.id, Name: call.name, Arguments: args})
+			repaired = args
+		case repaired != args && debug:
+			fmt.Printf("  🔧 repaired tool call arguments: %s → %s\n", args, repaired)
+		}
+		out = append(out, map[string]any{
+			"index":    idx,
+			"function": map[string]any{"arguments": repaired},
+		})
+	}
+	b.calls = nil
+	return out, failed
+}
@@ -0,0 +1,207 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Tool definitions and calls in the Anthropic Messages and Gemini
+// generateContent dialects. The proxy speaks OpenAI to clients; these
+// convert the tool parts of a request into a provider's dialect and the
+// tool calls of its response back.
+
+// ToolsToAnthropic converts OpenAI function tools to Anthropic tools:
+// {"name", "description", "input_schema"}.
+func ToolsToAnthropic(tools []any) []any {
+	out := make([]any, 0, len(tools))
+	for _, t := range tools {
+		fn, ok := functionOf(t)
+		if !ok {
+			continue
+		}
+		tool := map[string]any{
+			"name":         fn["name"],
+			"input_schema": schemaOrEmpty(fn["parameters"]),
+		}
+		if desc, ok := fn["description"]; ok {
+			tool["description"] = desc
+		}
+		out = append(out, tool)
+	}
+	return out
+}
+
+// ToolChoiceToAnthropic converts an OpenAI tool_choice. It returns nil for
+// "none", which Anthropic expresses by omitting the tools.
+func ToolChoiceToAnthropic(choice any) any {
+	switch c := choice.(type) {
+	case string:
+		switch c {
+		case "required":
+			return map[string]any{"type": "any"}
+		case "none":
+			return nil
+		}
+		return map[string]any{"type": "auto"}
+	case map[string]any:
+		if name := toolChoiceName(c); name != "" {
+			return map[string]any{"type": "tool", "name": name}
+		}
+	}
+	return map[string]any{"type": "auto"}
+}
+
+// ToolCallsFromAnthropic extracts the text and the tool_use blocks of an
+// Anthropic response's content as OpenAI tool_calls.
+func ToolCallsFromAnthropic(content []any) (text string, calls []any) {
+	for _, b := range content {
+		block, _ := b.(map[string]any)
+		switch block["type"] {
+		case "text":
+			s, _ := block["text"].(string)
+			text += s
+		case "tool_use":
+			name, _ := block["name"].(string)
+			id, _ := block["id"].(string)
+			calls = append(calls, openAIToolCall(len(calls), id, name, block["input"]))
+		}
+	}
+	return text, calls
+}
+
+// ToolsToGemini converts OpenAI function tools to a Gemini tools list with
+// one functionDeclarations entry. Schema keywords Gemini rejects are removed.
+func ToolsToGemini(tools []any) []any {
+	var decls []any
+	for _, t := range tools {
+		fn, ok := functionOf(t)
+		if !ok {
+			continue
+		}
+		decl := map[string]any{"name": fn["name"]}
+		if desc, ok := fn["description"]; ok {
+			decl["description"] = desc
+		}
+		if params, ok := fn["parameters"]; ok {
+			decl["parameters"] = geminiSchema(params)
+		}
+		decls = append(decls, decl)
+	}
+	if len(decls) == 0 {
+		return nil
+	}
+	return []any{map[string]any{"functionDeclarations": decls}}
+}
+
+// ToolChoiceToGemini converts an OpenAI tool_choice to a Gemini toolConfig.
+func ToolChoiceToGemini(choice any) map[string]any {
+	cfg := map[string]any{"mode": "AUTO"}
+	switch c := choice.(type) {
+	case string:
+		switch c {
+		case "required":
+			cfg["mode"] = "ANY"
+		case "none":
+			cfg["mode"] = "NONE"
+		}
+	case map[string]any:
+		if name := toolChoiceName(c); name != "" {
+			cfg["mode"] = "ANY"
+			cfg["allowedFunctionNames"] = []any{name}
+		}
+	}
+	return map[string]any{"functionCallingConfig": cfg}
+}
+
+// ToolCallsFromGemini extracts the text and the functionCall parts of a
+// Gemini candidate as OpenAI tool_calls. Gemini has no call IDs, so they
+// are generated from the position.
+func ToolCallsFromGemini(parts []any) (text string, calls []any) {
+	for _, p := range parts {
+		part, _ := p.(map[string]any)
+		if s, ok := part["text"].(string); ok {
+			text += s
+		}
+		if call, ok := part["functionCall"].(map[string]any); ok {
+			name, _ := call["name"].(string)
+			id := fmt.Sprintf("call_%d", len(calls))
+			calls = append(calls, openAIToolCall(len(calls), id, name, call["args"]))
+		}
+	}
+	return text, calls
+}
+
+// functionOf returns the function of an OpenAI tool definition.
+func functionOf(t any) (map[string]any, bool) {
+	tool, _ := t.(map[string]any)
+	if tool["type"] != nil && tool["type"] != "function" {
+		return nil, false
+	}
+	fn, ok := tool["function"].(map[string]any)
+	return fn, ok
+}
+
+// toolChoiceName returns the function named by an object tool_choice.
+func toolChoiceName(c map[string]any) string {
+	fn, _ := c["function"].(map[string]any)
+	name, _ := fn["name"].(string)
+	return name
+}
+
+func schemaOrEmpty(params any) any {
+	if params == nil {
+		return map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return params
+}
+
+// geminiSchema copies a JSON schema without the keywords Gemini's OpenAPI
+// subset rejects.
+func geminiSchema(schema any) any {
+	switch s := schema.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(s))
+		for k, v := range s {
+			switch k {
+			case "$schema", "additionalProperties", "strict", "$id", "$defs", "$ref":
+				continue
+			}
+			if props, ok := v.(map[string]any); ok && k == "properties" {
+				// Property names are not keywords
+				copied := make(map[string]any, len(props))
+				for name, prop := range props {
+					copied[name] = geminiSchema(prop)
+				}
+				out[k] = copied
+				continue
+			}
+			out[k] = geminiSchema(v)
+		}
+		return out
+	case []any:
+		out := make([]any, len(s))
+		for i, v := range s {
+			out[i] = geminiSchema(v)
+		}
+		return out
+	}
+	return schema
+}
+
+// openAIToolCall builds an OpenAI tool call; input is encoded as the JSON
+// arguments string.
+func openAIToolCall(index int, id, name string, input any) map[string]any {
+	if input == nil {
+		input = map[string]any{}
+	}
+	args, _ := json.Marshal(input)
+	return map[string]any{
+		"index": index,
+		"id":    id,
+		"type":  "function",
+		"function": map[string]any{
+			"name":      name,
+			"arguments": string(args),
+		},
+	}
+}
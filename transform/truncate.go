@@ -0,0 +1,107 @@
+package transform
+
+import "encoding/json"
+
+// TruncateMessages drops the oldest non-system messages until the request's
+// estimated token count fits within limit. The last message is always kept,
+// and an assistant message with tool calls is dropped together with its tool
+// results so the history stays valid. With trim, when dropping alone is not
+// enough, the text of the oldest remaining non-system message is shortened
+// from the start. It returns the new body and the number of messages dropped.
+func TruncateMessages(body []byte, limit int, trim bool) ([]byte, int) {
+	if EstimateTokens(len(body)) <= limit {
+		return body, 0
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body, 0
+	}
+	messages, ok := data["messages"].([]any)
+	if !ok {
+		return body, 0
+	}
+
+	// Track the size incrementally rather than re-encoding the whole body
+	// after every drop
+	size := len(body)
+	dropped := 0
+	for EstimateTokens(size) > limit {
+		i := oldestDroppable(messages)
+		if i < 0 {
+			break
+		}
+		n := droppedWith(messages, i)
+		if n == 0 {
+			break
+		}
+		for _, m := range messages[i : i+n] {
+			b, _ := json.Marshal(m)
+			size -= len(b) + 1 // the separating comma
+		}
+		messages = append(messages[:i], messages[i+n:]...)
+		dropped += n
+	}
+	data["messages"] = messages
+
+	if excess := (EstimateTokens(size) - limit) * 4; trim && excess > 0 {
+		for _, m := range messages {
+			msg, _ := m.(map[string]any)
+			content, ok := msg["content"].(string)
+			if !ok || msg["role"] == "system" || msg["role"] == "developer" {
+				continue
+			}
+			// Keep the end of the text, which is usually the most relevant
+			if excess < len(content) {
+				msg["content"] = "…" + content[validStart(content, excess):]
+			}
+			break
+		}
+	}
+
+	newBody, err := json.Marshal(data)
+	if err != nil {
+		return body, 0
+	}
+	return newBody, dropped
+}
+
+// oldestDroppable returns the index of the first non-system message other
+// than the last one, or -1.
+func oldestDroppable(messages []any) int {
+	for i, m := range messages[:max(len(messages)-1, 0)] {
+		msg, _ := m.(map[string]any)
+		if msg["role"] != "system" && msg["role"] != "developer" {
+			return i
+		}
+	}
+	return -1
+}
+
+// droppedWith returns how many messages starting at i must go together: an
+// assistant message with tool calls takes its tool results along. It returns
+// 0 when that would include the last message.
+func droppedWith(messages []any, i int) int {
+	n := 1
+	msg, _ := messages[i].(map[string]any)
+	if calls, _ := msg["tool_calls"].([]any); len(calls) > 0 {
+		for i+n < len(messages) {
+			next, _ := messages[i+n].(map[string]any)
+			if next["role"] != "tool" {
+				break
+			}
+			n++
+		}
+	}
+	if i+n >= len(messages) {
+		return 0
+	}
+	return n
+}
+
+// validStart moves i forward to the start of a UTF-8 sequence.
+func validStart(s string, i int) int {
+	for i < len(s) && s[i]&0xC0 == 0x80 {
+		i++
+	}
+	return i
+}
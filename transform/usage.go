@@ -0,0 +1,87 @@
+package transform
+
+import "encoding/json"
+
+// Usage is the token accounting reported by an upstream in the "usage" object.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+
+	// Prompt tokens read from and missing from the provider's prompt
+	// cache; both are 0 when the upstream reports no cache usage
+	CacheHitTokens  int `json:"prompt_cache_hit_tokens,omitempty"`
+	CacheMissTokens int `json:"prompt_cache_miss_tokens,omitempty"`
+}
+
+// Add returns the sum of u and o.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + o.PromptTokens,
+		CompletionTokens: u.CompletionTokens + o.CompletionTokens,
+		TotalTokens:      u.TotalTokens + o.TotalTokens,
+		CacheHitTokens:   u.CacheHitTokens + o.CacheHitTokens,
+		CacheMissTokens:  u.CacheMissTokens + o.CacheMissTokens,
+	}
+}
+
+// CacheReported reports whether the upstream said how much of the prompt
+// came from its cache.
+func (u Usage) CacheReported() bool {
+	return u.CacheHitTokens+u.CacheMissTokens > 0
+}
+
+// UsageFromMap extracts the usage object from a decoded response or SSE chunk.
+func UsageFromMap(data map[string]any) (Usage, bool) {
+	m, ok := data["usage"].(map[string]any)
+	if !ok {
+		return Usage{}, false
+	}
+	num := func(key string) int {
+		f, _ := m[key].(float64)
+		return int(f)
+	}
+	u := Usage{
+		PromptTokens:     num("prompt_tokens"),
+		CompletionTokens: num("completion_tokens"),
+		TotalTokens:      num("total_tokens"),
+	}
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+	if cached, ok := cachedTokens(m); ok {
+		u.CacheHitTokens, u.CacheMissTokens = cached, max(u.PromptTokens-cached, 0)
+	}
+	if hit, miss := num("prompt_cache_hit_tokens"), num("prompt_cache_miss_tokens"); hit+miss > 0 {
+		u.CacheHitTokens, u.CacheMissTokens = hit, miss
+	}
+	return u, true
+}
+
+// cachedTokens returns the cached prompt tokens of a usage object in the
+// OpenAI and Zhipu form, prompt_tokens_details.cached_tokens, or the Kimi
+// one, cached_tokens. DeepSeek's hit and miss counts are read by the caller.
+func cachedTokens(usage map[string]any) (int, bool) {
+	if details, ok := usage["prompt_tokens_details"].(map[string]any); ok {
+		if f, ok := details["cached_tokens"].(float64); ok {
+			return int(f), true
+		}
+	}
+	f, ok := usage["cached_tokens"].(float64)
+	return int(f), ok
+}
+
+// UsageFromBody extracts the usage object from a non-streaming response body.
+func UsageFromBody(body []byte) (Usage, bool) {
+	var data map[string]any
+	if json.Unmarshal(body, &data) != nil {
+		return Usage{}, false
+	}
+	return UsageFromMap(data)
+}
+
+// EstimateTokens gives a rough token count for n bytes of JSON/text,
+// used when the upstream doesn't report usage.
+func EstimateTokens(n int) int {
+	return (n + 3) / 4
+}